@@ -5,6 +5,7 @@ import (
 	cryptotls "crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os/signal"
@@ -28,17 +29,25 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/errortracking"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/handlers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/headers"
 	health "gitlab.com/gitlab-org/gitlab-pages/internal/healthcheck"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/invalidation"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/netutil"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/redirects"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/rejectmethods"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/routing"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/tar"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/chain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/disk"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/static"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/tls"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/uniqueDomain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/urilimiter"
@@ -47,6 +56,10 @@ import (
 
 var (
 	corsHandler = cors.New(cors.Options{AllowedMethods: []string{http.MethodGet, http.MethodHead}})
+
+	// warmUpPollInterval is the interval the domains source is checked at
+	// while it warms up on startup
+	warmUpPollInterval = 100 * time.Millisecond
 )
 
 type theApp struct {
@@ -152,7 +165,19 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 	handler = handlers.Ratelimiter(handler, &a.config.RateLimit)
 
 	// Health Check
-	handler = health.NewMiddleware(handler, a.config.General.StatusPath)
+	var warmers []health.Warmer
+	if warmer, ok := a.source.(health.Warmer); ok {
+		warmers = append(warmers, warmer)
+	}
+
+	handler = health.NewMiddleware(handler, a.config.General.StatusPath, a.config.General.ReadinessPath, warmers...)
+
+	// Push-based invalidation of the domains configuration and archives
+	handler, err := invalidation.NewMiddleware(handler, a.config.General.InvalidationPath,
+		a.config.GitLab.APISecretKey, a.source, zip.Instance(), tar.Instance())
+	if err != nil {
+		return nil, err
+	}
 
 	// Custom response headers
 	handler = customheaders.NewMiddleware(handler, a.config.General.CustomHeaders)
@@ -165,7 +190,7 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 	handler = handlePanicMiddleware(handler)
 
 	// Access logs and metrics
-	handler, err := logging.BasicAccessLogger(handler, a.config.Log.Format)
+	handler, err = logging.BasicAccessLogger(handler, a.config.Log.Format)
 	if err != nil {
 		return nil, err
 	}
@@ -297,6 +322,12 @@ func (a *theApp) Run() error {
 		result = multierror.Append(result, err)
 	}
 
+	if closer, ok := a.source.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("closing domains source: %w", err))
+		}
+	}
+
 	if result.ErrorOrNil() != nil {
 		errortracking.CaptureErrWithStackTrace(result.ErrorOrNil())
 		return result.ErrorOrNil()
@@ -352,12 +383,19 @@ func (a *theApp) listenMetrics(eg *errgroup.Group, config cfg.Metrics) *http.Ser
 
 func runApp(config *cfg.Config) error {
 	redirects.SetConfig(config.Redirects)
+	headers.SetConfig(config.Headers)
 
-	source, err := gitlab.New(&config.GitLab)
+	source, err := newDomainsSource(config)
 	if err != nil {
 		return fmt.Errorf("could not create domains config source: %w", err)
 	}
 
+	// the listeners only start accepting requests once the domains
+	// configuration cache is populated
+	if warmer, ok := source.(health.Warmer); ok {
+		waitForWarmUp(warmer, config.GitLab.Cache.WarmUpTimeout)
+	}
+
 	a := theApp{config: config, source: source}
 
 	err = logging.ConfigureLogging(a.config.Log.Format, a.config.Log.Verbose)
@@ -365,7 +403,12 @@ func runApp(config *cfg.Config) error {
 		return fmt.Errorf("failed to initialize logging: %w", err)
 	}
 
-	a.Artifact = artifact.New(config.ArtifactsServer.URL, config.ArtifactsServer.TimeoutSeconds, config.General.Domain)
+	artifactsTransport, err := httptransport.ClientTransport(config.ArtifactsServer.ClientTLS)
+	if err != nil {
+		return fmt.Errorf("failed to load artifacts server client TLS files: %w", err)
+	}
+
+	a.Artifact = artifact.New(config.ArtifactsServer.URL, config.ArtifactsServer.TimeoutSeconds, config.General.Domain, artifactsTransport)
 
 	if err := a.setAuth(config); err != nil {
 		return err
@@ -383,15 +426,91 @@ func runApp(config *cfg.Config) error {
 		return fmt.Errorf("failed to reconfigure zip VFS: %w", err)
 	}
 
+	if err := tar.Instance().Reconfigure(config); err != nil {
+		return fmt.Errorf("failed to reconfigure tar VFS: %w", err)
+	}
+
+	if err := local.Instance().Reconfigure(config); err != nil {
+		return fmt.Errorf("failed to reconfigure local VFS: %w", err)
+	}
+
 	return a.Run()
 }
 
+// waitForWarmUp waits for warmer to finish warming up, or for timeout to
+// elapse
+func waitForWarmUp(warmer health.Warmer, timeout time.Duration) {
+	if !warmer.Warming() {
+		return
+	}
+
+	log.Info("waiting for the domains configuration cache to warm up")
+
+	ticker := time.NewTicker(warmUpPollInterval)
+	defer ticker.Stop()
+
+	deadline := time.After(timeout)
+
+	for warmer.Warming() {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			log.WithField("timeout", timeout).Warn("domains configuration cache still warming up, starting to serve requests")
+			return
+		}
+	}
+}
+
+// newDomainsSource creates the domains configuration source selected with
+// domain-config-source, chaining the sources when it lists several of them
+func newDomainsSource(config *cfg.Config) (source.Source, error) {
+	sources := config.Domains.Sources()
+	if len(sources) == 1 {
+		return newDomainSource(config, sources[0])
+	}
+
+	links := make([]chain.Link, 0, len(sources))
+
+	for _, name := range sources {
+		s, err := newDomainSource(config, name)
+		if err != nil {
+			return nil, fmt.Errorf("creating %s domains source: %w", name, err)
+		}
+
+		links = append(links, chain.Link{
+			Name:               name,
+			Source:             s,
+			ContinueOnNotFound: config.Domains.ContinueOnNotFound(name),
+			ContinueOnError:    config.Domains.ContinueOnError(name),
+		})
+	}
+
+	return chain.New(links...), nil
+}
+
+// newDomainSource creates the domains configuration source name
+func newDomainSource(config *cfg.Config, name string) (source.Source, error) {
+	switch name {
+	case cfg.DomainSourceStatic:
+		return static.New(config.Domains.StaticFile, config.GitLab.EnableDisk)
+	case cfg.DomainSourceDisk:
+		// the working directory is the pages root when disk access is enabled
+		return disk.New(".", config.General.Domain, config.Domains.DiskRescanInterval)
+	default:
+		return gitlab.New(&config.GitLab, config.General.Domain)
+	}
+}
+
 func (a *theApp) setAuth(config *cfg.Config) error {
 	if config.Authentication.ClientID == "" {
 		return nil
 	}
 
-	var err error
+	transport, err := httptransport.ClientTransport(config.Authentication.ClientTLS)
+	if err != nil {
+		return fmt.Errorf("failed to load auth client TLS files: %w", err)
+	}
+
 	a.Auth, err = auth.New(&auth.Options{
 		PagesDomain:          config.General.Domain,
 		StoreSecret:          config.Authentication.Secret,
@@ -403,6 +522,7 @@ func (a *theApp) setAuth(config *cfg.Config) error {
 		AuthScope:            config.Authentication.Scope,
 		AuthTimeout:          config.Authentication.Timeout,
 		CookieSessionTimeout: config.Authentication.CookieSessionTimeout,
+		Transport:            transport,
 	})
 	if err != nil {
 		return fmt.Errorf("could not initialize auth package: %w", err)
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
@@ -81,3 +83,35 @@ func TestHandlePanicMiddleware(t *testing.T) {
 	counterCount := testutil.ToFloat64(metrics.PanicRecoveredCount)
 	require.Equal(t, float64(1), counterCount, "metric not updated")
 }
+
+// warmerStub finishes warming up after warmingChecks calls to Warming
+type warmerStub struct {
+	warmingChecks int64
+}
+
+func (w *warmerStub) Warming() bool {
+	return atomic.AddInt64(&w.warmingChecks, -1) >= 0
+}
+
+func TestWaitForWarmUp(t *testing.T) {
+	defaultInterval := warmUpPollInterval
+	warmUpPollInterval = time.Millisecond
+	t.Cleanup(func() { warmUpPollInterval = defaultInterval })
+
+	t.Run("waits for the warm-up", func(t *testing.T) {
+		warmer := &warmerStub{warmingChecks: 3}
+
+		waitForWarmUp(warmer, time.Minute)
+		require.False(t, warmer.Warming())
+	})
+
+	t.Run("stops waiting after the timeout", func(t *testing.T) {
+		warmer := &warmerStub{warmingChecks: 1 << 62}
+
+		start := time.Now()
+		waitForWarmUp(warmer, 10*time.Millisecond)
+
+		require.Less(t, time.Since(start), time.Second)
+		require.True(t, warmer.Warming())
+	})
+}
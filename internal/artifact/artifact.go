@@ -46,14 +46,19 @@ type Artifact struct {
 }
 
 // New when provided the arguments defined herein, returns a pointer to an
-// Artifact that is used to proxy requests.
-func New(server string, timeoutSeconds int, pagesDomain string) *Artifact {
+// Artifact that is used to proxy requests. The requests use
+// httptransport.DefaultTransport when transport is nil.
+func New(server string, timeoutSeconds int, pagesDomain string, transport http.RoundTripper) *Artifact {
+	if transport == nil {
+		transport = httptransport.DefaultTransport
+	}
+
 	return &Artifact{
 		server: strings.TrimRight(server, "/"),
 		suffix: "." + strings.ToLower(pagesDomain),
 		client: &http.Client{
 			Timeout:   time.Second * time.Duration(timeoutSeconds),
-			Transport: httptransport.DefaultTransport,
+			Transport: transport,
 		},
 	}
 }
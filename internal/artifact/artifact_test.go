@@ -89,7 +89,7 @@ func TestTryMakeRequest(t *testing.T) {
 
 			r.RemoteAddr = c.RemoteAddr
 
-			art := artifact.New(testServer.URL, 1, "gitlab-example.io")
+			art := artifact.New(testServer.URL, 1, "gitlab-example.io", nil)
 
 			result := httptest.NewRecorder()
 
@@ -277,7 +277,7 @@ func TestBuildURL(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.Description, func(t *testing.T) {
-			a := artifact.New(c.RawServer, 1, c.PagesDomain)
+			a := artifact.New(c.RawServer, 1, c.PagesDomain, nil)
 			u, ok := a.BuildURL(c.Host, c.Path)
 
 			msg := c.Description + " - generated URL: "
@@ -308,7 +308,7 @@ func TestContextCanceled(t *testing.T) {
 	r = r.WithContext(ctx)
 	// cancel context explicitly
 	cancel()
-	art := artifact.New(testServer.URL, 1, "gitlab-example.io")
+	art := artifact.New(testServer.URL, 1, "gitlab-example.io", nil)
 
 	require.True(t, art.TryMakeRequest(result, r, "", func(resp *http.Response) bool { return false }))
 	require.Equal(t, http.StatusNotFound, result.Code)
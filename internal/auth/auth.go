@@ -664,6 +664,9 @@ type Options struct {
 	AuthScope            string
 	AuthTimeout          time.Duration
 	CookieSessionTimeout time.Duration
+	// Transport of the requests to the GitLab API, which is
+	// httptransport.DefaultTransport when nil
+	Transport http.RoundTripper
 }
 
 // New when authentication supported this will be used to create authentication handler
@@ -674,6 +677,11 @@ func New(options *Options) (*Auth, error) {
 		return nil, err
 	}
 
+	transport := options.Transport
+	if transport == nil {
+		transport = httptransport.DefaultTransport
+	}
+
 	return &Auth{
 		pagesDomain:          options.PagesDomain,
 		clientID:             options.ClientID,
@@ -683,7 +691,7 @@ func New(options *Options) (*Auth, error) {
 		publicGitlabServer:   strings.TrimRight(options.PublicGitlabServer, "/"),
 		apiClient: &http.Client{
 			Timeout:   options.AuthTimeout,
-			Transport: httptransport.DefaultTransport,
+			Transport: transport,
 		},
 		store:                sessions.NewCookieStore(keys[0], keys[1]),
 		authSecret:           options.StoreSecret,
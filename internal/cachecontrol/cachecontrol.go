@@ -0,0 +1,139 @@
+// Package cachecontrol computes the Cache-Control directives of the files
+// served, according to their path and content type.
+package cachecontrol
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+const (
+	defaultMaxAge          = 10 * time.Minute
+	defaultImmutableMaxAge = 365 * 24 * time.Hour
+)
+
+// Default is the policy used until a Policy is configured. Like the default
+// configuration, it caches every file for 10 minutes and sets no directives
+// for the files of access controlled projects.
+var Default = &Policy{
+	maxAge:       defaultMaxAge,
+	html:         htmlDirectives(defaultMaxAge, 0),
+	immutable:    immutableDirectives(defaultImmutableMaxAge),
+	contentTypes: map[string]string{},
+}
+
+// Policy computes the Cache-Control directives of the files served. The
+// fingerprinted assets are immutable, the HTML pages can be revalidated in
+// the background once stale, and the other files are cached for a max-age,
+// unless a directive is configured for their content type. The HTML pages
+// are never fingerprinted assets, as their URL does not change when they do.
+type Policy struct {
+	maxAge       time.Duration
+	html         string
+	immutable    string
+	fingerprint  *regexp.Regexp
+	contentTypes map[string]string
+	// accessControl are the directives of the files of access controlled
+	// projects, none when empty
+	accessControl string
+}
+
+// New returns the Policy configured by cfg
+func New(cfg *config.CacheControl) (*Policy, error) {
+	p := &Policy{
+		maxAge:        cfg.MaxAge,
+		html:          htmlDirectives(cfg.HTMLMaxAge, cfg.HTMLStaleWhileRevalidate),
+		immutable:     immutableDirectives(cfg.ImmutableMaxAge),
+		contentTypes:  cfg.ContentTypes,
+		accessControl: cfg.AccessControl,
+	}
+
+	if cfg.FingerprintPattern != "" {
+		var err error
+		if p.fingerprint, err = regexp.Compile(cfg.FingerprintPattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Directives returns the Cache-Control directives of the file of path and
+// contentType
+func (p *Policy) Directives(path, contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if p.fingerprint != nil && mediaType != "text/html" && p.fingerprint.MatchString(path) {
+		return p.immutable
+	}
+
+	if err != nil {
+		return maxAge(p.maxAge)
+	}
+
+	if directives, ok := p.contentTypes[mediaType]; ok {
+		return directives
+	}
+
+	if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+		if directives, ok := p.contentTypes[mediaType[:i]+"/*"]; ok {
+			return directives
+		}
+	}
+
+	if mediaType == "text/html" {
+		return p.html
+	}
+
+	return maxAge(p.maxAge)
+}
+
+// AccessControl returns the Cache-Control directives of the files of access
+// controlled projects, which replace any other directives, and "" when they
+// must not have any
+func (p *Policy) AccessControl() string {
+	return p.accessControl
+}
+
+// Expires returns the Expires header matching the max-age of directives from
+// now in the format it has always been served with, for the caches which do
+// not support Cache-Control, and false when the directives have no max-age
+func Expires(directives string, now time.Time) (string, bool) {
+	for _, directive := range strings.Split(directives, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "max-age") {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(value), `"`), 10, 64)
+		if err != nil || seconds < 0 {
+			return "", false
+		}
+
+		return now.Add(time.Duration(seconds) * time.Second).Format(time.RFC1123), true
+	}
+
+	return "", false
+}
+
+func maxAge(d time.Duration) string {
+	return fmt.Sprintf("max-age=%d", int64(d.Seconds()))
+}
+
+func htmlDirectives(d, staleWhileRevalidate time.Duration) string {
+	if staleWhileRevalidate <= 0 {
+		return maxAge(d)
+	}
+
+	return fmt.Sprintf("%s, stale-while-revalidate=%d", maxAge(d), int64(staleWhileRevalidate.Seconds()))
+}
+
+func immutableDirectives(d time.Duration) string {
+	return maxAge(d) + ", immutable"
+}
@@ -0,0 +1,144 @@
+package cachecontrol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+func TestDirectives(t *testing.T) {
+	p, err := New(&config.CacheControl{
+		MaxAge:                   10 * time.Minute,
+		HTMLMaxAge:               time.Minute,
+		HTMLStaleWhileRevalidate: time.Hour,
+		ImmutableMaxAge:          24 * time.Hour,
+		FingerprintPattern:       `[.-][0-9a-f]{8,}\.[0-9a-z]+$`,
+		ContentTypes: map[string]string{
+			"image/*":          "public, max-age=86400",
+			"image/svg+xml":    "max-age=3600",
+			"application/json": "no-cache",
+		},
+	})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		path        string
+		contentType string
+		expected    string
+	}{
+		"html": {
+			path:        "index.html",
+			contentType: "text/html; charset=utf-8",
+			expected:    "max-age=60, stale-while-revalidate=3600",
+		},
+		"fingerprinted asset": {
+			path:        "assets/app.3f2a1b9c.js",
+			contentType: "text/javascript; charset=utf-8",
+			expected:    "max-age=86400, immutable",
+		},
+		"fingerprinted asset with a dash": {
+			path:        "assets/style-0123456789abcdef.css",
+			contentType: "text/css; charset=utf-8",
+			expected:    "max-age=86400, immutable",
+		},
+		"fingerprinted asset of a configured content type": {
+			path:        "images/logo.3f2a1b9c.png",
+			contentType: "image/png",
+			expected:    "max-age=86400, immutable",
+		},
+		"fingerprinted html": {
+			path:        "about.3f2a1b9c.html",
+			contentType: "text/html; charset=utf-8",
+			expected:    "max-age=60, stale-while-revalidate=3600",
+		},
+		"not fingerprinted asset": {
+			path:        "assets/app.js",
+			contentType: "text/javascript; charset=utf-8",
+			expected:    "max-age=600",
+		},
+		"short hash": {
+			path:        "assets/app.3f2a1b.js",
+			contentType: "text/javascript; charset=utf-8",
+			expected:    "max-age=600",
+		},
+		"content type": {
+			path:        "data.json",
+			contentType: "application/json",
+			expected:    "no-cache",
+		},
+		"type": {
+			path:        "images/logo.png",
+			contentType: "image/png",
+			expected:    "public, max-age=86400",
+		},
+		"content type over type": {
+			path:        "images/logo.svg",
+			contentType: "image/svg+xml",
+			expected:    "max-age=3600",
+		},
+		"invalid content type": {
+			path:        "file",
+			contentType: "invalid/;",
+			expected:    "max-age=600",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, p.Directives(tt.path, tt.contentType))
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	require.Equal(t, "max-age=600", Default.Directives("app.js", "text/javascript"))
+	require.Equal(t, "max-age=600", Default.Directives("index.html", "text/html"))
+	require.Equal(t, "max-age=600", Default.Directives("app.3f2a1b9c.js", "text/javascript"), "the fingerprinted assets are opt-in")
+	require.Empty(t, Default.AccessControl())
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(&config.CacheControl{MaxAge: time.Minute, HTMLMaxAge: time.Minute, AccessControl: "private, no-cache"})
+	require.NoError(t, err)
+	require.Equal(t, "max-age=60", p.Directives("index.html", "text/html"))
+	require.Equal(t, "private, no-cache", p.AccessControl())
+	require.Equal(t, "max-age=60", p.Directives("app.3f2a1b9c.js", "text/javascript"), "the fingerprinted assets are disabled")
+
+	_, err = New(&config.CacheControl{FingerprintPattern: "[0-9a-f"})
+	require.Error(t, err)
+}
+
+func TestExpires(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		directives string
+		expected   string
+	}{
+		"max-age": {
+			directives: "max-age=600",
+			expected:   "Mon, 01 Mar 2021 12:10:00 UTC",
+		},
+		"with other directives": {
+			directives: "public, MAX-AGE=60, stale-while-revalidate=600",
+			expected:   "Mon, 01 Mar 2021 12:01:00 UTC",
+		},
+		"without max-age": {
+			directives: "no-cache",
+		},
+		"invalid max-age": {
+			directives: "max-age=soon",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			expires, ok := Expires(tt.directives, now)
+			require.Equal(t, tt.expected != "", ok)
+			require.Equal(t, tt.expected, expires)
+		})
+	}
+}
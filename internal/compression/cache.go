@@ -0,0 +1,93 @@
+package compression
+
+import (
+	"container/list"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// Key identifies a compressed variant of a file of a project
+type Key struct {
+	ProjectID uint64
+	Path      string
+	// ContentID identifies the content of the file, or the deployment of the
+	// project when the content of its files is not identified
+	ContentID string
+	Encoding  string
+}
+
+type cacheEntry struct {
+	key  Key
+	data []byte
+}
+
+// cache keeps the compressed variants of files up to maxSize bytes, the least
+// recently used ones being evicted above it
+type cache struct {
+	mux     sync.Mutex
+	maxSize int64
+	size    int64
+	// lru lists the entries from the most to the least recently used
+	lru     *list.List
+	entries map[Key]*list.Element
+}
+
+func newCache(maxSize int64) *cache {
+	return &cache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[Key]*list.Element),
+	}
+}
+
+func (c *cache) get(key Key) ([]byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		metrics.CompressionCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	metrics.CompressionCacheRequests.WithLabelValues("hit").Inc()
+	c.lru.MoveToFront(element)
+
+	return element.Value.(*cacheEntry).data, true
+}
+
+// add caches data unless it is larger than the cache
+func (c *cache) add(key Key, data []byte) {
+	size := int64(len(data))
+	if size > c.maxSize {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if _, found := c.entries[key]; found {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.grow(size)
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *cache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.grow(-int64(len(entry.data)))
+}
+
+func (c *cache) grow(size int64) {
+	c.size += size
+	metrics.CompressionCacheSize.Add(float64(size))
+}
@@ -0,0 +1,36 @@
+package compression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(10)
+
+	first, second, third := Key{Path: "first"}, Key{Path: "second"}, Key{Path: "third"}
+
+	c.add(first, []byte("1111"))
+	c.add(second, []byte("2222"))
+
+	// the first variant becomes the most recently used one
+	_, found := c.get(first)
+	require.True(t, found)
+
+	c.add(third, []byte("3333"))
+	require.Equal(t, int64(8), c.size)
+
+	_, found = c.get(second)
+	require.False(t, found)
+
+	for _, key := range []Key{first, third} {
+		_, found = c.get(key)
+		require.True(t, found)
+	}
+
+	// variants larger than the cache are not cached
+	c.add(Key{Path: "large"}, []byte("larger than the cache"))
+	require.Equal(t, int64(8), c.size)
+	require.Len(t, c.entries, 2)
+}
@@ -0,0 +1,177 @@
+// Package compression compresses the files served on the fly, and caches
+// their compressed variants in memory.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	contentencoding "gitlab.com/feistel/go-contentencoding/encoding"
+	"golang.org/x/sync/singleflight"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// maxFileSize is the maximum size of the files compressed, larger files are
+// served uncompressed rather than compressed in memory
+const maxFileSize = 8 * 1024 * 1024
+
+// brotliLevel is a tradeoff between the compression ratio and the CPU time of
+// compressing files on the fly
+const brotliLevel = 5
+
+// encodingZstd is the Zstandard content encoding, unknown to contentencoding
+const encodingZstd = "zstd"
+
+// encoders create the writers compressing with each of the encodings
+var encoders = map[string]func(w io.Writer) io.WriteCloser{
+	contentencoding.Brotli: func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotliLevel)
+	},
+	contentencoding.Gzip: func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	encodingZstd: func(w io.Writer) io.WriteCloser {
+		// the options are valid, so that no error is returned
+		zw, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+
+		return zw
+	},
+}
+
+// compressibleTypes are the media types worth compressing other than text/*
+// and the +json and +xml ones
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/wasm":          true,
+	"application/xml":           true,
+	"application/x-javascript":  true,
+	"font/otf":                  true,
+	"font/ttf":                  true,
+	"image/bmp":                 true,
+	"image/svg+xml":             true,
+	"image/x-icon":              true,
+}
+
+// Compressor compresses files on the fly, and caches their compressed
+// variants
+type Compressor struct {
+	minSize int64
+	cache   *cache
+	// group compresses each variant once when it is requested concurrently
+	group singleflight.Group
+}
+
+// New returns a Compressor configured by cfg, or nil when the compression on
+// the fly is disabled
+func New(cfg *config.Compression) *Compressor {
+	if !cfg.Dynamic {
+		return nil
+	}
+
+	return &Compressor{
+		minSize: cfg.MinSize,
+		cache:   newCache(cfg.CacheSize),
+	}
+}
+
+// Compressible reports whether a file of contentType and size is compressed
+// on the fly
+func (c *Compressor) Compressible(contentType string, size int64) bool {
+	if size < c.minSize || size > maxFileSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// Supports reports whether files can be compressed with encoding
+func (c *Compressor) Supports(encoding string) bool {
+	return encoders[encoding] != nil
+}
+
+// Compress returns the content of the file of key compressed with its
+// encoding, reading the file with open when its variant is not cached. The
+// variants are only cached for the keys with a ContentID, which changes with
+// the content of the file.
+func (c *Compressor) Compress(key Key, open func() (io.ReadCloser, error)) ([]byte, error) {
+	if key.ContentID == "" {
+		return compress(key.Encoding, open)
+	}
+
+	if data, found := c.cache.get(key); found {
+		return data, nil
+	}
+
+	group := fmt.Sprintf("%d\x00%s\x00%s\x00%s", key.ProjectID, key.Path, key.ContentID, key.Encoding)
+
+	data, err, _ := c.group.Do(group, func() (interface{}, error) {
+		data, err := compress(key.Encoding, open)
+		if err != nil {
+			return nil, err
+		}
+
+		c.cache.add(key, data)
+
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data.([]byte), nil
+}
+
+func compress(encoding string, open func() (io.ReadCloser, error)) ([]byte, error) {
+	encoder := encoders[encoding]
+	if encoder == nil {
+		return nil, fmt.Errorf("unsupported encoding: %q", encoding)
+	}
+
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	start := time.Now()
+
+	var b bytes.Buffer
+
+	w := encoder(&b)
+
+	n, err := io.Copy(w, io.LimitReader(r, maxFileSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	metrics.CompressionCPUSeconds.WithLabelValues(encoding).Observe(time.Since(start).Seconds())
+
+	if n > 0 {
+		metrics.CompressionRatio.WithLabelValues(encoding).Observe(float64(b.Len()) / float64(n))
+	}
+
+	return b.Bytes(), nil
+}
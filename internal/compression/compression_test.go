@@ -0,0 +1,158 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+func newCompressor(t *testing.T) *Compressor {
+	t.Helper()
+
+	c := New(&config.Compression{Dynamic: true, MinSize: 10, CacheSize: 1024})
+	require.NotNil(t, c)
+
+	return c
+}
+
+func opener(content string, opened *int) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		*opened++
+		return io.NopCloser(strings.NewReader(content)), nil
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	require.Nil(t, New(&config.Compression{MinSize: 10, CacheSize: 1024}))
+}
+
+func TestCompressible(t *testing.T) {
+	c := newCompressor(t)
+
+	tests := map[string]struct {
+		contentType string
+		size        int64
+		expected    bool
+	}{
+		"html":               {contentType: "text/html; charset=utf-8", size: 100, expected: true},
+		"javascript":         {contentType: "application/javascript", size: 100, expected: true},
+		"json suffix":        {contentType: "application/ld+json", size: 100, expected: true},
+		"svg":                {contentType: "image/svg+xml", size: 100, expected: true},
+		"png":                {contentType: "image/png", size: 100},
+		"woff2":              {contentType: "font/woff2", size: 100},
+		"invalid type":       {contentType: "text/html; =", size: 100},
+		"smaller than min":   {contentType: "text/html", size: 9},
+		"larger than max":    {contentType: "text/html", size: maxFileSize + 1},
+		"minimum size":       {contentType: "text/css", size: 10, expected: true},
+		"maximum size":       {contentType: "text/css", size: maxFileSize, expected: true},
+		"unknown media type": {contentType: "application/octet-stream", size: 100},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, c.Compressible(tc.contentType, tc.size))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	content := strings.Repeat("GitLab Pages ", 50)
+
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	}
+
+	for encoding, decoder := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			c := newCompressor(t)
+			require.True(t, c.Supports(encoding))
+
+			var opened int
+			key := Key{ProjectID: 1, ContentID: "id", Path: "index.html", Encoding: encoding}
+
+			data, err := c.Compress(key, opener(content, &opened))
+			require.NoError(t, err)
+			require.Less(t, len(data), len(content))
+
+			r, err := decoder(bytes.NewReader(data))
+			require.NoError(t, err)
+
+			decompressed, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, content, string(decompressed))
+
+			// the compressed variant is cached
+			cached, err := c.Compress(key, opener(content, &opened))
+			require.NoError(t, err)
+			require.Equal(t, data, cached)
+			require.Equal(t, 1, opened)
+		})
+	}
+}
+
+func TestCompressWithoutContentID(t *testing.T) {
+	c := newCompressor(t)
+
+	var opened int
+	key := Key{ProjectID: 1, Path: "index.html", Encoding: "gzip"}
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Compress(key, opener("content", &opened))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, opened, "the variants of files without content identifier are not cached")
+}
+
+func TestCompressByProject(t *testing.T) {
+	c := newCompressor(t)
+
+	var opened int
+
+	for _, projectID := range []uint64{1, 2} {
+		key := Key{ProjectID: projectID, ContentID: "id", Path: "index.html", Encoding: "gzip"}
+
+		_, err := c.Compress(key, opener("content", &opened))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, opened, "the variants are not shared across projects")
+}
+
+func TestCompressFails(t *testing.T) {
+	c := newCompressor(t)
+	require.False(t, c.Supports("deflate"))
+
+	var opened int
+	_, err := c.Compress(Key{ProjectID: 1, ContentID: "id", Path: "index.html", Encoding: "deflate"}, opener("content", &opened))
+	require.Error(t, err)
+	require.Zero(t, opened)
+
+	errOpen := errors.New("open failed")
+	_, err = c.Compress(Key{ProjectID: 1, ContentID: "id", Path: "index.html", Encoding: "gzip"}, func() (io.ReadCloser, error) {
+		return nil, errOpen
+	})
+	require.ErrorIs(t, err, errOpen)
+
+	// errors are not cached
+	_, err = c.Compress(Key{ProjectID: 1, ContentID: "id", Path: "index.html", Encoding: "gzip"}, opener("content", &opened))
+	require.NoError(t, err)
+	require.Equal(t, 1, opened)
+}
@@ -23,13 +23,17 @@ type Config struct {
 	RateLimit       RateLimit
 	ArtifactsServer ArtifactsServer
 	Authentication  Auth
+	Domains         Domains
 	GitLab          GitLab
 	Log             Log
 	Redirects       Redirects
+	Headers         Headers
 	Sentry          Sentry
 	Server          Server
 	TLS             TLS
 	Zip             ZipServing
+	Compression     Compression
+	CacheControl    CacheControl
 	Metrics         Metrics
 
 	// These fields contain the raw strings passed for listen-http,
@@ -53,6 +57,8 @@ type General struct {
 	RootKey               []byte
 	ServerShutdownTimeout time.Duration
 	StatusPath            string
+	ReadinessPath         string
+	InvalidationPath      string
 
 	DisableCrossOriginRequests bool
 	InsecureCiphers            bool
@@ -83,6 +89,7 @@ type RateLimit struct {
 type ArtifactsServer struct {
 	URL            string
 	TimeoutSeconds int
+	ClientTLS      ClientTLS
 }
 
 // Auth groups settings related to configuring Authentication with
@@ -95,6 +102,7 @@ type Auth struct {
 	Scope                string
 	Timeout              time.Duration
 	CookieSessionTimeout time.Duration
+	ClientTLS            ClientTLS
 }
 
 // Cache configuration for GitLab API
@@ -105,18 +113,145 @@ type Cache struct {
 	RetrievalTimeout     time.Duration
 	MaxRetrievalInterval time.Duration
 	MaxRetrievalRetries  int
+	StaleIfError         time.Duration
+	SnapshotFile         string
+	SnapshotInterval     time.Duration
+	SnapshotMaxAge       time.Duration
+	WarmUp               bool
+	WarmUpLimit          int
+	WarmUpTimeout        time.Duration
+	Subscribe            bool
+	ResubscribeInterval  time.Duration
+	Store                string
+	RedisURL             string
+	RedisKeyPrefix       string
+}
+
+const (
+	// CacheStoreMemory keeps the domains cache in memory
+	CacheStoreMemory = "memory"
+	// CacheStoreRedis shares the domains cache between replicas through Redis
+	CacheStoreRedis = "redis"
+)
+
+const (
+	// DomainSourceGitLab fetches the domains configuration from the GitLab internal API
+	DomainSourceGitLab = "gitlab"
+	// DomainSourceStatic reads the domains configuration from a local file
+	DomainSourceStatic = "static"
+	// DomainSourceDisk discovers the domains configuration from the pages root
+	DomainSourceDisk = "disk"
+)
+
+const (
+	// DomainSourceContinue makes a chain of domains sources query the next
+	// source after a source
+	DomainSourceContinue = "continue"
+	// DomainSourceStop makes a chain of domains sources answer with the result
+	// of a source
+	DomainSourceStop = "stop"
+)
+
+const (
+	// ZipEvictionLRU evicts the least recently used zip archives first
+	ZipEvictionLRU = "lru"
+	// ZipEvictionLFU evicts the least frequently used zip archives first
+	ZipEvictionLFU = "lfu"
+)
+
+// Domains groups settings related to configuring the source Pages fetches the
+// domains configuration from
+type Domains struct {
+	// Source is a comma-separated list of the sources queried in order
+	Source             string
+	StaticFile         string
+	DiskRescanInterval time.Duration
+	// OnNotFound and OnError are the comma-separated source=continue or
+	// source=stop policies of the chained sources when a source does not know
+	// a domain or fails. By default, the next source is queried when a domain
+	// is not found and the error is returned when a source fails.
+	OnNotFound string
+	OnError    string
+}
+
+// Sources returns the names of the sources chained by Source
+func (d Domains) Sources() []string {
+	var sources []string
+
+	for _, name := range strings.Split(d.Source, ",") {
+		sources = append(sources, strings.TrimSpace(name))
+	}
+
+	return sources
+}
+
+// ContinueOnNotFound returns true when the source after source is queried
+// when source does not know a domain
+func (d Domains) ContinueOnNotFound(source string) bool {
+	policies, _ := parseDomainSourcePolicies(d.OnNotFound)
+
+	return policies[source] != DomainSourceStop
+}
+
+// ContinueOnError returns true when the source after source is queried when
+// source fails
+func (d Domains) ContinueOnError(source string) bool {
+	policies, _ := parseDomainSourcePolicies(d.OnError)
+
+	return policies[source] == DomainSourceContinue
+}
+
+// parseDomainSourcePolicies returns the policies of the comma-separated
+// source=policy pairs of value by source
+func parseDomainSourcePolicies(value string) (map[string]string, error) {
+	policies := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		source, policy, ok := strings.Cut(pair, "=")
+		source, policy = strings.TrimSpace(source), strings.TrimSpace(policy)
+
+		if !ok || (policy != DomainSourceContinue && policy != DomainSourceStop) {
+			return nil, fmt.Errorf("%q: %w", pair, errInvalidDomainSourcePolicy)
+		}
+
+		policies[source] = policy
+	}
+
+	return policies, nil
 }
 
 // GitLab groups settings related to configuring GitLab client used to
 // interact with GitLab API
 type GitLab struct {
-	PublicServer       string
-	InternalServer     string
-	APISecretKey       []byte
-	ClientHTTPTimeout  time.Duration
-	JWTTokenExpiration time.Duration
-	Cache              Cache
-	EnableDisk         bool
+	PublicServer   string
+	InternalServer string
+	APISecretKey   []byte
+	// APISecretKeysDir is the directory of the keys signing the requests to
+	// the GitLab API instead of APISecretKey
+	APISecretKeysDir            string
+	APISecretKeysReloadInterval time.Duration
+	ClientHTTPTimeout           time.Duration
+	JWTTokenExpiration          time.Duration
+	ClientTLS                   ClientTLS
+	Cache                       Cache
+	CircuitBreaker              CircuitBreaker
+	EnableDisk                  bool
+}
+
+// CircuitBreaker groups settings related to the circuit breaker of the
+// GitLab internal API client
+type CircuitBreaker struct {
+	Enabled             bool
+	ConsecutiveFailures int
+	FailureRatio        float64
+	MinRequests         int
+	Window              time.Duration
+	OpenTimeout         time.Duration
+	HalfOpenRequests    int
 }
 
 // Log groups settings related to configuring logging
@@ -132,6 +267,13 @@ type Redirects struct {
 	MaxRuleCount    int
 }
 
+// Headers groups settings related to configuring _headers limits
+type Headers struct {
+	MaxConfigSize   int
+	MaxPathSegments int
+	MaxRuleCount    int
+}
+
 // Sentry groups settings related to configuring Sentry
 type Sentry struct {
 	DSN         string
@@ -152,6 +294,59 @@ type ZipServing struct {
 	OpenTimeout        time.Duration
 	AllowedPaths       []string
 	HTTPClientTimeout  time.Duration
+	ClientTLS          ClientTLS
+	// DiskCacheDir is the directory of the local disk cache of the archives,
+	// disabled when empty
+	DiskCacheDir     string
+	DiskCacheMaxSize int64
+	// MemoryBudget is the estimated memory in bytes the cached archives can
+	// use before they are evicted following EvictionPolicy, unlimited when 0
+	MemoryBudget   int64
+	EvictionPolicy string
+}
+
+// Compression groups settings of the compression of responses on the fly
+type Compression struct {
+	// Dynamic enables the compression of the files served without a
+	// precompressed variant, when they are at least MinSize bytes and of a
+	// compressible type. The compressed variants are cached up to CacheSize
+	// bytes.
+	Dynamic   bool
+	MinSize   int64
+	CacheSize int64
+}
+
+// CacheControl groups settings of the Cache-Control policy of the files served
+type CacheControl struct {
+	// MaxAge is the max-age of the files without a more specific policy
+	MaxAge time.Duration
+	// HTMLMaxAge and HTMLStaleWhileRevalidate are the max-age and the
+	// stale-while-revalidate of the HTML pages
+	HTMLMaxAge               time.Duration
+	HTMLStaleWhileRevalidate time.Duration
+	// ImmutableMaxAge is the max-age of the immutable fingerprinted assets,
+	// whose paths match FingerprintPattern
+	ImmutableMaxAge    time.Duration
+	FingerprintPattern string
+	// ContentTypes are the Cache-Control directives of media types, like
+	// text/css, or of all the media types of a type, like image/*
+	ContentTypes map[string]string
+	// AccessControl are the Cache-Control directives of the files of access
+	// controlled projects, which have none when it is empty
+	AccessControl string
+}
+
+// ClientTLS groups the files configuring the TLS connections of an HTTP
+// client, for mutual TLS with the servers it connects to
+type ClientTLS struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Enabled returns true when any of the files is defined
+func (c ClientTLS) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.CAFile != ""
 }
 
 type Server struct {
@@ -171,6 +366,11 @@ var (
 	errInvalidHeaderParameter = errors.New("invalid syntax specified as header parameter")
 	errMetricsNoCertificate   = errors.New("metrics certificate path must not be empty")
 	errMetricsNoKey           = errors.New("metrics private key path must not be empty")
+
+	errInvalidCacheControlContentType   = errors.New("invalid syntax specified as cache-control-content-type parameter")
+	errDuplicateCacheControlContentType = errors.New("duplicate cache-control-content-type")
+
+	errInvalidDomainSourcePolicy = errors.New("domain source policy must be source=continue or source=stop")
 )
 
 func internalGitlabServerFromFlags() string {
@@ -246,6 +446,36 @@ func loadMetricsConfig() (metrics Metrics, err error) {
 	return metrics, nil
 }
 
+// parseCacheControlContentTypes parses the Cache-Control directives of media
+// types given as `type/subtype: directives`
+func parseCacheControlContentTypes(contentTypes []string) (map[string]string, error) {
+	directives := make(map[string]string, len(contentTypes))
+
+	var result *multierror.Error
+	for _, c := range contentTypes {
+		mediaType, value, found := strings.Cut(c, ":")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		value = strings.TrimSpace(value)
+
+		if !found || strings.Count(mediaType, "/") != 1 || value == "" {
+			result = multierror.Append(result, fmt.Errorf("parsing error %s: %w", c, errInvalidCacheControlContentType))
+			continue
+		}
+
+		if _, ok := directives[mediaType]; ok {
+			result = multierror.Append(result, fmt.Errorf("%s already specified with value '%s': %w", mediaType, directives[mediaType], errDuplicateCacheControlContentType))
+		}
+
+		directives[mediaType] = value
+	}
+
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+
+	return directives, nil
+}
+
 func parseHeaderString(customHeaders []string) (http.Header, error) {
 	headers := make(http.Header, len(customHeaders))
 
@@ -284,6 +514,8 @@ func loadConfig() (*Config, error) {
 			RedirectHTTP:               *redirectHTTP,
 			RootDir:                    *pagesRoot,
 			StatusPath:                 *pagesStatus,
+			ReadinessPath:              *pagesReadiness,
+			InvalidationPath:           *pagesInvalidation,
 			ServerShutdownTimeout:      *serverShutdownTimeout,
 			DisableCrossOriginRequests: *disableCrossOriginRequests,
 			InsecureCiphers:            *insecureCiphers,
@@ -301,10 +533,24 @@ func loadConfig() (*Config, error) {
 			TLSDomainLimitPerSecond:   *rateLimitTLSDomain,
 			TLSDomainBurst:            *rateLimitTLSDomainBurst,
 		},
+		Domains: Domains{
+			Source:             *domainConfigSource,
+			StaticFile:         *domainConfigStaticFile,
+			DiskRescanInterval: *domainConfigDiskRescanInterval,
+			OnNotFound:         *domainConfigOnNotFound,
+			OnError:            *domainConfigOnError,
+		},
 		GitLab: GitLab{
-			ClientHTTPTimeout:  *gitlabClientHTTPTimeout,
-			JWTTokenExpiration: *gitlabClientJWTExpiry,
-			EnableDisk:         *enableDisk,
+			APISecretKeysDir:            *gitLabAPISecretKeysDir,
+			APISecretKeysReloadInterval: *gitLabAPISecretKeysReload,
+			ClientHTTPTimeout:           *gitlabClientHTTPTimeout,
+			JWTTokenExpiration:          *gitlabClientJWTExpiry,
+			EnableDisk:                  *enableDisk,
+			ClientTLS: ClientTLS{
+				CertFile: *gitlabClientTLSCert,
+				KeyFile:  *gitlabClientTLSKey,
+				CAFile:   *gitlabClientTLSCAFile,
+			},
 			Cache: Cache{
 				CacheExpiry:          *gitlabCacheExpiry,
 				CacheCleanupInterval: *gitlabCacheCleanup,
@@ -312,11 +558,37 @@ func loadConfig() (*Config, error) {
 				RetrievalTimeout:     *gitlabRetrievalTimeout,
 				MaxRetrievalInterval: *gitlabRetrievalInterval,
 				MaxRetrievalRetries:  *gitlabRetrievalRetries,
+				StaleIfError:         *gitlabCacheStaleIfError,
+				SnapshotFile:         *gitlabCacheSnapshotFile,
+				SnapshotInterval:     *gitlabCacheSnapshotInterval,
+				SnapshotMaxAge:       *gitlabCacheSnapshotMaxAge,
+				WarmUp:               *gitlabCacheWarmUp,
+				WarmUpLimit:          *gitlabCacheWarmUpLimit,
+				WarmUpTimeout:        *gitlabCacheWarmUpTimeout,
+				Subscribe:            *gitlabCacheSubscribe,
+				ResubscribeInterval:  *gitlabCacheResubscribe,
+				Store:                *gitlabCacheStore,
+				RedisURL:             *gitlabCacheRedisURL,
+				RedisKeyPrefix:       *gitlabCacheRedisKeyPrefix,
+			},
+			CircuitBreaker: CircuitBreaker{
+				Enabled:             *gitlabCircuitBreaker,
+				ConsecutiveFailures: *gitlabCircuitBreakerFailures,
+				FailureRatio:        *gitlabCircuitBreakerFailureRatio,
+				MinRequests:         *gitlabCircuitBreakerMinRequests,
+				Window:              *gitlabCircuitBreakerWindow,
+				OpenTimeout:         *gitlabCircuitBreakerOpenTimeout,
+				HalfOpenRequests:    *gitlabCircuitBreakerHalfOpenRequests,
 			},
 		},
 		ArtifactsServer: ArtifactsServer{
 			TimeoutSeconds: *artifactsServerTimeout,
 			URL:            *artifactsServer,
+			ClientTLS: ClientTLS{
+				CertFile: *artifactsServerTLSCert,
+				KeyFile:  *artifactsServerTLSKey,
+				CAFile:   *artifactsServerTLSCAFile,
+			},
 		},
 		Authentication: Auth{
 			Secret:               *secret,
@@ -326,6 +598,11 @@ func loadConfig() (*Config, error) {
 			Scope:                *authScope,
 			Timeout:              *authTimeout,
 			CookieSessionTimeout: *authCookieSessionTimeout,
+			ClientTLS: ClientTLS{
+				CertFile: *authTLSCert,
+				KeyFile:  *authTLSKey,
+				CAFile:   *authTLSCAFile,
+			},
 		},
 		Log: Log{
 			Format:  *logFormat,
@@ -336,6 +613,11 @@ func loadConfig() (*Config, error) {
 			MaxPathSegments: *redirectsMaxPathSegments,
 			MaxRuleCount:    *redirectsMaxRuleCount,
 		},
+		Headers: Headers{
+			MaxConfigSize:   *headersMaxConfigSize,
+			MaxPathSegments: *headersMaxPathSegments,
+			MaxRuleCount:    *headersMaxRuleCount,
+		},
 		Sentry: Sentry{
 			DSN:         *sentryDSN,
 			Environment: *sentryEnvironment,
@@ -351,6 +633,28 @@ func loadConfig() (*Config, error) {
 			OpenTimeout:        *zipOpenTimeout,
 			AllowedPaths:       []string{*pagesRoot},
 			HTTPClientTimeout:  *zipHTTPClientTimeout,
+			DiskCacheDir:       *zipDiskCacheDir,
+			DiskCacheMaxSize:   *zipDiskCacheMaxSize,
+			MemoryBudget:       *zipCacheMemoryBudget,
+			EvictionPolicy:     *zipCacheEvictPolicy,
+			ClientTLS: ClientTLS{
+				CertFile: *zipHTTPClientTLSCert,
+				KeyFile:  *zipHTTPClientTLSKey,
+				CAFile:   *zipHTTPClientTLSCAFile,
+			},
+		},
+		Compression: Compression{
+			Dynamic:   *compressionDynamic,
+			MinSize:   *compressionMinSize,
+			CacheSize: *compressionCacheSize,
+		},
+		CacheControl: CacheControl{
+			MaxAge:                   *cacheControlMaxAge,
+			HTMLMaxAge:               *cacheControlHTMLMaxAge,
+			HTMLStaleWhileRevalidate: *cacheControlHTMLStaleWhileRevalidate,
+			ImmutableMaxAge:          *cacheControlImmutableMaxAge,
+			FingerprintPattern:       *cacheControlFingerprintPattern,
+			AccessControl:            *cacheControlAccessControl,
 		},
 		Server: Server{
 			ReadTimeout:       *serverReadTimeout,
@@ -396,6 +700,10 @@ func loadConfig() (*Config, error) {
 
 	config.General.CustomHeaders = customHeaders
 
+	if config.CacheControl.ContentTypes, err = parseCacheControlContentTypes(cacheControlContentType.Split()); err != nil {
+		return nil, fmt.Errorf("unable to parse cache-control-content-type: %w", err)
+	}
+
 	// Populating remaining GitLab settings
 	config.GitLab.PublicServer = *publicGitLabServer
 
@@ -412,73 +720,129 @@ func loadConfig() (*Config, error) {
 
 func logFields(config *Config) map[string]any {
 	return map[string]any{
-		"artifacts-server":               config.ArtifactsServer.URL,
-		"artifacts-server-timeout":       *artifactsServerTimeout,
-		"default-config-filename":        flag.DefaultConfigFlagname,
-		"disable-cross-origin-requests":  *disableCrossOriginRequests,
-		"domain":                         config.General.Domain,
-		"insecure-ciphers":               config.General.InsecureCiphers,
-		"listen-http":                    listenHTTP,
-		"listen-https":                   listenHTTPS,
-		"listen-proxy":                   listenProxy,
-		"listen-https-proxyv2":           listenHTTPSProxyv2,
-		"log-format":                     config.Log.Format,
-		"log-verbose":                    config.Log.Verbose,
-		"metrics-address":                *metricsAddress,
-		"metrics-certificate":            *metricsCertificate,
-		"metrics-key":                    *metricsKey,
-		"pages-domain":                   *pagesDomain,
-		"pages-root":                     *pagesRoot,
-		"pages-status":                   *pagesStatus,
-		"propagate-correlation-id":       *propagateCorrelationID,
-		"redirect-http":                  config.General.RedirectHTTP,
-		"root-cert":                      *pagesRootKey,
-		"root-key":                       *pagesRootCert,
-		"status_path":                    config.General.StatusPath,
-		"tls-min-version":                *tlsMinVersion,
-		"tls-max-version":                *tlsMaxVersion,
-		"gitlab-server":                  config.GitLab.PublicServer,
-		"internal-gitlab-server":         config.GitLab.InternalServer,
-		"api-secret-key":                 *gitLabAPISecretKey,
-		"enable-disk":                    config.GitLab.EnableDisk,
-		"auth-redirect-uri":              config.Authentication.RedirectURI,
-		"auth-scope":                     config.Authentication.Scope,
-		"auth-cookie-session-timeout":    config.Authentication.CookieSessionTimeout,
-		"auth-timeout":                   config.Authentication.Timeout,
-		"max-conns":                      config.General.MaxConns,
-		"max-uri-length":                 config.General.MaxURILength,
-		"zip-cache-expiration":           config.Zip.ExpirationInterval,
-		"zip-cache-cleanup":              config.Zip.CleanupInterval,
-		"zip-cache-refresh":              config.Zip.RefreshInterval,
-		"zip-open-timeout":               config.Zip.OpenTimeout,
-		"zip-http-client-timeout":        config.Zip.HTTPClientTimeout,
-		"rate-limit-source-ip":           config.RateLimit.SourceIPLimitPerSecond,
-		"rate-limit-source-ip-burst":     config.RateLimit.SourceIPBurst,
-		"rate-limit-domain":              config.RateLimit.DomainLimitPerSecond,
-		"rate-limit-domain-burst":        config.RateLimit.DomainBurst,
-		"rate-limit-tls-source-ip":       config.RateLimit.TLSSourceIPLimitPerSecond,
-		"rate-limit-tls-source-ip-burst": config.RateLimit.TLSSourceIPBurst,
-		"rate-limit-tls-domain":          config.RateLimit.TLSDomainLimitPerSecond,
-		"rate-limit-tls-domain-burst":    config.RateLimit.TLSDomainBurst,
-		"gitlab-client-http-timeout":     config.GitLab.ClientHTTPTimeout,
-		"gitlab-client-jwt-expiry":       config.GitLab.JWTTokenExpiration,
-		"gitlab-cache-expiry":            config.GitLab.Cache.CacheExpiry,
-		"gitlab-cache-refresh":           config.GitLab.Cache.CacheCleanupInterval,
-		"gitlab-cache-cleanup":           config.GitLab.Cache.EntryRefreshTimeout,
-		"gitlab-retrieval-timeout":       config.GitLab.Cache.RetrievalTimeout,
-		"gitlab-retrieval-interval":      config.GitLab.Cache.MaxRetrievalInterval,
-		"gitlab-retrieval-retries":       config.GitLab.Cache.MaxRetrievalRetries,
-		"redirects-max-config-size":      config.Redirects.MaxConfigSize,
-		"redirects-max-path-segments":    config.Redirects.MaxPathSegments,
-		"redirects-max-rule-count":       config.Redirects.MaxRuleCount,
-		"server-read-timeout":            config.Server.ReadTimeout,
-		"server-read-header-timeout":     config.Server.ReadHeaderTimeout,
-		"server-write-timeout":           config.Server.WriteTimeout,
-		"server-keep-alive":              config.Server.ListenKeepAlive,
-		"server-shutdown-timeout":        config.General.ServerShutdownTimeout,
-		"sentry-dsn":                     config.Sentry.DSN,
-		"sentry-environment":             config.Sentry.Environment,
-		"version":                        config.General.ShowVersion,
+		"artifacts-server":                          config.ArtifactsServer.URL,
+		"artifacts-server-timeout":                  *artifactsServerTimeout,
+		"default-config-filename":                   flag.DefaultConfigFlagname,
+		"disable-cross-origin-requests":             *disableCrossOriginRequests,
+		"domain":                                    config.General.Domain,
+		"domain-config-source":                      config.Domains.Source,
+		"domain-config-static-file":                 config.Domains.StaticFile,
+		"domain-config-disk-rescan-interval":        config.Domains.DiskRescanInterval,
+		"domain-config-on-not-found":                config.Domains.OnNotFound,
+		"domain-config-on-error":                    config.Domains.OnError,
+		"insecure-ciphers":                          config.General.InsecureCiphers,
+		"listen-http":                               listenHTTP,
+		"listen-https":                              listenHTTPS,
+		"listen-proxy":                              listenProxy,
+		"listen-https-proxyv2":                      listenHTTPSProxyv2,
+		"log-format":                                config.Log.Format,
+		"log-verbose":                               config.Log.Verbose,
+		"metrics-address":                           *metricsAddress,
+		"metrics-certificate":                       *metricsCertificate,
+		"metrics-key":                               *metricsKey,
+		"pages-domain":                              *pagesDomain,
+		"pages-root":                                *pagesRoot,
+		"pages-status":                              *pagesStatus,
+		"pages-readiness":                           *pagesReadiness,
+		"pages-invalidation":                        *pagesInvalidation,
+		"propagate-correlation-id":                  *propagateCorrelationID,
+		"redirect-http":                             config.General.RedirectHTTP,
+		"root-cert":                                 *pagesRootKey,
+		"root-key":                                  *pagesRootCert,
+		"status_path":                               config.General.StatusPath,
+		"tls-min-version":                           *tlsMinVersion,
+		"tls-max-version":                           *tlsMaxVersion,
+		"gitlab-server":                             config.GitLab.PublicServer,
+		"internal-gitlab-server":                    config.GitLab.InternalServer,
+		"api-secret-key":                            *gitLabAPISecretKey,
+		"api-secret-keys-dir":                       *gitLabAPISecretKeysDir,
+		"api-secret-keys-reload-interval":           config.GitLab.APISecretKeysReloadInterval,
+		"enable-disk":                               config.GitLab.EnableDisk,
+		"auth-redirect-uri":                         config.Authentication.RedirectURI,
+		"auth-scope":                                config.Authentication.Scope,
+		"auth-cookie-session-timeout":               config.Authentication.CookieSessionTimeout,
+		"auth-timeout":                              config.Authentication.Timeout,
+		"max-conns":                                 config.General.MaxConns,
+		"max-uri-length":                            config.General.MaxURILength,
+		"zip-cache-expiration":                      config.Zip.ExpirationInterval,
+		"zip-cache-cleanup":                         config.Zip.CleanupInterval,
+		"zip-cache-refresh":                         config.Zip.RefreshInterval,
+		"zip-open-timeout":                          config.Zip.OpenTimeout,
+		"zip-http-client-timeout":                   config.Zip.HTTPClientTimeout,
+		"zip-disk-cache-dir":                        config.Zip.DiskCacheDir,
+		"zip-disk-cache-max-size":                   config.Zip.DiskCacheMaxSize,
+		"zip-cache-memory-budget":                   config.Zip.MemoryBudget,
+		"zip-cache-eviction-policy":                 config.Zip.EvictionPolicy,
+		"compression-dynamic":                       config.Compression.Dynamic,
+		"compression-min-size":                      config.Compression.MinSize,
+		"compression-cache-size":                    config.Compression.CacheSize,
+		"cache-control-max-age":                     config.CacheControl.MaxAge,
+		"cache-control-html-max-age":                config.CacheControl.HTMLMaxAge,
+		"cache-control-html-stale-while-revalidate": config.CacheControl.HTMLStaleWhileRevalidate,
+		"cache-control-immutable-max-age":           config.CacheControl.ImmutableMaxAge,
+		"cache-control-fingerprint-pattern":         config.CacheControl.FingerprintPattern,
+		"cache-control-content-type":                config.CacheControl.ContentTypes,
+		"cache-control-access-control":              config.CacheControl.AccessControl,
+		"gitlab-client-tls-cert":                    config.GitLab.ClientTLS.CertFile,
+		"gitlab-client-tls-key":                     config.GitLab.ClientTLS.KeyFile,
+		"gitlab-client-tls-ca-file":                 config.GitLab.ClientTLS.CAFile,
+		"zip-http-client-tls-cert":                  config.Zip.ClientTLS.CertFile,
+		"zip-http-client-tls-key":                   config.Zip.ClientTLS.KeyFile,
+		"zip-http-client-tls-ca-file":               config.Zip.ClientTLS.CAFile,
+		"artifacts-server-tls-cert":                 config.ArtifactsServer.ClientTLS.CertFile,
+		"artifacts-server-tls-key":                  config.ArtifactsServer.ClientTLS.KeyFile,
+		"artifacts-server-tls-ca-file":              config.ArtifactsServer.ClientTLS.CAFile,
+		"auth-tls-cert":                             config.Authentication.ClientTLS.CertFile,
+		"auth-tls-key":                              config.Authentication.ClientTLS.KeyFile,
+		"auth-tls-ca-file":                          config.Authentication.ClientTLS.CAFile,
+		"rate-limit-source-ip":                      config.RateLimit.SourceIPLimitPerSecond,
+		"rate-limit-source-ip-burst":                config.RateLimit.SourceIPBurst,
+		"rate-limit-domain":                         config.RateLimit.DomainLimitPerSecond,
+		"rate-limit-domain-burst":                   config.RateLimit.DomainBurst,
+		"rate-limit-tls-source-ip":                  config.RateLimit.TLSSourceIPLimitPerSecond,
+		"rate-limit-tls-source-ip-burst":            config.RateLimit.TLSSourceIPBurst,
+		"rate-limit-tls-domain":                     config.RateLimit.TLSDomainLimitPerSecond,
+		"rate-limit-tls-domain-burst":               config.RateLimit.TLSDomainBurst,
+		"gitlab-client-http-timeout":                config.GitLab.ClientHTTPTimeout,
+		"gitlab-client-jwt-expiry":                  config.GitLab.JWTTokenExpiration,
+		"gitlab-cache-expiry":                       config.GitLab.Cache.CacheExpiry,
+		"gitlab-cache-refresh":                      config.GitLab.Cache.CacheCleanupInterval,
+		"gitlab-cache-cleanup":                      config.GitLab.Cache.EntryRefreshTimeout,
+		"gitlab-retrieval-timeout":                  config.GitLab.Cache.RetrievalTimeout,
+		"gitlab-retrieval-interval":                 config.GitLab.Cache.MaxRetrievalInterval,
+		"gitlab-retrieval-retries":                  config.GitLab.Cache.MaxRetrievalRetries,
+		"gitlab-cache-stale-if-error":               config.GitLab.Cache.StaleIfError,
+		"gitlab-cache-snapshot-file":                config.GitLab.Cache.SnapshotFile,
+		"gitlab-cache-snapshot-interval":            config.GitLab.Cache.SnapshotInterval,
+		"gitlab-cache-snapshot-max-age":             config.GitLab.Cache.SnapshotMaxAge,
+		"gitlab-cache-warm-up":                      config.GitLab.Cache.WarmUp,
+		"gitlab-cache-warm-up-limit":                config.GitLab.Cache.WarmUpLimit,
+		"gitlab-cache-warm-up-timeout":              config.GitLab.Cache.WarmUpTimeout,
+		"gitlab-cache-subscribe":                    config.GitLab.Cache.Subscribe,
+		"gitlab-cache-resubscribe-interval":         config.GitLab.Cache.ResubscribeInterval,
+		"gitlab-circuit-breaker":                    config.GitLab.CircuitBreaker.Enabled,
+		"gitlab-circuit-breaker-failures":           config.GitLab.CircuitBreaker.ConsecutiveFailures,
+		"gitlab-circuit-breaker-failure-ratio":      config.GitLab.CircuitBreaker.FailureRatio,
+		"gitlab-circuit-breaker-min-requests":       config.GitLab.CircuitBreaker.MinRequests,
+		"gitlab-circuit-breaker-window":             config.GitLab.CircuitBreaker.Window,
+		"gitlab-circuit-breaker-open-timeout":       config.GitLab.CircuitBreaker.OpenTimeout,
+		"gitlab-circuit-breaker-half-open-requests": config.GitLab.CircuitBreaker.HalfOpenRequests,
+		"gitlab-cache-store":                        config.GitLab.Cache.Store,
+		"gitlab-cache-redis-key-prefix":             config.GitLab.Cache.RedisKeyPrefix,
+		"redirects-max-config-size":                 config.Redirects.MaxConfigSize,
+		"redirects-max-path-segments":               config.Redirects.MaxPathSegments,
+		"redirects-max-rule-count":                  config.Redirects.MaxRuleCount,
+		"headers-max-config-size":                   config.Headers.MaxConfigSize,
+		"headers-max-path-segments":                 config.Headers.MaxPathSegments,
+		"headers-max-rule-count":                    config.Headers.MaxRuleCount,
+		"server-read-timeout":                       config.Server.ReadTimeout,
+		"server-read-header-timeout":                config.Server.ReadHeaderTimeout,
+		"server-write-timeout":                      config.Server.WriteTimeout,
+		"server-keep-alive":                         config.Server.ListenKeepAlive,
+		"server-shutdown-timeout":                   config.General.ServerShutdownTimeout,
+		"sentry-dsn":                                config.Sentry.DSN,
+		"sentry-environment":                        config.Sentry.Environment,
+		"version":                                   config.General.ShowVersion,
 	}
 }
 
@@ -184,3 +184,67 @@ func TestParseHeaderString(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCacheControlContentTypes(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentTypes []string
+		expected     map[string]string
+		expectedErr  error
+	}{
+		{
+			name:         "media types",
+			contentTypes: []string{"text/css: max-age=3600", " Image/* : public, max-age=86400"},
+			expected:     map[string]string{"text/css": "max-age=3600", "image/*": "public, max-age=86400"},
+		},
+		{
+			name:         "no media types",
+			contentTypes: nil,
+			expected:     map[string]string{},
+		},
+		{
+			name:         "no directives",
+			contentTypes: []string{"text/css:"},
+			expectedErr:  errInvalidCacheControlContentType,
+		},
+		{
+			name:         "no subtype",
+			contentTypes: []string{"text: max-age=3600"},
+			expectedErr:  errInvalidCacheControlContentType,
+		},
+		{
+			name:         "duplicate media types",
+			contentTypes: []string{"text/css: max-age=3600", "TEXT/CSS: no-cache"},
+			expectedErr:  errDuplicateCacheControlContentType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCacheControlContentTypes(tt.contentTypes)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestDomainsPolicies(t *testing.T) {
+	domains := Domains{
+		Source:     "static, gitlab,disk",
+		OnNotFound: "static=stop",
+		OnError:    " gitlab = continue ",
+	}
+
+	require.Equal(t, []string{"static", "gitlab", "disk"}, domains.Sources())
+
+	require.False(t, domains.ContinueOnNotFound("static"))
+	require.True(t, domains.ContinueOnNotFound("gitlab"))
+
+	require.True(t, domains.ContinueOnError("gitlab"))
+	require.False(t, domains.ContinueOnError("static"))
+}
@@ -36,36 +36,70 @@ var (
 	rateLimitTLSDomain        = flag.Float64("rate-limit-tls-domain", 0.0, "Rate limit new TLS connections per second from to a single domain, 0 means is disabled")
 	rateLimitTLSDomainBurst   = flag.Int("rate-limit-tls-domain-burst", 100, "Rate limit new TLS connections from a single domain, maximum burst allowed per second")
 
-	artifactsServer         = flag.String("artifacts-server", "", "API URL to proxy artifact requests to, e.g.: 'https://gitlab.com/api/v4'")
-	artifactsServerTimeout  = flag.Int("artifacts-server-timeout", 10, "Timeout (in seconds) for a proxied request to the artifacts server")
-	pagesStatus             = flag.String("pages-status", "", "The url path for a status page, e.g., /@status")
-	metricsAddress          = flag.String("metrics-address", "", "The address to listen on for metrics requests")
-	metricsCertificate      = flag.String("metrics-certificate", "", "The default path to file certificate to serve metrics requests")
-	metricsKey              = flag.String("metrics-key", "", "The default path to file private key to serve metrics requests")
-	sentryDSN               = flag.String("sentry-dsn", "", "The address for sending sentry crash reporting to")
-	sentryEnvironment       = flag.String("sentry-environment", "", "The environment for sentry crash reporting")
-	propagateCorrelationID  = flag.Bool("propagate-correlation-id", true, "Reuse existing Correlation-ID from the incoming request header `X-Request-ID` if present")
-	serverShutdownTimeout   = flag.Duration("server-shutdown-timeout", 30*time.Second, "GitLab Pages server shutdown timeout (default: 30s)")
-	logFormat               = flag.String("log-format", "json", "The log output format: 'text' or 'json'")
-	logVerbose              = flag.Bool("log-verbose", false, "Verbose logging")
-	secret                  = flag.String("auth-secret", "", "Cookie store hash key, should be at least 32 bytes long")
-	publicGitLabServer      = flag.String("gitlab-server", "", "Public GitLab server, for example https://www.gitlab.com")
-	internalGitLabServer    = flag.String("internal-gitlab-server", "", "Internal GitLab server used for API requests, useful if you want to send that traffic over an internal load balancer, example value https://gitlab.example.internal (defaults to value of gitlab-server)")
-	gitLabAPISecretKey      = flag.String("api-secret-key", "", "File with secret key used to authenticate with the GitLab API")
-	gitlabClientHTTPTimeout = flag.Duration("gitlab-client-http-timeout", 10*time.Second, "GitLab API HTTP client connection timeout in seconds (default: 10s)")
-	gitlabClientJWTExpiry   = flag.Duration("gitlab-client-jwt-expiry", 30*time.Second, "JWT Token expiry time in seconds (default: 30s)")
-	gitlabCacheExpiry       = flag.Duration("gitlab-cache-expiry", 10*time.Minute, "The maximum time a domain's configuration is stored in the cache")
-	gitlabCacheRefresh      = flag.Duration("gitlab-cache-refresh", time.Minute, "The interval at which a domain's configuration is set to be due to refresh")
-	gitlabCacheCleanup      = flag.Duration("gitlab-cache-cleanup", time.Minute, "The interval at which expired items are removed from the cache")
-	gitlabRetrievalTimeout  = flag.Duration("gitlab-retrieval-timeout", 30*time.Second, "The maximum time to wait for a response from the GitLab API per request")
-	gitlabRetrievalInterval = flag.Duration("gitlab-retrieval-interval", time.Second, "The interval to wait before retrying to resolve a domain's configuration via the GitLab API")
-	gitlabRetrievalRetries  = flag.Int("gitlab-retrieval-retries", 3, "The maximum number of times to retry to resolve a domain's configuration via the API")
+	artifactsServer             = flag.String("artifacts-server", "", "API URL to proxy artifact requests to, e.g.: 'https://gitlab.com/api/v4'")
+	artifactsServerTimeout      = flag.Int("artifacts-server-timeout", 10, "Timeout (in seconds) for a proxied request to the artifacts server")
+	pagesStatus                 = flag.String("pages-status", "", "The url path for a status page, e.g., /@status")
+	pagesReadiness              = flag.String("pages-readiness", "", "The url path for a readiness check, which reports 'warming' until the domains configuration cache is warmed up, e.g., /@ready")
+	pagesInvalidation           = flag.String("pages-invalidation", "", "The url path GitLab calls to invalidate the cached configuration of domains and projects, e.g., /@invalidate. Requests are authenticated with a JWT signed with the API secret")
+	metricsAddress              = flag.String("metrics-address", "", "The address to listen on for metrics requests")
+	metricsCertificate          = flag.String("metrics-certificate", "", "The default path to file certificate to serve metrics requests")
+	metricsKey                  = flag.String("metrics-key", "", "The default path to file private key to serve metrics requests")
+	sentryDSN                   = flag.String("sentry-dsn", "", "The address for sending sentry crash reporting to")
+	sentryEnvironment           = flag.String("sentry-environment", "", "The environment for sentry crash reporting")
+	propagateCorrelationID      = flag.Bool("propagate-correlation-id", true, "Reuse existing Correlation-ID from the incoming request header `X-Request-ID` if present")
+	serverShutdownTimeout       = flag.Duration("server-shutdown-timeout", 30*time.Second, "GitLab Pages server shutdown timeout (default: 30s)")
+	logFormat                   = flag.String("log-format", "json", "The log output format: 'text' or 'json'")
+	logVerbose                  = flag.Bool("log-verbose", false, "Verbose logging")
+	secret                      = flag.String("auth-secret", "", "Cookie store hash key, should be at least 32 bytes long")
+	publicGitLabServer          = flag.String("gitlab-server", "", "Public GitLab server, for example https://www.gitlab.com")
+	internalGitLabServer        = flag.String("internal-gitlab-server", "", "Internal GitLab server used for API requests, useful if you want to send that traffic over an internal load balancer, example value https://gitlab.example.internal (defaults to value of gitlab-server)")
+	gitLabAPISecretKey          = flag.String("api-secret-key", "", "File with secret key used to authenticate with the GitLab API")
+	gitLabAPISecretKeysDir      = flag.String("api-secret-keys-dir", "", "Directory with the keys used to sign the requests to the GitLab API, named after their key ID and rotated by changing the key ID in its signing-key-id file. Takes precedence over api-secret-key to sign the requests, api-secret-key is still required to encrypt the domains cache and to authenticate invalidation requests")
+	gitLabAPISecretKeysReload   = flag.Duration("api-secret-keys-reload-interval", 30*time.Second, "The interval at which api-secret-keys-dir is checked for changes")
+	gitlabClientHTTPTimeout     = flag.Duration("gitlab-client-http-timeout", 10*time.Second, "GitLab API HTTP client connection timeout in seconds (default: 10s)")
+	gitlabClientJWTExpiry       = flag.Duration("gitlab-client-jwt-expiry", 30*time.Second, "JWT Token expiry time in seconds (default: 30s)")
+	gitlabCacheExpiry           = flag.Duration("gitlab-cache-expiry", 10*time.Minute, "The maximum time a domain's configuration is stored in the cache")
+	gitlabCacheRefresh          = flag.Duration("gitlab-cache-refresh", time.Minute, "The interval at which a domain's configuration is set to be due to refresh")
+	gitlabCacheCleanup          = flag.Duration("gitlab-cache-cleanup", time.Minute, "The interval at which expired items are removed from the cache")
+	gitlabRetrievalTimeout      = flag.Duration("gitlab-retrieval-timeout", 30*time.Second, "The maximum time to wait for a response from the GitLab API per request")
+	gitlabRetrievalInterval     = flag.Duration("gitlab-retrieval-interval", time.Second, "The interval to wait before retrying to resolve a domain's configuration via the GitLab API")
+	gitlabRetrievalRetries      = flag.Int("gitlab-retrieval-retries", 3, "The maximum number of times to retry to resolve a domain's configuration via the API")
+	gitlabCacheSnapshotFile     = flag.String("gitlab-cache-snapshot-file", "", "File to persist the domains configuration cache to, encrypted with the API secret. It is restored on startup to serve domains while the GitLab API is unavailable")
+	gitlabCacheStaleIfError     = flag.Duration("gitlab-cache-stale-if-error", 0, "The maximum time a domain's configuration is served past gitlab-cache-expiry while the GitLab API fails to refresh it. 0 disables serving stale configuration")
+	gitlabCacheStore            = flag.String("gitlab-cache-store", "memory", "The store of the domains configuration cache: 'memory' or 'redis' to share it between replicas, encrypted with the API secret")
+	gitlabCacheRedisURL         = flag.String("gitlab-cache-redis-url", "", "The URL of the Redis server when gitlab-cache-store is 'redis', e.g. redis://:password@localhost:6379/0")
+	gitlabCacheRedisKeyPrefix   = flag.String("gitlab-cache-redis-key-prefix", "gitlab-pages:domains:", "The prefix of the Redis keys of the domains configuration cache")
+	gitlabCacheSnapshotInterval = flag.Duration("gitlab-cache-snapshot-interval", time.Minute, "The interval at which the domains configuration cache is persisted to gitlab-cache-snapshot-file")
+	gitlabCacheSnapshotMaxAge   = flag.Duration("gitlab-cache-snapshot-max-age", 24*time.Hour, "The maximum age of the gitlab-cache-snapshot-file restored on startup. 0 restores it regardless of its age")
+	gitlabCacheWarmUp           = flag.Bool("gitlab-cache-warm-up", false, "Populate the domains configuration cache with the domains listed by the GitLab API on startup, before serving requests. The readiness check of pages-readiness reports 'warming' until it finishes")
+	gitlabCacheWarmUpLimit      = flag.Int("gitlab-cache-warm-up-limit", 0, "The maximum number of domains, from the most active one, cached on startup. 0 caches all the domains")
+	gitlabCacheWarmUpTimeout    = flag.Duration("gitlab-cache-warm-up-timeout", 5*time.Minute, "The maximum time to warm up the domains configuration cache on startup")
+	gitlabCacheSubscribe        = flag.Bool("gitlab-cache-subscribe", false, "Stream the changes of the domains configuration from the GitLab API into the cache, on top of refreshing it by polling")
+	gitlabCacheResubscribe      = flag.Duration("gitlab-cache-resubscribe-interval", 5*time.Second, "The interval to wait before resuming the domain events subscription after a disconnect")
+
+	gitlabCircuitBreaker                 = flag.Bool("gitlab-circuit-breaker", false, "Reject the GitLab API calls for gitlab-circuit-breaker-open-timeout once they keep failing, instead of retrying them")
+	gitlabCircuitBreakerFailures         = flag.Int("gitlab-circuit-breaker-failures", 10, "The number of consecutive failed GitLab API calls that opens the circuit breaker. 0 disables this condition")
+	gitlabCircuitBreakerFailureRatio     = flag.Float64("gitlab-circuit-breaker-failure-ratio", 0.5, "The ratio of failed GitLab API calls within gitlab-circuit-breaker-window that opens the circuit breaker. 0 disables this condition")
+	gitlabCircuitBreakerMinRequests      = flag.Int("gitlab-circuit-breaker-min-requests", 20, "The minimum number of GitLab API calls within gitlab-circuit-breaker-window before gitlab-circuit-breaker-failure-ratio applies")
+	gitlabCircuitBreakerWindow           = flag.Duration("gitlab-circuit-breaker-window", 10*time.Second, "The interval over which the ratio of failed GitLab API calls is measured")
+	gitlabCircuitBreakerOpenTimeout      = flag.Duration("gitlab-circuit-breaker-open-timeout", 30*time.Second, "The time the circuit breaker stays open before probing the GitLab API again")
+	gitlabCircuitBreakerHalfOpenRequests = flag.Int("gitlab-circuit-breaker-half-open-requests", 1, "The number of GitLab API calls allowed to probe the API while the circuit breaker is half-open")
 
 	// Check https://gitlab.com/gitlab-org/gitlab-pages/-/issues/472 before increasing default redirectsMaxConfigSize value
 	redirectsMaxConfigSize   = flag.Int("redirects-max-config-size", 64*1024, "The maximum size of the _redirects file, in bytes")
 	redirectsMaxPathSegments = flag.Int("redirects-max-path-segments", 25, "The maximum number of path segments allowed in _redirects rules URLs")
 	redirectsMaxRuleCount    = flag.Int("redirects-max-rule-count", 1000, "The maximum number of rules allowed in _redirects")
 
+	headersMaxConfigSize   = flag.Int("headers-max-config-size", 64*1024, "The maximum size of the _headers file, in bytes")
+	headersMaxPathSegments = flag.Int("headers-max-path-segments", 25, "The maximum number of path segments allowed in _headers rules paths")
+	headersMaxRuleCount    = flag.Int("headers-max-rule-count", 1000, "The maximum number of rules allowed in _headers")
+
+	domainConfigSource             = flag.String("domain-config-source", "gitlab", "Domain configuration source: 'gitlab' to use the GitLab internal API, 'static' to read domains from domain-config-static-file or 'disk' to discover them from pages-root. A comma-separated list of sources, e.g. 'static,gitlab,disk', queries them in order")
+	domainConfigStaticFile         = flag.String("domain-config-static-file", "", "YAML or JSON file with the virtual domains to serve when domain-config-source is 'static'. The file is reloaded when it changes")
+	domainConfigDiskRescanInterval = flag.Duration("domain-config-disk-rescan-interval", time.Minute, "Interval to rescan pages-root when domain-config-source is 'disk', for file systems that do not support inotify like NFS. 0 disables periodic rescans")
+	domainConfigOnNotFound         = flag.String("domain-config-on-not-found", "", "Comma-separated source=continue or source=stop policies for a domain not found by a source of domain-config-source, e.g. 'static=stop'. Sources continue to the next one by default")
+	domainConfigOnError            = flag.String("domain-config-on-error", "", "Comma-separated source=continue or source=stop policies for a source of domain-config-source failing, e.g. 'gitlab=continue'. Sources stop with the error by default")
+
 	enableDisk = flag.Bool("enable-disk", true, "Enable disk access, shall be disabled in environments where shared disk storage isn't available")
 
 	clientID                 = flag.String("auth-client-id", "", "GitLab application Client ID")
@@ -85,6 +119,34 @@ var (
 	zipCacheRefresh      = flag.Duration("zip-cache-refresh", 30*time.Second, "Zip serving archive cache refresh interval")
 	zipOpenTimeout       = flag.Duration("zip-open-timeout", 30*time.Second, "Zip archive open timeout")
 	zipHTTPClientTimeout = flag.Duration("zip-http-client-timeout", 30*time.Minute, "Zip HTTP client timeout")
+	zipDiskCacheDir      = flag.String("zip-disk-cache-dir", "", "Directory of the local disk cache of zip archives, which keeps the archives opened from object storage across restarts and cache expirations. Disabled when empty")
+	zipDiskCacheMaxSize  = flag.Int64("zip-disk-cache-max-size", 10*1024*1024*1024, "Maximum size of the zip-disk-cache-dir in bytes, the least recently used archives are evicted above it")
+	zipCacheMemoryBudget = flag.Int64("zip-cache-memory-budget", 0, "Estimated memory in bytes the zip archives cache can use before archives are evicted, 0 for no limit")
+	zipCacheEvictPolicy  = flag.String("zip-cache-eviction-policy", ZipEvictionLRU, "Policy evicting the zip archives above zip-cache-memory-budget: lru (least recently used) or lfu (least frequently used)")
+	compressionDynamic   = flag.Bool("compression-dynamic", false, "Compress files of compressible types on the fly with gzip or brotli when they have no precompressed .gz or .br variant")
+	compressionMinSize   = flag.Int64("compression-min-size", 1024, "Minimum size in bytes of the files compressed on the fly")
+	compressionCacheSize = flag.Int64("compression-cache-size", 64*1024*1024, "Maximum size in bytes of the files compressed on the fly kept in memory, the least recently used ones are evicted above it")
+
+	cacheControlMaxAge                   = flag.Duration("cache-control-max-age", 10*time.Minute, "The max-age of the Cache-Control of the files without a more specific policy")
+	cacheControlHTMLMaxAge               = flag.Duration("cache-control-html-max-age", 10*time.Minute, "The max-age of the Cache-Control of the HTML pages")
+	cacheControlHTMLStaleWhileRevalidate = flag.Duration("cache-control-html-stale-while-revalidate", 0, "The stale-while-revalidate of the Cache-Control of the HTML pages, 0 to disable it")
+	cacheControlImmutableMaxAge          = flag.Duration("cache-control-immutable-max-age", 365*24*time.Hour, "The max-age of the immutable Cache-Control of the fingerprinted assets")
+	cacheControlAccessControl            = flag.String("cache-control-access-control", "", "The Cache-Control directives of the files of access controlled projects, e.g. 'private, no-cache' to only let the clients cache them and revalidate them on every request. Not set when empty")
+	cacheControlFingerprintPattern       = flag.String("cache-control-fingerprint-pattern", "", "Regular expression matching the paths of the fingerprinted assets, which are served as immutable, e.g. '[.-][0-9a-f]{8,}\\.[0-9a-z]+$' for app.3f2a1b9c.js. HTML pages are never fingerprinted assets. Disabled when empty")
+
+	// Client certificates and CA certificates of the outbound connections
+	gitlabClientTLSCert      = flag.String("gitlab-client-tls-cert", "", clientTLSFlagUsage("client certificate", "GitLab API"))
+	gitlabClientTLSKey       = flag.String("gitlab-client-tls-key", "", clientTLSFlagUsage("client certificate key", "GitLab API"))
+	gitlabClientTLSCAFile    = flag.String("gitlab-client-tls-ca-file", "", clientTLSFlagUsage("CA certificates", "GitLab API"))
+	zipHTTPClientTLSCert     = flag.String("zip-http-client-tls-cert", "", clientTLSFlagUsage("client certificate", "zip archives storage"))
+	zipHTTPClientTLSKey      = flag.String("zip-http-client-tls-key", "", clientTLSFlagUsage("client certificate key", "zip archives storage"))
+	zipHTTPClientTLSCAFile   = flag.String("zip-http-client-tls-ca-file", "", clientTLSFlagUsage("CA certificates", "zip archives storage"))
+	artifactsServerTLSCert   = flag.String("artifacts-server-tls-cert", "", clientTLSFlagUsage("client certificate", "artifacts server"))
+	artifactsServerTLSKey    = flag.String("artifacts-server-tls-key", "", clientTLSFlagUsage("client certificate key", "artifacts server"))
+	artifactsServerTLSCAFile = flag.String("artifacts-server-tls-ca-file", "", clientTLSFlagUsage("CA certificates", "artifacts server"))
+	authTLSCert              = flag.String("auth-tls-cert", "", clientTLSFlagUsage("client certificate", "GitLab authentication API"))
+	authTLSKey               = flag.String("auth-tls-key", "", clientTLSFlagUsage("client certificate key", "GitLab authentication API"))
+	authTLSCAFile            = flag.String("auth-tls-ca-file", "", clientTLSFlagUsage("CA certificates", "GitLab authentication API"))
 
 	// HTTP server timeouts
 	serverReadTimeout       = flag.Duration("server-read-timeout", 5*time.Second, "ReadTimeout is the maximum duration for reading the entire request, including the body. A zero or negative value means there will be no timeout.")
@@ -104,12 +166,15 @@ var (
 
 	header = MultiStringFlag{separator: ";;"}
 
+	cacheControlContentType = MultiStringFlag{separator: ";;"}
+
 	// flags that won't be logged to the output on Pages boot
 	nonLoggableFlags = map[string]bool{
-		"auth-client-id":     true,
-		"auth-client-secret": true,
-		"auth-secret":        true,
-		"use-http2":          true,
+		"auth-client-id":         true,
+		"auth-client-secret":     true,
+		"auth-secret":            true,
+		"gitlab-cache-redis-url": true,
+		"use-http2":              true,
 	}
 )
 
@@ -120,6 +185,7 @@ func initFlags() {
 	flag.Var(&listenProxy, "listen-proxy", "The address(es) or unix socket paths to listen on for proxy requests")
 	flag.Var(&listenHTTPSProxyv2, "listen-https-proxyv2", "The address(es) or unix socket paths to listen on for HTTPS PROXYv2 requests (https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt)")
 	flag.Var(&header, "header", "The additional http header(s) that should be send to the client")
+	flag.Var(&cacheControlContentType, "cache-control-content-type", "The Cache-Control directives of a media type or of all the media types of a type, overriding the other policies but the fingerprinted assets one, e.g. 'image/*: max-age=86400'")
 
 	// read from -config=/path/to/gitlab-pages-config
 	flag.String(flag.DefaultConfigFlagname, "", "path to config file")
@@ -140,3 +206,7 @@ func tlsVersionFlagUsage(minOrMax string) string {
 
 	return fmt.Sprintf("Specifies the "+minOrMax+"imum SSL/TLS version, supported values are %s", strings.Join(versions, ", "))
 }
+
+func clientTLSFlagUsage(file, consumer string) string {
+	return fmt.Sprintf("PEM file with the %s used for the connections to the %s, reloaded when it changes", file, consumer)
+}
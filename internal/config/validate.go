@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
 
 	"github.com/hashicorp/go-multierror"
 )
@@ -18,6 +19,36 @@ var (
 	errArtifactsServerUnsupportedScheme = errors.New("artifacts-server scheme must be either http:// or https://")
 	errArtifactsServerInvalidTimeout    = errors.New("artifacts-server-timeout must be greater than or equal to 1")
 	errEmptyListener                    = errors.New("listener must not be empty")
+	errUnknownDomainConfigSource        = errors.New("domain-config-source must be one of 'gitlab', 'static' or 'disk'")
+	errDuplicatedDomainConfigSource     = errors.New("domain-config-source must not list a source more than once")
+	errUnknownDomainConfigPolicySource  = errors.New("domain-config-on-not-found and domain-config-on-error must only define policies of sources listed in domain-config-source")
+	errDomainConfigNoStaticFile         = errors.New("domain-config-static-file must be defined if domain-config-source is 'static'")
+	errDomainConfigDiskDisabled         = errors.New("enable-disk must be true if domain-config-source is 'disk'")
+	errDomainConfigNoPagesDomain        = errors.New("pages-domain must be defined if domain-config-source is 'disk'")
+	errUnknownCacheStore                = errors.New("gitlab-cache-store must be either 'memory' or 'redis'")
+	errCacheNoRedisURL                  = errors.New("gitlab-cache-redis-url must be defined if gitlab-cache-store is 'redis'")
+	errCacheRedisNoAPISecretKey         = errors.New("api-secret-key must be defined if gitlab-cache-store is 'redis'")
+	errCacheInvalidStaleIfError         = errors.New("gitlab-cache-stale-if-error must be greater than or equal to 0")
+	errCacheSnapshotInvalidInterval     = errors.New("gitlab-cache-snapshot-interval must be greater than 0 if gitlab-cache-snapshot-file is defined")
+	errCacheSnapshotNoAPISecretKey      = errors.New("api-secret-key must be defined if gitlab-cache-snapshot-file is defined")
+	errCacheSnapshotInvalidMaxAge       = errors.New("gitlab-cache-snapshot-max-age must not be negative")
+	errCacheWarmUpInvalidLimit          = errors.New("gitlab-cache-warm-up-limit must be greater than or equal to 0")
+	errCacheWarmUpInvalidTimeout        = errors.New("gitlab-cache-warm-up-timeout must be greater than 0 if gitlab-cache-warm-up is enabled")
+	errCircuitBreakerInvalidRatio       = errors.New("gitlab-circuit-breaker-failure-ratio must be between 0 and 1")
+	errCircuitBreakerInvalidWindow      = errors.New("gitlab-circuit-breaker-window must be greater than 0 if gitlab-circuit-breaker-failure-ratio is defined")
+	errCircuitBreakerInvalidTimeout     = errors.New("gitlab-circuit-breaker-open-timeout must be greater than 0 if the circuit breaker is enabled")
+	errCircuitBreakerInvalidProbes      = errors.New("gitlab-circuit-breaker-half-open-requests must be greater than or equal to 1 if the circuit breaker is enabled")
+	errAPISecretKeysInvalidInterval     = errors.New("api-secret-keys-reload-interval must be greater than 0 if api-secret-keys-dir is defined")
+	errCacheSubscriptionInvalidInterval = errors.New("gitlab-cache-resubscribe-interval must be greater than 0 if gitlab-cache-subscribe is enabled")
+	errZipDiskCacheInvalidMaxSize       = errors.New("zip-disk-cache-max-size must be greater than 0 if zip-disk-cache-dir is defined")
+	errZipCacheInvalidMemoryBudget      = errors.New("zip-cache-memory-budget must be greater than or equal to 0")
+	errZipCacheInvalidEvictionPolicy    = errors.New("zip-cache-eviction-policy must be lru or lfu")
+	errCompressionInvalidMinSize        = errors.New("compression-min-size must be greater than or equal to 0")
+	errCompressionInvalidCacheSize      = errors.New("compression-cache-size must be greater than or equal to 0")
+	errCacheControlInvalidMaxAge        = errors.New("cache-control-max-age, cache-control-html-max-age, cache-control-html-stale-while-revalidate and cache-control-immutable-max-age must be greater than or equal to 0")
+	errCacheControlInvalidPattern       = errors.New("cache-control-fingerprint-pattern must be a valid regular expression")
+	errClientTLSIncompleteKeyPair       = errors.New("tls-cert and tls-key must be defined together")
+	errInvalidationNoAPISecretKey       = errors.New("api-secret-key must be defined if pages-invalidation is defined")
 )
 
 // Validate values populated in Config
@@ -28,6 +59,23 @@ func Validate(config *Config) error {
 		validateListeners(config),
 		validateAuthConfig(config),
 		validateArtifactsServerConfig(config),
+		validateDomainsConfig(config),
+		validateCacheSnapshotConfig(config),
+		validateCacheStoreConfig(config),
+		validateCacheStaleIfError(config),
+		validateCircuitBreakerConfig(config),
+		validateCacheWarmUpConfig(config),
+		validateCacheSubscriptionConfig(config),
+		validateAPISecretKeysConfig(config),
+		validateZipDiskCacheConfig(config),
+		validateZipCacheBudgetConfig(config),
+		validateCompressionConfig(config),
+		validateCacheControlConfig(config),
+		validateInvalidationConfig(config),
+		validateClientTLSConfig(config.GitLab.ClientTLS, "gitlab-client"),
+		validateClientTLSConfig(config.Zip.ClientTLS, "zip-http-client"),
+		validateClientTLSConfig(config.ArtifactsServer.ClientTLS, "artifacts-server"),
+		validateClientTLSConfig(config.Authentication.ClientTLS, "auth"),
 		validateTLSVersions(*tlsMinVersion, *tlsMaxVersion),
 	)
 
@@ -114,6 +162,111 @@ func validateArtifactsServerConfig(config *Config) error {
 	return result.ErrorOrNil()
 }
 
+func validateDomainsConfig(config *Config) error {
+	var result *multierror.Error
+
+	sources := make(map[string]bool)
+
+	for _, name := range config.Domains.Sources() {
+		if sources[name] {
+			result = multierror.Append(result, errDuplicatedDomainConfigSource)
+		}
+
+		sources[name] = true
+
+		result = multierror.Append(result, validateDomainSource(config, name))
+	}
+
+	for _, policies := range []string{config.Domains.OnNotFound, config.Domains.OnError} {
+		parsed, err := parseDomainSourcePolicies(policies)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		for name := range parsed {
+			if !sources[name] {
+				result = multierror.Append(result, errUnknownDomainConfigPolicySource)
+			}
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateDomainSource(config *Config, name string) error {
+	switch name {
+	case DomainSourceGitLab:
+		return nil
+	case DomainSourceStatic:
+		if config.Domains.StaticFile == "" {
+			return errDomainConfigNoStaticFile
+		}
+
+		return nil
+	case DomainSourceDisk:
+		var result *multierror.Error
+
+		if !config.GitLab.EnableDisk {
+			result = multierror.Append(result, errDomainConfigDiskDisabled)
+		}
+
+		if config.General.Domain == "" {
+			result = multierror.Append(result, errDomainConfigNoPagesDomain)
+		}
+
+		return result.ErrorOrNil()
+	default:
+		return errUnknownDomainConfigSource
+	}
+}
+
+func validateCacheSnapshotConfig(config *Config) error {
+	if config.GitLab.Cache.SnapshotFile == "" {
+		return nil
+	}
+
+	var result *multierror.Error
+
+	if config.GitLab.Cache.SnapshotInterval <= 0 {
+		result = multierror.Append(result, errCacheSnapshotInvalidInterval)
+	}
+
+	if config.GitLab.Cache.SnapshotMaxAge < 0 {
+		result = multierror.Append(result, errCacheSnapshotInvalidMaxAge)
+	}
+
+	// the snapshot is encrypted with the API secret, which is optional when
+	// the requests are signed with the keys of api-secret-keys-dir
+	if len(config.GitLab.APISecretKey) == 0 {
+		result = multierror.Append(result, errCacheSnapshotNoAPISecretKey)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateCacheStoreConfig(config *Config) error {
+	switch config.GitLab.Cache.Store {
+	case CacheStoreMemory:
+		return nil
+	case CacheStoreRedis:
+		var result *multierror.Error
+
+		if config.GitLab.Cache.RedisURL == "" {
+			result = multierror.Append(result, errCacheNoRedisURL)
+		}
+
+		// the records shared through Redis are encrypted with the API secret
+		if len(config.GitLab.APISecretKey) == 0 {
+			result = multierror.Append(result, errCacheRedisNoAPISecretKey)
+		}
+
+		return result.ErrorOrNil()
+	default:
+		return errUnknownCacheStore
+	}
+}
+
 // validateTLSVersions returns error if the provided TLS versions config values are not valid
 func validateTLSVersions(min, max string) error {
 	tlsMin, tlsMinOk := allTLSVersions[min]
@@ -131,3 +284,141 @@ func validateTLSVersions(min, max string) error {
 
 	return nil
 }
+
+func validateCacheStaleIfError(config *Config) error {
+	if config.GitLab.Cache.StaleIfError < 0 {
+		return errCacheInvalidStaleIfError
+	}
+
+	return nil
+}
+
+func validateCircuitBreakerConfig(config *Config) error {
+	cb := config.GitLab.CircuitBreaker
+	if !cb.Enabled {
+		return nil
+	}
+
+	var result *multierror.Error
+
+	if cb.FailureRatio < 0 || cb.FailureRatio > 1 {
+		result = multierror.Append(result, errCircuitBreakerInvalidRatio)
+	}
+
+	if cb.FailureRatio > 0 && cb.Window <= 0 {
+		result = multierror.Append(result, errCircuitBreakerInvalidWindow)
+	}
+
+	if cb.ConsecutiveFailures <= 0 && cb.FailureRatio <= 0 {
+		return result.ErrorOrNil()
+	}
+
+	if cb.OpenTimeout <= 0 {
+		result = multierror.Append(result, errCircuitBreakerInvalidTimeout)
+	}
+
+	if cb.HalfOpenRequests < 1 {
+		result = multierror.Append(result, errCircuitBreakerInvalidProbes)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateCacheWarmUpConfig(config *Config) error {
+	if !config.GitLab.Cache.WarmUp {
+		return nil
+	}
+
+	var result *multierror.Error
+
+	if config.GitLab.Cache.WarmUpLimit < 0 {
+		result = multierror.Append(result, errCacheWarmUpInvalidLimit)
+	}
+
+	if config.GitLab.Cache.WarmUpTimeout <= 0 {
+		result = multierror.Append(result, errCacheWarmUpInvalidTimeout)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateCacheSubscriptionConfig(config *Config) error {
+	if config.GitLab.Cache.Subscribe && config.GitLab.Cache.ResubscribeInterval <= 0 {
+		return errCacheSubscriptionInvalidInterval
+	}
+
+	return nil
+}
+
+func validateAPISecretKeysConfig(config *Config) error {
+	if config.GitLab.APISecretKeysDir != "" && config.GitLab.APISecretKeysReloadInterval <= 0 {
+		return errAPISecretKeysInvalidInterval
+	}
+
+	return nil
+}
+
+func validateZipDiskCacheConfig(config *Config) error {
+	if config.Zip.DiskCacheDir != "" && config.Zip.DiskCacheMaxSize <= 0 {
+		return errZipDiskCacheInvalidMaxSize
+	}
+
+	return nil
+}
+
+func validateZipCacheBudgetConfig(config *Config) error {
+	if config.Zip.MemoryBudget < 0 {
+		return errZipCacheInvalidMemoryBudget
+	}
+
+	if config.Zip.EvictionPolicy != ZipEvictionLRU && config.Zip.EvictionPolicy != ZipEvictionLFU {
+		return errZipCacheInvalidEvictionPolicy
+	}
+
+	return nil
+}
+
+func validateCompressionConfig(config *Config) error {
+	if config.Compression.MinSize < 0 {
+		return errCompressionInvalidMinSize
+	}
+
+	if config.Compression.CacheSize < 0 {
+		return errCompressionInvalidCacheSize
+	}
+
+	return nil
+}
+
+func validateCacheControlConfig(config *Config) error {
+	cacheControl := config.CacheControl
+
+	if cacheControl.MaxAge < 0 || cacheControl.HTMLMaxAge < 0 ||
+		cacheControl.HTMLStaleWhileRevalidate < 0 || cacheControl.ImmutableMaxAge < 0 {
+		return errCacheControlInvalidMaxAge
+	}
+
+	if _, err := regexp.Compile(cacheControl.FingerprintPattern); err != nil {
+		return fmt.Errorf("%w: %s", errCacheControlInvalidPattern, err)
+	}
+
+	return nil
+}
+
+func validateInvalidationConfig(config *Config) error {
+	// the invalidation tokens are signed with the API secret, any token would
+	// be valid without it
+	if config.General.InvalidationPath != "" && len(config.GitLab.APISecretKey) == 0 {
+		return errInvalidationNoAPISecretKey
+	}
+
+	return nil
+}
+
+func validateClientTLSConfig(clientTLS ClientTLS, flagPrefix string) error {
+	if (clientTLS.CertFile == "") != (clientTLS.KeyFile == "") {
+		return fmt.Errorf("%s: %w", flagPrefix, errClientTLSIncompleteKeyPair)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -65,6 +66,193 @@ func TestConfigValidate(t *testing.T) {
 			cfg:         emptyListeners,
 			expectedErr: errEmptyListener,
 		},
+		{
+			name: "static_domains_source",
+			cfg:  staticDomainsSource,
+		},
+		{
+			name:        "static_domains_source_no_file",
+			cfg:         staticDomainsSourceNoFile,
+			expectedErr: errDomainConfigNoStaticFile,
+		},
+		{
+			name: "disk_domains_source",
+			cfg:  diskDomainsSource,
+		},
+		{
+			name:        "disk_domains_source_disk_disabled",
+			cfg:         diskDomainsSourceDiskDisabled,
+			expectedErr: errDomainConfigDiskDisabled,
+		},
+		{
+			name:        "disk_domains_source_no_pages_domain",
+			cfg:         diskDomainsSourceNoPagesDomain,
+			expectedErr: errDomainConfigNoPagesDomain,
+		},
+		{
+			name: "cache_snapshot",
+			cfg:  cacheSnapshot,
+		},
+		{
+			name:        "cache_snapshot_invalid_interval",
+			cfg:         cacheSnapshotInvalidInterval,
+			expectedErr: errCacheSnapshotInvalidInterval,
+		},
+		{
+			name:        "cache_snapshot_invalid_max_age",
+			cfg:         cacheSnapshotInvalidMaxAge,
+			expectedErr: errCacheSnapshotInvalidMaxAge,
+		},
+		{
+			name:        "cache_snapshot_no_api_secret_key",
+			cfg:         cacheSnapshotNoAPISecretKey,
+			expectedErr: errCacheSnapshotNoAPISecretKey,
+		},
+		{
+			name: "redis_cache_store",
+			cfg:  redisCacheStore,
+		},
+		{
+			name:        "redis_cache_store_no_url",
+			cfg:         redisCacheStoreNoURL,
+			expectedErr: errCacheNoRedisURL,
+		},
+		{
+			name:        "redis_cache_store_no_api_secret_key",
+			cfg:         redisCacheStoreNoAPISecretKey,
+			expectedErr: errCacheRedisNoAPISecretKey,
+		},
+		{
+			name:        "unknown_cache_store",
+			cfg:         unknownCacheStore,
+			expectedErr: errUnknownCacheStore,
+		},
+		{
+			name:        "cache_invalid_stale_if_error",
+			cfg:         cacheInvalidStaleIfError,
+			expectedErr: errCacheInvalidStaleIfError,
+		},
+		{
+			name:        "circuit_breaker_invalid_ratio",
+			cfg:         circuitBreakerInvalidRatio,
+			expectedErr: errCircuitBreakerInvalidRatio,
+		},
+		{
+			name:        "circuit_breaker_no_open_timeout",
+			cfg:         circuitBreakerNoOpenTimeout,
+			expectedErr: errCircuitBreakerInvalidTimeout,
+		},
+		{
+			name:        "cache_warm_up_invalid_timeout",
+			cfg:         cacheWarmUpInvalidTimeout,
+			expectedErr: errCacheWarmUpInvalidTimeout,
+		},
+		{
+			name:        "cache_subscription_invalid_interval",
+			cfg:         cacheSubscriptionInvalidInterval,
+			expectedErr: errCacheSubscriptionInvalidInterval,
+		},
+		{
+			name:        "api_secret_keys_invalid_interval",
+			cfg:         apiSecretKeysInvalidInterval,
+			expectedErr: errAPISecretKeysInvalidInterval,
+		},
+		{
+			name:        "gitlab_client_tls_cert_without_key",
+			cfg:         gitlabClientTLSCertWithoutKey,
+			expectedErr: errClientTLSIncompleteKeyPair,
+		},
+		{
+			name:        "zip_http_client_tls_key_without_cert",
+			cfg:         zipHTTPClientTLSKeyWithoutCert,
+			expectedErr: errClientTLSIncompleteKeyPair,
+		},
+		{
+			name: "zip_disk_cache",
+			cfg:  zipDiskCache,
+		},
+		{
+			name:        "zip_disk_cache_invalid_max_size",
+			cfg:         zipDiskCacheInvalidMaxSize,
+			expectedErr: errZipDiskCacheInvalidMaxSize,
+		},
+		{
+			name: "zip_cache_memory_budget",
+			cfg:  zipCacheWithMemoryBudget,
+		},
+		{
+			name:        "zip_cache_invalid_memory_budget",
+			cfg:         zipCacheInvalidMemoryBudget,
+			expectedErr: errZipCacheInvalidMemoryBudget,
+		},
+		{
+			name:        "zip_cache_invalid_eviction_policy",
+			cfg:         zipCacheInvalidEvictionPolicy,
+			expectedErr: errZipCacheInvalidEvictionPolicy,
+		},
+		{
+			name:        "compression_invalid_min_size",
+			cfg:         compressionInvalidMinSize,
+			expectedErr: errCompressionInvalidMinSize,
+		},
+		{
+			name:        "compression_invalid_cache_size",
+			cfg:         compressionInvalidCacheSize,
+			expectedErr: errCompressionInvalidCacheSize,
+		},
+		{
+			name:        "cache_control_invalid_max_age",
+			cfg:         cacheControlInvalidMaxAge,
+			expectedErr: errCacheControlInvalidMaxAge,
+		},
+		{
+			name:        "cache_control_invalid_pattern",
+			cfg:         cacheControlInvalidPattern,
+			expectedErr: errCacheControlInvalidPattern,
+		},
+		{
+			name: "invalidation",
+			cfg:  invalidation,
+		},
+		{
+			name:        "invalidation_no_api_secret_key",
+			cfg:         invalidationNoAPISecretKey,
+			expectedErr: errInvalidationNoAPISecretKey,
+		},
+		{
+			name:        "unknown_domains_source",
+			cfg:         unknownDomainsSource,
+			expectedErr: errUnknownDomainConfigSource,
+		},
+		{
+			name: "chained_domains_sources",
+			cfg:  chainedDomainsSources,
+		},
+		{
+			name:        "chained_domains_sources_unknown_source",
+			cfg:         chainedDomainsSourcesUnknownSource,
+			expectedErr: errUnknownDomainConfigSource,
+		},
+		{
+			name:        "chained_domains_sources_no_static_file",
+			cfg:         chainedDomainsSourcesNoStaticFile,
+			expectedErr: errDomainConfigNoStaticFile,
+		},
+		{
+			name:        "chained_domains_sources_duplicated_source",
+			cfg:         chainedDomainsSourcesDuplicatedSource,
+			expectedErr: errDuplicatedDomainConfigSource,
+		},
+		{
+			name:        "chained_domains_sources_invalid_policy",
+			cfg:         chainedDomainsSourcesInvalidPolicy,
+			expectedErr: errInvalidDomainSourcePolicy,
+		},
+		{
+			name:        "chained_domains_sources_policy_of_unknown_source",
+			cfg:         chainedDomainsSourcesPolicyOfUnknownSource,
+			expectedErr: errUnknownDomainConfigPolicySource,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -127,6 +315,190 @@ func artifactsInvalidTimeout(cfg *Config) {
 	cfg.ArtifactsServer.TimeoutSeconds = -1
 }
 
+func staticDomainsSource(cfg *Config) {
+	cfg.Domains = Domains{Source: DomainSourceStatic, StaticFile: "domains.yml"}
+}
+
+func staticDomainsSourceNoFile(cfg *Config) {
+	cfg.Domains = Domains{Source: DomainSourceStatic}
+}
+
+func diskDomainsSource(cfg *Config) {
+	cfg.Domains.Source = DomainSourceDisk
+	cfg.GitLab.EnableDisk = true
+	cfg.General.Domain = "gitlab.io"
+}
+
+func diskDomainsSourceDiskDisabled(cfg *Config) {
+	diskDomainsSource(cfg)
+	cfg.GitLab.EnableDisk = false
+}
+
+func diskDomainsSourceNoPagesDomain(cfg *Config) {
+	diskDomainsSource(cfg)
+	cfg.General.Domain = ""
+}
+
+func cacheSnapshot(cfg *Config) {
+	cfg.GitLab.Cache.SnapshotFile = "cache.snapshot"
+	cfg.GitLab.Cache.SnapshotInterval = time.Minute
+	cfg.GitLab.APISecretKey = []byte("0123456789abcdef0123456789abcdef")
+}
+
+func cacheSnapshotNoAPISecretKey(cfg *Config) {
+	cacheSnapshot(cfg)
+	cfg.GitLab.APISecretKeysDir = "keys"
+	cfg.GitLab.APISecretKeysReloadInterval = time.Minute
+	cfg.GitLab.APISecretKey = nil
+}
+
+func cacheSnapshotInvalidMaxAge(cfg *Config) {
+	cacheSnapshot(cfg)
+	cfg.GitLab.Cache.SnapshotMaxAge = -time.Hour
+}
+
+func cacheSnapshotInvalidInterval(cfg *Config) {
+	cacheSnapshot(cfg)
+	cfg.GitLab.Cache.SnapshotInterval = 0
+}
+
+func redisCacheStore(cfg *Config) {
+	cfg.GitLab.Cache.Store = CacheStoreRedis
+	cfg.GitLab.Cache.RedisURL = "redis://localhost:6379/0"
+	cfg.GitLab.APISecretKey = []byte("0123456789abcdef0123456789abcdef")
+}
+
+func redisCacheStoreNoURL(cfg *Config) {
+	redisCacheStore(cfg)
+	cfg.GitLab.Cache.RedisURL = ""
+}
+
+func redisCacheStoreNoAPISecretKey(cfg *Config) {
+	redisCacheStore(cfg)
+	cfg.GitLab.APISecretKey = nil
+}
+
+func unknownCacheStore(cfg *Config) {
+	cfg.GitLab.Cache.Store = "unknown"
+}
+
+func cacheInvalidStaleIfError(cfg *Config) {
+	cfg.GitLab.Cache.StaleIfError = -time.Second
+}
+
+func circuitBreakerInvalidRatio(cfg *Config) {
+	cfg.GitLab.CircuitBreaker.Enabled = true
+	cfg.GitLab.CircuitBreaker.FailureRatio = 1.5
+	cfg.GitLab.CircuitBreaker.Window = time.Second
+}
+
+func circuitBreakerNoOpenTimeout(cfg *Config) {
+	cfg.GitLab.CircuitBreaker.Enabled = true
+	cfg.GitLab.CircuitBreaker.ConsecutiveFailures = 5
+	cfg.GitLab.CircuitBreaker.HalfOpenRequests = 1
+}
+
+func cacheWarmUpInvalidTimeout(cfg *Config) {
+	cfg.GitLab.Cache.WarmUp = true
+}
+
+func cacheSubscriptionInvalidInterval(cfg *Config) {
+	cfg.GitLab.Cache.Subscribe = true
+}
+
+func apiSecretKeysInvalidInterval(cfg *Config) {
+	cfg.GitLab.APISecretKeysDir = "/etc/gitlab-pages/api-keys"
+}
+
+func gitlabClientTLSCertWithoutKey(cfg *Config) {
+	cfg.GitLab.ClientTLS.CertFile = "/etc/gitlab-pages/client.crt"
+}
+
+func zipHTTPClientTLSKeyWithoutCert(cfg *Config) {
+	cfg.Zip.ClientTLS.KeyFile = "/etc/gitlab-pages/client.key"
+}
+
+func zipDiskCache(cfg *Config) {
+	cfg.Zip.DiskCacheDir = "/var/cache/gitlab-pages"
+	cfg.Zip.DiskCacheMaxSize = 1024
+}
+
+func zipDiskCacheInvalidMaxSize(cfg *Config) {
+	zipDiskCache(cfg)
+	cfg.Zip.DiskCacheMaxSize = 0
+}
+
+func zipCacheWithMemoryBudget(cfg *Config) {
+	cfg.Zip.MemoryBudget = 512 * 1024 * 1024
+	cfg.Zip.EvictionPolicy = ZipEvictionLFU
+}
+
+func zipCacheInvalidMemoryBudget(cfg *Config) {
+	cfg.Zip.MemoryBudget = -1
+}
+
+func zipCacheInvalidEvictionPolicy(cfg *Config) {
+	cfg.Zip.EvictionPolicy = "fifo"
+}
+
+func compressionInvalidMinSize(cfg *Config) {
+	cfg.Compression.MinSize = -1
+}
+
+func compressionInvalidCacheSize(cfg *Config) {
+	cfg.Compression.CacheSize = -1
+}
+
+func cacheControlInvalidMaxAge(cfg *Config) {
+	cfg.CacheControl.HTMLStaleWhileRevalidate = -time.Second
+}
+
+func cacheControlInvalidPattern(cfg *Config) {
+	cfg.CacheControl.FingerprintPattern = "[0-9a-f"
+}
+
+func invalidation(cfg *Config) {
+	cfg.General.InvalidationPath = "/@invalidate"
+	cfg.GitLab.APISecretKey = []byte("0123456789abcdef0123456789abcdef")
+}
+
+func invalidationNoAPISecretKey(cfg *Config) {
+	invalidation(cfg)
+	cfg.GitLab.APISecretKey = nil
+}
+
+func unknownDomainsSource(cfg *Config) {
+	cfg.Domains.Source = "unknown"
+}
+
+func chainedDomainsSources(cfg *Config) {
+	diskDomainsSource(cfg)
+	cfg.Domains.Source = "static, gitlab, disk"
+	cfg.Domains.StaticFile = "domains.yml"
+	cfg.Domains.OnNotFound = "static=continue,gitlab=stop"
+	cfg.Domains.OnError = "gitlab=continue"
+}
+
+func chainedDomainsSourcesUnknownSource(cfg *Config) {
+	cfg.Domains.Source = "gitlab,unknown"
+}
+
+func chainedDomainsSourcesNoStaticFile(cfg *Config) {
+	cfg.Domains.Source = "static,gitlab"
+}
+
+func chainedDomainsSourcesDuplicatedSource(cfg *Config) {
+	cfg.Domains.Source = "gitlab,gitlab"
+}
+
+func chainedDomainsSourcesInvalidPolicy(cfg *Config) {
+	cfg.Domains.OnError = "gitlab=retry"
+}
+
+func chainedDomainsSourcesPolicyOfUnknownSource(cfg *Config) {
+	cfg.Domains.OnNotFound = "static=stop"
+}
+
 func validConfig() Config {
 	cfg := Config{
 		ListenHTTPStrings: MultiStringFlag{
@@ -147,8 +519,15 @@ func validConfig() Config {
 			ClientSecret: "bar-secret",
 			RedirectURI:  "https://example.com",
 		},
+		Domains: Domains{
+			Source: DomainSourceGitLab,
+		},
 		GitLab: GitLab{
 			PublicServer: "https://gitlab.example.com",
+			Cache:        Cache{Store: CacheStoreMemory},
+		},
+		Zip: ZipServing{
+			EvictionPolicy: ZipEvictionLRU,
 		},
 	}
 
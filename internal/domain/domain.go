@@ -25,6 +25,10 @@ type Domain struct {
 
 	Resolver Resolver
 
+	// Stale is true when the domain configuration is served past its
+	// expiration because the domains source failed to refresh it
+	Stale bool
+
 	certificate      *tls.Certificate
 	certificateError error
 	certificateOnce  sync.Once
@@ -50,6 +54,10 @@ func (d *Domain) resolve(r *http.Request) (*serving.Request, error) {
 		return nil, ErrDomainDoesNotExist
 	}
 
+	if memo, ok := r.Context().Value(ctxServingKey).(*servingMemo); ok {
+		return memo.resolve(d, r)
+	}
+
 	return d.Resolver.Resolve(r)
 }
 
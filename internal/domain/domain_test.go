@@ -221,3 +221,31 @@ func mockResolver(t *testing.T, project *serving.LookupPath, subpath string, err
 
 	return mockResolver
 }
+
+func TestResolveOncePerRequest(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	resolver := mock.NewMockResolver(mockCtrl)
+
+	resolver.EXPECT().
+		Resolve(gomock.Any()).
+		Times(2).
+		Return(&serving.Request{
+			Serving:    local.Instance(),
+			LookupPath: &serving.LookupPath{Path: "group/project/public", IsHTTPSOnly: true},
+		}, nil)
+
+	d := domain.New("group.gitlab-example.com", "", "", resolver)
+
+	r, err := http.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project/index.html", nil)
+	require.NoError(t, err)
+	r = domain.ReqWithDomain(r, d)
+
+	require.True(t, d.IsHTTPSOnly(r))
+	require.False(t, d.IsAccessControlEnabled(r))
+	require.True(t, d.IsHTTPSOnly(r))
+
+	// a rewritten URL path gets resolved again
+	r.URL.Path = "/project/other.html"
+	require.True(t, d.IsHTTPSOnly(r))
+	require.False(t, d.IsAccessControlEnabled(r))
+}
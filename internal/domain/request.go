@@ -3,18 +3,23 @@ package domain
 import (
 	"context"
 	"net/http"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 )
 
 type ctxKey string
 
 const (
-	ctxDomainKey ctxKey = "domain"
+	ctxDomainKey  ctxKey = "domain"
+	ctxServingKey ctxKey = "serving"
 )
 
 // ReqWithDomain saves domain in the request's context
 func ReqWithDomain(r *http.Request, domain *Domain) *http.Request {
 	ctx := r.Context()
 	ctx = context.WithValue(ctx, ctxDomainKey, domain)
+	ctx = context.WithValue(ctx, ctxServingKey, &servingMemo{})
 
 	return r.WithContext(ctx)
 }
@@ -23,3 +28,31 @@ func ReqWithDomain(r *http.Request, domain *Domain) *http.Request {
 func FromRequest(r *http.Request) *Domain {
 	return r.Context().Value(ctxDomainKey).(*Domain)
 }
+
+// servingMemo memoizes the serving request a domain resolves for a request,
+// so that the request gets resolved once however many middlewares look up
+// its project
+type servingMemo struct {
+	mux      sync.Mutex
+	resolved bool
+	domain   *Domain
+	urlPath  string
+	request  *serving.Request
+	err      error
+}
+
+// resolve returns the serving request of r resolved by d, which is only
+// resolved again when the domain or the URL path of r changed
+func (m *servingMemo) resolve(d *Domain, r *http.Request) (*serving.Request, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.resolved && m.domain == d && m.urlPath == r.URL.Path {
+		return m.request, m.err
+	}
+
+	m.request, m.err = d.Resolver.Resolve(r)
+	m.resolved, m.domain, m.urlPath = true, d, r.URL.Path
+
+	return m.request, m.err
+}
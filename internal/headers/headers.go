@@ -0,0 +1,198 @@
+// Package headers provides functions for parsing and applying per-path
+// response headers according to Netlify style _headers syntax
+package headers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+const (
+	// ConfigFile is the default name of the file containing the header rules.
+	// It follows Netlify's syntax
+	//  - https://docs.netlify.com/routing/headers/
+	ConfigFile = "_headers"
+
+	defaultMaxConfigSize = 64 * 1024
+
+	// maxPathSegments is used to limit the number of path segments allowed in rules paths
+	defaultMaxPathSegments = 25
+
+	// maxRuleCount is used to limit the total number of rules allowed in _headers
+	defaultMaxRuleCount = 1000
+
+	// parsedHeadersCacheSize is the number of parsed _headers files cached
+	parsedHeadersCacheSize = 1000
+	// parsedHeadersCacheExpiration is the time a parsed _headers file is
+	// cached, it is parsed again when it is modified anyway
+	parsedHeadersCacheExpiration = 10 * time.Minute
+)
+
+var (
+	cfg = config.Headers{
+		MaxConfigSize:   defaultMaxConfigSize,
+		MaxPathSegments: defaultMaxPathSegments,
+		MaxRuleCount:    defaultMaxRuleCount,
+	}
+
+	errConfigNotFound     = errors.New("_headers file not found")
+	errNeedRegularFile    = errors.New("_headers needs to be a regular file (not a directory)")
+	errFileTooLarge       = errors.New("_headers file too large")
+	errFailedToOpenConfig = errors.New("unable to open _headers file")
+	errFailedToReadConfig = errors.New("unable to read _headers file")
+
+	// parsedHeaders caches the parsed _headers files by root, size and
+	// modification time, so that they are not read, parsed and compiled for
+	// every file served
+	parsedHeaders = lru.New(
+		"headers",
+		lru.WithMaxSize(parsedHeadersCacheSize),
+		lru.WithExpirationInterval(parsedHeadersCacheExpiration),
+	)
+)
+
+func SetConfig(headersConfig config.Headers) {
+	cfg = headersConfig
+}
+
+// Headers holds the rules of a _headers file
+type Headers struct {
+	rules []*rule
+	error error
+}
+
+// Status maps over each header rule and returns any error message
+func (h *Headers) Status() string {
+	if h.error != nil {
+		return fmt.Sprintf("parse error: %s", h.error.Error())
+	}
+
+	messages := make([]string, 0, len(h.rules)+1)
+	messages = append(messages, fmt.Sprintf("%d rules", len(h.rules)))
+
+	for i, rule := range h.rules {
+		if i >= cfg.MaxRuleCount {
+			messages = append([]string{
+				fmt.Sprintf(
+					"The _headers file contains (%d) rules, more than the maximum of %d rules. Only the first %d rules will be processed.",
+					len(h.rules),
+					cfg.MaxRuleCount,
+					cfg.MaxRuleCount,
+				)},
+				messages...,
+			)
+
+			break
+		}
+
+		if rule.err != nil {
+			messages = append(messages, fmt.Sprintf("rule %d: error: %s", i+1, rule.err.Error()))
+		} else {
+			messages = append(messages, fmt.Sprintf("rule %d: valid", i+1))
+		}
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Apply sets the headers of the valid rules matching path in the response.
+// The values of a header set by several rules are all kept, and they replace
+// the values of the header already set in the response.
+func (h *Headers) Apply(w http.ResponseWriter, path string) {
+	headers := h.match(path)
+
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
+}
+
+// ParseHeaders decodes Netlify style headers from the projects `.../public/_headers`
+// https://docs.netlify.com/routing/headers/#syntax-for-the-headers-file
+func ParseHeaders(ctx context.Context, root vfs.Root) *Headers {
+	fi, headers := stat(ctx, root)
+	if headers != nil {
+		return headers
+	}
+
+	return read(ctx, root, fi)
+}
+
+// LoadHeaders works like ParseHeaders, but the headers parsed are cached for
+// the root identified by rootKey, which must change with the deployment of
+// the root. The file is only read again when its size or modification time
+// changes.
+func LoadHeaders(ctx context.Context, root vfs.Root, rootKey string) *Headers {
+	fi, headers := stat(ctx, root)
+	if headers != nil {
+		return headers
+	}
+
+	key := fmt.Sprintf("%d\x00%d", fi.Size(), fi.ModTime().UnixNano())
+
+	cached, _ := parsedHeaders.FindOrFetch(rootKey, key, func() (interface{}, error) {
+		return read(ctx, root, fi), nil
+	})
+
+	return cached.(*Headers)
+}
+
+// stat returns the FileInfo of the _headers file of root, or the Headers
+// holding the error when it cannot be read
+func stat(ctx context.Context, root vfs.Root) (os.FileInfo, *Headers) {
+	fi, err := root.Lstat(ctx, ConfigFile)
+	if err != nil {
+		return nil, &Headers{error: errConfigNotFound}
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, &Headers{error: errNeedRegularFile}
+	}
+
+	if fi.Size() > int64(cfg.MaxConfigSize) {
+		return nil, &Headers{error: errFileTooLarge}
+	}
+
+	return fi, nil
+}
+
+// read reads and parses the _headers file of root
+func read(ctx context.Context, root vfs.Root, fi os.FileInfo) *Headers {
+	reader, err := root.Open(ctx, ConfigFile)
+	if err != nil {
+		return &Headers{error: errFailedToOpenConfig}
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, int64(cfg.MaxConfigSize)))
+	if err != nil {
+		return &Headers{error: errFailedToReadConfig}
+	}
+
+	rules, err := parse(string(data))
+	if err != nil {
+		return &Headers{error: err}
+	}
+
+	for i, rule := range rules {
+		if i >= cfg.MaxRuleCount {
+			break
+		}
+
+		rule.err = validateRule(rule)
+		if rule.err == nil {
+			rule.pattern, rule.err = compilePath(rule.path)
+		}
+	}
+
+	return &Headers{rules: rules}
+}
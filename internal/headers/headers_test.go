@@ -0,0 +1,213 @@
+package headers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+func TestParseHeaders(t *testing.T) {
+	ctx := context.Background()
+
+	root, tmpDir := testhelpers.TmpDir(t)
+
+	tests := []struct {
+		name          string
+		headersFile   string
+		expectedRules int
+		expectedErr   error
+	}{
+		{
+			name:          "No `_headers` file present",
+			headersFile:   "",
+			expectedRules: 0,
+			expectedErr:   errConfigNotFound,
+		},
+		{
+			name:          "Everything working as expected",
+			headersFile:   "/*\n  X-Frame-Options: DENY\n\n# assets\n/assets/*\n\tCache-Control: max-age=3600\n",
+			expectedRules: 2,
+		},
+		{
+			name:          "Config file too big",
+			headersFile:   strings.Repeat("a", 2*cfg.MaxConfigSize),
+			expectedRules: 0,
+			expectedErr:   errFileTooLarge,
+		},
+		{
+			name:          "Header without a path",
+			headersFile:   "  X-Frame-Options: DENY\n/*\n",
+			expectedRules: 0,
+			expectedErr:   errFailedToParseConfig,
+		},
+		{
+			name:          "Header without a colon",
+			headersFile:   "/*\n  X-Frame-Options DENY\n",
+			expectedRules: 0,
+			expectedErr:   errFailedToParseConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.headersFile != "" {
+				err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte(tt.headersFile), 0600)
+				require.NoError(t, err)
+			}
+
+			headers := ParseHeaders(ctx, root)
+
+			require.ErrorIs(t, headers.error, tt.expectedErr)
+			require.Len(t, headers.rules, tt.expectedRules)
+		})
+	}
+}
+
+func TestLoadHeaders(t *testing.T) {
+	ctx := context.Background()
+
+	root, tmpDir := testhelpers.TmpDir(t)
+	configPath := path.Join(tmpDir, ConfigFile)
+
+	headers := LoadHeaders(ctx, root, tmpDir)
+	require.ErrorIs(t, headers.error, errConfigNotFound)
+
+	require.NoError(t, os.WriteFile(configPath, []byte("/*\n  X-Frame-Options: DENY\n"), 0600))
+
+	headers = LoadHeaders(ctx, root, tmpDir)
+	require.NoError(t, headers.error)
+	require.Len(t, headers.rules, 1)
+	require.Same(t, headers, LoadHeaders(ctx, root, tmpDir), "the parsed file is cached")
+
+	modified := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(configPath, []byte("/*\n  X-Frame-Options: DENY\n/a\n  X-Robots-Tag: none\n"), 0600))
+	require.NoError(t, os.Chtimes(configPath, modified, modified))
+
+	headers = LoadHeaders(ctx, root, tmpDir)
+	require.NoError(t, headers.error)
+	require.Len(t, headers.rules, 2, "the modified file is parsed again")
+}
+
+func TestHeadersApply(t *testing.T) {
+	root, tmpDir := testhelpers.TmpDir(t)
+
+	err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte(`
+/*
+  X-Frame-Options: DENY
+  Content-Security-Policy: default-src 'self'
+
+/project/docs/*
+  Content-Security-Policy: script-src 'self'
+  Access-Control-Allow-Origin: *
+
+/project/:page/index.html
+  cache-control: no-cache
+
+/project/*.css
+  Set-Cookie: session=1
+`), 0600)
+	require.NoError(t, err)
+
+	headers := ParseHeaders(context.Background(), root)
+
+	tests := map[string]struct {
+		path     string
+		expected http.Header
+	}{
+		"root": {
+			path: "/",
+			expected: http.Header{
+				"X-Frame-Options":         {"DENY"},
+				"Content-Security-Policy": {"default-src 'self'"},
+				"Cache-Control":           {"max-age=600"},
+			},
+		},
+		"splat": {
+			path: "/project/docs/api/index.html",
+			expected: http.Header{
+				"X-Frame-Options":             {"DENY"},
+				"Content-Security-Policy":     {"default-src 'self'", "script-src 'self'"},
+				"Access-Control-Allow-Origin": {"*"},
+				"Cache-Control":               {"max-age=600"},
+			},
+		},
+		"placeholder": {
+			path: "/project/about/index.html",
+			expected: http.Header{
+				"X-Frame-Options":         {"DENY"},
+				"Content-Security-Policy": {"default-src 'self'"},
+				"Cache-Control":           {"no-cache"},
+			},
+		},
+		"denied header": {
+			path: "/project/style.css",
+			expected: http.Header{
+				"X-Frame-Options":         {"DENY"},
+				"Content-Security-Policy": {"default-src 'self'"},
+				"Cache-Control":           {"max-age=600"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("Cache-Control", "max-age=600")
+
+			headers.Apply(w, tt.path)
+
+			require.Equal(t, tt.expected, w.Header())
+		})
+	}
+}
+
+func TestHeadersStatus(t *testing.T) {
+	root, tmpDir := testhelpers.TmpDir(t)
+
+	err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte(`
+/*
+  X-Frame-Options: DENY
+https://gitlab.com/*
+  X-Frame-Options: DENY
+/private/*
+  Set-Cookie: session=1
+/empty
+`), 0600)
+	require.NoError(t, err)
+
+	require.Equal(t, strings.Join([]string{
+		"4 rules",
+		"rule 1: valid",
+		"rule 2: error: no domain-level headers",
+		"rule 3: error: header can not be set: Set-Cookie",
+		"rule 4: error: no headers",
+	}, "\n"), ParseHeaders(context.Background(), root).Status())
+
+	require.NoError(t, os.Remove(path.Join(tmpDir, ConfigFile)))
+	require.Equal(t, "parse error: _headers file not found", ParseHeaders(context.Background(), root).Status())
+}
+
+func TestMaxRuleCount(t *testing.T) {
+	root, tmpDir := testhelpers.TmpDir(t)
+
+	err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte(strings.Repeat("/goto.html\n  X-Rule: any\n", cfg.MaxRuleCount-1)+
+		"/1000.html\n  X-Rule: 1000\n"+
+		"/1001.html\n  X-Rule: 1001\n",
+	), 0600)
+	require.NoError(t, err)
+
+	headers := ParseHeaders(context.Background(), root)
+
+	require.Equal(t, http.Header{"X-Rule": {"1000"}}, headers.match("/1000.html"))
+	require.Empty(t, headers.match("/1001.html"))
+	require.True(t, strings.HasPrefix(headers.Status(), "The _headers file contains (1001) rules, more than the maximum of 1000 rules."))
+}
@@ -0,0 +1,67 @@
+package headers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var regexPlaceholder = regexp.MustCompile(`(?i)^:[a-z]+$`)
+
+// compilePath compiles the path pattern of a rule into a regular expression
+// matching the requested paths, ignoring their trailing slashes.
+//
+// For example, given a path pattern like this:
+//
+//	/docs/:version/*
+//
+// the regular expression would match paths like this:
+//
+//	/docs/v1/
+//	/docs/v1/index.html
+//	/docs/v2/api/index.html
+//
+// A splat segment `*` matches any number of segments, while a `*` in another
+// segment like `*.css` only matches the characters of that segment.
+// A placeholder segment like `:version` matches exactly one segment.
+func compilePath(path string) (*regexp.Regexp, error) {
+	var regexSegments []string
+
+	for _, segment := range strings.Split(path, "/") {
+		switch {
+		case segment == "":
+			continue
+		case segment == "*":
+			regexSegments = append(regexSegments, `(/.*)?`)
+		case regexPlaceholder.MatchString(segment):
+			regexSegments = append(regexSegments, `/+[^/]+`)
+		default:
+			regexSegments = append(regexSegments, "/+"+strings.ReplaceAll(regexp.QuoteMeta(segment), `\*`, `[^/]*`))
+		}
+	}
+
+	return regexp.Compile(`(?i)^` + strings.Join(regexSegments, "") + `/*$`)
+}
+
+// match returns the headers of the valid rules matching path, in the order
+// of the rules
+func (h *Headers) match(path string) http.Header {
+	headers := make(http.Header)
+
+	for i, rule := range h.rules {
+		if i >= cfg.MaxRuleCount {
+			// do not process any more rules
+			break
+		}
+
+		if rule.err != nil || !rule.pattern.MatchString(path) {
+			continue
+		}
+
+		for name, values := range rule.headers {
+			headers[name] = append(headers[name], values...)
+		}
+	}
+
+	return headers
+}
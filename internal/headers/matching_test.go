@@ -0,0 +1,75 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilePath(t *testing.T) {
+	tests := map[string]struct {
+		path      string
+		matches   []string
+		noMatches []string
+	}{
+		"exact": {
+			path:      "/index.html",
+			matches:   []string{"/index.html", "/index.html/", "/INDEX.html"},
+			noMatches: []string{"/", "/index.htm", "/other/index.html"},
+		},
+		"directory": {
+			path:      "/docs/",
+			matches:   []string{"/docs", "/docs/", "//docs//"},
+			noMatches: []string{"/docs/index.html", "/documents"},
+		},
+		"root": {
+			path:      "/",
+			matches:   []string{"/"},
+			noMatches: []string{"/index.html"},
+		},
+		"everything": {
+			path:    "/*",
+			matches: []string{"/", "/index.html", "/docs/api/index.html"},
+		},
+		"splat": {
+			path:      "/docs/*",
+			matches:   []string{"/docs", "/docs/", "/docs/index.html", "/docs/api/index.html"},
+			noMatches: []string{"/documents", "/blog/docs/index.html"},
+		},
+		"splat in the middle": {
+			path:      "/docs/*/index.html",
+			matches:   []string{"/docs/index.html", "/docs/v1/index.html", "/docs/v1/api/index.html"},
+			noMatches: []string{"/docs/v1/style.css"},
+		},
+		"splat in a segment": {
+			path:      "/assets/*.css",
+			matches:   []string{"/assets/style.css", "/assets/.css"},
+			noMatches: []string{"/assets/css/style.css", "/assets/style.js"},
+		},
+		"placeholder": {
+			path:      "/docs/:version/index.html",
+			matches:   []string{"/docs/v1/index.html"},
+			noMatches: []string{"/docs/index.html", "/docs/v1/api/index.html"},
+		},
+		"special characters": {
+			path:      "/a+b/(c).html",
+			matches:   []string{"/a+b/(c).html"},
+			noMatches: []string{"/aab/c.html"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pattern, err := compilePath(tt.path)
+			require.NoError(t, err)
+
+			for _, path := range tt.matches {
+				require.True(t, pattern.MatchString(path), "%q should match %q", tt.path, path)
+			}
+
+			for _, path := range tt.noMatches {
+				require.False(t, pattern.MatchString(path), "%q should not match %q", tt.path, path)
+			}
+		})
+	}
+}
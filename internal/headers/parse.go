@@ -0,0 +1,64 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	errFailedToParseConfig = errors.New("failed to parse _headers file")
+	errHeaderWithoutPath   = errors.New("header without a path")
+	errNoHeaderSeparator   = errors.New("header without a colon separating its name and value")
+)
+
+// rule holds the headers set on the paths matching its path pattern
+type rule struct {
+	path    string
+	headers http.Header
+
+	// err is the validation error of the rule, which is ignored when set
+	err error
+	// pattern matches the paths of the rule once it is validated
+	pattern *regexp.Regexp
+}
+
+// parse reads the rules of a _headers file. A rule is a path pattern starting
+// at the beginning of a line, followed by the indented headers set on the
+// matching paths, one `Name: value` per line. The blank lines and the lines
+// starting with # are ignored.
+//
+//	/assets/*
+//	  Cache-Control: public, max-age=31536000
+//	  Access-Control-Allow-Origin: *
+func parse(data string) ([]*rule, error) {
+	var rules []*rule
+
+	for n, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			rules = append(rules, &rule{path: trimmed, headers: make(http.Header)})
+			continue
+		}
+
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("%w: line %d: %s", errFailedToParseConfig, n+1, errHeaderWithoutPath)
+		}
+
+		name, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			return nil, fmt.Errorf("%w: line %d: %s", errFailedToParseConfig, n+1, errNoHeaderSeparator)
+		}
+
+		// the names are validated with the rule
+		rules[len(rules)-1].headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return rules, nil
+}
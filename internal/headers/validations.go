@@ -0,0 +1,131 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	errFailedToParsePath               = errors.New("unable to parse path")
+	errNoDomainLevelHeaders            = errors.New("no domain-level headers")
+	errNoStartingForwardSlashInURLPath = errors.New("url path must start with forward slash /")
+	errNoHeaders                       = errors.New("no headers")
+	errInvalidHeaderName               = errors.New("invalid header name")
+	errInvalidHeaderValue              = errors.New("invalid header value")
+	errDeniedHeader                    = errors.New("header can not be set")
+
+	regexHeaderName = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+)
+
+// deniedHeaders can not be set by the _headers file, as they are either set
+// by Pages to serve the content or they could affect the other sites served
+// under the same domain
+var deniedHeaders = map[string]bool{
+	"Accept-Ranges":             true,
+	"Alt-Svc":                   true,
+	"Clear-Site-Data":           true,
+	"Connection":                true,
+	"Content-Encoding":          true,
+	"Content-Length":            true,
+	"Content-Range":             true,
+	"Date":                      true,
+	"Etag":                      true,
+	"Keep-Alive":                true,
+	"Last-Modified":             true,
+	"Location":                  true,
+	"Proxy-Authenticate":        true,
+	"Public-Key-Pins":           true,
+	"Server":                    true,
+	"Service-Worker-Allowed":    true,
+	"Set-Cookie":                true,
+	"Set-Cookie2":               true,
+	"Strict-Transport-Security": true,
+	"Trailer":                   true,
+	"Transfer-Encoding":         true,
+	"Upgrade":                   true,
+	"Vary":                      true,
+	"Www-Authenticate":          true,
+}
+
+// validatePath runs validations against a rule path.
+// Returns `nil` if the path is valid.
+func validatePath(path string) error {
+	url, err := url.Parse(path)
+	if err != nil {
+		return errFailedToParsePath
+	}
+
+	// No support for the headers of other domains:
+	// - `https://gitlab.com/*`
+	// - `//gitlab.com/*`
+	// - `/\gitlab.com/*`
+	if url.Host != "" || url.Scheme != "" || strings.HasPrefix(url.Path, "/\\") {
+		return errNoDomainLevelHeaders
+	}
+
+	if !strings.HasPrefix(url.Path, "/") {
+		return errNoStartingForwardSlashInURLPath
+	}
+
+	// Limit the number of path segments a rule can contain.
+	// This prevents the matching logic from generating regular
+	// expressions that are too large/complex.
+	if strings.Count(url.Path, "/") > cfg.MaxPathSegments {
+		return fmt.Errorf("url path cannot contain more than %d forward slashes", cfg.MaxPathSegments)
+	}
+
+	return nil
+}
+
+// validateHeader runs validations against a header of a rule.
+// Returns `nil` if the header is valid.
+func validateHeader(name string, values []string) error {
+	if !regexHeaderName.MatchString(name) {
+		return fmt.Errorf("%w: %q", errInvalidHeaderName, name)
+	}
+
+	if deniedHeaders[name] {
+		return fmt.Errorf("%w: %s", errDeniedHeader, name)
+	}
+
+	for _, value := range values {
+		for _, c := range value {
+			if c != '\t' && (c < ' ' || c == 0x7f) {
+				return fmt.Errorf("%w: %s", errInvalidHeaderValue, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRule runs all validation rules on the provided rule.
+// Returns `nil` if the rule is valid
+func validateRule(r *rule) error {
+	if err := validatePath(r.path); err != nil {
+		return err
+	}
+
+	if len(r.headers) == 0 {
+		return errNoHeaders
+	}
+
+	names := make([]string, 0, len(r.headers))
+	for name := range r.headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := validateHeader(name, r.headers[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeadersValidatePath(t *testing.T) {
+	tests := map[string]struct {
+		path        string
+		expectedErr error
+	}{
+		"valid_path": {
+			path: "/index.html",
+		},
+		"splats_and_placeholders": {
+			path: "/docs/:version/*",
+		},
+		"no_domain_level_headers": {
+			path:        "https://GitLab.com/*",
+			expectedErr: errNoDomainLevelHeaders,
+		},
+		"no_special_characters_escape_domain_level_headers": {
+			path:        "/\\GitLab.com",
+			expectedErr: errNoDomainLevelHeaders,
+		},
+		"no_schemaless_url_domain_level_headers": {
+			path:        "//GitLab.com/pages.html",
+			expectedErr: errNoDomainLevelHeaders,
+		},
+		"no_relative_path": {
+			path:        "index.html",
+			expectedErr: errNoStartingForwardSlashInURLPath,
+		},
+		"too_many_slashes": {
+			path:        strings.Repeat("/a", 26),
+			expectedErr: fmt.Errorf("url path cannot contain more than %d forward slashes", defaultMaxPathSegments),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validatePath(tt.path)
+			if tt.expectedErr != nil {
+				require.Equal(t, tt.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHeadersValidateRule(t *testing.T) {
+	tests := map[string]struct {
+		headers     http.Header
+		expectedErr error
+	}{
+		"valid_rule": {
+			headers: http.Header{
+				"Content-Security-Policy":     {"default-src 'self'"},
+				"Access-Control-Allow-Origin": {"*"},
+				"Cache-Control":               {"public,\tmax-age=3600"},
+			},
+		},
+		"no_headers": {
+			headers:     http.Header{},
+			expectedErr: errNoHeaders,
+		},
+		"invalid_header_name": {
+			headers:     http.Header{"X Frame Options": {"DENY"}},
+			expectedErr: errInvalidHeaderName,
+		},
+		"invalid_header_value": {
+			headers:     http.Header{"X-Frame-Options": {"DENY\x00"}},
+			expectedErr: errInvalidHeaderValue,
+		},
+		"set_cookie": {
+			headers:     http.Header{"Set-Cookie": {"session=1"}},
+			expectedErr: errDeniedHeader,
+		},
+		"hsts": {
+			headers:     http.Header{"Strict-Transport-Security": {"max-age=0"}},
+			expectedErr: errDeniedHeader,
+		},
+		"content_encoding": {
+			headers:     http.Header{"Content-Encoding": {"gzip"}},
+			expectedErr: errDeniedHeader,
+		},
+		"service_worker_allowed": {
+			headers:     http.Header{"Service-Worker-Allowed": {"/"}},
+			expectedErr: errDeniedHeader,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateRule(&rule{path: "/*", headers: tt.headers})
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
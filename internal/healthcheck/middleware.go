@@ -4,16 +4,38 @@ import (
 	"net/http"
 )
 
-// NewMiddleware is serving the application status check
-func NewMiddleware(handler http.Handler, statusPath string) http.Handler {
+// Warmer is implemented by components warming up after startup, e.g. a
+// domains source populating its cache
+type Warmer interface {
+	// Warming returns true until the warm-up finishes
+	Warming() bool
+}
+
+// NewMiddleware is serving the application status check, which reports the
+// application is alive, and its readiness check. As the application serves
+// requests while warming up, the readiness is "warming" until none of the
+// warmers is warming up anymore.
+func NewMiddleware(handler http.Handler, statusPath, readinessPath string, warmers ...Warmer) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == statusPath {
+		switch {
+		case statusPath != "" && r.URL.Path == statusPath:
 			w.Header().Set("Cache-Control", "no-store")
 			w.Write([]byte("success\n"))
+		case readinessPath != "" && r.URL.Path == readinessPath:
+			w.Header().Set("Cache-Control", "no-store")
 
-			return
-		}
+			for _, warmer := range warmers {
+				if warmer.Warming() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("warming\n"))
 
-		handler.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.Write([]byte("success\n"))
+		default:
+			handler.ServeHTTP(w, r)
+		}
 	})
 }
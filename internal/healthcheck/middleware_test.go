@@ -24,11 +24,16 @@ func TestHealthCheckMiddleware(t *testing.T) {
 			path: "/-/healthcheck",
 			body: "success\n",
 		},
+		"Readiness request": {
+			path: "/-/readiness",
+			body: "success\n",
+		},
 	}
 
 	cfg := config.Config{
 		General: config.General{
-			StatusPath: "/-/healthcheck",
+			StatusPath:    "/-/healthcheck",
+			ReadinessPath: "/-/readiness",
 		},
 	}
 
@@ -39,7 +44,7 @@ func TestHealthCheckMiddleware(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			middleware := healthcheck.NewMiddleware(handler, cfg.General.StatusPath)
+			middleware := healthcheck.NewMiddleware(handler, cfg.General.StatusPath, cfg.General.ReadinessPath)
 
 			u := "https://example.com" + tc.path
 
@@ -48,3 +53,32 @@ func TestHealthCheckMiddleware(t *testing.T) {
 		})
 	}
 }
+
+type warmer struct {
+	warming bool
+}
+
+func (w *warmer) Warming() bool {
+	return w.warming
+}
+
+func TestHealthCheckMiddlewareWarming(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	source := &warmer{warming: true}
+	middleware := healthcheck.NewMiddleware(handler, "/-/healthcheck", "/-/readiness", &warmer{}, source)
+
+	// the application is alive while it warms up
+	require.HTTPStatusCode(t, middleware.ServeHTTP, http.MethodGet, "https://example.com/-/healthcheck", nil, http.StatusOK)
+	require.HTTPBodyContains(t, middleware.ServeHTTP, http.MethodGet, "https://example.com/-/healthcheck", nil, "success\n")
+
+	u := "https://example.com/-/readiness"
+
+	require.HTTPStatusCode(t, middleware.ServeHTTP, http.MethodGet, u, nil, http.StatusServiceUnavailable)
+	require.HTTPBodyContains(t, middleware.ServeHTTP, http.MethodGet, u, nil, "warming\n")
+
+	source.warming = false
+
+	require.HTTPStatusCode(t, middleware.ServeHTTP, http.MethodGet, u, nil, http.StatusOK)
+	require.HTTPBodyContains(t, middleware.ServeHTTP, http.MethodGet, u, nil, "success\n")
+}
@@ -0,0 +1,292 @@
+package httptransport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+// ClientTransport returns the transport of an HTTP client using clientTLS. It
+// is DefaultTransport when clientTLS is not enabled, so that the clients
+// without TLS settings share its connections.
+func ClientTransport(clientTLS config.ClientTLS) (http.RoundTripper, error) {
+	if !clientTLS.Enabled() {
+		return DefaultTransport, nil
+	}
+
+	return NewTransportWithClientTLS(clientTLS.CertFile, clientTLS.KeyFile, clientTLS.CAFile)
+}
+
+// clientTLSReloadInterval is the minimum interval between two checks of the
+// client TLS files for changes
+var clientTLSReloadInterval = time.Minute
+
+// NewTransportWithClientTLS initializes a Transport like NewTransport, which
+// presents the client certificate of certFile and keyFile to the servers, and
+// trusts the CA certificates of caFile on top of the system ones. Each of the
+// files is optional, but certFile and keyFile must be defined together. The
+// files are loaded again when they change, so that certificates can be rotated
+// without restarting Pages.
+func NewTransportWithClientTLS(certFile, keyFile, caFile string) (Transport, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("client certificate and key must be defined together")
+	}
+
+	files := &clientTLSFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+
+	if err := files.reload(); err != nil {
+		return nil, err
+	}
+
+	t := &clientTLSTransport{files: files, protocols: make(map[string]http.RoundTripper)}
+	t.rootCAs = files.currentRootCAs()
+	t.current = t.newTransport(t.rootCAs)
+
+	return t, nil
+}
+
+// clientTLSTransport is the Transport of the clients with TLS settings. The
+// certificates of the servers are verified by crypto/tls with the CA
+// certificates loaded last, a new http.Transport replacing the current one
+// when they change.
+type clientTLSTransport struct {
+	files *clientTLSFiles
+
+	mux       sync.Mutex
+	current   *http.Transport
+	rootCAs   *x509.CertPool
+	protocols map[string]http.RoundTripper
+}
+
+// RoundTrip executes the request with the transport trusting the CA
+// certificates loaded last
+func (t *clientTLSTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.transport().RoundTrip(r)
+}
+
+// RegisterProtocol registers rt for scheme on the current transport and on
+// the ones replacing it
+func (t *clientTLSTransport) RegisterProtocol(scheme string, rt http.RoundTripper) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.protocols[scheme] = rt
+	t.current.RegisterProtocol(scheme, rt)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport
+func (t *clientTLSTransport) CloseIdleConnections() {
+	t.transport().CloseIdleConnections()
+}
+
+// transport returns the current transport, replacing it when the CA
+// certificates changed
+func (t *clientTLSTransport) transport() *http.Transport {
+	t.files.reloadPeriodically()
+	rootCAs := t.files.currentRootCAs()
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if rootCAs == t.rootCAs {
+		return t.current
+	}
+
+	previous := t.current
+
+	t.rootCAs = rootCAs
+	t.current = t.newTransport(rootCAs)
+	for scheme, rt := range t.protocols {
+		t.current.RegisterProtocol(scheme, rt)
+	}
+
+	// the connections in use are closed once their requests are done
+	previous.CloseIdleConnections()
+
+	return t.current
+}
+
+// newTransport returns the transport trusting rootCAs, which presents the
+// client certificate loaded last
+func (t *clientTLSTransport) newTransport(rootCAs *x509.CertPool) *http.Transport {
+	// the transport dials the TLS connections itself, so that proxies and
+	// HTTP/2 are supported
+	transport := NewTransport()
+	transport.DialTLS = nil
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.ForceAttemptHTTP2 = true
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:              rootCAs,
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: t.files.clientCertificate,
+	}
+
+	return transport
+}
+
+// clientTLSFiles holds the client certificate and the CA certificates loaded
+// from their files
+type clientTLSFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mux           sync.Mutex
+	fingerprint   string
+	caFingerprint string
+	checked       time.Time
+	certificate   *tls.Certificate
+	rootCAs       *x509.CertPool
+}
+
+// currentRootCAs returns the CA certificates loaded last, or the system ones
+// when caFile is not defined
+func (f *clientTLSFiles) currentRootCAs() *x509.CertPool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.rootCAs == nil {
+		return pool()
+	}
+
+	return f.rootCAs
+}
+
+// clientCertificate returns the client certificate loaded last, or no
+// certificate when certFile is not defined
+func (f *clientTLSFiles) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	f.reloadPeriodically()
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.certificate == nil {
+		return &tls.Certificate{}, nil
+	}
+
+	return f.certificate, nil
+}
+
+// reloadPeriodically reloads the files when they have not been checked for
+// changes for clientTLSReloadInterval. The files that cannot be loaded
+// anymore are ignored in favor of their previous content.
+func (f *clientTLSFiles) reloadPeriodically() {
+	f.mux.Lock()
+	due := time.Since(f.checked) >= clientTLSReloadInterval
+	if due {
+		f.checked = time.Now()
+	}
+	f.mux.Unlock()
+
+	if !due {
+		return
+	}
+
+	if err := f.reload(); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"cert_file": f.certFile,
+			"ca_file":   f.caFile,
+		}).Error("failed to reload client TLS files, keeping the current certificates")
+	}
+}
+
+// reload loads the files again when their size or modification time changed
+func (f *clientTLSFiles) reload() error {
+	fingerprint, err := filesFingerprint(f.certFile, f.keyFile, f.caFile)
+	if err != nil {
+		return err
+	}
+
+	caFingerprint, err := filesFingerprint(f.caFile)
+	if err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	unchanged := fingerprint == f.fingerprint
+	caUnchanged := caFingerprint == f.caFingerprint
+	rootCAs := f.rootCAs
+	f.mux.Unlock()
+
+	if unchanged {
+		return nil
+	}
+
+	var certificate *tls.Certificate
+	if f.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+
+		certificate = &cert
+	}
+
+	// the CA certificates are only loaded again when they changed, as the
+	// transport trusting them gets replaced
+	if f.caFile != "" && !caUnchanged {
+		if rootCAs, err = loadCAFile(f.caFile); err != nil {
+			return err
+		}
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.fingerprint = fingerprint
+	f.caFingerprint = caFingerprint
+	f.checked = time.Now()
+	f.certificate = certificate
+	f.rootCAs = rootCAs
+
+	return nil
+}
+
+// loadCAFile returns the system cert pool with the certificates of caFile
+func loadCAFile(caFile string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file: %w", err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	if !rootCAs.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no PEM encoded certificate found in CA file %q", caFile)
+	}
+
+	return rootCAs, nil
+}
+
+func filesFingerprint(files ...string) (string, error) {
+	var b strings.Builder
+
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&b, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,372 @@
+package httptransport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key of commonName signed by
+// ca, which is valid for 127.0.0.1
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	return ca.issueFor(t, commonName, usage, []string{commonName}, []net.IP{net.ParseIP("127.0.0.1")})
+}
+
+// issueFor returns the PEM encoded certificate and key of commonName signed
+// by ca, which is only valid for dnsNames and ips
+func (ca *testCA) issueFor(t *testing.T, commonName string, usage x509.ExtKeyUsage, dnsNames []string, ips []net.IP) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, content, 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// newMutualTLSServer returns a server which requires a client certificate
+// issued by ca, and responds with its common name
+func newMutualTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+
+	return newMutualTLSServerWithCert(t, ca, certPEM, keyPEM)
+}
+
+// newMutualTLSServerWithCert returns a server like newMutualTLSServer, which
+// presents the certificate of certPEM and keyPEM
+func newMutualTLSServerWithCert(t *testing.T, ca *testCA, certPEM, keyPEM []byte) *httptest.Server {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func getCommonName(t *testing.T, transport http.RoundTripper, url string) (string, error) {
+	t.Helper()
+
+	transport.(interface{ CloseIdleConnections() }).CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var commonName string
+	_, err = fmt.Fscan(resp.Body, &commonName)
+	require.NoError(t, err)
+
+	return commonName, nil
+}
+
+func TestNewTransportWithClientTLS(t *testing.T) {
+	reloadInterval := clientTLSReloadInterval
+	clientTLSReloadInterval = 0
+	t.Cleanup(func() { clientTLSReloadInterval = reloadInterval })
+
+	ca := newTestCA(t)
+	server := newMutualTLSServer(t, ca)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	now := time.Now()
+
+	certPEM, keyPEM := ca.issue(t, "client-1", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, now)
+	writeFile(t, keyFile, keyPEM, now)
+	writeFile(t, caFile, ca.pem, now)
+
+	t.Run("without client certificate", func(t *testing.T) {
+		transport, err := NewTransportWithClientTLS("", "", caFile)
+		require.NoError(t, err)
+
+		_, err = getCommonName(t, transport, server.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("without CA certificates", func(t *testing.T) {
+		transport, err := NewTransportWithClientTLS(certFile, keyFile, "")
+		require.NoError(t, err)
+
+		_, err = getCommonName(t, transport, server.URL)
+		require.Error(t, err)
+	})
+
+	transport, err := NewTransportWithClientTLS(certFile, keyFile, caFile)
+	require.NoError(t, err)
+
+	commonName, err := getCommonName(t, transport, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "client-1", commonName)
+
+	// rotate the client certificate
+	certPEM, keyPEM = ca.issue(t, "client-2", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, now.Add(time.Minute))
+	writeFile(t, keyFile, keyPEM, now.Add(time.Minute))
+
+	commonName, err = getCommonName(t, transport, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "client-2", commonName)
+
+	// a broken certificate keeps the previous one in use
+	writeFile(t, keyFile, []byte("broken"), now.Add(2*time.Minute))
+
+	commonName, err = getCommonName(t, transport, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "client-2", commonName)
+
+	// the files are not checked for changes on every connection
+	clientTLSReloadInterval = time.Hour
+
+	certPEM, keyPEM = ca.issue(t, "client-3", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, now.Add(3*time.Minute))
+	writeFile(t, keyFile, keyPEM, now.Add(3*time.Minute))
+
+	commonName, err = getCommonName(t, transport, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "client-2", commonName)
+}
+
+func TestNewTransportWithClientTLSVerifiesServer(t *testing.T) {
+	ca := newTestCA(t)
+	server := newMutualTLSServer(t, ca)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	certPEM, keyPEM := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+
+	// a CA which did not issue the certificate of the server
+	writeFile(t, caFile, newTestCA(t).pem, time.Now())
+
+	transport, err := NewTransportWithClientTLS(certFile, keyFile, caFile)
+	require.NoError(t, err)
+
+	current := transport.(*clientTLSTransport).transport()
+	require.NotNil(t, current.Proxy)
+	require.Nil(t, current.DialTLS) //nolint:staticcheck
+	require.True(t, current.ForceAttemptHTTP2)
+	require.False(t, current.TLSClientConfig.InsecureSkipVerify)
+
+	_, err = getCommonName(t, transport, server.URL)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "certificate signed by unknown authority")
+}
+
+func TestNewTransportWithClientTLSVerifiesServerName(t *testing.T) {
+	ca := newTestCA(t)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	certPEM, keyPEM := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	tests := map[string]struct {
+		dnsNames    []string
+		ips         []net.IP
+		expectedErr string
+	}{
+		"certificate of another host": {
+			dnsNames:    []string{"attacker.example"},
+			expectedErr: "cannot validate certificate for 127.0.0.1",
+		},
+		"certificate of another IP address": {
+			ips:         []net.IP{net.ParseIP("127.0.0.2")},
+			expectedErr: "certificate is valid for 127.0.0.2, not 127.0.0.1",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			serverCertPEM, serverKeyPEM := ca.issueFor(t, "server", x509.ExtKeyUsageServerAuth, tc.dnsNames, tc.ips)
+			server := newMutualTLSServerWithCert(t, ca, serverCertPEM, serverKeyPEM)
+
+			transport, err := NewTransportWithClientTLS(certFile, keyFile, caFile)
+			require.NoError(t, err)
+
+			// the server is addressed by its IP address
+			_, err = getCommonName(t, transport, server.URL)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestNewTransportWithClientTLSReloadsCAFile(t *testing.T) {
+	reloadInterval := clientTLSReloadInterval
+	clientTLSReloadInterval = 0
+	t.Cleanup(func() { clientTLSReloadInterval = reloadInterval })
+
+	ca := newTestCA(t)
+	server := newMutualTLSServer(t, ca)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	now := time.Now()
+
+	certPEM, keyPEM := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, now)
+	writeFile(t, keyFile, keyPEM, now)
+	writeFile(t, caFile, newTestCA(t).pem, now)
+
+	transport, err := NewTransportWithClientTLS(certFile, keyFile, caFile)
+	require.NoError(t, err)
+
+	_, err = getCommonName(t, transport, server.URL)
+	require.Error(t, err)
+
+	// the CA of the server is trusted once the CA file is updated
+	writeFile(t, caFile, ca.pem, now.Add(time.Minute))
+
+	commonName, err := getCommonName(t, transport, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "client", commonName)
+}
+
+func TestNewTransportWithClientTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	writeFile(t, invalidFile, []byte("invalid"), time.Now())
+
+	tests := map[string]struct {
+		certFile string
+		keyFile  string
+		caFile   string
+	}{
+		"certificate without key": {certFile: invalidFile},
+		"key without certificate": {keyFile: invalidFile},
+		"invalid client key pair": {certFile: invalidFile, keyFile: invalidFile},
+		"invalid CA file":         {caFile: invalidFile},
+		"missing CA file":         {caFile: filepath.Join(dir, "missing.pem")},
+		"missing client key pair": {certFile: filepath.Join(dir, "missing.crt"), keyFile: filepath.Join(dir, "missing.key")},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewTransportWithClientTLS(tc.certFile, tc.keyFile, tc.caFile)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	transport, err := ClientTransport(config.ClientTLS{})
+	require.NoError(t, err)
+	require.Same(t, DefaultTransport, transport)
+
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	transport, err = ClientTransport(config.ClientTLS{CAFile: caFile})
+	require.NoError(t, err)
+	require.NotSame(t, DefaultTransport, transport)
+}
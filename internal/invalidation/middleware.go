@@ -0,0 +1,186 @@
+// Package invalidation provides an endpoint GitLab calls to invalidate the
+// cached configuration and archives of domains when they change, e.g. after a
+// deployment, instead of waiting for the caches to expire.
+package invalidation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+const (
+	// tokenHeader is the header holding the JWT, which is the same header
+	// Pages uses to authenticate to the GitLab internal API
+	tokenHeader = "Gitlab-Pages-Api-Request"
+	// tokenIssuer and tokenAudience must be the iss and aud claims of the
+	// tokens, so that the tokens Pages signs for the GitLab API, which share
+	// the same secret, cannot be replayed against the endpoint
+	tokenIssuer   = "gitlab"
+	tokenAudience = "gitlab-pages-invalidation"
+
+	maxBodySize = 1024 * 1024
+)
+
+var (
+	errNoSecret        = errors.New("invalidation endpoint requires the GitLab API secret")
+	errMissingToken    = errors.New("missing token")
+	errMissingExp      = errors.New("token must have an expiration time")
+	errInvalidIssuer   = errors.New("token must be issued by " + tokenIssuer)
+	errInvalidAudience = errors.New("token audience must be " + tokenAudience)
+)
+
+// Request is the body of an invalidation request, e.g.:
+//
+//	{"hosts": ["group.gitlab.io"], "project_ids": [123], "refresh": true}
+type Request struct {
+	Hosts []string `json:"hosts"`
+	// ProjectIDs invalidates the domains serving the projects. With the Redis
+	// domains cache store, only the domains cached by the replica receiving
+	// the request are found, so GitLab must send it to every replica or list
+	// the hosts too.
+	ProjectIDs []uint64 `json:"project_ids"`
+	// Refresh refreshes the domains configuration straight away instead of
+	// evicting it
+	Refresh bool `json:"refresh"`
+}
+
+// Response is the body of the response to an invalidation request
+type Response struct {
+	// Archives is the number of archives dropped from the cache
+	Archives int `json:"archives"`
+}
+
+type middleware struct {
+	next      http.Handler
+	path      string
+	secret    []byte
+	domains   source.Invalidator
+	archiveFS []vfs.Invalidator
+}
+
+// NewMiddleware serves the invalidation endpoint on path. Requests must be
+// POST requests authenticated with a JWT signed with secret, the GitLab API
+// secret, issued by tokenIssuer for tokenAudience. The configuration of the
+// hosts and projects requested gets invalidated in domains, and the archives
+// they serve are dropped from the servings. An empty path disables the
+// endpoint.
+func NewMiddleware(handler http.Handler, path string, secret []byte, domains source.Source, servings ...serving.Serving) (http.Handler, error) {
+	if path == "" {
+		return handler, nil
+	}
+
+	// an empty secret is a valid HMAC key, which anyone can sign tokens with
+	if len(secret) == 0 {
+		return nil, errNoSecret
+	}
+
+	m := &middleware{
+		next:   handler,
+		path:   path,
+		secret: secret,
+	}
+
+	m.domains, _ = domains.(source.Invalidator)
+
+	for _, s := range servings {
+		if invalidator, ok := s.(vfs.Invalidator); ok {
+			m.archiveFS = append(m.archiveFS, invalidator)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != m.path {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := m.authenticate(r); err != nil {
+		logging.LogRequest(r).WithError(err).Warn("invalidation request unauthorized")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var cacheKeys []string
+	if m.domains != nil {
+		cacheKeys = m.domains.Invalidate(req.Hosts, req.ProjectIDs, req.Refresh)
+	}
+
+	var res Response
+	for _, fs := range m.archiveFS {
+		res.Archives += fs.Invalidate(cacheKeys)
+	}
+
+	logging.LogRequest(r).WithFields(log.Fields{
+		"hosts":            req.Hosts,
+		"project_ids":      req.ProjectIDs,
+		"refresh":          req.Refresh,
+		"archives_dropped": res.Archives,
+		"cache_keys_count": len(cacheKeys),
+	}).Info("invalidated domains")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (m *middleware) authenticate(r *http.Request) error {
+	token := r.Header.Get(tokenHeader)
+	if token == "" {
+		return errMissingToken
+	}
+
+	claims := &jwt.RegisteredClaims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+
+		return m.secret, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// tokens without expiration could be replayed forever
+	if claims.ExpiresAt == nil {
+		return errMissingExp
+	}
+
+	if claims.Issuer != tokenIssuer {
+		return errInvalidIssuer
+	}
+
+	if !claims.VerifyAudience(tokenAudience, true) {
+		return errInvalidAudience
+	}
+
+	return nil
+}
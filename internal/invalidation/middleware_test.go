@@ -0,0 +1,215 @@
+package invalidation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+type domainsStub struct {
+	hosts      []string
+	projectIDs []uint64
+	refresh    bool
+}
+
+func (d *domainsStub) GetDomain(context.Context, string) (*domain.Domain, error) {
+	return nil, nil
+}
+
+func (d *domainsStub) Invalidate(hosts []string, projectIDs []uint64, refresh bool) []string {
+	d.hosts, d.projectIDs, d.refresh = hosts, projectIDs, refresh
+
+	return []string{"sha1", "sha2"}
+}
+
+type servingStub struct {
+	serving.Serving
+	cacheKeys []string
+}
+
+func (s *servingStub) Invalidate(cacheKeys []string) int {
+	s.cacheKeys = cacheKeys
+
+	return len(cacheKeys)
+}
+
+func (s *servingStub) Reconfigure(*config.Config) error {
+	return nil
+}
+
+func token(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.RegisteredClaims) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func validClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    "gitlab",
+		Audience:  jwt.ClaimStrings{"gitlab-pages-invalidation"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		method         string
+		path           string
+		token          string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"invalidates hosts and projects": {
+			method:         http.MethodPost,
+			path:           "/@invalidate",
+			token:          token(t, jwt.SigningMethodHS256, secret, validClaims()),
+			body:           `{"hosts":["group.gitlab.io"],"project_ids":[123],"refresh":true}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"archives":2}`,
+		},
+		"other paths are served by the next handler": {
+			method:         http.MethodPost,
+			path:           "/index.html",
+			expectedStatus: http.StatusTeapot,
+		},
+		"method not allowed": {
+			method:         http.MethodGet,
+			path:           "/@invalidate",
+			token:          token(t, jwt.SigningMethodHS256, secret, validClaims()),
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"missing token": {
+			method:         http.MethodPost,
+			path:           "/@invalidate",
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token signed with another secret": {
+			method:         http.MethodPost,
+			path:           "/@invalidate",
+			token:          token(t, jwt.SigningMethodHS256, []byte("another secret"), validClaims()),
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token without expiration": {
+			method: http.MethodPost,
+			path:   "/@invalidate",
+			token: token(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+				Issuer:   "gitlab",
+				Audience: jwt.ClaimStrings{"gitlab-pages-invalidation"},
+			}),
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"expired token": {
+			method: http.MethodPost,
+			path:   "/@invalidate",
+			token: token(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			}),
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token signed by Pages for the GitLab API": {
+			method: http.MethodPost,
+			path:   "/@invalidate",
+			token: token(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+				Issuer:    "gitlab-pages",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			}),
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token without audience": {
+			method: http.MethodPost,
+			path:   "/@invalidate",
+			token: token(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+				Issuer:    "gitlab",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			}),
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"unsigned token": {
+			method:         http.MethodPost,
+			path:           "/@invalidate",
+			token:          token(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, validClaims()),
+			body:           `{}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"invalid body": {
+			method:         http.MethodPost,
+			path:           "/@invalidate",
+			token:          token(t, jwt.SigningMethodHS256, secret, validClaims()),
+			body:           `{"hosts":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			domains := &domainsStub{}
+			archives := &servingStub{}
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			})
+
+			handler, err := NewMiddleware(next, "/@invalidate", secret, domains, archives)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(tc.method, "https://pages.gitlab.io"+tc.path, strings.NewReader(tc.body))
+			if tc.token != "" {
+				r.Header.Set("Gitlab-Pages-Api-Request", tc.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+
+			if tc.expectedStatus != http.StatusOK {
+				require.Nil(t, domains.hosts)
+				require.Nil(t, archives.cacheKeys)
+				return
+			}
+
+			require.JSONEq(t, tc.expectedBody, w.Body.String())
+			require.Equal(t, []string{"group.gitlab.io"}, domains.hosts)
+			require.Equal(t, []uint64{123}, domains.projectIDs)
+			require.True(t, domains.refresh)
+			require.Equal(t, []string{"sha1", "sha2"}, archives.cacheKeys)
+		})
+	}
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler, err := NewMiddleware(next, "", nil, &domainsStub{})
+	require.NoError(t, err)
+	require.IsType(t, next, handler)
+}
+
+func TestMiddlewareWithoutSecret(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	_, err := NewMiddleware(next, "/@invalidate", []byte{}, &domainsStub{})
+	require.ErrorIs(t, err, errNoSecret)
+}
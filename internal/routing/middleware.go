@@ -12,6 +12,9 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
 )
 
+// staleHeader marks responses served with a stale domain configuration
+const staleHeader = "Gitlab-Pages-Stale"
+
 // NewMiddleware returns middleware which determine the host and domain for the request, for
 // downstream middlewares to use
 func NewMiddleware(handler http.Handler, s source.Source) http.Handler {
@@ -31,6 +34,10 @@ func NewMiddleware(handler http.Handler, s source.Source) http.Handler {
 			return
 		}
 
+		if d != nil && d.Stale {
+			w.Header().Set(staleHeader, "true")
+		}
+
 		r = domain.ReqWithDomain(r, d)
 
 		handler.ServeHTTP(w, r)
@@ -11,19 +11,26 @@ import (
 
 	contentencoding "gitlab.com/feistel/go-contentencoding/encoding"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/compression"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 )
 
+// encodingZstd is the Zstandard content encoding, unknown to contentencoding
+const encodingZstd = "zstd"
+
 var (
 	// Server side content encoding priority.
 	supportedEncodings = contentencoding.Preference{
 		contentencoding.Brotli:   1.0,
+		encodingZstd:             0.75,
 		contentencoding.Gzip:     0.5,
 		contentencoding.Identity: 0.1,
 	}
 
 	compressedEncodings = map[string]string{
 		contentencoding.Brotli: ".br",
+		encodingZstd:           ".zst",
 		contentencoding.Gzip:   ".gz",
 	}
 )
@@ -62,45 +69,99 @@ func (reader *Reader) detectContentType(ctx context.Context, root vfs.Root, path
 }
 
 func (reader *Reader) handleContentEncoding(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, fullPath string) string {
+	for _, encoding := range acceptedEncodings(r) {
+		extension := compressedEncodings[encoding]
+		path := fullPath + extension
+
+		// Ensure the file is not a symlink
+		if fi, err := root.Lstat(ctx, path); err == nil && fi.Mode().IsRegular() {
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			// http.ServeContent doesn't set Content-Length if Content-Encoding is set
+			w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+
+			return path
+		}
+	}
+
+	return fullPath
+}
+
+// acceptedEncodings returns the compressed encodings accepted by the client,
+// the preferred one first
+func acceptedEncodings(r *http.Request) []string {
 	// don't accept range requests for compressed content
 	if r.Header.Get("Range") != "" {
-		return fullPath
+		return nil
 	}
 
 	acceptHeader := r.Header.Get("Accept-Encoding")
 
 	// don't send compressed content if there's no accept-encoding header
 	if acceptHeader == "" {
-		return fullPath
+		return nil
 	}
 
 	results, err := supportedEncodings.Negotiate(acceptHeader, contentencoding.AliasIdentity)
 	if err != nil {
-		return fullPath
-	}
-
-	if len(results) == 0 {
-		return fullPath
+		return nil
 	}
 
-	for _, encoding := range results {
+	for i, encoding := range results {
 		if encoding == contentencoding.Identity {
-			break
+			return results[:i]
 		}
+	}
 
-		extension := compressedEncodings[encoding]
-		path := fullPath + extension
+	return results
+}
 
-		// Ensure the file is not a symlink
-		if fi, err := root.Lstat(ctx, path); err == nil && fi.Mode().IsRegular() {
-			w.Header().Set("Content-Encoding", encoding)
+// compressionEncoding negotiates the encoding preferred by the client to
+// compress a file on the fly, when the file is compressible and is not served
+// precompressed already, and sets it as the Content-Encoding of the response.
+// It returns "" when the content is not compressed.
+func (reader *Reader) compressionEncoding(w http.ResponseWriter, r *http.Request, contentType string, size int64) string {
+	if reader.compressor == nil || w.Header().Get("Content-Encoding") != "" || !reader.compressor.Compressible(contentType, size) {
+		return ""
+	}
 
-			// http.ServeContent doesn't set Content-Length if Content-Encoding is set
-			w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	// the content served depends on the encodings accepted by the client
+	w.Header().Add("Vary", "Accept-Encoding")
 
-			return path
+	for _, encoding := range acceptedEncodings(r) {
+		if reader.compressor.Supports(encoding) {
+			w.Header().Set("Content-Encoding", encoding)
+			return encoding
 		}
 	}
 
-	return fullPath
+	return ""
+}
+
+// compressContent compresses the content of file with the encoding of key
+func (reader *Reader) compressContent(w http.ResponseWriter, file vfs.File, key compression.Key) ([]byte, error) {
+	data, err := reader.compressor.Compress(key, func() (io.ReadCloser, error) {
+		return io.NopCloser(file), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+
+	return data, nil
+}
+
+// compressionKey returns the key of the variant of the file of fullPath
+// compressed with encoding. The content of a file is only identified within
+// its project, the path of the deployment standing for the project when its
+// ID is unknown.
+func compressionKey(lookupPath *serving.LookupPath, fullPath, contentID, encoding string) compression.Key {
+	key := compression.Key{ProjectID: lookupPath.ProjectID, Path: fullPath, ContentID: contentID, Encoding: encoding}
+	if key.ProjectID == 0 {
+		key.Path = lookupPath.Path + "\x00" + fullPath
+	}
+
+	return key
 }
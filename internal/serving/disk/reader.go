@@ -1,6 +1,7 @@
 package disk
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -13,7 +14,10 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachecontrol"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/compression"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/errortracking"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/headers"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/redirects"
@@ -27,6 +31,10 @@ import (
 type Reader struct {
 	fileSizeMetric *prometheus.HistogramVec
 	vfs            vfs.VFS
+	// compressor compresses files on the fly, nil when it is disabled
+	compressor *compression.Compressor
+	// cacheControl computes the Cache-Control of the files served
+	cacheControl *cachecontrol.Policy
 }
 
 // Show the user some validation messages for their _redirects file
@@ -37,6 +45,14 @@ func (reader *Reader) serveRedirectsStatus(h serving.Handler, redirects *redirec
 	fmt.Fprintln(h.Writer, redirects.Status())
 }
 
+// Show the user some validation messages for their _headers file
+func (reader *Reader) serveHeadersStatus(h serving.Handler, headers *headers.Headers) {
+	h.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	h.Writer.Header().Set("X-Content-Type-Options", "nosniff")
+	h.Writer.WriteHeader(http.StatusOK)
+	fmt.Fprintln(h.Writer, headers.Status())
+}
+
 // tryRedirects returns true if it successfully handled request
 func (reader *Reader) tryRedirects(h serving.Handler) bool {
 	ctx := h.Request.Context()
@@ -111,7 +127,13 @@ func (reader *Reader) tryFile(h serving.Handler) bool {
 		return true
 	}
 
-	return reader.serveFile(ctx, h.Writer, h.Request, root, fullPath, h.LookupPath.SHA256, h.LookupPath.HasAccessControl)
+	// Serve status of `_headers` under `_headers`
+	if fullPath == headers.ConfigFile {
+		reader.serveHeadersStatus(h, headers.ParseHeaders(ctx, root))
+		return true
+	}
+
+	return reader.serveFile(ctx, h.Writer, h.Request, root, fullPath, h.LookupPath)
 }
 
 func redirectPath(request *http.Request) string {
@@ -196,7 +218,8 @@ func (reader *Reader) resolvePath(ctx context.Context, root vfs.Root, subPath ..
 	return fullPath, nil
 }
 
-func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, origPath, sha string, accessControl bool) bool {
+func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, origPath string, lookupPath *serving.LookupPath) bool {
+	sha := lookupPath.SHA256
 	fullPath := reader.handleContentEncoding(ctx, w, r, root, origPath)
 
 	file, err := root.Open(ctx, fullPath)
@@ -213,26 +236,64 @@ func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *h
 		return true
 	}
 
-	ce := w.Header().Get("Content-Encoding")
-	w.Header().Set("ETag", fmt.Sprintf("%q", etag(ce, sha)))
-
-	if !accessControl {
-		// Set caching headers
-		w.Header().Set("Cache-Control", "max-age=600")
-		w.Header().Set("Expires", time.Now().Add(10*time.Minute).Format(time.RFC1123))
-	}
-
 	contentType, err := reader.detectContentType(ctx, root, origPath)
 	if err != nil {
 		httperrors.Serve500WithRequest(w, r, "detectContentType", err)
 		return true
 	}
 
+	encoding := reader.compressionEncoding(w, r, contentType, fi.Size())
+
+	contentID := reader.contentID(ctx, root, fullPath)
+	if contentID == "" {
+		contentID = sha
+	}
+
+	ce := w.Header().Get("Content-Encoding")
+	w.Header().Set("ETag", fmt.Sprintf("%q", etag(ce, contentID)))
+
+	w.Header().Set("Cache-Control", reader.cacheControl.Directives(origPath, contentType))
 	w.Header().Set("Content-Type", contentType)
 
+	// the headers of the _headers file override the ones set above
+	headers.LoadHeaders(ctx, root, lookupPath.Path+"\x00"+sha).Apply(w, r.URL.Path)
+
+	if lookupPath.HasAccessControl {
+		// the files of access controlled projects only get the directives
+		// configured for them, whatever the policy or the _headers file set
+		if directives := reader.cacheControl.AccessControl(); directives != "" {
+			w.Header().Set("Cache-Control", directives)
+		} else {
+			w.Header().Del("Cache-Control")
+		}
+		w.Header().Del("Expires")
+	} else if w.Header().Get("Expires") == "" {
+		if expires, ok := cachecontrol.Expires(w.Header().Get("Cache-Control"), time.Now()); ok {
+			w.Header().Set("Expires", expires)
+		}
+	}
+
 	reader.fileSizeMetric.WithLabelValues(reader.vfs.Name()).Observe(float64(fi.Size()))
 
-	// Support vfs.SeekableFile if available (uncompressed files)
+	// the content is only compressed for the responses sending it
+	if encoding != "" && !vfsServing.WithoutContent(w, r, fi.ModTime()) {
+		compressed, err := reader.compressContent(w, file, compressionKey(lookupPath, fullPath, contentID, encoding))
+		if err != nil {
+			w.Header().Del("Content-Encoding")
+			httperrors.Serve500WithRequest(w, r, "compressContent", err)
+			return true
+		}
+
+		http.ServeContent(w, r, origPath, fi.ModTime(), bytes.NewReader(compressed))
+		return true
+	}
+
+	if encoding != "" {
+		vfsServing.ServeCompressedFile(w, r, fi.ModTime(), file)
+		return true
+	}
+
+	// Support vfs.SeekableFile if available (all but the files of compressed tar archives)
 	if rs, ok := file.(vfs.SeekableFile); ok {
 		http.ServeContent(w, r, origPath, fi.ModTime(), rs)
 	} else {
@@ -243,6 +304,23 @@ func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *h
 	return true
 }
 
+// contentID returns the identifier of the content of the file of fullPath
+// when the root identifies it, so that its ETag does not change across
+// deployments until its content changes, and "" otherwise
+func (reader *Reader) contentID(ctx context.Context, root vfs.Root, fullPath string) string {
+	identifier, ok := root.(vfs.ContentIdentifier)
+	if !ok {
+		return ""
+	}
+
+	id, err := identifier.ContentID(ctx, fullPath)
+	if err != nil {
+		return ""
+	}
+
+	return id
+}
+
 func etag(contentEncoding, sha string) string {
 	if contentEncoding == "" {
 		return sha
@@ -1,10 +1,28 @@
 package disk
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/local"
 )
 
 func Test_redirectPath(t *testing.T) {
@@ -66,3 +84,575 @@ func newRequest(t *testing.T, url string) *http.Request {
 
 	return r
 }
+
+// contentID returns the identifier of the content of a local file
+func contentID(content string) string {
+	sum := sha256.Sum256([]byte(content))
+
+	return hex.EncodeToString(sum[:16])
+}
+
+func TestServeFileCompression(t *testing.T) {
+	dir := t.TempDir()
+
+	html := strings.Repeat("<p>GitLab Pages</p>\n", 100)
+
+	files := map[string]string{
+		"index.html":        html,
+		"small.html":        "<p>small</p>",
+		"image.png":         html,
+		"style.css":         html,
+		"style.css.gz":      "precompressed",
+		"script.js":         html,
+		"script.js.zst":     "precompressed",
+		"uncompressed.html": html,
+	}
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	tests := map[string]struct {
+		path             string
+		disabled         bool
+		headers          http.Header
+		expectedStatus   int
+		expectedEncoding string
+		expectedVary     string
+		expectedBody     string
+	}{
+		"gzip": {
+			path:             "/index.html",
+			headers:          http.Header{"Accept-Encoding": {"gzip"}},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "gzip",
+			expectedVary:     "Accept-Encoding",
+			expectedBody:     html,
+		},
+		"brotli preferred": {
+			path:             "/index.html",
+			headers:          http.Header{"Accept-Encoding": {"gzip, deflate, br"}},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "br",
+			expectedVary:     "Accept-Encoding",
+			expectedBody:     html,
+		},
+		"zstd": {
+			path:             "/index.html",
+			headers:          http.Header{"Accept-Encoding": {"zstd"}},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "zstd",
+			expectedVary:     "Accept-Encoding",
+			expectedBody:     html,
+		},
+		"zstd preferred over gzip": {
+			path:             "/index.html",
+			headers:          http.Header{"Accept-Encoding": {"gzip, zstd"}},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "zstd",
+			expectedVary:     "Accept-Encoding",
+			expectedBody:     html,
+		},
+		"identity": {
+			path:           "/index.html",
+			expectedStatus: http.StatusOK,
+			expectedVary:   "Accept-Encoding",
+			expectedBody:   html,
+		},
+		"unsupported encoding": {
+			path:           "/index.html",
+			headers:        http.Header{"Accept-Encoding": {"deflate"}},
+			expectedStatus: http.StatusOK,
+			expectedVary:   "Accept-Encoding",
+			expectedBody:   html,
+		},
+		"range": {
+			path:           "/index.html",
+			headers:        http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=3-14"}},
+			expectedStatus: http.StatusPartialContent,
+			expectedVary:   "Accept-Encoding",
+			expectedBody:   "GitLab Pages",
+		},
+		"smaller than the minimum size": {
+			path:           "/small.html",
+			headers:        http.Header{"Accept-Encoding": {"gzip"}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<p>small</p>",
+		},
+		"not compressible": {
+			path:           "/image.png",
+			headers:        http.Header{"Accept-Encoding": {"gzip"}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   html,
+		},
+		"precompressed": {
+			path:             "/style.css",
+			headers:          http.Header{"Accept-Encoding": {"gzip"}},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "gzip",
+			expectedVary:     "Accept-Encoding",
+			expectedBody:     "precompressed",
+		},
+		"precompressed zstd": {
+			path:             "/script.js",
+			headers:          http.Header{"Accept-Encoding": {"gzip, zstd"}},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "zstd",
+			expectedVary:     "Accept-Encoding",
+			expectedBody:     "precompressed",
+		},
+		"disabled": {
+			path:           "/uncompressed.html",
+			disabled:       true,
+			headers:        http.Header{"Accept-Encoding": {"gzip"}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   html,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(vfs.Instrumented(&local.VFS{}))
+			require.NoError(t, s.Reconfigure(&config.Config{
+				Compression: config.Compression{Dynamic: !tc.disabled, MinSize: 100, CacheSize: 1024 * 1024},
+			}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project"+tc.path, nil)
+			for header, values := range tc.headers {
+				r.Header[header] = values
+			}
+
+			require.True(t, s.ServeFileHTTP(serving.Handler{
+				Writer:     w,
+				Request:    r,
+				LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dir, SHA256: "sha"},
+				SubPath:    tc.path,
+			}))
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+			require.Equal(t, tc.expectedEncoding, resp.Header.Get("Content-Encoding"))
+			require.Equal(t, tc.expectedVary, resp.Header.Get("Vary"))
+
+			var body io.Reader = resp.Body
+
+			switch {
+			case tc.expectedBody == "precompressed":
+			case tc.expectedEncoding == "gzip":
+				gr, err := gzip.NewReader(body)
+				require.NoError(t, err)
+
+				body = gr
+			case tc.expectedEncoding == "br":
+				body = brotli.NewReader(body)
+			case tc.expectedEncoding == "zstd":
+				zr, err := zstd.NewReader(body)
+				require.NoError(t, err)
+				defer zr.Close()
+
+				body = zr
+			}
+
+			content, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedBody, string(content))
+
+			if tc.expectedEncoding != "" {
+				require.Equal(t, strconv.Itoa(w.Body.Len()), resp.Header.Get("Content-Length"))
+				// the ETag identifies the content of the file served
+				require.Equal(t, fmt.Sprintf("%q", contentID(tc.expectedBody)+"-"+tc.expectedEncoding), resp.Header.Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestServeFileCompressionWithoutContent(t *testing.T) {
+	dir := t.TempDir()
+	html := strings.Repeat("<p>GitLab Pages</p>\n", 100)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte(html), 0600))
+
+	s := New(vfs.Instrumented(&local.VFS{}))
+	require.NoError(t, s.Reconfigure(&config.Config{
+		Compression: config.Compression{Dynamic: true, MinSize: 100, CacheSize: 1024 * 1024},
+	}))
+
+	etag := fmt.Sprintf("%q", contentID(html)+"-gzip")
+
+	tests := map[string]struct {
+		method         string
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		"head": {
+			method:         http.MethodHead,
+			expectedStatus: http.StatusOK,
+		},
+		"not modified": {
+			method:         http.MethodGet,
+			ifNoneMatch:    etag,
+			expectedStatus: http.StatusNotModified,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, "http://group.gitlab-example.com/project/index.html", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tc.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+
+			require.True(t, s.ServeFileHTTP(serving.Handler{
+				Writer:     w,
+				Request:    r,
+				LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dir, SHA256: "sha"},
+				SubPath:    "index.html",
+			}))
+
+			// the headers are the ones of the compressed content, which is not
+			// compressed as it is not sent
+			require.Equal(t, tc.expectedStatus, w.Code)
+			require.Equal(t, etag, w.Header().Get("ETag"))
+			require.Empty(t, w.Header().Get("Content-Length"))
+			require.Zero(t, w.Body.Len())
+
+			if tc.expectedStatus == http.StatusOK {
+				require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestServeFileSharesCompressor(t *testing.T) {
+	cfg := &config.Config{Compression: config.Compression{Dynamic: true, MinSize: 100, CacheSize: 1024}}
+
+	first, second := New(vfs.Instrumented(&local.VFS{})), New(vfs.Instrumented(&local.VFS{}))
+	require.NoError(t, first.Reconfigure(cfg))
+	require.NoError(t, second.Reconfigure(cfg))
+
+	require.NotNil(t, first.(*Disk).reader.compressor)
+	require.Same(t, first.(*Disk).reader.compressor, second.(*Disk).reader.compressor)
+
+	cfg.Compression.CacheSize = 2048
+	require.NoError(t, second.Reconfigure(cfg))
+	require.NotSame(t, first.(*Disk).reader.compressor, second.(*Disk).reader.compressor)
+}
+
+func TestServeFileHeaders(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"index.html": "<p>GitLab Pages</p>",
+		"_headers":   "/project/*\n  X-Frame-Options: DENY\n  Cache-Control: no-cache\n/project/*.css\n  Set-Cookie: session=1\n",
+	}
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	tests := map[string]struct {
+		path            string
+		expectedHeaders http.Header
+		expectedBody    string
+	}{
+		"file": {
+			path: "/index.html",
+			expectedHeaders: http.Header{
+				"X-Frame-Options": {"DENY"},
+				"Cache-Control":   {"no-cache"},
+				"Content-Type":    {"text/html; charset=utf-8"},
+			},
+			expectedBody: "<p>GitLab Pages</p>",
+		},
+		"status": {
+			path: "/_headers",
+			expectedHeaders: http.Header{
+				"Content-Type":           {"text/plain; charset=utf-8"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+			expectedBody: "2 rules\nrule 1: valid\nrule 2: error: header can not be set: Set-Cookie\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(vfs.Instrumented(&local.VFS{}))
+			require.NoError(t, s.Reconfigure(&config.Config{}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project"+tc.path, nil)
+
+			require.True(t, s.ServeFileHTTP(serving.Handler{
+				Writer:     w,
+				Request:    r,
+				LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dir, SHA256: "sha"},
+				SubPath:    strings.TrimPrefix(tc.path, "/"),
+			}))
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			for header, values := range tc.expectedHeaders {
+				require.Equal(t, values, resp.Header.Values(header), header)
+			}
+
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestServeFileCacheControl(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"index.html":          "<p>GitLab Pages</p>",
+		"about.3f2a1b9c.html": "<p>About</p>",
+		"app.3f2a1b9c.js":     "console.log('GitLab Pages')",
+		"styles/style.css":    "p {}",
+		"_headers":            "/project/styles/*\n  Cache-Control: public, max-age=3600\n",
+	}
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "styles"), 0700))
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	tests := map[string]struct {
+		path            string
+		accessControl   bool
+		expected        string
+		expectedExpires time.Duration
+	}{
+		"html": {
+			path:            "/index.html",
+			expected:        "max-age=60, stale-while-revalidate=600",
+			expectedExpires: time.Minute,
+		},
+		"fingerprinted asset": {
+			path:            "/app.3f2a1b9c.js",
+			expected:        "max-age=31536000, immutable",
+			expectedExpires: 365 * 24 * time.Hour,
+		},
+		"fingerprinted html": {
+			path:            "/about.3f2a1b9c.html",
+			expected:        "max-age=60, stale-while-revalidate=600",
+			expectedExpires: time.Minute,
+		},
+		"overridden by _headers": {
+			path:            "/styles/style.css",
+			expected:        "public, max-age=3600",
+			expectedExpires: time.Hour,
+		},
+		"access control": {
+			path:          "/index.html",
+			accessControl: true,
+			expected:      "private, no-cache",
+		},
+		"access control fingerprinted asset": {
+			path:          "/app.3f2a1b9c.js",
+			accessControl: true,
+			expected:      "private, no-cache",
+		},
+		"access control overridden by _headers": {
+			path:          "/styles/style.css",
+			accessControl: true,
+			expected:      "private, no-cache",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(vfs.Instrumented(&local.VFS{}))
+			require.NoError(t, s.Reconfigure(&config.Config{CacheControl: config.CacheControl{
+				MaxAge:                   10 * time.Minute,
+				HTMLMaxAge:               time.Minute,
+				HTMLStaleWhileRevalidate: 10 * time.Minute,
+				ImmutableMaxAge:          365 * 24 * time.Hour,
+				FingerprintPattern:       `[.-][0-9a-f]{8,}\.[0-9a-z]+$`,
+				AccessControl:            "private, no-cache",
+			}}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project"+tc.path, nil)
+
+			require.True(t, s.ServeFileHTTP(serving.Handler{
+				Writer:     w,
+				Request:    r,
+				LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dir, SHA256: "sha", HasAccessControl: tc.accessControl},
+				SubPath:    strings.TrimPrefix(tc.path, "/"),
+			}))
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, tc.expected, resp.Header.Get("Cache-Control"))
+
+			if tc.accessControl {
+				require.Empty(t, resp.Header.Get("Expires"))
+				return
+			}
+
+			expires, err := time.Parse(time.RFC1123, resp.Header.Get("Expires"))
+			require.NoError(t, err)
+			require.WithinDuration(t, time.Now().Add(tc.expectedExpires), expires, 2*time.Second)
+		})
+	}
+}
+
+// the files are served with the same caching headers as before the
+// Cache-Control policy was configurable, until it is configured otherwise
+func TestServeFileCacheControlDefault(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"index.html":      "<p>GitLab Pages</p>",
+		"app.3f2a1b9c.js": "console.log('GitLab Pages')",
+		"image.png":       "png",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	policies := map[string]*config.Config{
+		"not configured": nil,
+		"default flags": {CacheControl: config.CacheControl{
+			MaxAge:          10 * time.Minute,
+			HTMLMaxAge:      10 * time.Minute,
+			ImmutableMaxAge: 365 * 24 * time.Hour,
+		}},
+	}
+
+	for policy, cfg := range policies {
+		for _, path := range []string{"/index.html", "/app.3f2a1b9c.js", "/image.png"} {
+			for _, accessControl := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s %s access control %t", policy, path, accessControl), func(t *testing.T) {
+					s := New(vfs.Instrumented(&local.VFS{}))
+					if cfg != nil {
+						require.NoError(t, s.Reconfigure(cfg))
+					}
+
+					w := httptest.NewRecorder()
+					r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project"+path, nil)
+
+					require.True(t, s.ServeFileHTTP(serving.Handler{
+						Writer:     w,
+						Request:    r,
+						LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dir, SHA256: "sha", HasAccessControl: accessControl},
+						SubPath:    strings.TrimPrefix(path, "/"),
+					}))
+
+					resp := w.Result()
+					defer resp.Body.Close()
+
+					require.Equal(t, http.StatusOK, resp.StatusCode)
+
+					if accessControl {
+						require.Empty(t, resp.Header.Values("Cache-Control"))
+						require.Empty(t, resp.Header.Values("Expires"))
+						return
+					}
+
+					require.Equal(t, []string{"max-age=600"}, resp.Header.Values("Cache-Control"))
+
+					expires := resp.Header.Get("Expires")
+					parsed, err := time.Parse(time.RFC1123, expires)
+					require.NoError(t, err)
+					require.Equal(t, parsed.In(time.Local).Format(time.RFC1123), expires)
+					require.WithinDuration(t, time.Now().Add(10*time.Minute), parsed, 2*time.Second)
+				})
+			}
+		}
+	}
+}
+
+func TestServeFileETag(t *testing.T) {
+	deployments := map[string]map[string]string{
+		"sha1": {"index.html": "<p>GitLab Pages</p>", "about.html": "<p>About</p>"},
+		"sha2": {"index.html": "<p>GitLab Pages</p>", "about.html": "<p>About GitLab Pages</p>"},
+	}
+
+	dirs := make(map[string]string, len(deployments))
+
+	for sha, files := range deployments {
+		dirs[sha] = t.TempDir()
+
+		for name, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dirs[sha], name), []byte(content), 0600))
+		}
+	}
+
+	serve := func(t *testing.T, sha, path, ifNoneMatch string) *http.Response {
+		t.Helper()
+
+		s := New(vfs.Instrumented(&local.VFS{}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project"+path, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		require.True(t, s.ServeFileHTTP(serving.Handler{
+			Writer:     w,
+			Request:    r,
+			LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dirs[sha], SHA256: sha},
+			SubPath:    strings.TrimPrefix(path, "/"),
+		}))
+
+		resp := w.Result()
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	tests := map[string]struct {
+		path           string
+		expectedStatus int
+	}{
+		"unchanged file": {
+			path:           "/index.html",
+			expectedStatus: http.StatusNotModified,
+		},
+		"changed file": {
+			path:           "/about.html",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			etag := serve(t, "sha1", tc.path, "").Header.Get("ETag")
+			require.Equal(t, fmt.Sprintf("%q", contentID(deployments["sha1"][strings.TrimPrefix(tc.path, "/")])), etag)
+
+			// the file is revalidated after a new deployment
+			resp := serve(t, "sha2", tc.path, etag)
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	t.Run("unconditional range request", func(t *testing.T) {
+		s := New(vfs.Instrumented(&local.VFS{}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project/index.html", nil)
+		r.Header.Set("Range", "bytes=0-2")
+
+		require.True(t, s.ServeFileHTTP(serving.Handler{
+			Writer:     w,
+			Request:    r,
+			LookupPath: &serving.LookupPath{Prefix: "/project/", Path: dirs["sha1"], SHA256: "sha1"},
+			SubPath:    "index.html",
+		}))
+
+		// the partial response has the same ETag as the full one
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, serve(t, "sha1", "/index.html", "").Header.Get("ETag"), w.Header().Get("ETag"))
+	})
+}
@@ -1,6 +1,10 @@
 package disk
 
 import (
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachecontrol"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/compression"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
@@ -37,11 +41,52 @@ func (s *Disk) ServeNotFoundHTTP(h serving.Handler) {
 	httperrors.Serve404(h.Writer)
 }
 
-// Reconfigure VFS
+// Reconfigure the compression of files on the fly, the Cache-Control policy
+// and the VFS
 func (s *Disk) Reconfigure(cfg *config.Config) error {
+	cacheControl, err := cachecontrol.New(&cfg.CacheControl)
+	if err != nil {
+		return err
+	}
+
+	s.reader.compressor = sharedCompressor(&cfg.Compression)
+	s.reader.cacheControl = cacheControl
+
 	return s.reader.vfs.Reconfigure(cfg)
 }
 
+// Invalidate drops the roots of cacheKeys cached by the VFS
+func (s *Disk) Invalidate(cacheKeys []string) int {
+	invalidator, ok := s.reader.vfs.(vfs.Invalidator)
+	if !ok {
+		return 0
+	}
+
+	return invalidator.Invalidate(cacheKeys)
+}
+
+// compressors shares the Compressor of the Disks reconfigured with the same
+// compression configuration, so that they share its cache
+var compressors struct {
+	mux        sync.Mutex
+	cfg        config.Compression
+	compressor *compression.Compressor
+}
+
+// sharedCompressor returns the Compressor shared by the Disks, creating a new
+// one when the configuration changes
+func sharedCompressor(cfg *config.Compression) *compression.Compressor {
+	compressors.mux.Lock()
+	defer compressors.mux.Unlock()
+
+	if compressors.compressor == nil || compressors.cfg != *cfg {
+		compressors.cfg = *cfg
+		compressors.compressor = compression.New(cfg)
+	}
+
+	return compressors.compressor
+}
+
 // New returns a serving instance that is capable of reading files
 // from the VFS
 func New(vfs vfs.VFS) serving.Serving {
@@ -49,6 +94,7 @@ func New(vfs vfs.VFS) serving.Serving {
 		reader: Reader{
 			fileSizeMetric: metrics.DiskServingFileSize,
 			vfs:            vfs,
+			cacheControl:   cachecontrol.Default,
 		},
 	}
 }
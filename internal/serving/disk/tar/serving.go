@@ -0,0 +1,18 @@
+package tar
+
+import (
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk"
+	zipserving "gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/zip"
+)
+
+var instance = disk.New(vfs.Instrumented(zip.NewTar(zipserving.VFS())))
+
+// Instance returns a serving instance that is capable of reading files
+// from tar and tar.gz archives opened from a URL, most likely stored in
+// object storage. The archives are cached with the zip archives.
+func Instance() serving.Serving {
+	return instance
+}
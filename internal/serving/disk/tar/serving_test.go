@@ -0,0 +1,283 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	zipserving "gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+var modTime = time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTar_ServeFileHTTP(t *testing.T) {
+	testServerURL := newTarFileServerURL(t)
+
+	tests := map[string]struct {
+		vfsPath        string
+		path           string
+		expectedStatus int
+		expectedBody   string
+		extraHeaders   http.Header
+	}{
+		"accessing /index.html": {
+			vfsPath:        testServerURL + "/public.tar",
+			path:           "/index.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "tar.gitlab.io/project/index.html\n",
+		},
+		"accessing /index.html of a tar.gz archive": {
+			vfsPath:        testServerURL + "/public.tar.gz",
+			path:           "/index.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "tar.gitlab.io/project/index.html\n",
+		},
+		"accessing /": {
+			vfsPath:        testServerURL + "/public.tar.gz",
+			path:           "/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "tar.gitlab.io/project/index.html\n",
+		},
+		"accessing a file of a subdirectory": {
+			vfsPath:        testServerURL + "/public.tar.gz",
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "tar.gitlab.io/project/subdir/linked.html\n",
+		},
+		"accessing a symlink": {
+			vfsPath:        testServerURL + "/public.tar",
+			path:           "/symlink.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "tar.gitlab.io/project/subdir/linked.html\n",
+		},
+		"accessing with Range": {
+			vfsPath:        testServerURL + "/public.tar",
+			path:           "/index.html",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "index.html",
+			extraHeaders: http.Header{
+				"Range": {"bytes=22-31"},
+			},
+		},
+		"accessing with Range of a tar.gz archive": {
+			vfsPath: testServerURL + "/public.tar.gz",
+			path:    "/index.html",
+			// the files of compressed archives are not seekable
+			expectedStatus: http.StatusOK,
+			expectedBody:   "tar.gitlab.io/project/index.html\n",
+			extraHeaders: http.Header{
+				"Range": {"bytes=22-31"},
+			},
+		},
+		"accessing / If-Modified-Since": {
+			vfsPath:        testServerURL + "/public.tar",
+			path:           "/",
+			expectedStatus: http.StatusNotModified,
+			extraHeaders: http.Header{
+				"If-Modified-Since": {time.Now().Format(http.TimeFormat)},
+			},
+		},
+		"accessing without /": {
+			vfsPath:        testServerURL + "/public.tar",
+			path:           "",
+			expectedStatus: http.StatusFound,
+			expectedBody:   "<a href=\"//tar.gitlab.io/tar/\">Found</a>.\n\n",
+		},
+		"accessing a missing file": {
+			vfsPath: testServerURL + "/public.tar",
+			path:    "/missing.html",
+			// we expect the status to not be set
+			expectedStatus: 0,
+		},
+		"accessing archive that is 404": {
+			vfsPath: testServerURL + "/invalid.tar",
+			path:    "/index.html",
+			// we expect the status to not be set
+			expectedStatus: 0,
+		},
+		"accessing archive that is 500": {
+			vfsPath:        testServerURL + "/500",
+			path:           "/index.html",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		"accessing archive that is not a tar archive": {
+			vfsPath:        testServerURL + "/corrupted.tar",
+			path:           "/index.html",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	s := reconfigure(t)
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Code = 0 // ensure that code is not set, and it is being set by handler
+			r := httptest.NewRequest(http.MethodGet, "http://tar.gitlab.io/tar"+test.path, nil)
+
+			if test.extraHeaders != nil {
+				r.Header = test.extraHeaders
+			}
+
+			handler := serving.Handler{
+				Writer:  w,
+				Request: r,
+				LookupPath: &serving.LookupPath{
+					Prefix: "/tar/",
+					Path:   test.vfsPath,
+					SHA256: sha(test.vfsPath),
+				},
+				SubPath: test.path,
+			}
+
+			if test.expectedStatus == 0 {
+				require.False(t, s.ServeFileHTTP(handler))
+				require.Zero(t, w.Code, "we expect status to not be set")
+				return
+			}
+
+			require.True(t, s.ServeFileHTTP(handler))
+
+			resp := w.Result()
+			testhelpers.Close(t, resp.Body)
+
+			require.Equal(t, test.expectedStatus, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			if test.expectedStatus == http.StatusOK {
+				require.Equal(t, modTime.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+				require.NotEmpty(t, resp.Header.Get("ETag"))
+			}
+
+			if test.expectedStatus != http.StatusInternalServerError {
+				require.Equal(t, test.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestTarSharesTheCacheOfTheZipArchives(t *testing.T) {
+	testServerURL := newTarFileServerURL(t)
+	s := reconfigure(t)
+
+	url := testServerURL + "/public.tar"
+	handler := func() serving.Handler {
+		return serving.Handler{
+			Writer:     httptest.NewRecorder(),
+			Request:    httptest.NewRequest(http.MethodGet, "http://tar.gitlab.io/tar/index.html", nil),
+			LookupPath: &serving.LookupPath{Prefix: "/tar/", Path: url, SHA256: sha(url)},
+			SubPath:    "/index.html",
+		}
+	}
+
+	require.True(t, s.ServeFileHTTP(handler()))
+	require.Equal(t, 1, zipserving.VFS().Invalidate([]string{sha(url)}), "the tar archive is in the cache of the zip archives")
+	require.Zero(t, zipserving.VFS().Invalidate([]string{sha(url)}))
+
+	require.True(t, s.ServeFileHTTP(handler()))
+}
+
+var reconfigureOnce sync.Once
+
+// reconfigure configures the cache of the zip archives, which the tar
+// archives are cached with, and returns the tar serving instance. The zip
+// serving is only reconfigured once, as it registers the file protocol.
+func reconfigure(t *testing.T) serving.Serving {
+	t.Helper()
+
+	cfg := &config.Config{
+		Zip: config.ZipServing{
+			ExpirationInterval: 10 * time.Second,
+			CleanupInterval:    5 * time.Second,
+			RefreshInterval:    5 * time.Second,
+			OpenTimeout:        5 * time.Second,
+		},
+	}
+
+	reconfigureOnce.Do(func() {
+		require.NoError(t, zipserving.Instance().Reconfigure(cfg))
+	})
+
+	s := Instance()
+	require.NoError(t, s.Reconfigure(cfg))
+
+	return s
+}
+
+func sha(path string) string {
+	sha := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sha[:])
+}
+
+// newTarFileServerURL serves the tar and tar.gz archives of a project with
+// range requests support
+func newTarFileServerURL(t *testing.T) string {
+	t.Helper()
+
+	archive := createTar(t)
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	_, err := gw.Write(archive)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	m := http.NewServeMux()
+	m.HandleFunc("/public.tar", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "public.tar", modTime, bytes.NewReader(archive))
+	})
+	m.HandleFunc("/public.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "public.tar.gz", modTime, bytes.NewReader(compressed.Bytes()))
+	})
+	m.HandleFunc("/corrupted.tar", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "corrupted.tar", modTime, bytes.NewReader(bytes.Repeat([]byte("corrupted"), 1024)))
+	})
+	m.HandleFunc("/500", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	testServer := httptest.NewServer(m)
+	t.Cleanup(testServer.Close)
+
+	return testServer.URL
+}
+
+func createTar(t *testing.T) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+
+	write := func(header *tar.Header, content string) {
+		header.ModTime = modTime
+		header.Size = int64(len(content))
+		require.NoError(t, tw.WriteHeader(header))
+
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+
+	write(&tar.Header{Typeflag: tar.TypeDir, Name: "public/", Mode: 0755}, "")
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: "public/index.html", Mode: 0644}, "tar.gitlab.io/project/index.html\n")
+	write(&tar.Header{Typeflag: tar.TypeDir, Name: "public/subdir/", Mode: 0755}, "")
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: "public/subdir/linked.html", Mode: 0644}, "tar.gitlab.io/project/subdir/linked.html\n")
+	write(&tar.Header{Typeflag: tar.TypeSymlink, Name: "public/symlink.html", Linkname: "subdir/linked.html", Mode: 0777}, "")
+
+	require.NoError(t, tw.Close())
+
+	return b.Bytes()
+}
@@ -8,10 +8,18 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/zip"
 )
 
-var instance = disk.New(vfs.Instrumented(zip.New(&config.ZipServing{})))
+var archives = zip.New(&config.ZipServing{})
+
+var instance = disk.New(vfs.Instrumented(archives))
 
 // Instance returns a serving instance that is capable of reading files
 // from a zip archives opened from a URL, most likely stored in object storage
 func Instance() serving.Serving {
 	return instance
 }
+
+// VFS returns the VFS of the zip archives, the cache of which is shared by
+// all the deployment archives
+func VFS() *zip.VFS {
+	return archives
+}
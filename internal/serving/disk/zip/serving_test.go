@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -28,6 +29,8 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 	httpURL := testServerURL + "/public.zip"
 	fileURL := "file://" + wd + "/group/zip.gitlab.io/public-without-dirs.zip"
 
+	indexHTML := "zip.gitlab.io/project/index.html\n"
+
 	tests := map[string]struct {
 		vfsPath        string
 		path           string
@@ -125,6 +128,23 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 			vfsPath:        httpURL,
 			path:           "/",
 			expectedStatus: http.StatusNotModified,
+			extraHeaders: http.Header{
+				"If-None-Match": {fmt.Sprintf("%q", contentID(indexHTML))},
+			},
+		},
+		"accessing / If-None-Match from another deployment": {
+			vfsPath:        fileURL,
+			path:           "/",
+			expectedStatus: http.StatusNotModified,
+			extraHeaders: http.Header{
+				"If-None-Match": {fmt.Sprintf("%q", contentID(indexHTML))},
+			},
+		},
+		"accessing / If-None-Match with the deployment SHA256": {
+			vfsPath:        httpURL,
+			path:           "/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   indexHTML,
 			extraHeaders: http.Header{
 				"If-None-Match": {fmt.Sprintf("%q", sha(httpURL))},
 			},
@@ -144,7 +164,7 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "zip.gitlab.io/project/index.html\n",
 			extraHeaders: http.Header{
-				"If-Match": {fmt.Sprintf("%q", sha(httpURL))},
+				"If-Match": {fmt.Sprintf("%q", contentID(indexHTML))},
 			},
 		},
 		"accessing / If-Match fails": {
@@ -155,6 +175,44 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 				"If-Match": {fmt.Sprintf("%q", "wrongetag")},
 			},
 		},
+		"accessing deflated file with Range": {
+			vfsPath:        httpURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "subdir/linked",
+			extraHeaders: http.Header{
+				"Range": {"bytes=14-26"},
+			},
+		},
+		"accessing deflated file with Range from disk": {
+			vfsPath:        fileURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "subdir/linked",
+			extraHeaders: http.Header{
+				"Range": {"bytes=14-26"},
+			},
+		},
+		"accessing deflated file with Range and If-Range": {
+			vfsPath:        httpURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "linked.html\n",
+			extraHeaders: http.Header{
+				"Range":    {"bytes=-12"},
+				"If-Range": {fmt.Sprintf("%q", contentID("symlink.html->subdir/linked.html\n"))},
+			},
+		},
+		"accessing deflated file with Range and stale If-Range": {
+			vfsPath:        httpURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "symlink.html->subdir/linked.html\n",
+			extraHeaders: http.Header{
+				"Range":    {"bytes=14-26"},
+				"If-Range": {fmt.Sprintf("%q", "staleetag")},
+			},
+		},
 		"accessing / If-Match fails2": {
 			vfsPath:        httpURL,
 			path:           "/",
@@ -227,6 +285,11 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 	}
 }
 
+// contentID returns the identifier of a file of content in an archive
+func contentID(content string) string {
+	return fmt.Sprintf("%08x-%x", crc32.ChecksumIEEE([]byte(content)), len(content))
+}
+
 func sha(path string) string {
 	sha := sha256.Sum256([]byte(path))
 	s := hex.EncodeToString(sha[:])
@@ -0,0 +1,125 @@
+// Package chain provides a domains configuration source that queries an
+// ordered list of sources, so that e.g. a static file can override the
+// domains of the GitLab API.
+package chain
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/hashicorp/go-multierror"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/healthcheck"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	resultFound    = "found"
+	resultNotFound = "not_found"
+	resultError    = "error"
+)
+
+// Link is a source of a Chain, with what the chain does when the source does
+// not answer with a domain
+type Link struct {
+	// Name identifies the source in the logs and metrics
+	Name   string
+	Source source.Source
+	// ContinueOnNotFound queries the next source when Source does not know a
+	// domain, instead of answering that the domain does not exist
+	ContinueOnNotFound bool
+	// ContinueOnError queries the next source when Source fails, instead of
+	// answering with its error
+	ContinueOnError bool
+}
+
+// Chain is a domains configuration source that queries its sources in order
+// until one of them answers. It answers with the result of the last source
+// queried when none of them does.
+type Chain struct {
+	links []Link
+}
+
+// New returns a Chain of links
+func New(links ...Link) *Chain {
+	return &Chain{links: links}
+}
+
+// GetDomain returns the domain name from the first source answering for it
+func (c *Chain) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
+	var d *domain.Domain
+	var err error
+
+	for i, link := range c.links {
+		d, err = link.Source.GetDomain(ctx, name)
+
+		last := i == len(c.links)-1
+
+		switch {
+		case err == nil && d != nil:
+			metrics.DomainsSourceChainAnswers.WithLabelValues(link.Name, resultFound).Inc()
+			return d, nil
+		case err == nil || errors.Is(err, domain.ErrDomainDoesNotExist):
+			if last || !link.ContinueOnNotFound {
+				metrics.DomainsSourceChainAnswers.WithLabelValues(link.Name, resultNotFound).Inc()
+				return d, err
+			}
+		default:
+			if last || !link.ContinueOnError {
+				metrics.DomainsSourceChainAnswers.WithLabelValues(link.Name, resultError).Inc()
+				return nil, err
+			}
+
+			log.WithError(err).WithFields(log.Fields{
+				"domain_source": link.Name,
+				"host":          name,
+			}).Warn("domains source failed, querying the next one")
+		}
+	}
+
+	return d, err
+}
+
+// Warming returns true while any of the sources is warming up. It implements
+// healthcheck.Warmer.
+func (c *Chain) Warming() bool {
+	for _, link := range c.links {
+		if warmer, ok := link.Source.(healthcheck.Warmer); ok && warmer.Warming() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close closes the sources which can be closed
+func (c *Chain) Close() error {
+	var result *multierror.Error
+
+	for _, link := range c.links {
+		if closer, ok := link.Source.(io.Closer); ok {
+			result = multierror.Append(result, closer.Close())
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Invalidate invalidates the configuration of hosts and projectIDs in all the
+// sources caching it, and returns the serving cache keys they invalidated. It
+// implements source.Invalidator.
+func (c *Chain) Invalidate(hosts []string, projectIDs []uint64, refresh bool) []string {
+	var cacheKeys []string
+
+	for _, link := range c.links {
+		if invalidator, ok := link.Source.(source.Invalidator); ok {
+			cacheKeys = append(cacheKeys, invalidator.Invalidate(hosts, projectIDs, refresh)...)
+		}
+	}
+
+	return cacheKeys
+}
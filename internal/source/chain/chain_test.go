@@ -0,0 +1,196 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+var errSourceFailed = errors.New("source failed")
+
+// stubSource answers with its domain or error, and records its queries
+type stubSource struct {
+	domain  *domain.Domain
+	err     error
+	warming bool
+	queries int
+	// invalidated are the hosts it invalidated
+	invalidated []string
+	closed      bool
+}
+
+func (s *stubSource) GetDomain(_ context.Context, _ string) (*domain.Domain, error) {
+	s.queries++
+
+	return s.domain, s.err
+}
+
+func (s *stubSource) Warming() bool {
+	return s.warming
+}
+
+func (s *stubSource) Invalidate(hosts []string, _ []uint64, _ bool) []string {
+	s.invalidated = append(s.invalidated, hosts...)
+
+	return hosts
+}
+
+func (s *stubSource) Close() error {
+	s.closed = true
+
+	return s.err
+}
+
+func found(name string) *stubSource {
+	return &stubSource{domain: &domain.Domain{Name: name}}
+}
+
+func notFound() *stubSource {
+	return &stubSource{err: domain.ErrDomainDoesNotExist}
+}
+
+func failing() *stubSource {
+	return &stubSource{err: errSourceFailed}
+}
+
+func TestGetDomain(t *testing.T) {
+	tests := map[string]struct {
+		links          []Link
+		expectedDomain string
+		expectedErr    error
+		expectedSource string
+		expectedResult string
+		// expectedQueries are the number of queries of each link
+		expectedQueries []int
+	}{
+		"first source found": {
+			links: []Link{
+				{Name: "static", Source: found("static")},
+				{Name: "gitlab", Source: found("gitlab")},
+			},
+			expectedDomain:  "static",
+			expectedSource:  "static",
+			expectedResult:  resultFound,
+			expectedQueries: []int{1, 0},
+		},
+		"not found continues": {
+			links: []Link{
+				{Name: "static", Source: notFound(), ContinueOnNotFound: true},
+				{Name: "gitlab", Source: found("gitlab")},
+			},
+			expectedDomain:  "gitlab",
+			expectedSource:  "gitlab",
+			expectedResult:  resultFound,
+			expectedQueries: []int{1, 1},
+		},
+		"nil domain is not found": {
+			links: []Link{
+				{Name: "static", Source: &stubSource{}, ContinueOnNotFound: true},
+				{Name: "gitlab", Source: found("gitlab")},
+			},
+			expectedDomain:  "gitlab",
+			expectedSource:  "gitlab",
+			expectedResult:  resultFound,
+			expectedQueries: []int{1, 1},
+		},
+		"not found stops": {
+			links: []Link{
+				{Name: "static", Source: notFound()},
+				{Name: "gitlab", Source: found("gitlab")},
+			},
+			expectedErr:     domain.ErrDomainDoesNotExist,
+			expectedSource:  "static",
+			expectedResult:  resultNotFound,
+			expectedQueries: []int{1, 0},
+		},
+		"error stops": {
+			links: []Link{
+				{Name: "gitlab", Source: failing(), ContinueOnNotFound: true},
+				{Name: "disk", Source: found("disk")},
+			},
+			expectedErr:     errSourceFailed,
+			expectedSource:  "gitlab",
+			expectedResult:  resultError,
+			expectedQueries: []int{1, 0},
+		},
+		"error continues": {
+			links: []Link{
+				{Name: "gitlab", Source: failing(), ContinueOnError: true},
+				{Name: "disk", Source: found("disk")},
+			},
+			expectedDomain:  "disk",
+			expectedSource:  "disk",
+			expectedResult:  resultFound,
+			expectedQueries: []int{1, 1},
+		},
+		"last source answers when none found": {
+			links: []Link{
+				{Name: "gitlab", Source: failing(), ContinueOnError: true},
+				{Name: "disk", Source: notFound(), ContinueOnNotFound: true},
+			},
+			expectedErr:     domain.ErrDomainDoesNotExist,
+			expectedSource:  "disk",
+			expectedResult:  resultNotFound,
+			expectedQueries: []int{1, 1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			answers := metrics.DomainsSourceChainAnswers.WithLabelValues(tc.expectedSource, tc.expectedResult)
+			before := testutil.ToFloat64(answers)
+
+			d, err := New(tc.links...).GetDomain(context.Background(), "group.gitlab.io")
+
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				require.Nil(t, d)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedDomain, d.Name)
+			}
+
+			require.Equal(t, before+1, testutil.ToFloat64(answers))
+
+			for i, link := range tc.links {
+				require.Equal(t, tc.expectedQueries[i], link.Source.(*stubSource).queries, link.Name)
+			}
+		})
+	}
+}
+
+func TestWarming(t *testing.T) {
+	static, gitlab := found("static"), found("gitlab")
+	chain := New(Link{Name: "static", Source: static}, Link{Name: "gitlab", Source: gitlab})
+
+	require.False(t, chain.Warming())
+
+	gitlab.warming = true
+	require.True(t, chain.Warming())
+}
+
+func TestInvalidate(t *testing.T) {
+	static, gitlab := found("static"), found("gitlab")
+	chain := New(Link{Name: "static", Source: static}, Link{Name: "gitlab", Source: gitlab})
+
+	cacheKeys := chain.Invalidate([]string{"group.gitlab.io"}, nil, false)
+
+	require.Equal(t, []string{"group.gitlab.io", "group.gitlab.io"}, cacheKeys)
+	require.Equal(t, []string{"group.gitlab.io"}, static.invalidated)
+	require.Equal(t, []string{"group.gitlab.io"}, gitlab.invalidated)
+}
+
+func TestClose(t *testing.T) {
+	static, gitlab := found("static"), failing()
+	chain := New(Link{Name: "static", Source: static}, Link{Name: "gitlab", Source: gitlab})
+
+	require.ErrorIs(t, chain.Close(), errSourceFailed)
+	require.True(t, static.closed)
+	require.True(t, gitlab.closed)
+}
@@ -0,0 +1,207 @@
+// Package disk provides a domains configuration source that discovers the
+// projects deployed to the pages root directory, so that Pages can keep
+// serving them without the GitLab internal API.
+package disk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+// rescanDelay is how long to wait for the pages root to settle after a change
+// before scanning it again, so that a deployment extracting many files only
+// triggers a single rescan
+const rescanDelay = time.Second
+
+var errNoPagesDomain = errors.New("pages domain has not been provided")
+
+// Disk is a domains configuration source that discovers the projects from the
+// `<group>/<project>/public` directories of the pages root. The tree is watched
+// for changes and rescanned, so new projects are served without a restart.
+type Disk struct {
+	source *gitlab.Gitlab
+
+	root           string
+	pagesDomain    string
+	rescanInterval time.Duration
+
+	watcher   *fsnotify.Watcher
+	watched   map[string]bool
+	closeOnce sync.Once // ensures the source is only closed once
+	done      chan struct{}
+
+	mux     sync.RWMutex
+	domains map[string]*api.VirtualDomain
+}
+
+// New scans the pages root and starts watching it for changes. Source paths of
+// the discovered projects are relative to root, which is the working directory
+// when disk access is enabled. rescanInterval periodically rescans the whole
+// tree for file systems that do not support inotify, like NFS; 0 disables it.
+func New(root, pagesDomain string, rescanInterval time.Duration) (*Disk, error) {
+	if pagesDomain == "" {
+		return nil, errNoPagesDomain
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating pages root watcher: %w", err)
+	}
+
+	d := &Disk{
+		root:           filepath.Clean(root),
+		pagesDomain:    strings.ToLower(pagesDomain),
+		rescanInterval: rescanInterval,
+		watcher:        watcher,
+		watched:        make(map[string]bool),
+		done:           make(chan struct{}),
+	}
+	d.source = gitlab.NewFromResolver(d, true)
+
+	if err := d.rescan(); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go d.watch()
+
+	return d, nil
+}
+
+// GetDomain returns a representation of the domain discovered in the pages root
+func (d *Disk) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
+	return d.source.GetDomain(ctx, name)
+}
+
+// Resolve returns the lookup of the virtual domain discovered for host. It
+// implements api.Resolver.
+func (d *Disk) Resolve(_ context.Context, host string) *api.Lookup {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	virtualDomain := d.domains[strings.ToLower(host)]
+	if virtualDomain == nil {
+		return &api.Lookup{Name: host, Error: domain.ErrDomainDoesNotExist}
+	}
+
+	return &api.Lookup{Name: host, Domain: virtualDomain}
+}
+
+// Close stops watching the pages root for changes. The source can be closed
+// more than once, e.g. by the app and by a chain of sources.
+func (d *Disk) Close() error {
+	var err error
+
+	d.closeOnce.Do(func() {
+		close(d.done)
+		err = d.watcher.Close()
+	})
+
+	return err
+}
+
+func (d *Disk) watch() {
+	rescanTimer := time.NewTimer(rescanDelay)
+	rescanTimer.Stop()
+	defer rescanTimer.Stop()
+
+	var interval <-chan time.Time
+	if d.rescanInterval > 0 {
+		ticker := time.NewTicker(d.rescanInterval)
+		defer ticker.Stop()
+
+		interval = ticker.C
+	}
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case _, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+
+			rescanTimer.Reset(rescanDelay)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.WithError(err).WithField("pages_root", d.root).Warn("pages root watcher error")
+		case <-rescanTimer.C:
+			d.rescanOrLog()
+		case <-interval:
+			d.rescanOrLog()
+		}
+	}
+}
+
+func (d *Disk) rescanOrLog() {
+	if err := d.rescan(); err != nil {
+		log.WithError(err).WithField("pages_root", d.root).
+			Error("failed to rescan pages root, keeping previous configuration")
+	}
+}
+
+func (d *Disk) rescan() error {
+	s := newScanner(d.root, d.pagesDomain)
+	if err := s.scan(); err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	d.domains = s.domains
+	d.mux.Unlock()
+
+	d.updateWatches(s.dirs)
+
+	log.WithFields(log.Fields{
+		"pages_root":    d.root,
+		"domains_count": len(s.domains),
+	}).Debug("scanned pages root")
+
+	return nil
+}
+
+// updateWatches watches every group, subgroup and project directory found by
+// the last scan. The public directories are not watched, as changes of the
+// files served do not affect the domains configuration.
+func (d *Disk) updateWatches(dirs []string) {
+	current := make(map[string]bool, len(dirs))
+
+	for _, dir := range dirs {
+		current[dir] = true
+		if d.watched[dir] {
+			continue
+		}
+
+		if err := d.watcher.Add(dir); err != nil {
+			log.WithError(err).WithField("path", dir).Warn("failed to watch pages directory")
+			continue
+		}
+
+		d.watched[dir] = true
+	}
+
+	for dir := range d.watched {
+		if !current[dir] {
+			// the directory might have been removed already, which also
+			// removes its watch
+			_ = d.watcher.Remove(dir)
+			delete(d.watched, dir)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package disk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+)
+
+const pagesRoot = "../../../shared/pages"
+
+func TestGetDomain(t *testing.T) {
+	d, err := New(pagesRoot, "gitlab-example.com", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	tests := map[string]struct {
+		host               string
+		url                string
+		expectedPrefix     string
+		expectedPath       string
+		expectedSubPath    string
+		expectedProjectID  uint64
+		expectedNamespace  bool
+		expectedCertifcate string
+	}{
+		"namespace project": {
+			host:              "group.gitlab-example.com",
+			url:               "/index.html",
+			expectedPrefix:    "/",
+			expectedPath:      pagesRoot + "/group/group.gitlab-example.com/public/",
+			expectedNamespace: true,
+		},
+		"project": {
+			host:           "group.gitlab-example.com",
+			url:            "/project/index.html",
+			expectedPrefix: "/project/",
+			expectedPath:   pagesRoot + "/group/project/public/",
+		},
+		"subgroup project": {
+			host:           "group.gitlab-example.com",
+			url:            "/subgroup/project/index.html",
+			expectedPrefix: "/subgroup/project/",
+			expectedPath:   pagesRoot + "/group/subgroup/project/public/",
+		},
+		"group domain is case insensitive": {
+			host:           "CapitalGroup.gitlab-example.com",
+			url:            "/CapitalProject/",
+			expectedPrefix: "/CapitalProject/",
+			expectedPath:   pagesRoot + "/CapitalGroup/CapitalProject/public/",
+		},
+		"project with access control": {
+			host:              "group.auth.gitlab-example.com",
+			url:               "/private.project/",
+			expectedPrefix:    "/private.project/",
+			expectedPath:      pagesRoot + "/group.auth/private.project/public/",
+			expectedProjectID: 1000,
+		},
+		"custom domain": {
+			host:               "other.domain.com",
+			url:                "/",
+			expectedPrefix:     "/",
+			expectedPath:       pagesRoot + "/group/group.test.io/public/",
+			expectedCertifcate: "test",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dom, err := d.GetDomain(context.Background(), tc.host)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedCertifcate, dom.CertificateCert)
+
+			r := httptest.NewRequest(http.MethodGet, "http://"+tc.host+tc.url, nil)
+			lookupPath, err := dom.GetLookupPath(r)
+			require.NoError(t, err)
+			require.Equal(t, "file", lookupPath.ServingType)
+			require.Equal(t, tc.expectedPrefix, lookupPath.Prefix)
+			require.Equal(t, tc.expectedPath, lookupPath.Path)
+			require.Equal(t, tc.expectedProjectID, lookupPath.ProjectID)
+			require.Equal(t, tc.expectedNamespace, lookupPath.IsNamespaceProject)
+		})
+	}
+}
+
+func TestGetDomainNotFound(t *testing.T) {
+	d, err := New(pagesRoot, "gitlab-example.com", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	for _, host := range []string{
+		"unknown.gitlab-example.com",
+		// groups without any project with a public directory
+		"group.no.public.gitlab-example.com",
+		// files are not groups
+		"is_file.gitlab-example.com",
+	} {
+		t.Run(host, func(t *testing.T) {
+			_, err := d.GetDomain(context.Background(), host)
+			require.ErrorIs(t, err, domain.ErrDomainDoesNotExist)
+		})
+	}
+}
+
+func TestCustomDomainOfPagesDomainIsIgnored(t *testing.T) {
+	root := t.TempDir()
+	writeProject(t, root, "group/project", `{"Domains":[{"Domain":"other.gitlab-example.com"},{"Domain":"example.com"}]}`)
+
+	d, err := New(root, "gitlab-example.com", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	require.ErrorIs(t, d.Resolve(context.Background(), "other.gitlab-example.com").Error, domain.ErrDomainDoesNotExist)
+	require.NoError(t, d.Resolve(context.Background(), "example.com").Error)
+}
+
+func TestCustomDomainInheritsProjectSettings(t *testing.T) {
+	root := t.TempDir()
+	writeProject(t, root, "group/project", `{"Domains":[{"Domain":"example.com"}],"https_only":true,"access_control":true}`)
+
+	d, err := New(root, "gitlab-example.com", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	lookup := d.Resolve(context.Background(), "example.com")
+	require.NoError(t, lookup.Error)
+	require.True(t, lookup.Domain.LookupPaths[0].HTTPSOnly)
+	require.True(t, lookup.Domain.LookupPaths[0].AccessControl)
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	writeProject(t, root, "group/project", "")
+
+	d, err := New(root, "gitlab-example.com", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	require.NoError(t, d.Resolve(context.Background(), "group.gitlab-example.com").Error)
+
+	// new group and subgroup projects get discovered
+	writeProject(t, root, "new/subgroup/project", `{"Domains":[{"Domain":"example.com"}]}`)
+
+	require.Eventually(t, func() bool {
+		return d.Resolve(context.Background(), "example.com").Error == nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	lookup := d.Resolve(context.Background(), "new.gitlab-example.com")
+	require.NoError(t, lookup.Error)
+	require.Equal(t, "/subgroup/project/", lookup.Domain.LookupPaths[0].Prefix)
+
+	// removed projects are not served anymore
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "group")))
+
+	require.Eventually(t, func() bool {
+		return d.Resolve(context.Background(), "group.gitlab-example.com").Error != nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPeriodicRescan(t *testing.T) {
+	root := t.TempDir()
+
+	d, err := New(root, "gitlab-example.com", 50*time.Millisecond)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	// remove the watches to make sure the project is discovered by the
+	// periodic rescan
+	require.NoError(t, d.watcher.Remove(root))
+
+	writeProject(t, root, "group/project", "")
+
+	require.Eventually(t, func() bool {
+		return d.Resolve(context.Background(), "group.gitlab-example.com").Error == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestScanWatchesOnlyGroupsAndProjects(t *testing.T) {
+	root := t.TempDir()
+	writeProject(t, root, "group/project", "")
+	writeProject(t, root, "group/subgroup/project", "")
+
+	for _, dir := range []string{
+		// the files served are not scanned
+		"group/project/public/assets/images",
+		// the deployments of GitLab are not groups
+		"@hashed/ab/cd/abcd/pages_deployments/1/public",
+		// a directory holding files is not a group
+		"group/uploads/2021/01",
+		// a project which has not been deployed yet
+		"group/pending",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0755))
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "group", "uploads", "file.txt"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "group", "pending", "config.json"), []byte("{}"), 0644))
+
+	s := newScanner(root, "gitlab-example.com")
+	require.NoError(t, s.scan())
+
+	var dirs []string
+	for _, dir := range s.dirs {
+		rel, err := filepath.Rel(root, dir)
+		require.NoError(t, err)
+
+		dirs = append(dirs, filepath.ToSlash(rel))
+	}
+
+	require.ElementsMatch(t, []string{
+		".",
+		"group",
+		"group/project",
+		"group/pending",
+		"group/subgroup",
+		"group/subgroup/project",
+	}, dirs)
+
+	require.Len(t, s.domains["group.gitlab-example.com"].LookupPaths, 2)
+}
+
+func writeProject(t *testing.T, root, projectPath, config string) {
+	t.Helper()
+
+	projectDir := filepath.Join(root, filepath.FromSlash(projectPath))
+	require.NoError(t, os.MkdirAll(filepath.Join(projectDir, "public"), 0755))
+
+	if config != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(projectDir, "config.json"), []byte(config), 0644))
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	d, err := New(pagesRoot, "gitlab-example.com", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Close())
+	require.NoError(t, d.Close(), "the chain of sources closes it again")
+}
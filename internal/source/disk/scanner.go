@@ -0,0 +1,272 @@
+package disk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+const (
+	// subgroupScanLimit is the maximum depth of nested subgroups searched for
+	// projects, matching the limit of GitLab
+	subgroupScanLimit = 21
+
+	// maxConfigSize is the largest project config.json file that gets parsed
+	maxConfigSize = 64 * 1024
+)
+
+// domainConfig is a custom domain of a project config.json file
+type domainConfig struct {
+	Domain        string
+	Certificate   string
+	Key           string
+	HTTPSOnly     bool `json:"https_only"`
+	ID            int  `json:"id"`
+	AccessControl bool `json:"access_control"`
+}
+
+// projectConfig is the content of a project config.json file, written by
+// GitLab next to the public directory of the project, e.g.:
+//
+//	{
+//	  "Domains": [
+//	    {"Domain": "example.com", "Certificate": "...", "Key": "..."}
+//	  ],
+//	  "https_only": true,
+//	  "id": 123,
+//	  "access_control": false
+//	}
+type projectConfig struct {
+	Domains       []domainConfig
+	HTTPSOnly     bool `json:"https_only"`
+	ID            int  `json:"id"`
+	AccessControl bool `json:"access_control"`
+}
+
+// scanner walks the pages root once and builds the virtual domains of the
+// projects it finds
+type scanner struct {
+	root        string
+	pagesDomain string
+
+	domains map[string]*api.VirtualDomain
+	dirs    []string
+}
+
+func newScanner(root, pagesDomain string) *scanner {
+	return &scanner{
+		root:        root,
+		pagesDomain: pagesDomain,
+		domains:     make(map[string]*api.VirtualDomain),
+	}
+}
+
+func (s *scanner) scan() error {
+	groups, err := os.ReadDir(s.root)
+	if err != nil {
+		return fmt.Errorf("reading pages root: %w", err)
+	}
+
+	s.dirs = append(s.dirs, s.root)
+
+	for _, group := range groups {
+		if skipEntry(group) {
+			continue
+		}
+
+		s.scanGroup(group.Name(), "", 0)
+	}
+
+	for _, virtualDomain := range s.domains {
+		virtualDomain.Compile()
+	}
+
+	return nil
+}
+
+// scanGroup looks for projects in the group directory, a directory with a
+// public directory or a config.json file is a project and any other directory
+// is a subgroup. A directory holding files is neither a group nor a project,
+// so it is not scanned nor watched.
+func (s *scanner) scanGroup(group, subPath string, depth int) {
+	dir := filepath.Join(s.root, group, filepath.FromSlash(subPath))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.WithError(err).WithField("path", dir).Warn("failed to read pages group directory")
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			return
+		}
+	}
+
+	s.dirs = append(s.dirs, dir)
+
+	for _, entry := range entries {
+		if skipEntry(entry) {
+			continue
+		}
+
+		projectPath := path.Join(subPath, entry.Name())
+		projectDir := filepath.Join(dir, entry.Name())
+
+		if isDir(filepath.Join(projectDir, "public")) {
+			s.dirs = append(s.dirs, projectDir)
+			s.addProject(group, projectPath)
+			continue
+		}
+
+		// the project has not been deployed yet, its directory is watched
+		// for the public directory to be created
+		if isFile(filepath.Join(projectDir, "config.json")) {
+			s.dirs = append(s.dirs, projectDir)
+			continue
+		}
+
+		if depth < subgroupScanLimit {
+			s.scanGroup(group, projectPath, depth+1)
+		}
+	}
+}
+
+func (s *scanner) addProject(group, projectPath string) {
+	projectDir := filepath.Join(s.root, group, filepath.FromSlash(projectPath))
+	sourcePath := filepath.ToSlash(filepath.Join(projectDir, "public")) + "/"
+
+	config, err := readProjectConfig(filepath.Join(projectDir, "config.json"))
+	if err != nil {
+		log.WithError(err).WithField("path", projectDir).Warn("failed to read project config.json")
+	}
+
+	groupDomain := strings.ToLower(group + "." + s.pagesDomain)
+
+	// the project named after the group domain is the namespace project served
+	// from the root of the group domain, any other project is served from a
+	// sub path named after the project
+	prefix := "/" + projectPath + "/"
+	if strings.ToLower(projectPath) == groupDomain {
+		prefix = "/"
+	}
+
+	s.addLookupPath(groupDomain, &api.VirtualDomain{}, api.LookupPath{
+		ProjectID:     config.ID,
+		AccessControl: config.AccessControl,
+		HTTPSOnly:     config.HTTPSOnly,
+		Prefix:        prefix,
+		Source:        api.Source{Type: "file", Path: sourcePath},
+	})
+
+	for _, customDomain := range config.Domains {
+		// custom domains belong to the project, so they inherit its settings
+		// when GitLab did not write them for the domain itself
+		if customDomain.ID == 0 {
+			customDomain.ID = config.ID
+		}
+
+		name := strings.ToLower(strings.TrimSpace(customDomain.Domain))
+		if !s.isCustomDomainAllowed(name) {
+			log.WithFields(log.Fields{
+				"path":   projectDir,
+				"domain": name,
+			}).Warn("ignoring custom domain of project config.json")
+
+			continue
+		}
+
+		s.addLookupPath(name, &api.VirtualDomain{
+			Certificate: customDomain.Certificate,
+			Key:         customDomain.Key,
+		}, api.LookupPath{
+			ProjectID:     customDomain.ID,
+			AccessControl: customDomain.AccessControl || config.AccessControl,
+			HTTPSOnly:     customDomain.HTTPSOnly || config.HTTPSOnly,
+			Prefix:        "/",
+			Source:        api.Source{Type: "file", Path: sourcePath},
+		})
+	}
+}
+
+// addLookupPath adds lookupPath to the virtual domain name, which gets created
+// from virtualDomain when it does not exist yet
+func (s *scanner) addLookupPath(name string, virtualDomain *api.VirtualDomain, lookupPath api.LookupPath) {
+	existing, ok := s.domains[name]
+	if !ok {
+		s.domains[name] = virtualDomain
+		existing = virtualDomain
+	}
+
+	for _, l := range existing.LookupPaths {
+		if l.Prefix == lookupPath.Prefix {
+			log.WithFields(log.Fields{
+				"domain": name,
+				"prefix": lookupPath.Prefix,
+				"path":   lookupPath.Source.Path,
+			}).Warn("ignoring project already served by another directory")
+
+			return
+		}
+	}
+
+	existing.LookupPaths = append(existing.LookupPaths, lookupPath)
+}
+
+// isCustomDomainAllowed rejects custom domains that are the pages domain or
+// one of its subdomains, as these are reserved for the groups
+func (s *scanner) isCustomDomainAllowed(name string) bool {
+	if name == "" || name == s.pagesDomain {
+		return false
+	}
+
+	return !strings.HasSuffix(name, "."+s.pagesDomain)
+}
+
+func readProjectConfig(path string) (projectConfig, error) {
+	var config projectConfig
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+
+		return config, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(io.LimitReader(f, maxConfigSize)).Decode(&config); err != nil {
+		return projectConfig{}, err
+	}
+
+	return config, nil
+}
+
+// skipEntry returns true if the entry of a group directory cannot be a
+// project nor a subgroup: the files, the hidden directories and the
+// directories of GitLab like @hashed, which hold the deployments of the
+// projects rather than groups
+func skipEntry(entry os.DirEntry) bool {
+	return !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "@")
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+
+	return err == nil && fi.IsDir()
+}
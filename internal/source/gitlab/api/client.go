@@ -9,3 +9,36 @@ type Client interface {
 	// Resolve retrieves an VirtualDomain from the GitLab API and wraps it into a Lookup
 	GetLookup(ctx context.Context, domain string) Lookup
 }
+
+// Lister is a Client able to list all the virtual domains at once
+type Lister interface {
+	// ListLookups retrieves a page of perPage virtual domains, from the most
+	// active one, wrapped into Lookups. It returns the number of the next
+	// page, which is 0 after the last page.
+	ListLookups(ctx context.Context, page, perPage int) ([]Lookup, int, error)
+}
+
+// Revalidator is a Client able to revalidate a lookup it retrieved before
+type Revalidator interface {
+	// RevalidateLookup retrieves a Lookup from the GitLab API, keeping
+	// previous as is when its VirtualDomain has not changed since then
+	RevalidateLookup(ctx context.Context, domain string, previous *Lookup) Lookup
+}
+
+// DomainEvent is a change of the configuration of a virtual domain streamed by
+// the GitLab API
+type DomainEvent struct {
+	// Cursor identifies the event, a subscription resumes after it
+	Cursor string
+	// Lookup is the new configuration of the domain. Its error is
+	// domain.ErrDomainDoesNotExist when the domain has been deleted.
+	Lookup Lookup
+}
+
+// Subscriber is a Client able to stream the changes of the virtual domains
+type Subscriber interface {
+	// Subscribe passes the events following cursor to handle until ctx is
+	// done or the stream ends. The events are streamed from now on when cursor
+	// is empty.
+	Subscribe(ctx context.Context, cursor string, handle func(DomainEvent)) error
+}
@@ -11,13 +11,19 @@ type Lookup struct {
 	Name   string
 	Error  error
 	Domain *VirtualDomain
+	// ETag identifies the version of Domain, it is sent back to the GitLab
+	// API to revalidate the lookup
+	ETag string
+	// Stale is true when the lookup is served past its expiration because it
+	// could not be refreshed
+	Stale bool
 }
 
 func (l *Lookup) ParseDomain(r io.Reader) {
 	l.Error = json.NewDecoder(r).Decode(&l.Domain)
 
 	if l.Domain != nil {
-		sortLookupsByPrefixLengthDesc(l.Domain.LookupPaths)
+		l.Domain.Compile()
 	}
 }
 
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"strings"
+)
+
+// lookupPathTrie indexes the lookup paths of a virtual domain by the segments
+// of their prefix, so that the lookup path of a request is found in as many
+// steps as the request path has segments instead of checking every lookup path
+type lookupPathTrie struct {
+	children map[string]*lookupPathTrie
+	// lookupPath is the index of the lookup path whose prefix ends at this
+	// node, or -1 when there is none
+	lookupPath int
+}
+
+func newLookupPathTrie() *lookupPathTrie {
+	return &lookupPathTrie{lookupPath: -1}
+}
+
+// compileLookupPathTrie returns the trie of lookupPaths, or nil when they cannot
+// be indexed by segments because a prefix does not start and end with a slash
+func compileLookupPathTrie(lookupPaths []LookupPath) *lookupPathTrie {
+	root := newLookupPathTrie()
+
+	for i, lookupPath := range lookupPaths {
+		if !strings.HasPrefix(lookupPath.Prefix, "/") || !strings.HasSuffix(lookupPath.Prefix, "/") {
+			return nil
+		}
+
+		node := root
+		for _, segment := range prefixSegments(lookupPath.Prefix) {
+			child, ok := node.children[segment]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[string]*lookupPathTrie)
+				}
+
+				child = newLookupPathTrie()
+				node.children[segment] = child
+			}
+
+			node = child
+		}
+
+		// lookup paths are sorted, the first one with a prefix wins
+		if node.lookupPath < 0 {
+			node.lookupPath = i
+		}
+	}
+
+	return root
+}
+
+// find returns the index of the lookup path with the longest prefix matching
+// urlPath, which must be clean, or -1 when none matches
+func (t *lookupPathTrie) find(urlPath string) int {
+	if !strings.HasPrefix(urlPath, "/") {
+		return -1
+	}
+
+	found := t.lookupPath
+	node := t
+
+	for rest := urlPath[1:]; rest != ""; {
+		segment := rest
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			segment, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+
+		node = node.children[segment]
+		if node == nil {
+			break
+		}
+
+		if node.lookupPath >= 0 {
+			found = node.lookupPath
+		}
+	}
+
+	return found
+}
+
+func prefixSegments(prefix string) []string {
+	trimmed := strings.Trim(prefix, "/")
+	if trimmed == "" {
+		return nil
+	}
+
+	return strings.Split(trimmed, "/")
+}
@@ -1,5 +1,10 @@
 package api
 
+import (
+	"path"
+	"strings"
+)
+
 // VirtualDomain represents a GitLab Pages virtual domain that is being sent
 // from GitLab API
 type VirtualDomain struct {
@@ -7,4 +12,64 @@ type VirtualDomain struct {
 	Key         string `json:"key,omitempty"`
 
 	LookupPaths []LookupPath `json:"lookup_paths"`
+
+	trie *lookupPathTrie
+}
+
+// SortLookupPaths sorts the lookup paths in the order they need to be checked
+// when resolving a request, see sortLookupsByPrefixLengthDesc.
+func (d *VirtualDomain) SortLookupPaths() {
+	sortLookupsByPrefixLengthDesc(d.LookupPaths)
+}
+
+// Compile sorts the lookup paths and indexes them by prefix for
+// FindLookupPath. It must be called before the virtual domain is shared, as
+// it is not safe for concurrent use.
+func (d *VirtualDomain) Compile() {
+	d.SortLookupPaths()
+	d.trie = compileLookupPathTrie(d.LookupPaths)
+}
+
+// FindLookupPath returns the lookup path serving urlPath, and the path of the
+// requested file relative to the lookup path prefix. The lookup path with the
+// longest prefix wins, which is found with the index built by Compile, or
+// by checking every lookup path in order otherwise.
+func (d *VirtualDomain) FindLookupPath(urlPath string) (*LookupPath, string, bool) {
+	urlPath = path.Clean(urlPath)
+
+	if d.trie != nil {
+		i := d.trie.find(urlPath)
+		if i < 0 {
+			return nil, "", false
+		}
+
+		return &d.LookupPaths[i], subPath(urlPath, d.LookupPaths[i].Prefix), true
+	}
+
+	for i := range d.LookupPaths {
+		lookupPath := &d.LookupPaths[i]
+
+		if strings.HasPrefix(urlPath, lookupPath.Prefix) || urlPath == path.Clean(lookupPath.Prefix) {
+			return lookupPath, subPath(urlPath, lookupPath.Prefix), true
+		}
+	}
+
+	return nil, "", false
+}
+
+// subPath returns the path of urlPath relative to prefix, it is empty when
+// urlPath is the root path of prefix
+func subPath(urlPath, prefix string) string {
+	if !strings.HasPrefix(urlPath, prefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(urlPath, prefix)
+}
+
+// ListedVirtualDomain is a virtual domain with its host, as listed by the
+// GitLab API
+type ListedVirtualDomain struct {
+	Host   string        `json:"host"`
+	Domain VirtualDomain `json:"domain"`
 }
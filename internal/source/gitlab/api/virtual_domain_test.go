@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testVirtualDomain(prefixes ...string) *VirtualDomain {
+	d := &VirtualDomain{}
+	for i, prefix := range prefixes {
+		d.LookupPaths = append(d.LookupPaths, LookupPath{ProjectID: i + 1, Prefix: prefix})
+	}
+
+	return d
+}
+
+func TestFindLookupPath(t *testing.T) {
+	d := testVirtualDomain("/", "/project/", "/group/subgroup/project/", "/group/", "/project-2/")
+	d.Compile()
+	require.NotNil(t, d.trie)
+
+	tests := map[string]struct {
+		urlPath         string
+		expectedPrefix  string
+		expectedSubPath string
+	}{
+		"namespace project root":   {urlPath: "/", expectedPrefix: "/"},
+		"namespace project file":   {urlPath: "/index.html", expectedPrefix: "/", expectedSubPath: "index.html"},
+		"project root":             {urlPath: "/project", expectedPrefix: "/project/"},
+		"project root with slash":  {urlPath: "/project/", expectedPrefix: "/project/"},
+		"project file":             {urlPath: "/project/a/b.html", expectedPrefix: "/project/", expectedSubPath: "a/b.html"},
+		"similar project prefix":   {urlPath: "/project-2/index.html", expectedPrefix: "/project-2/", expectedSubPath: "index.html"},
+		"unknown project":          {urlPath: "/projects/index.html", expectedPrefix: "/", expectedSubPath: "projects/index.html"},
+		"subgroup project":         {urlPath: "/group/subgroup/project/index.html", expectedPrefix: "/group/subgroup/project/", expectedSubPath: "index.html"},
+		"group project":            {urlPath: "/group/subgroup/index.html", expectedPrefix: "/group/", expectedSubPath: "subgroup/index.html"},
+		"unclean path":             {urlPath: "/group/../project//index.html", expectedPrefix: "/project/", expectedSubPath: "index.html"},
+		"subgroup project root":    {urlPath: "/group/subgroup/project", expectedPrefix: "/group/subgroup/project/"},
+		"file named like a prefix": {urlPath: "/group/subgroup/project.html", expectedPrefix: "/group/", expectedSubPath: "subgroup/project.html"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lookupPath, subPath, ok := d.FindLookupPath(tc.urlPath)
+			require.True(t, ok)
+			require.Equal(t, tc.expectedPrefix, lookupPath.Prefix)
+			require.Equal(t, tc.expectedSubPath, subPath)
+		})
+	}
+}
+
+func TestFindLookupPathWithoutNamespaceProject(t *testing.T) {
+	d := testVirtualDomain("/project/")
+	d.Compile()
+
+	_, _, ok := d.FindLookupPath("/other/index.html")
+	require.False(t, ok)
+
+	_, _, ok = d.FindLookupPath("/")
+	require.False(t, ok)
+}
+
+func TestFindLookupPathMatchesLinearScan(t *testing.T) {
+	prefixes := [][]string{
+		{"/", "/a/", "/a/b/", "/b/"},
+		{"/a/b/c/", "/a/", "/c/"},
+		{"/a/", "/a/"},
+		// prefixes without a trailing slash cannot be compiled
+		{"/", "/a", "/ab/"},
+	}
+
+	urlPaths := []string{"/", "/a", "/a/", "/a/x", "/ab", "/ab/x", "/a/b", "/a/b/c", "/a/b/c/d/e", "/b/index.html", "/c", "/x/y"}
+
+	for i, p := range prefixes {
+		t.Run(fmt.Sprint(p), func(t *testing.T) {
+			compiled := testVirtualDomain(p...)
+			compiled.Compile()
+
+			linear := testVirtualDomain(p...)
+			linear.SortLookupPaths()
+
+			if i == len(prefixes)-1 {
+				require.Nil(t, compiled.trie)
+			}
+
+			for _, urlPath := range urlPaths {
+				expected, expectedSubPath, expectedOK := linear.FindLookupPath(urlPath)
+				actual, actualSubPath, actualOK := compiled.FindLookupPath(urlPath)
+
+				require.Equal(t, expectedOK, actualOK, urlPath)
+				require.Equal(t, expectedSubPath, actualSubPath, urlPath)
+
+				if expectedOK {
+					require.Equal(t, expected.ProjectID, actual.ProjectID, urlPath)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindLookupPath(b *testing.B) {
+	prefixes := []string{"/"}
+	for i := 0; i < 5000; i++ {
+		prefixes = append(prefixes, fmt.Sprintf("/project-%d/", i))
+	}
+
+	compiled := testVirtualDomain(prefixes...)
+	compiled.Compile()
+
+	linear := testVirtualDomain(prefixes...)
+	linear.SortLookupPaths()
+
+	b.Run("compiled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			compiled.FindLookupPath("/project-4999/index.html")
+		}
+	})
+
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			linear.FindLookupPath("/project-4999/index.html")
+		}
+	})
+}
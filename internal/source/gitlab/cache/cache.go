@@ -2,27 +2,65 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
+	"github.com/go-redis/redis/v8"
 	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 // Cache is a short and long caching mechanism for GitLab source
 type Cache struct {
-	store     Store
-	retriever *Retriever
+	store             Store
+	retriever         *Retriever
+	refreshTimeout    time.Duration
+	expirationTimeout time.Duration
+	staleIfError      time.Duration
 }
 
-// NewCache creates a new instance of Cache.
+// NewCache creates a new instance of Cache using an in-memory store.
 func NewCache(client api.Client, cc *config.Cache) *Cache {
+	return newCache(client, cc, newMemStore(cc))
+}
+
+// NewFromConfig creates a new instance of Cache using the store selected in
+// cc. The entries shared through Redis are encrypted with a key derived from
+// secret.
+func NewFromConfig(client api.Client, cc *config.Cache, secret []byte) (*Cache, error) {
+	if cc.Store != config.CacheStoreRedis {
+		return NewCache(client, cc), nil
+	}
+
+	opts, err := redis.ParseURL(cc.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis URL: %w", err)
+	}
+
+	store, err := newRedisStore(cc, redis.NewClient(opts), secret)
+	if err != nil {
+		return nil, fmt.Errorf("creating redis store: %w", err)
+	}
+
+	return newCache(client, cc, store), nil
+}
+
+func newCache(client api.Client, cc *config.Cache, store Store) *Cache {
 	r := NewRetriever(client, cc.RetrievalTimeout, cc.MaxRetrievalInterval, cc.MaxRetrievalRetries)
 	return &Cache{
-		store:     newMemStore(cc),
-		retriever: r,
+		store:             store,
+		retriever:         r,
+		refreshTimeout:    cc.EntryRefreshTimeout,
+		expirationTimeout: cc.CacheExpiry,
+		staleIfError:      cc.StaleIfError,
 	}
 }
 
@@ -35,6 +73,9 @@ func NewCache(client api.Client, cc *config.Cache) *Cache {
 //     retrieval of the latest configuration we are going to obtain through the
 //     API, and we immediately return an old value, to avoid blocking clients. In
 //     this case it is also a cache hit.
+//   - If the entry has expired, which only happens with a stale-if-error
+//     window, we wait for the refresh instead, and return the old value marked
+//     as stale once the refresh failed. It is a cache miss.
 //   - If cache entry has not been populated with a lookup information yet, we
 //     block all the clients and make them wait until we retrieve the lookup from
 //     the GitLab API. Clients should not wait for longer than
@@ -88,8 +129,21 @@ func (c *Cache) Resolve(ctx context.Context, domain string) *api.Lookup {
 	if entry.NeedsRefresh() {
 		c.Refresh(entry)
 
+		// the stores keep the expired entries for the stale-if-error window,
+		// they are only served, marked as stale, once refreshing them failed
+		if c.staleIfError > 0 && entry.expiredAndNotStale() {
+			metrics.DomainsSourceCacheMiss.Inc()
+			return c.waitRefresh(ctx, entry)
+		}
+
 		metrics.DomainsSourceCacheHit.Inc()
-		return entry.Lookup()
+
+		lookup := entry.Lookup()
+		if lookup.Stale {
+			metrics.DomainsSourceCacheStale.Inc()
+		}
+
+		return lookup
 	}
 
 	metrics.DomainsSourceCacheMiss.Inc()
@@ -97,6 +151,12 @@ func (c *Cache) Resolve(ctx context.Context, domain string) *api.Lookup {
 }
 
 func (c *Cache) retrieve(ctx context.Context, entry *Entry) *api.Lookup {
+	return c.revalidate(ctx, entry, nil)
+}
+
+// revalidate retrieves the lookup of entry like retrieve, but lets the
+// retriever keep previous when it has not been modified
+func (c *Cache) revalidate(ctx context.Context, entry *Entry, previous *api.Lookup) *api.Lookup {
 	// We run the code within an additional func() to run both `e.setResponse`
 	// and `c.retriever.Retrieve` asynchronously.
 	// We are using a sync.Once so this assumes that setResponse is always called
@@ -105,7 +165,7 @@ func (c *Cache) retrieve(ctx context.Context, entry *Entry) *api.Lookup {
 		correlationID := correlation.ExtractFromContext(ctx)
 
 		go func() {
-			l := c.retriever.Retrieve(correlationID, entry.domain)
+			l := c.retriever.Revalidate(correlationID, entry.domain, previous)
 			entry.setResponse(l)
 		}()
 	})
@@ -121,28 +181,322 @@ func (c *Cache) retrieve(ctx context.Context, entry *Entry) *api.Lookup {
 	return lookup
 }
 
+// waitRefresh waits for entry to be refreshed, and returns the lookup of the
+// entry replacing it
+func (c *Cache) waitRefresh(ctx context.Context, entry *Entry) *api.Lookup {
+	select {
+	case <-ctx.Done():
+		return &api.Lookup{Name: entry.domain, Error: fmt.Errorf("original context done: %w", ctx.Err())}
+	case <-entry.refreshed:
+	}
+
+	// the entry replacing an invalidated one may not be retrieved yet
+	if entry.replacement.Lookup() == nil {
+		return c.retrieve(ctx, entry.replacement)
+	}
+
+	lookup := entry.replacement.Lookup()
+	if lookup.Stale {
+		metrics.DomainsSourceCacheStale.Inc()
+	}
+
+	return lookup
+}
+
 // Refresh will update the entry in the store only when it gets resolved successfully.
 // If an existing successful entry exists, it will only be replaced if the new resolved
 // entry is successful too.
 // Errored refreshed Entry responses will not replace the previously successful entry.response
-// for a maximum time of e.expirationTimeout.
+// for a maximum time of e.expirationTimeout, plus the stale-if-error window when
+// it is enabled. Past e.expirationTimeout the response is marked as stale.
 func (c *Cache) Refresh(entry *Entry) {
 	entry.refresh.Do(func() {
-		go c.refreshFunc(entry)
+		// the refreshed entry is created straight away, so that an
+		// invalidation happening afterwards drops it
+		refreshed := newCacheEntry(entry.domain, entry.refreshTimeout, entry.expirationTimeout)
+
+		go c.refreshFunc(entry, refreshed)
 	})
 }
 
-func (c *Cache) refreshFunc(e *Entry) {
-	entry := newCacheEntry(e.domain, e.refreshTimeout, e.expirationTimeout)
-
-	c.retrieve(context.Background(), entry)
+func (c *Cache) refreshFunc(e, entry *Entry) {
+	c.revalidate(context.Background(), entry, e.Lookup())
 
 	// do not replace existing Entry `e.response` when `entry.response` has an error
 	// and `e` has not expired. See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/281.
-	if !e.isExpired() && entry.hasTemporaryError() {
-		entry.response = e.response
-		entry.refreshedOriginalTimestamp = e.created
+	if entry.hasTemporaryError() {
+		switch {
+		case !e.isExpired():
+			entry.response = e.response
+			entry.refreshedOriginalTimestamp = e.originalTimestamp()
+			entry.adopted = true
+		case c.staleIfError > 0 && e.isStale(c.staleIfError) && e.response.Error == nil:
+			log.WithError(entry.response.Error).WithField("domain", e.domain).
+				Warn("failed to refresh domain, serving stale configuration")
+
+			stale := *e.response
+			stale.Stale = true
+
+			entry.response = &stale
+			entry.refreshedOriginalTimestamp = e.originalTimestamp()
+			entry.adopted = true
+		}
+	}
+
+	// the entry stored is kept when it has been refreshed after entry, and
+	// entry is dropped when e gets invalidated while it is being refreshed.
+	// The requests waiting for entry started before the invalidation, so they
+	// are still served with it when nothing else is stored.
+	stored := c.store.ReplaceOrCreate(e.domain, entry)
+	if stored == nil {
+		stored = entry
+	}
+
+	e.setRefreshed(stored)
+}
+
+// warmUpPageSize is the number of domains listed per GitLab API call when
+// warming up the cache
+const warmUpPageSize = 100
+
+// WarmUp populates the cache with the domains listed by lister, up to limit
+// domains from the most active one, or all of them when limit is 0. It returns
+// the number of domains cached, including when listing them fails midway.
+func (c *Cache) WarmUp(ctx context.Context, lister api.Lister, limit int) (int, error) {
+	// the page size must not change between pages for them to be consistent
+	perPage := warmUpPageSize
+	if limit > 0 && limit < perPage {
+		perPage = limit
+	}
+
+	count := 0
+
+	for page := 1; page > 0; {
+		lookups, nextPage, err := lister.ListLookups(ctx, page, perPage)
+		if err != nil {
+			return count, err
+		}
+
+		for _, lookup := range lookups {
+			if limit > 0 && count >= limit {
+				return count, nil
+			}
+
+			c.store.ReplaceOrCreate(lookup.Name, newResolvedEntry(lookup, time.Now(), c.refreshTimeout, c.expirationTimeout))
+			count++
+		}
+
+		// a next page which does not advance would list the same pages forever
+		if len(lookups) == 0 || (limit > 0 && count >= limit) || nextPage <= page {
+			break
+		}
+
+		page = nextPage
+	}
+
+	return count, nil
+}
+
+// Subscribe streams the changes of the domains configuration from subscriber
+// into the cache until ctx is done. When the subscription gets disconnected,
+// it resumes after the last event received once reconnectInterval elapsed.
+// The entries keep being refreshed from the GitLab API regardless, so that
+// they eventually get updated when the events cannot be streamed. The events
+// only update the domains cached already, the other ones are resolved on
+// their first request.
+func (c *Cache) Subscribe(ctx context.Context, subscriber api.Subscriber, reconnectInterval time.Duration) {
+	var cursor string
+
+	for {
+		err := subscriber.Subscribe(ctx, cursor, func(event api.DomainEvent) {
+			if event.Cursor != "" {
+				cursor = event.Cursor
+			}
+
+			eventType := "updated"
+			if event.Lookup.Error != nil {
+				eventType = "deleted"
+			}
+
+			if !c.store.Replace(event.Lookup.Name, newResolvedEntry(event.Lookup, time.Now(), c.refreshTimeout, c.expirationTimeout)) {
+				eventType = "skipped"
+			}
+
+			metrics.DomainsSourceCacheSubscriptionEvents.WithLabelValues(eventType).Inc()
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger := log.WithField("cursor", cursor)
+
+		switch {
+		case errors.Is(err, client.ErrSubscriptionUnsupported):
+			logger.WithError(err).Warn("GitLab does not stream domain events, the domains configuration gets refreshed by polling only")
+			return
+		case errors.Is(err, client.ErrCursorExpired):
+			// the events missed are refreshed by polling
+			logger.WithError(err).Warn("domain events subscription cursor expired, resuming from the latest event")
+			cursor = ""
+		case err != nil:
+			logger.WithError(err).Warn("domain events subscription failed")
+		default:
+			logger.Info("domain events subscription ended")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// RestoreSnapshot loads the lookups persisted to the snapshot into the cache.
+// They are served straight away, but need to be refreshed, so the GitLab API
+// gets asked for the latest configuration on their first request. A snapshot
+// saved more than maxAge ago is not restored, unless maxAge is 0.
+func (c *Cache) RestoreSnapshot(s *Snapshot, maxAge time.Duration) (int, error) {
+	records, created, err := s.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	if maxAge > 0 && time.Since(created) > maxAge {
+		return 0, fmt.Errorf("%w: saved at %s", errSnapshotTooOld, created.Format(time.RFC3339))
+	}
+
+	for _, r := range records {
+		c.store.ReplaceOrCreate(r.Name, newRestoredEntry(r.lookup(), c.refreshTimeout, c.expirationTimeout))
+	}
+
+	return len(records), nil
+}
+
+// SaveSnapshot persists the successful lookups of the cache to the snapshot.
+// Errors and domains that do not exist are not persisted.
+func (c *Cache) SaveSnapshot(s *Snapshot) (int, error) {
+	var records []record
+
+	c.store.Range(func(domain string, entry *Entry) bool {
+		if r, ok := newRecord(domain, entry); ok && !r.NotFound {
+			records = append(records, r)
+		}
+
+		return true
+	})
+
+	return len(records), s.Save(records)
+}
+
+// PersistSnapshot saves the snapshot every interval until ctx is done, and
+// once more when it is done so that the latest lookups are persisted on
+// shutdown
+func (c *Cache) PersistSnapshot(ctx context.Context, s *Snapshot, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.persistSnapshot(s)
+			return
+		case <-ticker.C:
+			c.persistSnapshot(s)
+		}
+	}
+}
+
+func (c *Cache) persistSnapshot(s *Snapshot) {
+	count, err := c.SaveSnapshot(s)
+	if err != nil {
+		log.WithError(err).Error("failed to save domains cache snapshot")
+		return
+	}
+
+	log.WithField("domains_count", count).Debug("saved domains cache snapshot")
+}
+
+// Close releases the resources of the store, like its connections to Redis
+func (c *Cache) Close() error {
+	if closer, ok := c.store.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Invalidate evicts the entries of hosts and of the domains serving any of the
+// projectIDs, so that they are retrieved from the GitLab API again on their
+// next request. When refresh is true, the entries are refreshed straight away
+// instead and keep being served until the refresh succeeds. Either way, a
+// refresh in flight which started before is never stored. With the Redis
+// store, the domains of projectIDs are only looked for in the entries cached
+// by this replica. It returns the serving cache keys of the lookup paths of
+// the invalidated entries.
+func (c *Cache) Invalidate(hosts []string, projectIDs []uint64, refresh bool) []string {
+	invalidHosts := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		invalidHosts[strings.ToLower(host)] = true
+	}
+
+	invalidProjects := make(map[uint64]bool, len(projectIDs))
+	for _, id := range projectIDs {
+		invalidProjects[id] = true
+	}
+
+	var cacheKeys []string
+	entries := make(map[string]*Entry)
+
+	c.store.Range(func(domain string, entry *Entry) bool {
+		lookup := entry.Lookup()
+		if lookup == nil || lookup.Domain == nil {
+			if invalidHosts[domain] {
+				entries[domain] = entry
+			}
+
+			return true
+		}
+
+		invalid := invalidHosts[domain]
+		for _, lookupPath := range lookup.Domain.LookupPaths {
+			if invalidProjects[uint64(lookupPath.ProjectID)] {
+				invalid = true
+			}
+		}
+
+		if invalid {
+			entries[domain] = entry
+			for _, lookupPath := range lookup.Domain.LookupPaths {
+				cacheKeys = append(cacheKeys, lookupPath.Source.SHA256)
+			}
+		}
+
+		return true
+	})
+
+	for domain, entry := range entries {
+		if refresh && entry.Lookup() != nil {
+			// the entry may be refreshed already, so a copy of it gets refreshed
+			if renewed := c.store.Invalidate(domain); renewed != nil {
+				c.Refresh(renewed)
+			}
+
+			continue
+		}
+
+		c.store.Delete(domain)
+	}
+
+	// hosts which are not cached locally might still be cached by a shared store
+	if !refresh {
+		for host := range invalidHosts {
+			if _, ok := entries[host]; !ok {
+				c.store.Delete(host)
+			}
+		}
 	}
 
-	c.store.ReplaceOrCreate(e.domain, entry)
+	return cacheKeys
 }
@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -11,7 +12,9 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
 )
 
@@ -252,3 +255,391 @@ func TestResolve(t *testing.T) {
 		})
 	})
 }
+
+func TestResolveStaleIfError(t *testing.T) {
+	tests := map[string]struct {
+		staleIfError  time.Duration
+		age           time.Duration
+		expectedStale bool
+		// expectedServed is true when the entry is served while it gets
+		// refreshed, instead of waiting for the refresh
+		expectedServed bool
+	}{
+		"when expired entry is within the stale window": {
+			staleIfError:  time.Minute,
+			age:           90 * time.Second,
+			expectedStale: true,
+		},
+		"when expired entry is past the stale window": {
+			staleIfError: time.Minute,
+			age:          3 * time.Minute,
+		},
+		"when stale-if-error is disabled": {
+			age:            90 * time.Second,
+			expectedServed: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cc := &config.Cache{
+				CacheExpiry:          time.Minute,
+				CacheCleanupInterval: time.Minute,
+				EntryRefreshTimeout:  30 * time.Second,
+				RetrievalTimeout:     time.Second,
+				MaxRetrievalRetries:  1,
+				StaleIfError:         tc.staleIfError,
+			}
+
+			withTestCache(resolverConfig{failure: errors.New("500 error")}, cc, func(cache *Cache, resolver *clientMock) {
+				cache.withTestEntry(entryConfig{retrieved: true}, func(entry *Entry) {
+					entry.created = time.Now().Add(-tc.age)
+
+					lookup := cache.Resolve(context.Background(), "my.gitlab.com")
+
+					if tc.expectedServed {
+						require.Same(t, entry.Lookup(), lookup)
+
+						require.Eventually(t, func() bool {
+							lookup = cache.Resolve(context.Background(), "my.gitlab.com")
+							return lookup != entry.Lookup()
+						}, time.Second, 50*time.Millisecond)
+					}
+
+					require.Equal(t, tc.expectedStale, lookup.Stale)
+
+					if tc.expectedStale {
+						require.NoError(t, lookup.Error)
+						require.Equal(t, "my.gitlab.com", lookup.Name)
+
+						// the stale entry is served while it gets refreshed again
+						require.Same(t, lookup, cache.Resolve(context.Background(), "my.gitlab.com"))
+					} else {
+						require.EqualError(t, lookup.Error, "500 error")
+					}
+				})
+			})
+		})
+	}
+
+	t.Run("when expired entry is refreshed", func(t *testing.T) {
+		cc := testhelpers.CacheConfig
+		cc.StaleIfError = time.Minute
+
+		withTestCache(resolverConfig{bufferSize: 1}, &cc, func(cache *Cache, resolver *clientMock) {
+			cache.withTestEntry(entryConfig{expired: true, retrieved: true}, func(entry *Entry) {
+				resolver.domain <- "my.gitlab.com"
+
+				lookup := cache.Resolve(context.Background(), "my.gitlab.com")
+
+				require.NotSame(t, entry.Lookup(), lookup)
+				require.NoError(t, lookup.Error)
+				require.False(t, lookup.Stale)
+				require.Equal(t, uint64(1), <-resolver.lookups)
+			})
+		})
+	})
+
+	t.Run("when waiting for the refresh is canceled", func(t *testing.T) {
+		cc := testhelpers.CacheConfig
+		cc.StaleIfError = time.Minute
+
+		withTestCache(resolverConfig{}, &cc, func(cache *Cache, resolver *clientMock) {
+			cache.withTestEntry(entryConfig{expired: true, retrieved: true}, func(*Entry) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				lookup := cache.Resolve(ctx, "my.gitlab.com")
+				resolver.domain <- "my.gitlab.com"
+
+				require.ErrorIs(t, lookup.Error, context.Canceled)
+			})
+		})
+	})
+}
+
+func TestInvalidate(t *testing.T) {
+	lookupWithProject := func(name string, projectID int, sha string) api.Lookup {
+		return api.Lookup{Name: name, Domain: &api.VirtualDomain{
+			LookupPaths: []api.LookupPath{{ProjectID: projectID, Source: api.Source{SHA256: sha}}},
+		}}
+	}
+
+	populate := func(cache *Cache) {
+		for _, lookup := range []api.Lookup{
+			lookupWithProject("host.gitlab.io", 1, "sha1"),
+			lookupWithProject("project.gitlab.io", 2, "sha2"),
+			lookupWithProject("custom.example.com", 2, "sha2"),
+			lookupWithProject("other.gitlab.io", 3, "sha3"),
+		} {
+			lookup := lookup
+			cache.withTestEntry(entryConfig{domain: lookup.Name}, func(entry *Entry) {
+				entry.setResponse(lookup)
+			})
+		}
+	}
+
+	t.Run("evicts hosts and projects", func(t *testing.T) {
+		withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+			populate(cache)
+
+			cacheKeys := cache.Invalidate([]string{"Host.gitlab.io", "unknown.gitlab.io"}, []uint64{2}, false)
+			require.ElementsMatch(t, []string{"sha1", "sha2", "sha2"}, cacheKeys)
+
+			for _, name := range []string{"host.gitlab.io", "project.gitlab.io", "custom.example.com"} {
+				require.False(t, cache.store.LoadOrCreate(name).isResolved(), name)
+			}
+
+			require.True(t, cache.store.LoadOrCreate("other.gitlab.io").isResolved())
+		})
+	})
+
+	t.Run("refreshes hosts", func(t *testing.T) {
+		withTestCache(resolverConfig{bufferSize: 1}, nil, func(cache *Cache, resolver *clientMock) {
+			populate(cache)
+			entry := cache.store.LoadOrCreate("host.gitlab.io")
+
+			cacheKeys := cache.Invalidate([]string{"host.gitlab.io"}, nil, true)
+			require.Equal(t, []string{"sha1"}, cacheKeys)
+
+			// the lookup keeps being served until it gets refreshed
+			renewed := cache.store.LoadOrCreate("host.gitlab.io")
+			require.Same(t, entry.Lookup(), renewed.Lookup())
+
+			resolver.domain <- "host.gitlab.io"
+			<-resolver.lookups
+
+			require.Eventually(t, func() bool {
+				return cache.store.LoadOrCreate("host.gitlab.io") != renewed
+			}, time.Second, 10*time.Millisecond)
+		})
+	})
+
+	t.Run("refresh in flight is not stored once the host is evicted", func(t *testing.T) {
+		withTestCache(resolverConfig{bufferSize: 1}, nil, func(cache *Cache, resolver *clientMock) {
+			populate(cache)
+			entry := cache.store.LoadOrCreate("host.gitlab.io")
+
+			// the refresh is blocked until the resolver gets a domain
+			cache.Refresh(entry)
+			cache.Invalidate([]string{"host.gitlab.io"}, nil, false)
+
+			resolver.domain <- "host.gitlab.io"
+			<-resolver.lookups
+			<-entry.refreshed
+
+			require.False(t, cache.store.LoadOrCreate("host.gitlab.io").isResolved())
+		})
+	})
+
+	t.Run("refresh in flight is not stored once the host is refreshed again", func(t *testing.T) {
+		withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+			populate(cache)
+
+			started := newResolvedEntry(lookupWithProject("host.gitlab.io", 1, "sha2"), time.Now(), time.Minute, time.Minute)
+
+			renewed := cache.store.Invalidate("host.gitlab.io")
+			require.NotNil(t, renewed)
+			require.Same(t, renewed, cache.store.ReplaceOrCreate("host.gitlab.io", started))
+			require.Same(t, renewed, cache.store.LoadOrCreate("host.gitlab.io"))
+		})
+	})
+
+	t.Run("refresh started before is not stored over the refreshed entry", func(t *testing.T) {
+		withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+			started := time.Now()
+
+			refreshed := newResolvedEntry(lookupWithProject("host.gitlab.io", 1, "sha2"), started.Add(time.Millisecond), time.Minute, time.Minute)
+			require.Same(t, refreshed, cache.store.ReplaceOrCreate("host.gitlab.io", refreshed))
+
+			outdated := newResolvedEntry(lookupWithProject("host.gitlab.io", 1, "sha1"), started, time.Minute, time.Minute)
+			require.Same(t, refreshed, cache.store.ReplaceOrCreate("host.gitlab.io", outdated))
+			require.Same(t, refreshed, cache.store.LoadOrCreate("host.gitlab.io"))
+		})
+	})
+}
+
+type listerStub struct {
+	hosts    []string
+	perPages []int
+	// stuck always returns the same next page, as a broken pagination would
+	stuck bool
+}
+
+func (l *listerStub) ListLookups(_ context.Context, page, perPage int) ([]api.Lookup, int, error) {
+	l.perPages = append(l.perPages, perPage)
+
+	var lookups []api.Lookup
+	for i := (page - 1) * perPage; i < len(l.hosts) && i < page*perPage; i++ {
+		lookups = append(lookups, api.Lookup{Name: l.hosts[i], Domain: &api.VirtualDomain{}})
+	}
+
+	if l.stuck {
+		return lookups, page, nil
+	}
+
+	if page*perPage >= len(l.hosts) {
+		return lookups, 0, nil
+	}
+
+	return lookups, page + 1, nil
+}
+
+func TestWarmUp(t *testing.T) {
+	hosts := make([]string, 250)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("group-%d.gitlab.io", i)
+	}
+
+	tests := map[string]struct {
+		limit            int
+		stuck            bool
+		expectedCount    int
+		expectedPerPages []int
+	}{
+		"next page not advancing": {
+			stuck:            true,
+			expectedCount:    100,
+			expectedPerPages: []int{100},
+		},
+		"all domains": {
+			expectedCount:    250,
+			expectedPerPages: []int{100, 100, 100},
+		},
+		"fewer domains than a page": {
+			limit:            20,
+			expectedCount:    20,
+			expectedPerPages: []int{20},
+		},
+		"most active domains": {
+			limit:            150,
+			expectedCount:    150,
+			expectedPerPages: []int{100, 100},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+				lister := &listerStub{hosts: hosts, stuck: tc.stuck}
+
+				count, err := cache.WarmUp(context.Background(), lister, tc.limit)
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedCount, count)
+				require.Equal(t, tc.expectedPerPages, lister.perPages)
+
+				for _, host := range hosts[:tc.expectedCount] {
+					lookup := cache.Resolve(context.Background(), host)
+					require.NoError(t, lookup.Error)
+					require.Equal(t, host, lookup.Name)
+				}
+
+				require.Empty(t, resolver.lookups)
+			})
+		})
+	}
+}
+
+// subscriberStub replays a session of events per subscription, and records
+// the cursors it got subscribed with
+type subscriberStub struct {
+	sessions []subscriberSession
+	cursors  []string
+}
+
+type subscriberSession struct {
+	events []api.DomainEvent
+	err    error
+}
+
+func (s *subscriberStub) Subscribe(_ context.Context, cursor string, handle func(api.DomainEvent)) error {
+	s.cursors = append(s.cursors, cursor)
+
+	session := s.sessions[0]
+	s.sessions = s.sessions[1:]
+
+	for _, event := range session.events {
+		handle(event)
+	}
+
+	return session.err
+}
+
+func TestSubscribe(t *testing.T) {
+	withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+		for _, host := range []string{"created.gitlab.io", "deleted.gitlab.io", "updated.gitlab.io"} {
+			cache.withTestEntry(entryConfig{domain: host, retrieved: true}, func(*Entry) {})
+		}
+
+		subscriber := &subscriberStub{sessions: []subscriberSession{
+			{
+				events: []api.DomainEvent{
+					{Cursor: "1", Lookup: api.Lookup{Name: "created.gitlab.io", Domain: &api.VirtualDomain{Certificate: "v1"}}},
+					{Cursor: "2", Lookup: api.Lookup{Name: "deleted.gitlab.io", Error: domain.ErrDomainDoesNotExist}},
+				},
+				err: errors.New("connection reset"),
+			},
+			{
+				events: []api.DomainEvent{
+					{Cursor: "3", Lookup: api.Lookup{Name: "created.gitlab.io", Domain: &api.VirtualDomain{Certificate: "v2"}}},
+				},
+				err: client.ErrCursorExpired,
+			},
+			{
+				events: []api.DomainEvent{
+					{Cursor: "10", Lookup: api.Lookup{Name: "updated.gitlab.io", Domain: &api.VirtualDomain{Certificate: "v1"}}},
+					{Cursor: "11", Lookup: api.Lookup{Name: "uncached.gitlab.io", Domain: &api.VirtualDomain{Certificate: "v1"}}},
+				},
+			},
+			{
+				err: client.ErrSubscriptionUnsupported,
+			},
+		}}
+
+		// returns once the subscription is unsupported
+		cache.Subscribe(context.Background(), subscriber, time.Millisecond)
+
+		require.Equal(t, []string{"", "2", "", "11"}, subscriber.cursors)
+
+		// the events do not cache the domains which are not cached yet
+		cache.store.Range(func(domain string, _ *Entry) bool {
+			require.NotEqual(t, "uncached.gitlab.io", domain)
+			return true
+		})
+
+		lookup := cache.Resolve(context.Background(), "created.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.Equal(t, "v2", lookup.Domain.Certificate)
+
+		lookup = cache.Resolve(context.Background(), "updated.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.Equal(t, "v1", lookup.Domain.Certificate)
+
+		lookup = cache.Resolve(context.Background(), "deleted.gitlab.io")
+		require.ErrorIs(t, lookup.Error, domain.ErrDomainDoesNotExist)
+
+		require.Empty(t, resolver.lookups)
+	})
+}
+
+func TestSubscribeUntilContextIsDone(t *testing.T) {
+	withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		subscriber := &subscriberStub{sessions: []subscriberSession{{err: errors.New("connection refused")}}}
+
+		done := make(chan struct{})
+		go func() {
+			cache.Subscribe(ctx, subscriber, time.Hour)
+			close(done)
+		}()
+
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("subscription did not stop")
+		}
+	})
+}
@@ -20,11 +20,20 @@ type Entry struct {
 	refreshedOriginalTimestamp time.Time
 	retrieve                   *sync.Once
 	refresh                    *sync.Once
+	loadShared                 *sync.Once
 	mux                        *sync.RWMutex
 	retrieved                  chan struct{}
 	response                   *api.Lookup
 	refreshTimeout             time.Duration
 	expirationTimeout          time.Duration
+	// adopted is true when the lookup has not been retrieved from the GitLab
+	// API for this entry, but restored from a snapshot, loaded from a shared
+	// store or kept from the entry it refreshed
+	adopted bool
+	// refreshed is closed once the entry has been refreshed, replacement
+	// being the entry replacing it
+	refreshed   chan struct{}
+	replacement *Entry
 }
 
 func newCacheEntry(domain string, refreshTimeout, entryExpirationTimeout time.Duration) *Entry {
@@ -33,13 +42,37 @@ func newCacheEntry(domain string, refreshTimeout, entryExpirationTimeout time.Du
 		created:           time.Now(),
 		retrieve:          &sync.Once{},
 		refresh:           &sync.Once{},
+		loadShared:        &sync.Once{},
 		mux:               &sync.RWMutex{},
 		retrieved:         make(chan struct{}),
+		refreshed:         make(chan struct{}),
 		refreshTimeout:    refreshTimeout,
 		expirationTimeout: entryExpirationTimeout,
 	}
 }
 
+// newRestoredEntry returns an entry resolved with lookup that needs to be
+// refreshed straight away, but does not expire before expirationTimeout
+func newRestoredEntry(lookup api.Lookup, refreshTimeout, entryExpirationTimeout time.Duration) *Entry {
+	created := time.Now().Add(-refreshTimeout - time.Nanosecond)
+
+	entry := newResolvedEntry(lookup, created, refreshTimeout, entryExpirationTimeout)
+	entry.adopted = true
+
+	return entry
+}
+
+// newResolvedEntry returns an entry that has been resolved with lookup at
+// created
+func newResolvedEntry(lookup api.Lookup, created time.Time, refreshTimeout, entryExpirationTimeout time.Duration) *Entry {
+	entry := newCacheEntry(lookup.Name, refreshTimeout, entryExpirationTimeout)
+	entry.created = created
+	entry.retrieve.Do(func() {})
+	entry.setResponse(lookup)
+
+	return entry
+}
+
 // IsUpToDate returns true if the entry has been resolved correctly and has not
 // expired yet. False otherwise.
 func (e *Entry) IsUpToDate() bool {
@@ -74,12 +107,54 @@ func (e *Entry) setResponse(lookup api.Lookup) {
 	close(e.retrieved)
 }
 
-func (e *Entry) isOutdated() bool {
+// renewed returns a copy of the resolved entry e which has not been refreshed
+// yet
+func (e *Entry) renewed() *Entry {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	entry := newCacheEntry(e.domain, e.refreshTimeout, e.expirationTimeout)
+	entry.created = e.created
+	entry.refreshedOriginalTimestamp = e.refreshedOriginalTimestamp
+	entry.adopted = true
+	entry.retrieve.Do(func() {})
+	entry.response = e.response
+	close(entry.retrieved)
+
+	return entry
+}
+
+// setRefreshed sets the entry replacing e once it has been refreshed
+func (e *Entry) setRefreshed(replacement *Entry) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.replacement = replacement
+	close(e.refreshed)
+}
+
+// expiredAndNotStale returns true if the entry has expired and is not a stale
+// entry served because its refresh failed already
+func (e *Entry) expiredAndNotStale() bool {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	return e.isResolved() && e.isExpired() && !e.response.Stale
+}
+
+// originalTimestamp returns when the lookup of the entry has been retrieved
+// from the GitLab API, which is before the entry has been created when it
+// kept the lookup of the entry it refreshed
+func (e *Entry) originalTimestamp() time.Time {
 	if !e.refreshedOriginalTimestamp.IsZero() {
-		return time.Since(e.refreshedOriginalTimestamp) > e.refreshTimeout
+		return e.refreshedOriginalTimestamp
 	}
 
-	return time.Since(e.created) > e.refreshTimeout
+	return e.created
+}
+
+func (e *Entry) isOutdated() bool {
+	return time.Since(e.originalTimestamp()) > e.refreshTimeout
 }
 
 func (e *Entry) isResolved() bool {
@@ -87,11 +162,13 @@ func (e *Entry) isResolved() bool {
 }
 
 func (e *Entry) isExpired() bool {
-	if !e.refreshedOriginalTimestamp.IsZero() {
-		return time.Since(e.refreshedOriginalTimestamp) > e.expirationTimeout
-	}
+	return time.Since(e.originalTimestamp()) > e.expirationTimeout
+}
 
-	return time.Since(e.created) > e.expirationTimeout
+// isStale returns true if the entry has expired, but still can be served
+// within the staleIfError window while its refresh fails
+func (e *Entry) isStale(staleIfError time.Duration) bool {
+	return e.isExpired() && time.Since(e.originalTimestamp()) <= e.expirationTimeout+staleIfError
 }
 
 func (e *Entry) domainExists() bool {
@@ -107,7 +107,7 @@ func TestEntryRefresh(t *testing.T) {
 
 		require.Eventually(t, entry.NeedsRefresh, 100*time.Millisecond, time.Millisecond, "entry should need refresh")
 
-		cache.refreshFunc(entry)
+		cache.refreshFunc(entry, newCacheEntry(entry.domain, entry.refreshTimeout, entry.expirationTimeout))
 
 		require.True(t, client.failed, "refresh should have failed")
 
@@ -134,7 +134,7 @@ func TestEntryRefresh(t *testing.T) {
 		// wait for entry to expire
 		time.Sleep(cc.CacheExpiry)
 		// refreshing the entry after it has expired should create a completely new one
-		cache.refreshFunc(entry)
+		cache.refreshFunc(entry, newCacheEntry(entry.domain, entry.refreshTimeout, entry.expirationTimeout))
 
 		require.True(t, client.failed, "refresh should have failed")
 
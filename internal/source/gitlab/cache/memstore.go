@@ -10,15 +10,22 @@ import (
 )
 
 type memstore struct {
-	store                  *cache.Cache
+	store *cache.Cache
+	// invalidated keeps when the domains have been invalidated, so that the
+	// entries retrieved before, e.g. by a refresh in flight, are not stored
+	invalidated            *cache.Cache
 	mux                    *sync.RWMutex
 	entryRefreshTimeout    time.Duration
 	entryExpirationTimeout time.Duration
 }
 
-func newMemStore(cc *config.Cache) Store {
+func newMemStore(cc *config.Cache) *memstore {
 	return &memstore{
-		store:                  cache.New(cc.CacheExpiry, cc.CacheCleanupInterval),
+		// entries are kept past their expiration to be served stale when
+		// refreshing them fails
+		store: cache.New(cc.CacheExpiry+cc.StaleIfError, cc.CacheCleanupInterval),
+		// the entries retrieved before are expired past this duration anyway
+		invalidated:            cache.New(cc.CacheExpiry+cc.StaleIfError, cc.CacheCleanupInterval),
 		mux:                    &sync.RWMutex{},
 		entryRefreshTimeout:    cc.EntryRefreshTimeout,
 		entryExpirationTimeout: cc.CacheExpiry,
@@ -28,33 +35,156 @@ func newMemStore(cc *config.Cache) Store {
 // LoadOrCreate writes or retrieves a domain entry from the cache in a
 // thread-safe way, trying to make this read-preferring RW locking.
 func (m *memstore) LoadOrCreate(domain string) *Entry {
+	entry, _ := m.loadOrCreate(domain)
+
+	return entry
+}
+
+// loadOrCreate works like LoadOrCreate, it also returns true when the entry
+// has been created
+func (m *memstore) loadOrCreate(domain string) (*Entry, bool) {
 	m.mux.RLock()
 	entry, exists := m.store.Get(domain)
 	m.mux.RUnlock()
 
 	if exists {
-		return entry.(*Entry)
+		return entry.(*Entry), false
 	}
 
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
 	if entry, exists = m.store.Get(domain); exists {
-		return entry.(*Entry)
+		return entry.(*Entry), false
 	}
 
 	newEntry := newCacheEntry(domain, m.entryRefreshTimeout, m.entryExpirationTimeout)
 	m.store.SetDefault(domain, newEntry)
 
-	return newEntry
+	return newEntry, true
+}
+
+// load returns the entry of domain if it exists
+func (m *memstore) load(domain string) (*Entry, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	entry, exists := m.store.Get(domain)
+	if !exists {
+		return nil, false
+	}
+
+	return entry.(*Entry), true
 }
 
+// add stores entry unless an entry of domain exists already or domain has
+// been invalidated after entry has been retrieved, it returns the entry stored
+// for domain or nil when there is none
+func (m *memstore) add(domain string, entry *Entry) *Entry {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if existing, exists := m.store.Get(domain); exists {
+		return existing.(*Entry)
+	}
+
+	if m.invalidatedAfter(domain, entry) {
+		return nil
+	}
+
+	m.store.SetDefault(domain, entry)
+
+	return entry
+}
+
+// ReplaceOrCreate stores entry for domain unless the lookup of the entry
+// stored has been retrieved after it, so that a refresh started before
+// another one never overwrites it, or domain has been invalidated after it has
+// been retrieved. It returns the entry stored for domain, or nil when there is
+// none.
 func (m *memstore) ReplaceOrCreate(domain string, entry *Entry) *Entry {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
+	existing, exists := m.store.Get(domain)
+	if exists && existing.(*Entry).originalTimestamp().After(entry.originalTimestamp()) {
+		return existing.(*Entry)
+	}
+
+	if m.invalidatedAfter(domain, entry) {
+		if exists {
+			return existing.(*Entry)
+		}
+
+		return nil
+	}
+
 	m.store.Delete(domain)
 	m.store.SetDefault(domain, entry)
 
 	return entry
 }
+
+func (m *memstore) Replace(domain string, entry *Entry) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, exists := m.store.Get(domain); !exists {
+		return false
+	}
+
+	m.store.SetDefault(domain, entry)
+
+	return true
+}
+
+func (m *memstore) Range(f func(domain string, entry *Entry) bool) {
+	m.mux.RLock()
+	items := m.store.Items()
+	m.mux.RUnlock()
+
+	for domain, item := range items {
+		if !f(domain, item.Object.(*Entry)) {
+			return
+		}
+	}
+}
+
+// Delete removes the entry of domain, the entries retrieved before are not
+// stored anymore
+func (m *memstore) Delete(domain string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.store.Delete(domain)
+	m.invalidated.SetDefault(domain, time.Now())
+}
+
+// Invalidate replaces the resolved entry of domain by a copy which has not
+// been refreshed yet, the entries retrieved before, like the one of a refresh
+// in flight, are not stored anymore. It returns the copy, or nil when no
+// resolved entry of domain is stored.
+func (m *memstore) Invalidate(domain string) *Entry {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.invalidated.SetDefault(domain, time.Now())
+
+	existing, exists := m.store.Get(domain)
+	if !exists || existing.(*Entry).Lookup() == nil {
+		return nil
+	}
+
+	renewed := existing.(*Entry).renewed()
+	m.store.SetDefault(domain, renewed)
+
+	return renewed
+}
+
+// invalidatedAfter returns true if domain has been invalidated after the
+// lookup of entry has been retrieved
+func (m *memstore) invalidatedAfter(domain string, entry *Entry) bool {
+	invalidated, ok := m.invalidated.Get(domain)
+
+	return ok && entry.originalTimestamp().Before(invalidated.(time.Time))
+}
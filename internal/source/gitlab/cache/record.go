@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+// record is the serialized form of a resolved cache entry, shared through
+// snapshots and the Redis store. Only successful lookups and domains that do
+// not exist are recorded, temporary errors are never shared.
+type record struct {
+	Name     string             `json:"name"`
+	Domain   *api.VirtualDomain `json:"domain,omitempty"`
+	NotFound bool               `json:"not_found,omitempty"`
+	ETag     string             `json:"etag,omitempty"`
+	// Created is when the lookup was retrieved from the GitLab API, which is
+	// the original timestamp of entries that kept their lookup on refresh
+	Created time.Time `json:"created"`
+}
+
+// newRecord returns the record of entry, or false when the entry is not
+// resolved yet or has a temporary error
+func newRecord(name string, entry *Entry) (record, bool) {
+	entry.mux.RLock()
+	defer entry.mux.RUnlock()
+
+	if !entry.isResolved() {
+		return record{}, false
+	}
+
+	created := entry.originalTimestamp()
+	lookup := entry.response
+
+	switch {
+	case lookup.Error == nil && lookup.Domain != nil:
+		return record{Name: name, Domain: lookup.Domain, ETag: lookup.ETag, Created: created}, true
+	case errors.Is(lookup.Error, domain.ErrDomainDoesNotExist):
+		return record{Name: name, NotFound: true, Created: created}, true
+	default:
+		return record{}, false
+	}
+}
+
+func (r record) lookup() api.Lookup {
+	if r.NotFound {
+		return api.Lookup{Name: r.Name, Error: domain.ErrDomainDoesNotExist}
+	}
+
+	if r.Domain != nil {
+		r.Domain.Compile()
+	}
+
+	return api.Lookup{Name: r.Name, Domain: r.Domain, ETag: r.ETag}
+}
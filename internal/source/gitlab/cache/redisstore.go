@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// redisTimeout is the maximum time to wait for Redis, when it is slower the
+// store behaves like the in-memory store. Only the requests of the domains not
+// cached locally wait for Redis.
+const redisTimeout = time.Second
+
+// redisKeyContext separates the key encrypting the Redis records from any
+// other key derived from the API secret
+const redisKeyContext = "gitlab-pages domains cache redis"
+
+var errRedisRecordTooShort = errors.New("redis record is too short")
+
+// redisStore is a Store shared by all Pages replicas through Redis. Entries
+// are kept in a local in-memory store in front of Redis, which is only queried
+// when an entry does not exist locally or needs a refresh. Successful lookups
+// and domains that do not exist are written to Redis once resolved, temporary
+// errors are only cached locally. The records are encrypted with a key derived
+// from the API secret, as they contain the private keys of the domains
+// certificates.
+type redisStore struct {
+	local                  *memstore
+	client                 redis.UniversalClient
+	aead                   cipher.AEAD
+	keyPrefix              string
+	entryRefreshTimeout    time.Duration
+	entryExpirationTimeout time.Duration
+	staleIfError           time.Duration
+}
+
+func newRedisStore(cc *config.Cache, client redis.UniversalClient, secret []byte) (*redisStore, error) {
+	aead, err := newAEAD(secret, redisKeyContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisStore{
+		local:                  newMemStore(cc),
+		client:                 client,
+		aead:                   aead,
+		keyPrefix:              cc.RedisKeyPrefix,
+		entryRefreshTimeout:    cc.EntryRefreshTimeout,
+		entryExpirationTimeout: cc.CacheExpiry,
+		staleIfError:           cc.StaleIfError,
+	}, nil
+}
+
+// LoadOrCreate returns the local entry of domain. When it does not exist, an
+// entry resolved by another replica is loaded from Redis instead. When it
+// needs a refresh, it is served straight away while a fresher entry resolved
+// by another replica is loaded from Redis in the background, once per entry,
+// so that a slow Redis does not delay the requests.
+func (s *redisStore) LoadOrCreate(domain string) *Entry {
+	entry, exists := s.local.load(domain)
+	if exists {
+		if entry.NeedsRefresh() {
+			entry.loadShared.Do(func() {
+				go s.loadFresher(domain)
+			})
+		}
+
+		return entry
+	}
+
+	// the shared entry is not stored when it has been retrieved before the
+	// domain got invalidated
+	if shared := s.get(domain); shared != nil {
+		if stored := s.local.add(domain, shared); stored != nil {
+			return stored
+		}
+	}
+
+	entry, created := s.local.loadOrCreate(domain)
+	if created {
+		go s.setWhenRetrieved(domain, entry)
+	}
+
+	return entry
+}
+
+// loadFresher replaces the local entry of domain by the one shared through
+// Redis when it is up to date
+func (s *redisStore) loadFresher(domain string) {
+	if shared := s.get(domain); shared != nil && shared.IsUpToDate() {
+		s.local.ReplaceOrCreate(domain, shared)
+	}
+}
+
+// ReplaceOrCreate replaces the local entry of domain, unless it has been
+// retrieved after entry, and shares it through Redis
+func (s *redisStore) ReplaceOrCreate(domain string, entry *Entry) *Entry {
+	stored := s.local.ReplaceOrCreate(domain, entry)
+	if stored == entry {
+		s.set(domain, entry)
+	}
+
+	return stored
+}
+
+// Replace replaces the local entry of domain when it exists and shares it
+// through Redis
+func (s *redisStore) Replace(domain string, entry *Entry) bool {
+	if !s.local.Replace(domain, entry) {
+		return false
+	}
+
+	s.set(domain, entry)
+
+	return true
+}
+
+// Delete removes the entry of domain locally and from Redis, so that other
+// replicas do not load it anymore. Their local entries are kept until they
+// need a refresh.
+func (s *redisStore) Delete(domain string) {
+	s.local.Delete(domain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.key(domain)).Err(); err != nil {
+		metrics.DomainsSourceCacheRedisRequests.WithLabelValues("del", "error").Inc()
+		log.WithError(err).WithField("domain", domain).Warn("failed to delete domain from redis")
+		return
+	}
+
+	metrics.DomainsSourceCacheRedisRequests.WithLabelValues("del", "ok").Inc()
+}
+
+// Invalidate replaces the local entry of domain by a copy to refresh, which
+// gets shared through Redis once refreshed
+func (s *redisStore) Invalidate(domain string) *Entry {
+	return s.local.Invalidate(domain)
+}
+
+// Range calls f for every entry of the local store
+func (s *redisStore) Range(f func(domain string, entry *Entry) bool) {
+	s.local.Range(f)
+}
+
+// Close closes the connections to Redis
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *redisStore) setWhenRetrieved(domain string, entry *Entry) {
+	timer := time.NewTimer(s.entryExpirationTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-entry.retrieved:
+		s.set(domain, entry)
+	case <-timer.C:
+		// the entry has never been retrieved and expired in the meantime
+	}
+}
+
+func (s *redisStore) get(domain string) *Entry {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.key(domain)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		metrics.DomainsSourceCacheRedisRequests.WithLabelValues("get", "miss").Inc()
+		return nil
+	}
+
+	if err != nil {
+		metrics.DomainsSourceCacheRedisRequests.WithLabelValues("get", "error").Inc()
+		log.WithError(err).WithField("domain", domain).Warn("failed to get domain from redis")
+		return nil
+	}
+
+	var r record
+	if err := s.decode(domain, data, &r); err != nil {
+		metrics.DomainsSourceCacheRedisRequests.WithLabelValues("get", "error").Inc()
+		log.WithError(err).WithField("domain", domain).Warn("failed to decode domain from redis")
+		return nil
+	}
+
+	metrics.DomainsSourceCacheRedisRequests.WithLabelValues("get", "hit").Inc()
+
+	// expired entries within the stale-if-error window are still loaded, they
+	// are only served when refreshing them fails
+	entry := newResolvedEntry(r.lookup(), r.Created, s.entryRefreshTimeout, s.entryExpirationTimeout)
+	entry.adopted = true
+
+	if entry.isExpired() && !entry.isStale(s.staleIfError) {
+		return nil
+	}
+
+	return entry
+}
+
+func (s *redisStore) set(domain string, entry *Entry) {
+	// the lookups which have not been retrieved by this replica are already
+	// shared, or outdated
+	if entry.adopted {
+		return
+	}
+
+	r, ok := newRecord(domain, entry)
+	if !ok {
+		return
+	}
+
+	// the entry expires in Redis at the same time it expires locally
+	ttl := s.entryExpirationTimeout + s.staleIfError - time.Since(r.Created)
+	if ttl <= 0 {
+		return
+	}
+
+	data, err := s.encode(domain, r)
+	if err != nil {
+		metrics.DomainsSourceCacheRedisRequests.WithLabelValues("set", "error").Inc()
+		log.WithError(err).WithField("domain", domain).Warn("failed to encode domain for redis")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, s.key(domain), data, ttl).Err(); err != nil {
+		metrics.DomainsSourceCacheRedisRequests.WithLabelValues("set", "error").Inc()
+		log.WithError(err).WithField("domain", domain).Warn("failed to set domain in redis")
+		return
+	}
+
+	metrics.DomainsSourceCacheRedisRequests.WithLabelValues("set", "ok").Inc()
+}
+
+// encode encrypts the record r of domain, the domain being authenticated so
+// that the record of a domain cannot be served for another one
+func (s *redisStore) encode(domain string, r record) ([]byte, error) {
+	plaintext, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, plaintext, []byte(domain)), nil
+}
+
+// decode decrypts the record of domain from data into r
+func (s *redisStore) decode(domain string, data []byte, r *record) error {
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return errRedisRecordTooShort
+	}
+
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(domain))
+	if err != nil {
+		return fmt.Errorf("decrypting record: %w", err)
+	}
+
+	return json.Unmarshal(plaintext, r)
+}
+
+func (s *redisStore) key(domain string) string {
+	return s.keyPrefix + domain
+}
@@ -0,0 +1,315 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+type lookupClient struct {
+	lookup api.Lookup
+	calls  chan string
+}
+
+func (c *lookupClient) GetLookup(_ context.Context, name string) api.Lookup {
+	c.calls <- name
+
+	lookup := c.lookup
+	lookup.Name = name
+
+	return lookup
+}
+
+func (c *lookupClient) Status() error {
+	return nil
+}
+
+func newTestRedisCache(t *testing.T, mr *miniredis.Miniredis, lookup api.Lookup) (*Cache, *lookupClient) {
+	t.Helper()
+
+	cc := testhelpers.CacheConfig
+	cc.CacheExpiry = time.Minute
+	cc.EntryRefreshTimeout = time.Minute
+	cc.MaxRetrievalRetries = 1
+	cc.RedisKeyPrefix = "pages:"
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	resolver := &lookupClient{lookup: lookup, calls: make(chan string, 10)}
+
+	store, err := newRedisStore(&cc, client, testSecret)
+	require.NoError(t, err)
+
+	return newCache(resolver, &cc, store), resolver
+}
+
+func TestRedisStoreSharesLookups(t *testing.T) {
+	tests := map[string]struct {
+		lookup       api.Lookup
+		expectShared bool
+	}{
+		"successful lookup": {
+			lookup:       api.Lookup{Domain: &api.VirtualDomain{Certificate: "cert", Key: "private key"}},
+			expectShared: true,
+		},
+		"domain does not exist": {
+			lookup:       api.Lookup{Error: domain.ErrDomainDoesNotExist},
+			expectShared: true,
+		},
+		"temporary error": {
+			lookup: api.Lookup{Error: errors.New("500 error")},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// the domain is compiled when it is parsed from the API response
+			if tc.lookup.Domain != nil {
+				tc.lookup.Domain.Compile()
+			}
+
+			mr := miniredis.RunT(t)
+
+			replica1, client1 := newTestRedisCache(t, mr, tc.lookup)
+			replica2, client2 := newTestRedisCache(t, mr, api.Lookup{Error: errors.New("must not be called")})
+
+			lookup := replica1.Resolve(context.Background(), "my.gitlab.io")
+			require.Equal(t, tc.lookup.Error, lookup.Error)
+			require.Equal(t, "my.gitlab.io", <-client1.calls)
+
+			if !tc.expectShared {
+				// the write happens asynchronously once the entry is retrieved
+				time.Sleep(50 * time.Millisecond)
+				require.False(t, mr.Exists("pages:my.gitlab.io"))
+				return
+			}
+
+			require.Eventually(t, func() bool {
+				return mr.Exists("pages:my.gitlab.io")
+			}, time.Second, 10*time.Millisecond)
+			require.Greater(t, mr.TTL("pages:my.gitlab.io"), time.Duration(0))
+
+			// the records are encrypted
+			data, err := mr.Get("pages:my.gitlab.io")
+			require.NoError(t, err)
+			require.NotContains(t, data, "my.gitlab.io")
+			require.NotContains(t, data, "private key")
+
+			lookup = replica2.Resolve(context.Background(), "my.gitlab.io")
+			require.ErrorIs(t, lookup.Error, tc.lookup.Error)
+			require.Equal(t, tc.lookup.Domain, lookup.Domain)
+			require.Empty(t, client2.calls)
+		})
+	}
+}
+
+func TestRedisStoreLoadsFresherEntry(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	cc := &config.Cache{
+		CacheExpiry:          time.Minute,
+		CacheCleanupInterval: time.Minute,
+		EntryRefreshTimeout:  time.Second,
+		RedisKeyPrefix:       "pages:",
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	replica1, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+	replica2, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+
+	outdated := newResolvedEntry(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{Key: "old"}},
+		time.Now().Add(-2*time.Second), cc.EntryRefreshTimeout, cc.CacheExpiry)
+	replica1.local.ReplaceOrCreate("my.gitlab.io", outdated)
+
+	// another replica refreshed the entry
+	refreshed := newResolvedEntry(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{Key: "new"}},
+		time.Now(), cc.EntryRefreshTimeout, cc.CacheExpiry)
+	replica2.ReplaceOrCreate("my.gitlab.io", refreshed)
+
+	// the outdated entry is served until the fresher one is loaded
+	require.Same(t, outdated, replica1.LoadOrCreate("my.gitlab.io"))
+
+	require.Eventually(t, func() bool {
+		entry := replica1.LoadOrCreate("my.gitlab.io")
+		return entry.IsUpToDate() && entry.Lookup().Domain.Key == "new"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRedisStoreDoesNotWaitForRedisToRefresh(t *testing.T) {
+	// Redis accepts the connections, but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conns = append(conns, conn)
+		}
+	}()
+
+	cc := &config.Cache{
+		CacheExpiry:          time.Minute,
+		CacheCleanupInterval: time.Minute,
+		EntryRefreshTimeout:  time.Second,
+		RedisKeyPrefix:       "pages:",
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: l.Addr().String()})
+	t.Cleanup(func() { client.Close() })
+
+	store, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+
+	outdated := newResolvedEntry(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{}},
+		time.Now().Add(-2*time.Second), cc.EntryRefreshTimeout, cc.CacheExpiry)
+	store.local.ReplaceOrCreate("my.gitlab.io", outdated)
+
+	start := time.Now()
+	require.Same(t, outdated, store.LoadOrCreate("my.gitlab.io"))
+	require.Same(t, outdated, store.LoadOrCreate("my.gitlab.io"))
+	require.Less(t, time.Since(start), redisTimeout/2)
+}
+
+func TestRedisStoreReplace(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	cc := &config.Cache{
+		CacheExpiry:          time.Minute,
+		CacheCleanupInterval: time.Minute,
+		EntryRefreshTimeout:  time.Minute,
+		RedisKeyPrefix:       "pages:",
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+
+	entry := newResolvedEntry(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{}}, time.Now(), cc.EntryRefreshTimeout, cc.CacheExpiry)
+
+	// an entry which is not cached is not replaced
+	require.False(t, store.Replace("my.gitlab.io", entry))
+	require.False(t, mr.Exists("pages:my.gitlab.io"))
+
+	store.local.LoadOrCreate("my.gitlab.io")
+
+	require.True(t, store.Replace("my.gitlab.io", entry))
+	require.Same(t, entry, store.LoadOrCreate("my.gitlab.io"))
+	require.True(t, mr.Exists("pages:my.gitlab.io"))
+}
+
+func TestRedisStoreUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	cache, client := newTestRedisCache(t, mr, api.Lookup{Domain: &api.VirtualDomain{}})
+	mr.Close()
+
+	lookup := cache.Resolve(context.Background(), "my.gitlab.io")
+	require.NoError(t, lookup.Error)
+	require.Equal(t, "my.gitlab.io", <-client.calls)
+
+	// the entry is still cached locally
+	lookup = cache.Resolve(context.Background(), "my.gitlab.io")
+	require.NoError(t, lookup.Error)
+	require.Empty(t, client.calls)
+}
+
+func TestRedisStoreDoesNotShareAdoptedEntries(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	cc := &config.Cache{
+		CacheExpiry:          time.Minute,
+		CacheCleanupInterval: time.Minute,
+		EntryRefreshTimeout:  time.Second,
+		RedisKeyPrefix:       "pages:",
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+
+	lookup := api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{}}
+
+	store.ReplaceOrCreate("my.gitlab.io", newRestoredEntry(lookup, cc.EntryRefreshTimeout, cc.CacheExpiry))
+	require.False(t, mr.Exists("pages:my.gitlab.io"), "restored entries are not shared")
+
+	store.ReplaceOrCreate("my.gitlab.io", newResolvedEntry(lookup, time.Now(), cc.EntryRefreshTimeout, cc.CacheExpiry))
+	require.True(t, mr.Exists("pages:my.gitlab.io"))
+
+	mr.FlushAll()
+
+	// the entry loaded from Redis is not written back once stored locally
+	other, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+
+	store.ReplaceOrCreate("other.gitlab.io", newResolvedEntry(api.Lookup{Name: "other.gitlab.io", Domain: &api.VirtualDomain{}}, time.Now().Add(-2*time.Second), cc.EntryRefreshTimeout, cc.CacheExpiry))
+	mr.SetTTL("pages:other.gitlab.io", time.Hour)
+
+	entry := other.LoadOrCreate("other.gitlab.io")
+	require.True(t, entry.adopted)
+
+	other.ReplaceOrCreate("other.gitlab.io", entry)
+	require.Equal(t, time.Hour, mr.TTL("pages:other.gitlab.io"))
+}
+
+func TestRedisStoreRecordOfAnotherSecret(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	cc := &config.Cache{
+		CacheExpiry:          time.Minute,
+		CacheCleanupInterval: time.Minute,
+		EntryRefreshTimeout:  time.Minute,
+		RedisKeyPrefix:       "pages:",
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store, err := newRedisStore(cc, client, testSecret)
+	require.NoError(t, err)
+
+	other, err := newRedisStore(cc, client, []byte("fedcba9876543210fedcba9876543210"))
+	require.NoError(t, err)
+
+	store.ReplaceOrCreate("my.gitlab.io", newResolvedEntry(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{}}, time.Now(), cc.EntryRefreshTimeout, cc.CacheExpiry))
+	require.True(t, mr.Exists("pages:my.gitlab.io"))
+
+	require.Nil(t, other.get("my.gitlab.io"))
+
+	// a record cannot be served for another domain
+	data, err := mr.Get("pages:my.gitlab.io")
+	require.NoError(t, err)
+	require.NoError(t, mr.Set("pages:other.gitlab.io", data))
+	require.Nil(t, store.get("other.gitlab.io"))
+}
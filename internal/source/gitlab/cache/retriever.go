@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"time"
 
 	"gitlab.com/gitlab-org/labkit/correlation"
@@ -15,7 +16,9 @@ import (
 )
 
 // Retriever is an utility type that performs an HTTP request with backoff in
-// case of errors
+// case of errors. The backoff starts at maxRetrievalInterval and doubles with
+// every retry, with a random jitter so that the retries of distinct domains
+// do not hit the GitLab API at the same time.
 type Retriever struct {
 	client               api.Client
 	retrievalTimeout     time.Duration
@@ -35,7 +38,14 @@ func NewRetriever(client api.Client, retrievalTimeout, maxRetrievalInterval time
 
 // Retrieve retrieves a lookup response from external source with timeout and
 // backoff. It has its own context with timeout.
-func (r *Retriever) Retrieve(correlationID, domain string) (lookup api.Lookup) {
+func (r *Retriever) Retrieve(correlationID, domain string) api.Lookup {
+	return r.Revalidate(correlationID, domain, nil)
+}
+
+// Revalidate retrieves a lookup response like Retrieve, but lets the client
+// keep previous when it has not been modified, if the client implements
+// api.Revalidator.
+func (r *Retriever) Revalidate(correlationID, domain string, previous *api.Lookup) (lookup api.Lookup) {
 	var logMsg string
 
 	ctx := correlation.ContextWithCorrelation(context.Background(), correlationID)
@@ -47,7 +57,7 @@ func (r *Retriever) Retrieve(correlationID, domain string) (lookup api.Lookup) {
 	case <-ctx.Done():
 		logMsg = "retrieval context done"
 		lookup = api.Lookup{Name: domain, Error: fmt.Errorf(logMsg+": %w", ctx.Err())}
-	case lookup = <-r.resolveWithBackoff(ctx, domain):
+	case lookup = <-r.resolveWithBackoff(ctx, domain, previous):
 		logMsg = "retrieval response sent"
 	}
 
@@ -62,14 +72,14 @@ func (r *Retriever) Retrieve(correlationID, domain string) (lookup api.Lookup) {
 	return lookup
 }
 
-func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName string) <-chan api.Lookup {
+func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName string, previous *api.Lookup) <-chan api.Lookup {
 	response := make(chan api.Lookup)
 
 	go func() {
 		var lookup api.Lookup
 
 		for i := 1; i <= r.maxRetrievalRetries; i++ {
-			lookup = r.client.GetLookup(ctx, domainName)
+			lookup = r.getLookup(ctx, domainName, previous)
 			if lookup.Error == nil || errors.Is(lookup.Error, domain.ErrDomainDoesNotExist) ||
 				errors.Is(lookup.Error, client.ErrUnauthorizedAPI) {
 				// do not retry if the domain does not exist or there is an auth error
@@ -81,7 +91,14 @@ func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName string) <
 				break
 			}
 
-			time.Sleep(r.maxRetrievalInterval)
+			if errors.Is(lookup.Error, client.ErrCircuitOpen) {
+				// fail fast while the GitLab API is degraded
+				break
+			}
+
+			if i == r.maxRetrievalRetries || !r.sleep(ctx, i) {
+				break
+			}
 		}
 
 		response <- lookup
@@ -90,3 +107,42 @@ func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName string) <
 
 	return response
 }
+
+func (r *Retriever) getLookup(ctx context.Context, domainName string, previous *api.Lookup) api.Lookup {
+	if revalidator, ok := r.client.(api.Revalidator); ok && previous != nil {
+		return revalidator.RevalidateLookup(ctx, domainName, previous)
+	}
+
+	return r.client.GetLookup(ctx, domainName)
+}
+
+// sleep waits for the backoff of the retry-th retry, it returns false if ctx
+// is done in the meantime
+func (r *Retriever) sleep(ctx context.Context, retry int) bool {
+	timer := time.NewTimer(backoff(r.maxRetrievalInterval, retry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// backoff returns a random duration between half and all of interval
+// doubled retry-1 times
+func backoff(interval time.Duration, retry int) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	// avoid overflowing the duration, the retrieval timeout is shorter anyway
+	if retry > 16 {
+		retry = 16
+	}
+
+	d := interval << (retry - 1)
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
+)
+
+type openBreakerClient struct {
+	calls int64
+}
+
+func (c *openBreakerClient) GetLookup(_ context.Context, host string) api.Lookup {
+	atomic.AddInt64(&c.calls, 1)
+
+	return api.Lookup{Name: host, Error: client.ErrCircuitOpen}
+}
+
+func (c *openBreakerClient) Status() error {
+	return nil
+}
+
+func TestRetrieveWithOpenCircuitBreaker(t *testing.T) {
+	c := &openBreakerClient{}
+	r := NewRetriever(c, time.Second, time.Minute, 3)
+
+	lookup := r.Retrieve("", "group.gitlab.io")
+
+	require.ErrorIs(t, lookup.Error, client.ErrCircuitOpen)
+	require.Equal(t, int64(1), atomic.LoadInt64(&c.calls))
+}
+
+func TestBackoff(t *testing.T) {
+	require.Zero(t, backoff(0, 1))
+
+	interval := 100 * time.Millisecond
+
+	for retry, max := range map[int]time.Duration{
+		1: interval,
+		2: 2 * interval,
+		3: 4 * interval,
+	} {
+		for i := 0; i < 100; i++ {
+			d := backoff(interval, retry)
+
+			require.GreaterOrEqual(t, d, max/2)
+			require.LessOrEqual(t, d, max)
+		}
+	}
+
+	require.Positive(t, backoff(time.Second, 1000), "the backoff does not overflow")
+}
+
+type revalidatorClient struct {
+	previous *api.Lookup
+}
+
+func (c *revalidatorClient) GetLookup(_ context.Context, host string) api.Lookup {
+	return api.Lookup{Name: host, Domain: &api.VirtualDomain{}, ETag: `"v2"`}
+}
+
+func (c *revalidatorClient) RevalidateLookup(_ context.Context, _ string, previous *api.Lookup) api.Lookup {
+	c.previous = previous
+
+	return *previous
+}
+
+func TestRevalidate(t *testing.T) {
+	c := &revalidatorClient{}
+	r := NewRetriever(c, time.Second, time.Millisecond, 3)
+
+	lookup := r.Retrieve("", "group.gitlab.io")
+	require.Equal(t, `"v2"`, lookup.ETag)
+	require.Nil(t, c.previous)
+
+	previous := &api.Lookup{Name: "group.gitlab.io", Domain: &api.VirtualDomain{}, ETag: `"v1"`}
+
+	lookup = r.Revalidate("", "group.gitlab.io", previous)
+	require.Same(t, previous, c.previous)
+	require.Same(t, previous.Domain, lookup.Domain)
+	require.Equal(t, `"v1"`, lookup.ETag)
+}
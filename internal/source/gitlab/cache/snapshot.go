@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion is increased whenever the format of the snapshot changes in
+// a way that older snapshots cannot be read anymore
+const snapshotVersion = 1
+
+// snapshotKeyContext separates the snapshot encryption key from any other key
+// derived from the API secret
+const snapshotKeyContext = "gitlab-pages domains cache snapshot"
+
+var (
+	errSnapshotTooShort = errors.New("snapshot is too short")
+	errSnapshotVersion  = errors.New("snapshot version is not supported")
+	errNoSecret         = errors.New("encryption requires the GitLab API secret")
+	errSnapshotTooOld   = errors.New("snapshot is older than its maximum age")
+)
+
+// snapshotContent is the content of a snapshot file before it gets encrypted
+type snapshotContent struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Records []record  `json:"records"`
+}
+
+// Snapshot persists the successful lookups of the cache to a local file, so
+// that they can be served after a restart when the GitLab API is unavailable.
+// The file is encrypted with a key derived from the API secret, as it contains
+// the private keys of the domains certificates.
+type Snapshot struct {
+	path string
+	aead cipher.AEAD
+}
+
+// NewSnapshot returns a snapshot stored in path and encrypted with a key
+// derived from secret
+func NewSnapshot(path string, secret []byte) (*Snapshot, error) {
+	aead, err := newAEAD(secret, snapshotKeyContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{path: path, aead: aead}, nil
+}
+
+// newAEAD returns an AES-GCM cipher whose key is derived from secret for the
+// purpose described by keyContext, so that the same secret never encrypts
+// different kinds of data with the same key
+func newAEAD(secret []byte, keyContext string) (cipher.AEAD, error) {
+	// a key derived from an empty secret would be known by anyone
+	if len(secret) == 0 {
+		return nil, errNoSecret
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(keyContext))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts and writes records to the snapshot file. The file is replaced
+// atomically, so a crash never leaves a partially written snapshot behind.
+func (s *Snapshot) Save(records []record) error {
+	plaintext, err := json.Marshal(snapshotContent{
+		Version: snapshotVersion,
+		Created: time.Now(),
+		Records: records,
+	})
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(s.aead.Seal(nonce, nonce, plaintext, nil)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Load reads and decrypts the records of the snapshot file, and returns when
+// they were saved
+func (s *Snapshot) Load() ([]record, time.Time, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, time.Time{}, errSnapshotTooShort
+	}
+
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("decrypting snapshot: %w", err)
+	}
+
+	var content snapshotContent
+	if err := json.Unmarshal(plaintext, &content); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if content.Version != snapshotVersion {
+		return nil, time.Time{}, fmt.Errorf("%w: %d", errSnapshotVersion, content.Version)
+	}
+
+	return content.Records, content.Created, nil
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSnapshotSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	snapshot, err := NewSnapshot(path, testSecret)
+	require.NoError(t, err)
+
+	records := []record{{
+		Name: "example.com",
+		Domain: &api.VirtualDomain{
+			Certificate: "certificate",
+			Key:         "private-key",
+			LookupPaths: []api.LookupPath{{ProjectID: 123, Prefix: "/"}},
+		},
+	}}
+
+	require.NoError(t, snapshot.Save(records))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "private-key")
+
+	loaded, created, err := snapshot.Load()
+	require.NoError(t, err)
+	require.Equal(t, records, loaded)
+	require.WithinDuration(t, time.Now(), created, time.Minute)
+
+	t.Run("with a different secret", func(t *testing.T) {
+		other, err := NewSnapshot(path, []byte("fedcba9876543210fedcba9876543210"))
+		require.NoError(t, err)
+
+		_, _, err = other.Load()
+		require.Error(t, err)
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("short"), 0600))
+
+		_, _, err = snapshot.Load()
+		require.ErrorIs(t, err, errSnapshotTooShort)
+	})
+}
+
+func TestNewSnapshotWithoutSecret(t *testing.T) {
+	_, err := NewSnapshot(filepath.Join(t.TempDir(), "cache.snapshot"), nil)
+	require.ErrorIs(t, err, errNoSecret)
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	snapshot, err := NewSnapshot(filepath.Join(t.TempDir(), "cache.snapshot"), testSecret)
+	require.NoError(t, err)
+
+	withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+		cache.withTestEntry(entryConfig{domain: "error.gitlab.io"}, func(entry *Entry) {
+			entry.setResponse(api.Lookup{Name: "error.gitlab.io", Error: errors.New("500 error")})
+		})
+		cache.withTestEntry(entryConfig{domain: "not-found.gitlab.io"}, func(entry *Entry) {
+			entry.setResponse(api.Lookup{Name: "not-found.gitlab.io", Error: domain.ErrDomainDoesNotExist})
+		})
+		cache.withTestEntry(entryConfig{domain: "pending.gitlab.io"}, func(*Entry) {})
+		cache.withTestEntry(entryConfig{domain: "my.gitlab.io"}, func(entry *Entry) {
+			entry.setResponse(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{Key: "key"}})
+		})
+
+		count, err := cache.SaveSnapshot(snapshot)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	cc := testhelpers.CacheConfig
+	cc.CacheExpiry = time.Minute
+	cc.MaxRetrievalRetries = 1
+
+	withTestCache(resolverConfig{failure: errors.New("500 error")}, &cc, func(cache *Cache, resolver *clientMock) {
+		count, err := cache.RestoreSnapshot(snapshot, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+
+		entry := cache.store.LoadOrCreate("my.gitlab.io")
+		require.True(t, entry.NeedsRefresh())
+		require.False(t, entry.isExpired())
+
+		// the restored lookup is served while the API fails to refresh it
+		lookup := cache.Resolve(context.Background(), "my.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.Equal(t, "key", lookup.Domain.Key)
+
+		<-resolver.lookups
+
+		require.Eventually(t, func() bool {
+			return cache.store.LoadOrCreate("my.gitlab.io") != entry
+		}, time.Second, 10*time.Millisecond)
+
+		lookup = cache.Resolve(context.Background(), "my.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.Equal(t, "key", lookup.Domain.Key)
+	})
+}
+
+func TestCacheRestoreSnapshotTooOld(t *testing.T) {
+	snapshot, err := NewSnapshot(filepath.Join(t.TempDir(), "cache.snapshot"), testSecret)
+	require.NoError(t, err)
+
+	require.NoError(t, snapshot.Save([]record{{Name: "my.gitlab.io", Domain: &api.VirtualDomain{}}}))
+
+	withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+		_, err := cache.RestoreSnapshot(snapshot, time.Nanosecond)
+		require.ErrorIs(t, err, errSnapshotTooOld)
+
+		cache.store.Range(func(domain string, _ *Entry) bool {
+			require.Failf(t, "unexpected restored entry", domain)
+			return true
+		})
+
+		// a max age of 0 restores snapshots of any age
+		count, err := cache.RestoreSnapshot(snapshot, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+}
+
+func TestCachePersistSnapshotOnShutdown(t *testing.T) {
+	snapshot, err := NewSnapshot(filepath.Join(t.TempDir(), "cache.snapshot"), testSecret)
+	require.NoError(t, err)
+
+	withTestCache(resolverConfig{}, nil, func(cache *Cache, resolver *clientMock) {
+		cache.withTestEntry(entryConfig{domain: "my.gitlab.io"}, func(entry *Entry) {
+			entry.setResponse(api.Lookup{Name: "my.gitlab.io", Domain: &api.VirtualDomain{Key: "key"}})
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// the snapshot is saved on shutdown even if the interval never elapsed
+		cache.PersistSnapshot(ctx, snapshot, time.Hour)
+
+		records, _, err := snapshot.Load()
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, "my.gitlab.io", records[0].Name)
+	})
+}
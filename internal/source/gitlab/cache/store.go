@@ -3,5 +3,20 @@ package cache
 // Store defines an interface describing an abstract cache store
 type Store interface {
 	LoadOrCreate(domain string) *Entry
+	// ReplaceOrCreate stores entry for domain unless the entry stored or an
+	// invalidation of domain is more recent, and returns the entry stored for
+	// domain, which is nil when there is none
 	ReplaceOrCreate(domain string, entry *Entry) *Entry
+	// Replace replaces the entry of domain only when it exists, and reports
+	// whether it did
+	Replace(domain string, entry *Entry) bool
+	// Delete removes the entry of domain, the entries retrieved before are
+	// not stored anymore
+	Delete(domain string)
+	// Invalidate replaces the resolved entry of domain by a copy to refresh,
+	// the entries retrieved before are not stored anymore. It returns the
+	// copy, or nil when no resolved entry of domain is stored.
+	Invalidate(domain string) *Entry
+	// Range calls f for every entry of the store until f returns false
+	Range(f func(domain string, entry *Entry) bool)
 }
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// ErrCircuitOpen is returned when resolving a domain while the circuit breaker
+// is open, without calling the GitLab API
+var ErrCircuitOpen = errors.New("GitLab API circuit breaker is open")
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+var breakerStates = []breakerState{stateClosed, stateOpen, stateHalfOpen}
+
+// breaker is a circuit breaker protecting the GitLab API when it is degraded.
+// It opens after ConsecutiveFailures failed calls in a row, or when the ratio
+// of failed calls within Window exceeds FailureRatio. While open, calls fail
+// fast with ErrCircuitOpen. After OpenTimeout it becomes half-open and lets
+// HalfOpenRequests calls probe the API: it closes again when they succeed and
+// opens again as soon as one of them fails. A nil breaker allows every call.
+type breaker struct {
+	cfg config.CircuitBreaker
+	now func() time.Time
+
+	mux                 sync.Mutex
+	state               breakerState
+	generation          uint64
+	openedAt            time.Time
+	consecutiveFailures int
+	windowStart         time.Time
+	requests            int
+	failures            int
+	probes              int
+	probeSuccesses      int
+}
+
+func newBreaker(cfg config.CircuitBreaker) *breaker {
+	if !cfg.Enabled || (cfg.ConsecutiveFailures <= 0 && cfg.FailureRatio <= 0) {
+		return nil
+	}
+
+	b := &breaker{cfg: cfg, now: time.Now}
+	b.windowStart = b.now()
+	b.setStateMetric()
+
+	return b
+}
+
+// allow returns whether a call can be made, and the generation it has to be
+// reported with to done
+func (b *breaker) allow() (uint64, bool) {
+	if b == nil {
+		return 0, true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.state == stateOpen {
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return 0, false
+		}
+
+		b.setState(stateHalfOpen)
+	}
+
+	if b.state == stateHalfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return 0, false
+		}
+
+		b.probes++
+	}
+
+	return b.generation, true
+}
+
+// done reports the result of a call allowed in generation. Results of calls
+// allowed before the last change of state are ignored.
+func (b *breaker) done(generation uint64, failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case stateHalfOpen:
+		if failed {
+			b.setState(stateOpen)
+			return
+		}
+
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.cfg.HalfOpenRequests {
+			b.setState(stateClosed)
+		}
+	case stateClosed:
+		b.record(failed)
+
+		if b.tripped() {
+			b.setState(stateOpen)
+		}
+	}
+}
+
+// canceled reports a call allowed in generation which got canceled before the
+// API answered. It is neither a success nor a failure, it only frees its
+// probe slot while half-open.
+func (b *breaker) canceled(generation uint64) {
+	if b == nil {
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if generation == b.generation && b.state == stateHalfOpen {
+		b.probes--
+	}
+}
+
+func (b *breaker) record(failed bool) {
+	now := b.now()
+	if now.Sub(b.windowStart) > b.cfg.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+
+	b.requests++
+
+	if !failed {
+		b.consecutiveFailures = 0
+		return
+	}
+
+	b.failures++
+	b.consecutiveFailures++
+}
+
+func (b *breaker) tripped() bool {
+	if b.cfg.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+
+	return b.cfg.FailureRatio > 0 && b.requests >= b.cfg.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio
+}
+
+func (b *breaker) setState(state breakerState) {
+	log.WithFields(log.Fields{
+		"from": b.state.String(),
+		"to":   state.String(),
+	}).Warn("GitLab API circuit breaker changed state")
+
+	b.state = state
+	b.generation++
+	b.probes = 0
+	b.probeSuccesses = 0
+	b.consecutiveFailures = 0
+	b.windowStart = b.now()
+	b.requests = 0
+	b.failures = 0
+
+	if state == stateOpen {
+		b.openedAt = b.now()
+	}
+
+	b.setStateMetric()
+}
+
+func (b *breaker) setStateMetric() {
+	for _, s := range breakerStates {
+		value := 0.0
+		if s == b.state {
+			value = 1
+		}
+
+		metrics.DomainsSourceAPICircuitBreakerState.WithLabelValues(s.String()).Set(value)
+	}
+}
+
+// isFailure returns whether err shows the GitLab API is degraded. Domains
+// that do not exist, authentication errors and requests canceled by the
+// client do not count as failures. The canceled requests are reported to
+// canceled rather than done.
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, errNotModified) || errors.Is(err, ErrUnauthorizedAPI) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+
+	return true
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func testBreaker(cfg config.CircuitBreaker) (*breaker, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+
+	cfg.Enabled = true
+	b := newBreaker(cfg)
+	b.now = clock.Now
+	b.windowStart = clock.now
+
+	return b, clock
+}
+
+func call(b *breaker, failed bool) bool {
+	generation, ok := b.allow()
+	if ok {
+		b.done(generation, failed)
+	}
+
+	return ok
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	tests := map[string]config.CircuitBreaker{
+		"when it is not enabled": {ConsecutiveFailures: 3, FailureRatio: 0.5, Window: time.Second, OpenTimeout: time.Minute, HalfOpenRequests: 1},
+		"without conditions":     {Enabled: true, OpenTimeout: time.Minute, HalfOpenRequests: 1},
+	}
+
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := newBreaker(cfg)
+			require.Nil(t, b)
+
+			for i := 0; i < 100; i++ {
+				require.True(t, call(b, true))
+			}
+		})
+	}
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	b, clock := testBreaker(config.CircuitBreaker{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Minute,
+		HalfOpenRequests:    1,
+	})
+
+	require.True(t, call(b, true))
+	require.True(t, call(b, true))
+	require.True(t, call(b, false), "a success resets the consecutive failures")
+	require.True(t, call(b, true))
+	require.True(t, call(b, true))
+	require.Equal(t, stateClosed, b.state)
+
+	require.True(t, call(b, true))
+	require.Equal(t, stateOpen, b.state)
+	require.False(t, call(b, false), "calls fail fast while open")
+
+	clock.now = clock.now.Add(time.Minute)
+
+	generation, ok := b.allow()
+	require.True(t, ok)
+	require.Equal(t, stateHalfOpen, b.state)
+
+	_, ok = b.allow()
+	require.False(t, ok, "only HalfOpenRequests calls probe the API")
+
+	b.done(generation, false)
+	require.Equal(t, stateClosed, b.state)
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	b, clock := testBreaker(config.CircuitBreaker{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+		HalfOpenRequests:    2,
+	})
+
+	require.True(t, call(b, true))
+	require.Equal(t, stateOpen, b.state)
+
+	clock.now = clock.now.Add(time.Minute)
+
+	require.True(t, call(b, false))
+	require.Equal(t, stateHalfOpen, b.state)
+
+	require.True(t, call(b, true))
+	require.Equal(t, stateOpen, b.state)
+	require.False(t, call(b, false))
+}
+
+func TestBreakerHalfOpenCanceledProbe(t *testing.T) {
+	b, clock := testBreaker(config.CircuitBreaker{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+		HalfOpenRequests:    1,
+	})
+
+	require.True(t, call(b, true))
+	require.Equal(t, stateOpen, b.state)
+
+	clock.now = clock.now.Add(time.Minute)
+
+	generation, ok := b.allow()
+	require.True(t, ok)
+
+	b.canceled(generation)
+	require.Equal(t, stateHalfOpen, b.state, "a canceled probe does not close the breaker")
+
+	require.True(t, call(b, false), "a canceled probe frees its slot")
+	require.Equal(t, stateClosed, b.state)
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b, clock := testBreaker(config.CircuitBreaker{
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		Window:           10 * time.Second,
+		OpenTimeout:      time.Minute,
+		HalfOpenRequests: 1,
+	})
+
+	require.True(t, call(b, true))
+	require.True(t, call(b, false))
+	require.True(t, call(b, true))
+	require.Equal(t, stateClosed, b.state, "not enough requests")
+
+	// the window is over, so the failures above are not counted anymore
+	clock.now = clock.now.Add(11 * time.Second)
+
+	require.True(t, call(b, true))
+	require.True(t, call(b, false))
+	require.True(t, call(b, false))
+	require.Equal(t, stateClosed, b.state)
+
+	require.True(t, call(b, true))
+	require.Equal(t, stateOpen, b.state)
+}
+
+func TestBreakerIgnoresResultsOfPreviousState(t *testing.T) {
+	b, _ := testBreaker(config.CircuitBreaker{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+		HalfOpenRequests:    1,
+	})
+
+	generation, ok := b.allow()
+	require.True(t, ok)
+
+	require.True(t, call(b, true))
+	require.Equal(t, stateOpen, b.state)
+
+	b.done(generation, false)
+	require.Equal(t, stateOpen, b.state)
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"no error":            {err: nil},
+		"unauthorized":        {err: ErrUnauthorizedAPI},
+		"canceled":            {err: fmt.Errorf("request: %w", context.Canceled)},
+		"not found":           {err: &statusError{code: http.StatusNotFound}},
+		"internal error":      {err: &statusError{code: http.StatusInternalServerError}, expected: true},
+		"service unavailable": {err: &statusError{code: http.StatusServiceUnavailable}, expected: true},
+		"deadline exceeded":   {err: context.DeadlineExceeded, expected: true},
+		"connection error":    {err: errors.New("connection refused"), expected: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, isFailure(tc.err))
+		})
+	}
+}
+
+func TestLookupWithOpenCircuitBreaker(t *testing.T) {
+	var requests int64
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewFromConfig(&config.GitLab{
+		InternalServer:     server.URL,
+		APISecretKey:       secretKey(t),
+		ClientHTTPTimeout:  defaultClientConnTimeout,
+		JWTTokenExpiration: defaultJWTTokenExpiry,
+		CircuitBreaker: config.CircuitBreaker{
+			Enabled:             true,
+			ConsecutiveFailures: 2,
+			OpenTimeout:         time.Minute,
+			HalfOpenRequests:    1,
+		},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+		require.EqualError(t, lookup.Error, "HTTP status: 502")
+	}
+
+	lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+	require.ErrorIs(t, lookup.Error, ErrCircuitOpen)
+	require.Equal(t, "group.gitlab.io", lookup.Name)
+	require.Equal(t, int64(2), atomic.LoadInt64(&requests))
+}
@@ -2,12 +2,17 @@ package client
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -34,12 +39,35 @@ const transportClientName = "gitlab_internal_api"
 // See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/535 for more details.
 var ErrUnauthorizedAPI = errors.New("pages endpoint unauthorized")
 
+// errNotModified is returned when the lookup revalidated with its ETag has
+// not been modified
+var errNotModified = errors.New("not modified")
+
+// statusError is returned when the GitLab API responds with an unexpected
+// HTTP status
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP status: %d", e.code)
+}
+
 // Client is a HTTP client to access Pages internal API
 type Client struct {
 	secretKey      []byte
 	baseURL        *url.URL
 	httpClient     *http.Client
 	jwtTokenExpiry time.Duration
+	breaker        *breaker
+	// keys signs the requests instead of secretKey when they are loaded
+	// from a keys directory
+	keys *keyDir
+	// stopWatchingKeys stops reloading keys
+	stopWatchingKeys context.CancelFunc
+	// streamClient has no timeout, for the long-lived responses streaming
+	// domain events
+	streamClient *http.Client
 }
 
 // NewClient initializes and returns new Client baseUrl is
@@ -49,6 +77,21 @@ func NewClient(baseURL string, secretKey []byte, connectionTimeout, jwtTokenExpi
 		return nil, errors.New("GitLab API URL or API secret has not been provided")
 	}
 
+	client, err := newClient(baseURL, httptransport.DefaultTransport, connectionTimeout, jwtTokenExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	client.secretKey = secretKey
+
+	return client, nil
+}
+
+func newClient(baseURL string, transport http.RoundTripper, connectionTimeout, jwtTokenExpiry time.Duration) (*Client, error) {
+	if len(baseURL) == 0 {
+		return nil, errors.New("GitLab API URL has not been provided")
+	}
+
 	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
@@ -62,30 +105,75 @@ func NewClient(baseURL string, secretKey []byte, connectionTimeout, jwtTokenExpi
 		return nil, errors.New("GitLab JWT token expiry has not been provided")
 	}
 
+	transport = httptransport.NewMeteredRoundTripper(
+		correlation.NewInstrumentedRoundTripper(
+			transport,
+			correlation.WithClientName(transportClientName),
+		),
+		transportClientName,
+		metrics.DomainsSourceAPITraceDuration,
+		metrics.DomainsSourceAPICallDuration,
+		metrics.DomainsSourceAPIReqTotal,
+		httptransport.DefaultTTFBTimeout,
+	)
+
 	return &Client{
-		secretKey: secretKey,
-		baseURL:   parsedURL,
+		baseURL: parsedURL,
 		httpClient: &http.Client{
-			Timeout: connectionTimeout,
-			Transport: httptransport.NewMeteredRoundTripper(
-				correlation.NewInstrumentedRoundTripper(
-					httptransport.DefaultTransport,
-					correlation.WithClientName(transportClientName),
-				),
-				transportClientName,
-				metrics.DomainsSourceAPITraceDuration,
-				metrics.DomainsSourceAPICallDuration,
-				metrics.DomainsSourceAPIReqTotal,
-				httptransport.DefaultTTFBTimeout,
-			),
+			Timeout:   connectionTimeout,
+			Transport: transport,
 		},
+		streamClient:   &http.Client{Transport: transport},
 		jwtTokenExpiry: jwtTokenExpiry,
 	}, nil
 }
 
-// NewFromConfig creates a new client from Config struct
+// NewFromConfig creates a new client from Config struct. The requests are
+// signed with the keys of cfg.APISecretKeysDir when it is defined, which gets
+// reloaded every cfg.APISecretKeysReloadInterval.
 func NewFromConfig(cfg *config.GitLab) (*Client, error) {
-	return NewClient(cfg.InternalServer, cfg.APISecretKey, cfg.ClientHTTPTimeout, cfg.JWTTokenExpiration)
+	var keys *keyDir
+	var err error
+
+	if cfg.APISecretKeysDir != "" {
+		if keys, err = newKeyDir(cfg.APISecretKeysDir); err != nil {
+			return nil, fmt.Errorf("loading GitLab API keys: %w", err)
+		}
+	} else if len(cfg.APISecretKey) == 0 {
+		return nil, errors.New("GitLab API secret has not been provided")
+	}
+
+	transport, err := httptransport.ClientTransport(cfg.ClientTLS)
+	if err != nil {
+		return nil, fmt.Errorf("loading GitLab API client TLS files: %w", err)
+	}
+
+	client, err := newClient(cfg.InternalServer, transport, cfg.ClientHTTPTimeout, cfg.JWTTokenExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	client.secretKey = cfg.APISecretKey
+	client.breaker = newBreaker(cfg.CircuitBreaker)
+
+	if keys != nil {
+		var ctx context.Context
+		ctx, client.stopWatchingKeys = context.WithCancel(context.Background())
+
+		client.keys = keys
+		go keys.watch(ctx, cfg.APISecretKeysReloadInterval)
+	}
+
+	return client, nil
+}
+
+// Close stops reloading the keys directory
+func (gc *Client) Close() error {
+	if gc.stopWatchingKeys != nil {
+		gc.stopWatchingKeys()
+	}
+
+	return nil
 }
 
 // Resolve returns a VirtualDomain configuration wrapped into a Lookup for a
@@ -99,10 +187,46 @@ func (gc *Client) Resolve(ctx context.Context, host string) *api.Lookup {
 // GetLookup returns a VirtualDomain configuration wrapped into a Lookup for a
 // given host
 func (gc *Client) GetLookup(ctx context.Context, host string) api.Lookup {
+	return gc.getLookup(ctx, host, nil)
+}
+
+// RevalidateLookup sends the ETag of previous to the GitLab API, and returns
+// previous without downloading and parsing the VirtualDomain again when it
+// has not been modified. It implements api.Revalidator.
+func (gc *Client) RevalidateLookup(ctx context.Context, host string, previous *api.Lookup) api.Lookup {
+	if previous == nil || previous.Error != nil || previous.Domain == nil {
+		previous = nil
+	}
+
+	return gc.getLookup(ctx, host, previous)
+}
+
+func (gc *Client) getLookup(ctx context.Context, host string, previous *api.Lookup) api.Lookup {
 	params := url.Values{}
 	params.Set("host", host)
 
-	resp, err := gc.get(ctx, "/api/v4/internal/pages", params)
+	var etag string
+	if previous != nil {
+		etag = previous.ETag
+	}
+
+	generation, ok := gc.breaker.allow()
+	if !ok {
+		metrics.DomainsSourceAPICircuitBreakerRejected.Inc()
+		return api.Lookup{Name: host, Error: ErrCircuitOpen}
+	}
+
+	resp, err := gc.get(ctx, "/api/v4/internal/pages", params, etag)
+	if errors.Is(err, context.Canceled) {
+		gc.breaker.canceled(generation)
+	} else {
+		gc.breaker.done(generation, isFailure(err))
+	}
+
+	if errors.Is(err, errNotModified) {
+		return api.Lookup{Name: host, Domain: previous.Domain, ETag: previous.ETag}
+	}
+
 	if err != nil {
 		metrics.DomainsSourceFailures.Inc()
 		return api.Lookup{Name: host, Error: err}
@@ -126,13 +250,71 @@ func (gc *Client) GetLookup(ctx context.Context, host string) api.Lookup {
 		resp.Body.Close()
 	}()
 
-	lookup := api.Lookup{Name: host}
-	lookup.ParseDomain(resp.Body)
+	lookup := api.Lookup{Name: host, ETag: resp.Header.Get("ETag")}
+	if lookup.ETag != "" {
+		lookup.ParseDomain(resp.Body)
+		return lookup
+	}
+
+	// without an ETag from the API, the lookup is identified by a hash of
+	// its content instead
+	hash := sha256.New()
+	body := io.TeeReader(resp.Body, hash)
+
+	lookup.ParseDomain(body)
+	io.Copy(io.Discard, body)
+
+	lookup.ETag = fmt.Sprintf("%q", hex.EncodeToString(hash.Sum(nil)))
 
 	return lookup
 }
 
-func (gc *Client) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+// ListLookups returns a page of the virtual domains, from the most active one,
+// wrapped into Lookups. It implements api.Lister.
+func (gc *Client) ListLookups(ctx context.Context, page, perPage int) ([]api.Lookup, int, error) {
+	params := url.Values{}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("per_page", strconv.Itoa(perPage))
+	params.Set("order_by", "activity")
+
+	resp, err := gc.get(ctx, "/api/v4/internal/pages/domains", params, "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp == nil {
+		return nil, 0, nil
+	}
+
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	var domains []api.ListedVirtualDomain
+	if err := json.NewDecoder(resp.Body).Decode(&domains); err != nil {
+		return nil, 0, fmt.Errorf("decoding domains: %w", err)
+	}
+
+	lookups := make([]api.Lookup, 0, len(domains))
+	for i := range domains {
+		virtualDomain := &domains[i].Domain
+		virtualDomain.Compile()
+
+		lookups = append(lookups, api.Lookup{Name: strings.ToLower(domains[i].Host), Domain: virtualDomain})
+	}
+
+	nextPage := 0
+	if next := resp.Header.Get("X-Next-Page"); next != "" {
+		if nextPage, err = strconv.Atoi(next); err != nil {
+			return nil, 0, fmt.Errorf("parsing next page: %w", err)
+		}
+	}
+
+	return lookups, nextPage, nil
+}
+
+func (gc *Client) get(ctx context.Context, path string, params url.Values, etag string) (*http.Response, error) {
 	endpoint, err := gc.endpoint(path, params)
 	if err != nil {
 		return nil, err
@@ -142,6 +324,11 @@ func (gc *Client) get(ctx context.Context, path string, params url.Values) (*htt
 	if err != nil {
 		return nil, err
 	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
 	resp, err := gc.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -161,13 +348,22 @@ func (gc *Client) get(ctx context.Context, path string, params url.Values) (*htt
 	resp.Body.Close()
 
 	// StatusNoContent means that a domain does not exist, it is not an error
-	if resp.StatusCode == http.StatusNoContent {
+	switch resp.StatusCode {
+	case http.StatusNoContent:
 		return nil, nil
-	} else if resp.StatusCode == http.StatusUnauthorized {
+	case http.StatusNotModified:
+		// a response which has not been revalidated cannot be not modified,
+		// e.g. when a proxy answers with a cached response
+		if etag == "" {
+			return nil, &statusError{code: resp.StatusCode}
+		}
+
+		return nil, errNotModified
+	case http.StatusUnauthorized:
 		return nil, ErrUnauthorizedAPI
 	}
 
-	return nil, fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	return nil, &statusError{code: resp.StatusCode}
 }
 
 func (gc *Client) endpoint(urlPath string, params url.Values) (*url.URL, error) {
@@ -210,10 +406,14 @@ func (gc *Client) token() (string, error) {
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(gc.jwtTokenExpiry)),
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(gc.secretKey)
-	if err != nil {
-		return "", err
+	if gc.keys == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(gc.secretKey)
 	}
 
-	return token, nil
+	key := gc.keys.signingKey()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+
+	return token.SignedString(key.key)
 }
@@ -16,6 +16,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
 const (
@@ -338,3 +339,94 @@ func Test_endpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestRevalidateLookup(t *testing.T) {
+	tests := map[string]struct {
+		etag string
+	}{
+		"with an ETag from the API": {etag: `"v1"`},
+		"with a content hash":       {},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var modified, notModified int
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+				if match := r.Header.Get("If-None-Match"); match != "" && (match == tc.etag || tc.etag == "") {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				modified++
+
+				if tc.etag != "" {
+					w.Header().Set("ETag", tc.etag)
+				}
+
+				http.ServeFile(w, r, "testdata/test.gitlab.io.json")
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			client := defaultClient(t, server.URL)
+
+			lookup := client.GetLookup(context.Background(), "test.gitlab.io")
+			require.NoError(t, lookup.Error)
+			require.NotNil(t, lookup.Domain)
+			require.NotEmpty(t, lookup.ETag)
+
+			if tc.etag != "" {
+				require.Equal(t, tc.etag, lookup.ETag)
+			}
+
+			revalidated := client.RevalidateLookup(context.Background(), "test.gitlab.io", &lookup)
+			require.NoError(t, revalidated.Error)
+			require.Same(t, lookup.Domain, revalidated.Domain)
+			require.Equal(t, lookup.ETag, revalidated.ETag)
+
+			require.Equal(t, 1, modified)
+			require.Equal(t, 1, notModified)
+		})
+	}
+}
+
+func TestRevalidateLookupWithoutPreviousDomain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("If-None-Match"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	previous := &api.Lookup{Name: "test.gitlab.io", Error: domain.ErrDomainDoesNotExist, ETag: `"v1"`}
+
+	lookup := client.RevalidateLookup(context.Background(), "test.gitlab.io", previous)
+	require.ErrorIs(t, lookup.Error, domain.ErrDomainDoesNotExist)
+}
+
+func TestLookupNotModifiedWithoutPreviousLookup(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("If-None-Match"))
+		w.WriteHeader(http.StatusNotModified)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	lookup := client.GetLookup(context.Background(), "test.gitlab.io")
+	require.EqualError(t, lookup.Error, "HTTP status: 304")
+
+	lookup = client.RevalidateLookup(context.Background(), "test.gitlab.io", nil)
+	require.EqualError(t, lookup.Error, "HTTP status: 304")
+}
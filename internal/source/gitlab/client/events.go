@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+const (
+	domainUpdatedEvent = "domain_updated"
+	domainDeletedEvent = "domain_deleted"
+)
+
+var (
+	// ErrSubscriptionUnsupported is returned when subscribing to an instance
+	// of the GitLab API that does not stream domain events
+	ErrSubscriptionUnsupported = errors.New("domain events subscription unsupported")
+	// ErrCursorExpired is returned when the events following the cursor of a
+	// subscription are not available anymore
+	ErrCursorExpired = errors.New("domain events cursor expired")
+	// ErrStreamIdle is returned when the events stream of a subscription did
+	// not receive anything, not even a keep-alive, for streamIdleTimeout
+	ErrStreamIdle = errors.New("domain events stream idle")
+)
+
+// streamIdleTimeout is the maximum time without receiving anything from the
+// events stream, after which the connection is assumed to be lost
+var streamIdleTimeout = 2 * time.Minute
+
+// serverSentEvent is an event of a text/event-stream response
+type serverSentEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// domainEventData is the data of a domain event, its domain is only defined
+// for domainUpdatedEvent
+type domainEventData struct {
+	Host   string          `json:"host"`
+	ETag   string          `json:"etag"`
+	Domain json.RawMessage `json:"domain"`
+}
+
+// Subscribe streams the domain events following cursor from the GitLab API as
+// server-sent events, the cursor being sent as their Last-Event-ID. The
+// subscription ends with ErrStreamIdle when the stream stays idle for
+// streamIdleTimeout, for the subscriber to subscribe again. It implements
+// api.Subscriber.
+func (gc *Client) Subscribe(ctx context.Context, cursor string, handle func(api.DomainEvent)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the connection is closed by canceling ctx when the stream is idle
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	endpoint, err := gc.endpoint("/api/v4/internal/pages/events", url.Values{})
+	if err != nil {
+		return err
+	}
+
+	req, err := gc.request(ctx, "GET", endpoint)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	if cursor != "" {
+		req.Header.Set("Last-Event-ID", cursor)
+	}
+
+	resp, err := gc.streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrSubscriptionUnsupported
+	case http.StatusGone:
+		return ErrCursorExpired
+	case http.StatusUnauthorized:
+		return ErrUnauthorizedAPI
+	default:
+		return &statusError{code: resp.StatusCode}
+	}
+
+	body := &idleReader{r: resp.Body, idle: idle}
+
+	err = readServerSentEvents(body, func(e serverSentEvent) {
+		event, ok, err := parseDomainEvent(e)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"event":  e.event,
+				"cursor": e.id,
+			}).Warn("skipping invalid domain event")
+		}
+
+		if ok {
+			handle(event)
+		}
+	})
+
+	if !idle.Stop() && ctx.Err() != nil {
+		return ErrStreamIdle
+	}
+
+	return err
+}
+
+// idleReader resets the idle timer whenever it reads from r
+type idleReader struct {
+	r    io.Reader
+	idle *time.Timer
+}
+
+func (i *idleReader) Read(p []byte) (int, error) {
+	n, err := i.r.Read(p)
+	if n > 0 {
+		i.idle.Reset(streamIdleTimeout)
+	}
+
+	return n, err
+}
+
+// parseDomainEvent returns the domain event of e, and false when e is not a
+// domain event
+func parseDomainEvent(e serverSentEvent) (api.DomainEvent, bool, error) {
+	if e.event != domainUpdatedEvent && e.event != domainDeletedEvent {
+		return api.DomainEvent{}, false, nil
+	}
+
+	var data domainEventData
+	if err := json.Unmarshal([]byte(e.data), &data); err != nil {
+		return api.DomainEvent{}, false, fmt.Errorf("decoding domain event: %w", err)
+	}
+
+	if data.Host == "" {
+		return api.DomainEvent{}, false, errors.New("domain event without host")
+	}
+
+	lookup := api.Lookup{Name: strings.ToLower(data.Host)}
+
+	if e.event == domainDeletedEvent {
+		lookup.Error = domain.ErrDomainDoesNotExist
+
+		return api.DomainEvent{Cursor: e.id, Lookup: lookup}, true, nil
+	}
+
+	lookup.ParseDomain(bytes.NewReader(data.Domain))
+	if lookup.Error != nil {
+		return api.DomainEvent{}, false, fmt.Errorf("decoding domain event: %w", lookup.Error)
+	}
+
+	// like lookups, a domain without an ETag is identified by a hash of its
+	// content instead
+	lookup.ETag = data.ETag
+	if lookup.ETag == "" {
+		hash := sha256.Sum256(data.Domain)
+		lookup.ETag = fmt.Sprintf("%q", hex.EncodeToString(hash[:]))
+	}
+
+	return api.DomainEvent{Cursor: e.id, Lookup: lookup}, true, nil
+}
+
+// readServerSentEvents passes the events of the text/event-stream r to
+// dispatch until r ends
+func readServerSentEvents(r io.Reader, dispatch func(serverSentEvent)) error {
+	reader := bufio.NewReader(r)
+
+	var event serverSentEvent
+	var data []string
+
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		// an empty line dispatches the event
+		if line == "" {
+			if len(data) > 0 {
+				event.data = strings.Join(data, "\n")
+				dispatch(event)
+			}
+
+			event, data = serverSentEvent{}, nil
+			continue
+		}
+
+		// lines starting with a colon are comments, used as keep-alives
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/test/gitlabstub"
+)
+
+func TestReadServerSentEvents(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"id: 1\nevent: domain_updated\ndata: {\"host\":\ndata: \"a\"}\n\n" +
+		"event: ping\r\n\r\n" +
+		"id: 2\r\nevent: domain_deleted\r\ndata:{\"host\":\"b\"}\r\nunknown: field\r\n\r\n" +
+		"id: 3\nevent: domain_deleted\ndata: {\"host\":\"incomplete\"}\n"
+
+	var events []serverSentEvent
+
+	err := readServerSentEvents(strings.NewReader(stream), func(e serverSentEvent) {
+		events = append(events, e)
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []serverSentEvent{
+		{id: "1", event: "domain_updated", data: "{\"host\":\n\"a\"}"},
+		{id: "2", event: "domain_deleted", data: "{\"host\":\"b\"}"},
+	}, events)
+}
+
+func TestParseDomainEvent(t *testing.T) {
+	tests := map[string]struct {
+		event          serverSentEvent
+		expectedOK     bool
+		expectedError  bool
+		expectedLookup api.Lookup
+	}{
+		"updated domain": {
+			event: serverSentEvent{
+				id:    "1",
+				event: domainUpdatedEvent,
+				data:  `{"host":"Group.GitLab.io","etag":"\"v1\"","domain":{"lookup_paths":[{"project_id":1,"prefix":"/"}]}}`,
+			},
+			expectedOK: true,
+			expectedLookup: api.Lookup{
+				Name:   "group.gitlab.io",
+				ETag:   `"v1"`,
+				Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{{ProjectID: 1, Prefix: "/"}}},
+			},
+		},
+		"deleted domain": {
+			event:          serverSentEvent{id: "2", event: domainDeletedEvent, data: `{"host":"group.gitlab.io"}`},
+			expectedOK:     true,
+			expectedLookup: api.Lookup{Name: "group.gitlab.io", Error: domain.ErrDomainDoesNotExist},
+		},
+		"unknown event": {
+			event: serverSentEvent{id: "3", event: "project_updated", data: `{}`},
+		},
+		"invalid data": {
+			event:         serverSentEvent{id: "4", event: domainUpdatedEvent, data: `{`},
+			expectedError: true,
+		},
+		"invalid domain": {
+			event:         serverSentEvent{id: "5", event: domainUpdatedEvent, data: `{"host":"group.gitlab.io","domain":[]}`},
+			expectedError: true,
+		},
+		"missing host": {
+			event:         serverSentEvent{id: "6", event: domainDeletedEvent, data: `{}`},
+			expectedError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			event, ok, err := parseDomainEvent(tc.event)
+			require.Equal(t, tc.expectedError, err != nil)
+			require.Equal(t, tc.expectedOK, ok)
+
+			if !ok {
+				return
+			}
+
+			require.Equal(t, tc.event.id, event.Cursor)
+			require.Equal(t, tc.expectedLookup.Name, event.Lookup.Name)
+			require.Equal(t, tc.expectedLookup.ETag, event.Lookup.ETag)
+			require.Equal(t, tc.expectedLookup.Error, event.Lookup.Error)
+
+			if tc.expectedLookup.Domain != nil {
+				require.Equal(t, tc.expectedLookup.Domain.LookupPaths, event.Lookup.Domain.LookupPaths)
+			}
+		})
+	}
+}
+
+func TestParseDomainEventWithoutETag(t *testing.T) {
+	event := serverSentEvent{event: domainUpdatedEvent, data: `{"host":"group.gitlab.io","domain":{"lookup_paths":[]}}`}
+
+	first, ok, err := parseDomainEvent(event)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotEmpty(t, first.Lookup.ETag)
+
+	second, _, _ := parseDomainEvent(event)
+	require.Equal(t, first.Lookup.ETag, second.Lookup.ETag)
+
+	event.data = `{"host":"group.gitlab.io","domain":{"certificate":"cert","lookup_paths":[]}}`
+
+	changed, _, _ := parseDomainEvent(event)
+	require.NotEqual(t, first.Lookup.ETag, changed.Lookup.ETag)
+}
+
+// subscribe subscribes client after cursor in the background, and returns
+// the events it receives and the error the subscription ends with
+func subscribe(ctx context.Context, client *Client, cursor string) (<-chan api.DomainEvent, <-chan error) {
+	events := make(chan api.DomainEvent, 10)
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- client.Subscribe(ctx, cursor, func(event api.DomainEvent) {
+			events <- event
+		})
+	}()
+
+	return events, errs
+}
+
+func receiveEvent(t *testing.T, events <-chan api.DomainEvent) api.DomainEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	return api.DomainEvent{}
+}
+
+func TestSubscribe(t *testing.T) {
+	emitter := gitlabstub.NewEventEmitter()
+
+	server, err := gitlabstub.NewUnstartedServer(gitlabstub.WithEventEmitter(emitter))
+	require.NoError(t, err)
+	server.Start()
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, emitter.EmitUpdated("group.gitlab.io", api.VirtualDomain{
+		LookupPaths: []api.LookupPath{{ProjectID: 1, Prefix: "/"}},
+	}))
+	emitter.EmitDeleted("deleted.gitlab.io")
+
+	events, errs := subscribe(ctx, client, "0")
+
+	event := receiveEvent(t, events)
+	require.Equal(t, "1", event.Cursor)
+	require.Equal(t, "group.gitlab.io", event.Lookup.Name)
+	require.NoError(t, event.Lookup.Error)
+	require.Equal(t, 1, event.Lookup.Domain.LookupPaths[0].ProjectID)
+
+	event = receiveEvent(t, events)
+	require.Equal(t, "2", event.Cursor)
+	require.ErrorIs(t, event.Lookup.Error, domain.ErrDomainDoesNotExist)
+
+	// events emitted while subscribed are streamed straight away
+	emitter.EmitDeleted("group.gitlab.io")
+
+	event = receiveEvent(t, events)
+	require.Equal(t, "3", event.Cursor)
+
+	emitter.Disconnect()
+	require.NoError(t, <-errs)
+
+	// the events emitted while disconnected are streamed after the cursor
+	emitter.EmitDeleted("other.gitlab.io")
+
+	events, errs = subscribe(ctx, client, "3")
+
+	event = receiveEvent(t, events)
+	require.Equal(t, "4", event.Cursor)
+	require.Equal(t, "other.gitlab.io", event.Lookup.Name)
+
+	cancel()
+	require.Error(t, <-errs)
+
+	emitter.Expire()
+
+	err = client.Subscribe(context.Background(), "3", func(api.DomainEvent) {})
+	require.ErrorIs(t, err, ErrCursorExpired)
+}
+
+func TestSubscribeUnsupported(t *testing.T) {
+	server, err := gitlabstub.NewUnstartedServer()
+	require.NoError(t, err)
+	server.Start()
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	err = client.Subscribe(context.Background(), "", func(api.DomainEvent) {})
+	require.ErrorIs(t, err, ErrSubscriptionUnsupported)
+}
+
+func TestSubscribeIdle(t *testing.T) {
+	idleTimeout := streamIdleTimeout
+	streamIdleTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { streamIdleTimeout = idleTimeout })
+
+	emitter := gitlabstub.NewEventEmitter()
+
+	server, err := gitlabstub.NewUnstartedServer(gitlabstub.WithEventEmitter(emitter))
+	require.NoError(t, err)
+	server.Start()
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	events, errs := subscribe(context.Background(), client, "")
+
+	// the events received keep the stream alive
+	for i := 0; i < 3; i++ {
+		time.Sleep(streamIdleTimeout / 2)
+		emitter.EmitDeleted("group.gitlab.io")
+		receiveEvent(t, events)
+	}
+
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, ErrStreamIdle)
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle subscription did not end")
+	}
+}
@@ -0,0 +1,304 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// SigningKeyIDFile is the file of a keys directory holding the ID of the key
+// signing the requests to the GitLab API
+const SigningKeyIDFile = "signing-key-id"
+
+const (
+	// secretKeyExt is the extension of the files holding a base64 encoded
+	// HS256 secret, like the api-secret-key file
+	secretKeyExt = ".key"
+	// privateKeyExt is the extension of the files holding a PEM encoded
+	// Ed25519 or RSA private key
+	privateKeyExt = ".pem"
+)
+
+var (
+	errNoSigningKey      = errors.New("no signing key")
+	errUnknownSigningKey = errors.New("unknown signing key")
+)
+
+// signingKey is a key signing JWT tokens, identified by the kid header of the
+// tokens it signs
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// keyDir is a directory of keys signing the requests to the GitLab API. Each
+// key is stored in a file named after its ID, and the ID of the key in use is
+// stored in SigningKeyIDFile, which is optional when the directory holds a
+// single key. The directory gets reloaded when its content changes, so that
+// keys can be rotated without restarting Pages: a new key is added to the
+// directory and trusted by GitLab, then used for signing, and the previous
+// key is removed.
+type keyDir struct {
+	path string
+
+	mux         sync.RWMutex
+	fingerprint string
+	signing     *signingKey
+}
+
+// newKeyDir loads the signing key of the keys directory at path
+func newKeyDir(path string) (*keyDir, error) {
+	d := &keyDir{path: path}
+
+	if _, err := d.reload(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// signingKey returns the key requests get currently signed with
+func (d *keyDir) signingKey() *signingKey {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	return d.signing
+}
+
+// reload loads the signing key again when the content of the directory
+// changed, and returns true when it did. The current key is kept when the
+// directory cannot be loaded.
+func (d *keyDir) reload() (bool, error) {
+	fingerprint, err := dirFingerprint(d.path)
+	if err != nil {
+		return false, err
+	}
+
+	d.mux.RLock()
+	unchanged := fingerprint == d.fingerprint
+	d.mux.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	key, err := loadSigningKey(d.path)
+	if err != nil {
+		return false, err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.fingerprint = fingerprint
+	d.signing = key
+
+	return true, nil
+}
+
+// watch reloads the directory every interval until ctx is done
+func (d *keyDir) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logger := log.WithField("path", d.path)
+
+			reloaded, err := d.reload()
+			if err != nil {
+				logger.WithError(err).Error("failed to reload GitLab API keys, keeping the current signing key")
+				continue
+			}
+
+			if reloaded {
+				logger.WithField("kid", d.signingKey().id).Info("reloaded GitLab API keys")
+			}
+		}
+	}
+}
+
+// dirFingerprint identifies the content of the directory at path by the name,
+// size and modification time of its files. Symlinks are followed, so that
+// directories updated by swapping a symlink, like mounted Kubernetes secrets,
+// are reloaded too.
+func dirFingerprint(path string) (string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("reading keys directory: %w", err)
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("reading keys directory: %w", err)
+		}
+
+		fmt.Fprintf(&b, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+
+	return b.String(), nil
+}
+
+// loadSigningKey loads the keys of the directory at path, and returns the key
+// named by its SigningKeyIDFile
+func loadSigningKey(path string) (*signingKey, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading keys directory: %w", err)
+	}
+
+	keys := make(map[string]*signingKey)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		id := strings.TrimSuffix(name, ext)
+
+		if strings.HasPrefix(name, ".") || id == "" || (ext != secretKeyExt && ext != privateKeyExt) {
+			continue
+		}
+
+		key, err := loadKey(filepath.Join(path, name), id, ext)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key %q", id)
+		}
+
+		keys[id] = key
+	}
+
+	id, err := signingKeyID(path, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnknownSigningKey, id)
+	}
+
+	return key, nil
+}
+
+// signingKeyID returns the ID stored in the SigningKeyIDFile of the directory
+// at path, or the ID of its only key when the file does not exist
+func signingKeyID(path string, keys map[string]*signingKey) (string, error) {
+	content, err := os.ReadFile(filepath.Join(path, SigningKeyIDFile))
+	if err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading signing key ID: %w", err)
+	}
+
+	if len(keys) != 1 {
+		ids := make([]string, 0, len(keys))
+		for id := range keys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		return "", fmt.Errorf("%w: %s must name one of %q", errNoSigningKey, SigningKeyIDFile, ids)
+	}
+
+	for id := range keys {
+		return id, nil
+	}
+
+	return "", nil
+}
+
+func loadKey(path, id, ext string) (*signingKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key %q: %w", id, err)
+	}
+
+	var key *signingKey
+	if ext == secretKeyExt {
+		key, err = parseSecretKey(content)
+	} else {
+		key, err = parsePrivateKey(content)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing key %q: %w", id, err)
+	}
+
+	key.id = id
+
+	return key, nil
+}
+
+func parseSecretKey(content []byte) (*signingKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes secret but got %d bytes", len(decoded))
+	}
+
+	return &signingKey{method: jwt.SigningMethodHS256, key: decoded}, nil
+}
+
+func parsePrivateKey(content []byte) (*signingKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return &signingKey{method: jwt.SigningMethodEdDSA, key: k}, nil
+	case *rsa.PrivateKey:
+		return &signingKey{method: jwt.SigningMethodRS256, key: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+)
+
+func writeKeyFile(t *testing.T, dir, name string, content []byte) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, content, 0600))
+
+	// ensure that rewriting a file changes its modification time even on file
+	// systems with a coarse timestamp granularity
+	modTime := time.Now().Add(time.Duration(len(content)) * time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func ed25519PEM(t *testing.T) ([]byte, crypto.PublicKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), public
+}
+
+func rsaPEM(t *testing.T) ([]byte, crypto.PublicKey) {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	der := x509.MarshalPKCS1PrivateKey(private)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), &private.PublicKey
+}
+
+// parseToken verifies the signature of tokenString with the key named by its
+// kid header
+func parseToken(t *testing.T, tokenString string, keys map[string]interface{}) *jwt.Token {
+	t.Helper()
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return keys[token.Header["kid"].(string)], nil
+	})
+	require.NoError(t, err)
+	require.True(t, token.Valid)
+
+	return token
+}
+
+func TestKeyDirSigningKey(t *testing.T) {
+	edKey, edPublic := ed25519PEM(t)
+	rsaKey, rsaPublic := rsaPEM(t)
+
+	tests := map[string]struct {
+		file            string
+		content         []byte
+		verificationKey interface{}
+		expectedAlg     string
+	}{
+		"Ed25519 key": {
+			file:            "ed.pem",
+			content:         edKey,
+			verificationKey: edPublic,
+			expectedAlg:     "EdDSA",
+		},
+		"RSA key": {
+			file:            "rsa.pem",
+			content:         rsaKey,
+			verificationKey: rsaPublic,
+			expectedAlg:     "RS256",
+		},
+		"secret key": {
+			file:            "secret.key",
+			content:         []byte(fixture.GitLabAPISecretKey + "\n"),
+			verificationKey: secretKey(t),
+			expectedAlg:     "HS256",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeKeyFile(t, dir, tc.file, tc.content)
+
+			keys, err := newKeyDir(dir)
+			require.NoError(t, err)
+
+			client, err := newClient("https://gitlab.example.com", http.DefaultTransport, defaultClientConnTimeout, defaultJWTTokenExpiry)
+			require.NoError(t, err)
+			client.keys = keys
+
+			tokenString, err := client.token()
+			require.NoError(t, err)
+
+			id := strings.TrimSuffix(tc.file, filepath.Ext(tc.file))
+			token := parseToken(t, tokenString, map[string]interface{}{id: tc.verificationKey})
+			require.Equal(t, tc.expectedAlg, token.Header["alg"])
+			require.Equal(t, id, token.Header["kid"])
+		})
+	}
+}
+
+func TestKeyDirErrors(t *testing.T) {
+	edKey, _ := ed25519PEM(t)
+
+	tests := map[string]struct {
+		files         map[string][]byte
+		expectedError error
+	}{
+		"no key": {
+			files:         map[string][]byte{"README": []byte("keys")},
+			expectedError: errNoSigningKey,
+		},
+		"many keys without signing key ID": {
+			files:         map[string][]byte{"v1.pem": edKey, "v2.key": []byte(fixture.GitLabAPISecretKey)},
+			expectedError: errNoSigningKey,
+		},
+		"unknown signing key ID": {
+			files:         map[string][]byte{"v1.pem": edKey, SigningKeyIDFile: []byte("v2\n")},
+			expectedError: errUnknownSigningKey,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, content := range tc.files {
+				writeKeyFile(t, dir, file, content)
+			}
+
+			_, err := newKeyDir(dir)
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for file, content := range map[string]string{
+			"short.key":   base64.StdEncoding.EncodeToString([]byte("short")),
+			"invalid.key": "not base64",
+			"invalid.pem": "not PEM",
+			"public.pem":  "-----BEGIN PUBLIC KEY-----\nMCowBQYDK2VwAyEA\n-----END PUBLIC KEY-----\n",
+		} {
+			dir := t.TempDir()
+			writeKeyFile(t, dir, file, []byte(content))
+
+			_, err := newKeyDir(dir)
+			require.Error(t, err, file)
+		}
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := newKeyDir(filepath.Join(t.TempDir(), "missing"))
+		require.Error(t, err)
+	})
+}
+
+func TestKeyDirRotation(t *testing.T) {
+	oldKey, oldPublic := ed25519PEM(t)
+	newKey, newPublic := rsaPEM(t)
+
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "old.pem", oldKey)
+
+	keys, err := newKeyDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, "old", keys.signingKey().id)
+
+	reloaded, err := keys.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// add the new key, which is not used until it is the signing key
+	writeKeyFile(t, dir, "new.pem", newKey)
+	writeKeyFile(t, dir, SigningKeyIDFile, []byte("old"))
+
+	reloaded, err = keys.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, "old", keys.signingKey().id)
+
+	// switch the signing key
+	writeKeyFile(t, dir, SigningKeyIDFile, []byte("new\n"))
+
+	reloaded, err = keys.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, "new", keys.signingKey().id)
+
+	// remove the old key
+	require.NoError(t, os.Remove(filepath.Join(dir, "old.pem")))
+
+	reloaded, err = keys.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, "new", keys.signingKey().id)
+
+	// a broken directory keeps the current signing key
+	writeKeyFile(t, dir, SigningKeyIDFile, []byte("old"))
+
+	_, err = keys.reload()
+	require.ErrorIs(t, err, errUnknownSigningKey)
+	require.Equal(t, "new", keys.signingKey().id)
+
+	client, err := newClient("https://gitlab.example.com", http.DefaultTransport, defaultClientConnTimeout, defaultJWTTokenExpiry)
+	require.NoError(t, err)
+	client.keys = keys
+
+	tokenString, err := client.token()
+	require.NoError(t, err)
+
+	token := parseToken(t, tokenString, map[string]interface{}{"old": oldPublic, "new": newPublic})
+	require.Equal(t, "new", token.Header["kid"])
+}
+
+func TestNewFromConfigWithKeysDir(t *testing.T) {
+	edKey, edPublic := ed25519PEM(t)
+
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "v1.pem", edKey)
+
+	tokens := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("Gitlab-Pages-Api-Request")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := NewFromConfig(&config.GitLab{
+		InternalServer:              server.URL,
+		APISecretKeysDir:            dir,
+		APISecretKeysReloadInterval: time.Hour,
+		ClientHTTPTimeout:           defaultClientConnTimeout,
+		JWTTokenExpiration:          defaultJWTTokenExpiry,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	client.GetLookup(context.Background(), "group.gitlab.io")
+
+	token := parseToken(t, <-tokens, map[string]interface{}{"v1": edPublic})
+	require.Equal(t, "v1", token.Header["kid"])
+	require.Equal(t, "gitlab-pages", token.Claims.(jwt.MapClaims)["iss"])
+}
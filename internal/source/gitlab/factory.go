@@ -10,6 +10,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/tar"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
@@ -47,6 +48,8 @@ func (g *Gitlab) fabricateServing(lookup api.LookupPath) (serving.Serving, error
 		return local.Instance(), nil
 	case "zip":
 		return zip.Instance(), nil
+	case "tar":
+		return tar.Instance(), nil
 	}
 
 	return nil, fmt.Errorf("gitlab: unknown serving source type: %q", source.Type)
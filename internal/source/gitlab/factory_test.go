@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/tar"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
@@ -57,4 +58,29 @@ func TestFabricateServing(t *testing.T) {
 		require.ErrorIs(t, err, ErrDiskDisabled)
 		require.Nil(t, srv)
 	})
+
+	t.Run("when lookup path requires tar serving", func(t *testing.T) {
+		g := Gitlab{}
+
+		lookup := api.LookupPath{
+			Prefix: "/",
+			Source: api.Source{Type: "tar", Path: "https://objects.example.com/public.tar.gz"},
+		}
+		srv, err := g.fabricateServing(lookup)
+		require.NoError(t, err)
+		require.Same(t, tar.Instance(), srv)
+	})
+	t.Run("when lookup path requires tar serving from disk but disk is disabled", func(t *testing.T) {
+		g := Gitlab{
+			enableDisk: false,
+		}
+
+		lookup := api.LookupPath{
+			Prefix: "/",
+			Source: api.Source{Type: "tar", Path: "file:///pages/group/project/public.tar.gz"},
+		}
+		srv, err := g.fabricateServing(lookup)
+		require.ErrorIs(t, err, ErrDiskDisabled)
+		require.Nil(t, srv)
+	})
 }
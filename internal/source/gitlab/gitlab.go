@@ -4,47 +4,215 @@ import (
 	"context"
 	"errors"
 	"net/http"
-	"path"
+	"os"
 	"strings"
+	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/cache"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
 
+const (
+	domainsCacheMaxSize    = 10000
+	domainsCacheExpiration = 10 * time.Minute
+
+	// the wildcard domains which do not exist are cached for a short time,
+	// as creating one is only picked up once it expires
+	wildcardsCacheMaxSize    = 10000
+	wildcardsCacheExpiration = time.Minute
+)
+
 // Gitlab source represent a new domains configuration source. We fetch all the
 // information about domains from GitLab instance.
 type Gitlab struct {
 	client     api.Resolver
 	enableDisk bool
+	// pagesDomain is the domain under which the hosts never fall back to a
+	// wildcard domain, "" when it is unknown
+	pagesDomain string
+	// cache is the cache of the lookups retrieved by apiClient, they are
+	// both nil when the lookups are resolved by another source
+	cache     *cache.Cache
+	apiClient *client.Client
+	// domains caches the domains built from lookups by their ETag, so that
+	// their certificate is only parsed again when the lookup changes
+	domains *lru.Cache
+	// missingWildcards caches whether the wildcard domains do not exist, so
+	// that the hosts which are not domains do not look them up every time
+	missingWildcards *lru.Cache
+	// warmed is closed once the cache warm-up finishes, it is nil when the
+	// cache does not get warmed up
+	warmed chan struct{}
+	// ctx is canceled by Close to stop the background tasks of the cache,
+	// persisted being closed once the snapshot has been saved a last time
+	ctx       context.Context
+	cancel    context.CancelFunc
+	persisted chan struct{}
 }
 
-// New returns a new instance of gitlab domain source.
-func New(cfg *config.GitLab) (*Gitlab, error) {
+// New returns a new instance of gitlab domain source. The hosts under
+// pagesDomain are not resolved from wildcard domains.
+func New(cfg *config.GitLab, pagesDomain string) (*Gitlab, error) {
 	glClient, err := client.NewFromConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	g := &Gitlab{
-		client:     cache.NewCache(glClient, &cfg.Cache),
-		enableDisk: cfg.EnableDisk,
+	c, err := cache.NewFromConfig(glClient, &cfg.Cache, cfg.APISecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	g := NewFromResolver(c, cfg.EnableDisk)
+	g.pagesDomain = strings.ToLower(pagesDomain)
+	g.cache = c
+	g.apiClient = glClient
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+
+	if cfg.Cache.SnapshotFile != "" {
+		if err := g.restoreSnapshot(cfg); err != nil {
+			g.cancel()
+			return nil, err
+		}
+	}
+
+	if cfg.Cache.WarmUp {
+		g.warmed = make(chan struct{})
+		go g.warmUp(c, glClient, &cfg.Cache)
+	}
+
+	if cfg.Cache.Subscribe {
+		go c.Subscribe(g.ctx, glClient, cfg.Cache.ResubscribeInterval)
 	}
 
 	return g, nil
 }
 
+// warmUp populates the cache with the domains listed by the GitLab API
+func (g *Gitlab) warmUp(c *cache.Cache, lister api.Lister, cc *config.Cache) {
+	defer close(g.warmed)
+
+	ctx, cancel := context.WithTimeout(g.ctx, cc.WarmUpTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	count, err := c.WarmUp(ctx, lister, cc.WarmUpLimit)
+
+	logger := log.WithFields(log.Fields{
+		"domains_count": count,
+		"duration_s":    time.Since(start).Seconds(),
+	})
+
+	if err != nil {
+		logger.WithError(err).Warn("failed to warm up domains cache")
+		return
+	}
+
+	logger.Info("warmed up domains cache")
+}
+
+// Warming returns true until the cache warm-up finishes. It implements
+// healthcheck.Warmer.
+func (g *Gitlab) Warming() bool {
+	if g.warmed == nil {
+		return false
+	}
+
+	select {
+	case <-g.warmed:
+		return false
+	default:
+		return true
+	}
+}
+
+// restoreSnapshot restores the domains cache from its snapshot and starts
+// persisting it until g is closed. A snapshot that cannot be restored does not
+// prevent Pages from starting, as it only delays serving until the GitLab API
+// is reachable.
+func (g *Gitlab) restoreSnapshot(cfg *config.GitLab) error {
+	snapshot, err := cache.NewSnapshot(cfg.Cache.SnapshotFile, cfg.APISecretKey)
+	if err != nil {
+		return err
+	}
+
+	logger := log.WithField("path", cfg.Cache.SnapshotFile)
+
+	count, err := g.cache.RestoreSnapshot(snapshot, cfg.Cache.SnapshotMaxAge)
+	switch {
+	case os.IsNotExist(err):
+		logger.Info("domains cache snapshot does not exist yet")
+	case err != nil:
+		logger.WithError(err).Warn("failed to restore domains cache snapshot")
+	default:
+		logger.WithField("domains_count", count).Info("restored domains cache snapshot")
+	}
+
+	g.persisted = make(chan struct{})
+
+	go func() {
+		defer close(g.persisted)
+
+		g.cache.PersistSnapshot(g.ctx, snapshot, cfg.Cache.SnapshotInterval)
+	}()
+
+	return nil
+}
+
+// Close stops the background tasks of the domains cache, saving its snapshot
+// a last time, stops reloading the GitLab API keys and releases the resources
+// of the domains cache
+func (g *Gitlab) Close() error {
+	if g.cache == nil {
+		return nil
+	}
+
+	g.cancel()
+
+	if g.persisted != nil {
+		<-g.persisted
+	}
+
+	g.apiClient.Close()
+
+	return g.cache.Close()
+}
+
+// NewFromResolver returns a new instance of gitlab domain source that fetches
+// the lookups from resolver instead of the GitLab API. It allows other
+// domains configuration sources to share how lookups get served.
+func NewFromResolver(resolver api.Resolver, enableDisk bool) *Gitlab {
+	return &Gitlab{
+		client:     resolver,
+		enableDisk: enableDisk,
+		domains: lru.New(
+			"domains",
+			lru.WithMaxSize(domainsCacheMaxSize),
+			lru.WithExpirationInterval(domainsCacheExpiration),
+		),
+		missingWildcards: lru.New(
+			"missing-wildcards",
+			lru.WithMaxSize(wildcardsCacheMaxSize),
+			lru.WithExpirationInterval(wildcardsCacheExpiration),
+		),
+	}
+}
+
 // GetDomain return a representation of a domain that we have fetched from
 // GitLab
 func (g *Gitlab) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
-	lookup := g.client.Resolve(ctx, name)
+	lookup := g.resolve(ctx, name)
 
 	if lookup.Error != nil {
 		if errors.Is(lookup.Error, client.ErrUnauthorizedAPI) {
@@ -54,11 +222,108 @@ func (g *Gitlab) GetDomain(ctx context.Context, name string) (*domain.Domain, er
 		return nil, lookup.Error
 	}
 
-	// TODO introduce a second-level cache for domains, invalidate using etags
-	// from first-level cache
+	if lookup.ETag == "" || lookup.Stale || g.domains == nil {
+		return g.newDomain(name, lookup), nil
+	}
+
+	d, err := g.domains.FindOrFetch(name, lookup.ETag, func() (interface{}, error) {
+		return g.newDomain(name, lookup), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.(*domain.Domain), nil
+}
+
+// resolve returns the lookup of host, falling back to the lookup of the
+// wildcard domain covering host when host is not a domain on its own. The
+// hosts under the pages domain never fall back to a wildcard domain, and the
+// wildcard domains which do not exist are not looked up again until
+// missingWildcards expires them.
+func (g *Gitlab) resolve(ctx context.Context, host string) *api.Lookup {
+	lookup := g.client.Resolve(ctx, host)
+	if !errors.Is(lookup.Error, domain.ErrDomainDoesNotExist) || g.underPagesDomain(host) {
+		return lookup
+	}
+
+	wildcard, ok := wildcardHost(host)
+	if !ok {
+		return lookup
+	}
+
+	var wildcardLookup *api.Lookup
+
+	missing, err := g.missingWildcards.FindOrFetch(wildcard, wildcard, func() (interface{}, error) {
+		wildcardLookup = g.client.Resolve(ctx, wildcard)
+
+		return errors.Is(wildcardLookup.Error, domain.ErrDomainDoesNotExist), nil
+	})
+	if err != nil || missing.(bool) {
+		return lookup
+	}
+
+	// the wildcard domain existed when it was last looked up
+	if wildcardLookup == nil {
+		wildcardLookup = g.client.Resolve(ctx, wildcard)
+	}
+
+	if errors.Is(wildcardLookup.Error, domain.ErrDomainDoesNotExist) {
+		return lookup
+	}
+
+	return wildcardLookup
+}
+
+// underPagesDomain reports whether host is the pages domain or one of its
+// subdomains
+func (g *Gitlab) underPagesDomain(host string) bool {
+	if g.pagesDomain == "" {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	return host == g.pagesDomain || strings.HasSuffix(host, "."+g.pagesDomain)
+}
+
+// wildcardHost returns the wildcard domain matching host, e.g.
+// *.docs.example.com for a.docs.example.com. A wildcard only replaces the
+// leftmost label, and is never returned for a top-level or wildcard domain.
+func wildcardHost(host string) (string, bool) {
+	if strings.HasPrefix(host, "*.") {
+		return "", false
+	}
+
+	i := strings.IndexByte(host, '.')
+	if i <= 0 {
+		return "", false
+	}
+
+	parent := host[i+1:]
+	if !strings.Contains(strings.TrimSuffix(parent, "."), ".") {
+		return "", false
+	}
+
+	return "*." + parent, true
+}
+
+func (g *Gitlab) newDomain(name string, lookup *api.Lookup) *domain.Domain {
 	d := domain.New(name, lookup.Domain.Certificate, lookup.Domain.Key, g)
+	d.Stale = lookup.Stale
 
-	return d, nil
+	return d
+}
+
+// Invalidate invalidates the cached configuration of hosts and of the domains
+// serving any of projectIDs. It implements source.Invalidator.
+func (g *Gitlab) Invalidate(hosts []string, projectIDs []uint64, refresh bool) []string {
+	invalidator, ok := g.client.(source.Invalidator)
+	if !ok {
+		return nil
+	}
+
+	return invalidator.Invalidate(hosts, projectIDs, refresh)
 }
 
 // Resolve is supposed to return the serving request containing lookup path,
@@ -67,34 +332,23 @@ func (g *Gitlab) GetDomain(ctx context.Context, name string) (*domain.Domain, er
 func (g *Gitlab) Resolve(r *http.Request) (*serving.Request, error) {
 	host := request.GetHostWithoutPort(r)
 
-	response := g.client.Resolve(r.Context(), host)
+	response := g.resolve(r.Context(), host)
 	if response.Error != nil {
 		return nil, response.Error
 	}
 
-	urlPath := path.Clean(r.URL.Path)
 	size := len(response.Domain.LookupPaths)
 
-	for _, lookup := range response.Domain.LookupPaths {
-		isSubPath := strings.HasPrefix(urlPath, lookup.Prefix)
-		isRootPath := urlPath == path.Clean(lookup.Prefix)
-
-		if isSubPath || isRootPath {
-			subPath := ""
-			if isSubPath {
-				subPath = strings.TrimPrefix(urlPath, lookup.Prefix)
-			}
-
-			srv, err := g.fabricateServing(lookup)
-			if err != nil {
-				return nil, err
-			}
-
-			return &serving.Request{
-				Serving:    srv,
-				LookupPath: fabricateLookupPath(size, lookup),
-				SubPath:    subPath}, nil
+	if lookup, subPath, ok := response.Domain.FindLookupPath(r.URL.Path); ok {
+		srv, err := g.fabricateServing(*lookup)
+		if err != nil {
+			return nil, err
 		}
+
+		return &serving.Request{
+			Serving:    srv,
+			LookupPath: fabricateLookupPath(size, *lookup),
+			SubPath:    subPath}, nil
 	}
 
 	logging.LogRequest(r).WithError(domain.ErrDomainDoesNotExist).WithFields(
@@ -2,21 +2,28 @@ package gitlab
 
 import (
 	"context"
+	"encoding/base64"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/cache"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/mock"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+	"gitlab.com/gitlab-org/gitlab-pages/test/gitlabstub"
 )
 
 type lookupPathTest struct {
@@ -267,3 +274,246 @@ func sendResolveRequest(t *testing.T, wg *sync.WaitGroup, resolver api.Resolver,
 
 	wg.Done()
 }
+
+type lookupResolver struct {
+	lookup *api.Lookup
+}
+
+func (r *lookupResolver) Resolve(context.Context, string) *api.Lookup {
+	return r.lookup
+}
+
+func TestGetDomainReusesDomainsWithTheSameETag(t *testing.T) {
+	resolver := &lookupResolver{lookup: &api.Lookup{
+		Name:   "test.gitlab.io",
+		Domain: &api.VirtualDomain{Certificate: "cert", Key: "key"},
+		ETag:   `"v1"`,
+	}}
+
+	source := NewFromResolver(resolver, true)
+
+	first, err := source.GetDomain(context.Background(), "test.gitlab.io")
+	require.NoError(t, err)
+
+	second, err := source.GetDomain(context.Background(), "test.gitlab.io")
+	require.NoError(t, err)
+	require.Same(t, first, second)
+
+	resolver.lookup = &api.Lookup{
+		Name:   "test.gitlab.io",
+		Domain: &api.VirtualDomain{Certificate: "new cert", Key: "new key"},
+		ETag:   `"v2"`,
+	}
+
+	changed, err := source.GetDomain(context.Background(), "test.gitlab.io")
+	require.NoError(t, err)
+	require.NotSame(t, first, changed)
+	require.Equal(t, "new cert", changed.CertificateCert)
+
+	resolver.lookup = &api.Lookup{
+		Name:   "test.gitlab.io",
+		Domain: resolver.lookup.Domain,
+		ETag:   `"v2"`,
+		Stale:  true,
+	}
+
+	stale, err := source.GetDomain(context.Background(), "test.gitlab.io")
+	require.NoError(t, err)
+	require.NotSame(t, changed, stale)
+	require.True(t, stale.Stale)
+}
+
+func TestWarmUp(t *testing.T) {
+	var lookups int64
+
+	server, err := gitlabstub.NewUnstartedServer(gitlabstub.WithPagesHandler(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&lookups, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	require.NoError(t, err)
+
+	server.Start()
+	defer server.Close()
+
+	secretKey, err := base64.StdEncoding.DecodeString(fixture.GitLabAPISecretKey)
+	require.NoError(t, err)
+
+	source, err := New(&config.GitLab{
+		InternalServer:     server.URL,
+		APISecretKey:       secretKey,
+		ClientHTTPTimeout:  10 * time.Second,
+		JWTTokenExpiration: 30 * time.Second,
+		Cache: config.Cache{
+			CacheExpiry:          time.Minute,
+			CacheCleanupInterval: time.Minute,
+			EntryRefreshTimeout:  time.Minute,
+			RetrievalTimeout:     time.Second,
+			MaxRetrievalRetries:  1,
+			Store:                config.CacheStoreMemory,
+			WarmUp:               true,
+			WarmUpTimeout:        time.Minute,
+		},
+	}, "gitlab.io")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !source.Warming()
+	}, time.Second, 10*time.Millisecond)
+
+	d, err := source.GetDomain(context.Background(), "zip-from-disk.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "zip-from-disk.gitlab.io", d.Name)
+	require.Zero(t, atomic.LoadInt64(&lookups), "warmed up domains are not looked up")
+}
+
+func TestWarmingWithoutWarmUp(t *testing.T) {
+	source := NewFromResolver(&lookupResolver{}, false)
+
+	require.False(t, source.Warming())
+}
+
+// hostsResolver resolves the lookups of hosts, and records the hosts it got
+// asked for
+type hostsResolver struct {
+	lookups  map[string]*api.Lookup
+	resolved []string
+}
+
+func (r *hostsResolver) Resolve(_ context.Context, host string) *api.Lookup {
+	r.resolved = append(r.resolved, host)
+
+	if lookup, ok := r.lookups[host]; ok {
+		return lookup
+	}
+
+	return &api.Lookup{Name: host, Error: domain.ErrDomainDoesNotExist}
+}
+
+func TestGetDomainWithWildcard(t *testing.T) {
+	wildcard := &api.Lookup{
+		Name:   "*.docs.example.com",
+		Domain: &api.VirtualDomain{Certificate: "wildcard cert", Key: "wildcard key"},
+	}
+
+	tests := map[string]struct {
+		host             string
+		pagesDomain      string
+		lookups          map[string]*api.Lookup
+		expectedCert     string
+		expectedError    error
+		expectedResolved []string
+	}{
+		"when the host is a domain": {
+			host: "a.docs.example.com",
+			lookups: map[string]*api.Lookup{
+				"a.docs.example.com": {Name: "a.docs.example.com", Domain: &api.VirtualDomain{Certificate: "cert"}},
+				"*.docs.example.com": wildcard,
+			},
+			expectedCert:     "cert",
+			expectedResolved: []string{"a.docs.example.com"},
+		},
+		"when the host is covered by a wildcard domain": {
+			host:             "a.docs.example.com",
+			lookups:          map[string]*api.Lookup{"*.docs.example.com": wildcard},
+			expectedCert:     "wildcard cert",
+			expectedResolved: []string{"a.docs.example.com", "*.docs.example.com"},
+		},
+		"when the wildcard only covers the leftmost label": {
+			host:             "a.b.docs.example.com",
+			lookups:          map[string]*api.Lookup{"*.docs.example.com": wildcard},
+			expectedError:    domain.ErrDomainDoesNotExist,
+			expectedResolved: []string{"a.b.docs.example.com", "*.b.docs.example.com"},
+		},
+		"when no domain covers the host": {
+			host:             "a.docs.example.com",
+			expectedError:    domain.ErrDomainDoesNotExist,
+			expectedResolved: []string{"a.docs.example.com", "*.docs.example.com"},
+		},
+		"when the host fails to resolve": {
+			host: "a.docs.example.com",
+			lookups: map[string]*api.Lookup{
+				"a.docs.example.com": {Name: "a.docs.example.com", Error: client.ErrUnauthorizedAPI},
+				"*.docs.example.com": wildcard,
+			},
+			expectedError:    client.ErrUnauthorizedAPI,
+			expectedResolved: []string{"a.docs.example.com"},
+		},
+		"when the wildcard domain fails to resolve": {
+			host: "a.docs.example.com",
+			lookups: map[string]*api.Lookup{
+				"*.docs.example.com": {Name: "*.docs.example.com", Error: client.ErrCircuitOpen},
+			},
+			expectedError:    client.ErrCircuitOpen,
+			expectedResolved: []string{"a.docs.example.com", "*.docs.example.com"},
+		},
+		"when the host is under the pages domain": {
+			host:             "a.group.gitlab.io",
+			pagesDomain:      "gitlab.io",
+			lookups:          map[string]*api.Lookup{"*.group.gitlab.io": wildcard},
+			expectedError:    domain.ErrDomainDoesNotExist,
+			expectedResolved: []string{"a.group.gitlab.io"},
+		},
+		"when the host has a single label below its top-level domain": {
+			host:             "example.com",
+			lookups:          map[string]*api.Lookup{"*.com": wildcard},
+			expectedError:    domain.ErrDomainDoesNotExist,
+			expectedResolved: []string{"example.com"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			resolver := &hostsResolver{lookups: tc.lookups}
+			source := NewFromResolver(resolver, true)
+			source.pagesDomain = tc.pagesDomain
+
+			d, err := source.GetDomain(context.Background(), tc.host)
+			require.Equal(t, tc.expectedResolved, resolver.resolved)
+
+			if tc.expectedError != nil {
+				require.ErrorIs(t, err, tc.expectedError)
+				require.Nil(t, d)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.host, d.Name)
+			require.Equal(t, tc.expectedCert, d.CertificateCert)
+		})
+	}
+}
+
+func TestGetDomainCachesMissingWildcards(t *testing.T) {
+	resolver := &hostsResolver{}
+	source := NewFromResolver(resolver, true)
+
+	for _, host := range []string{"a.docs.example.com", "b.docs.example.com"} {
+		_, err := source.GetDomain(context.Background(), host)
+		require.ErrorIs(t, err, domain.ErrDomainDoesNotExist)
+	}
+
+	// the missing wildcard domain is only looked up once
+	require.Equal(t, []string{"a.docs.example.com", "*.docs.example.com", "b.docs.example.com"}, resolver.resolved)
+}
+
+func TestResolveWithWildcard(t *testing.T) {
+	resolver := &hostsResolver{lookups: map[string]*api.Lookup{
+		"*.docs.example.com": {
+			Name: "*.docs.example.com",
+			Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{{
+				ProjectID: 1,
+				Prefix:    "/",
+				Source:    api.Source{Type: "file", Path: "group/project/public/"},
+			}}},
+		},
+	}}
+
+	source := NewFromResolver(resolver, true)
+
+	request := httptest.NewRequest(http.MethodGet, "https://preview-1.docs.example.com/index.html", nil)
+
+	response, err := source.Resolve(request)
+	require.NoError(t, err)
+	require.Equal(t, "/", response.LookupPath.Prefix)
+	require.Equal(t, "index.html", response.SubPath)
+}
@@ -10,3 +10,13 @@ import (
 type Source interface {
 	GetDomain(context.Context, string) (*domain.Domain, error)
 }
+
+// Invalidator is implemented by domains sources that cache the domains
+// configuration, so that it can be invalidated when it changes.
+type Invalidator interface {
+	// Invalidate invalidates the configuration of hosts and of the domains
+	// serving any of projectIDs, either by evicting it or by refreshing it
+	// straight away. It returns the serving cache keys of the invalidated
+	// lookup paths.
+	Invalidate(hosts []string, projectIDs []uint64, refresh bool) []string
+}
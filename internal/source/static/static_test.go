@@ -0,0 +1,175 @@
+package static
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		file          string
+		expectedError string
+	}{
+		"yaml file": {
+			file: "testdata/domains.yml",
+		},
+		"json file": {
+			file: "testdata/domains.json",
+		},
+		"missing file": {
+			file:          "testdata/missing.yml",
+			expectedError: "reading static domains file",
+		},
+		"empty path": {
+			expectedError: errNoPath.Error(),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := New(tc.file, true)
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+
+			lookup := s.Resolve(context.Background(), "group.gitlab-example.com")
+			require.NoError(t, lookup.Error)
+			require.Equal(t, "https://objects.example.com/group/public.zip", lookup.Domain.LookupPaths[len(lookup.Domain.LookupPaths)-1].Source.Path)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		content       string
+		expectedError error
+	}{
+		"valid domain": {
+			content: "domains: {example.com: {lookup_paths: [{prefix: /, source: {type: zip, path: a.zip}}]}}",
+		},
+		"empty file": {
+			content:       "",
+			expectedError: errNoDomains,
+		},
+		"no domains": {
+			content: "domains: {}",
+		},
+		"no lookup paths": {
+			content:       "domains: {example.com: {}}",
+			expectedError: errNoLookupPaths,
+		},
+		"invalid prefix": {
+			content:       "domains: {example.com: {lookup_paths: [{prefix: project, source: {type: zip}}]}}",
+			expectedError: errInvalidPrefix,
+		},
+		"no source type": {
+			content:       "domains: {example.com: {lookup_paths: [{prefix: /}]}}",
+			expectedError: errNoSourceType,
+		},
+		"duplicated domain": {
+			content:       "domains: {example.com: {lookup_paths: [{prefix: /, source: {type: zip}}]}, Example.com: {lookup_paths: [{prefix: /, source: {type: zip}}]}}",
+			expectedError: errDuplicatedDomain,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parse([]byte(tc.content))
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestGetDomain(t *testing.T) {
+	s, err := New("testdata/domains.yml", true)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	t.Run("custom domain with certificate", func(t *testing.T) {
+		d, err := s.GetDomain(context.Background(), "custom.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "custom.example.com", d.Name)
+		require.Equal(t, "cert", d.CertificateCert)
+		require.Equal(t, "key", d.CertificateKey)
+
+		r := httptest.NewRequest(http.MethodGet, "https://custom.example.com/index.html", nil)
+		lookupPath, err := d.GetLookupPath(r)
+		require.NoError(t, err)
+		require.Equal(t, "file", lookupPath.ServingType)
+		require.Equal(t, "group/project/public/", lookupPath.Path)
+		require.Equal(t, "unique.gitlab-example.com", lookupPath.UniqueHost)
+	})
+
+	t.Run("namespace domain with a project", func(t *testing.T) {
+		d, err := s.GetDomain(context.Background(), "group.gitlab-example.com")
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodGet, "https://group.gitlab-example.com/my/project/index.html", nil)
+		lookupPath, err := d.GetLookupPath(r)
+		require.NoError(t, err)
+		require.Equal(t, "/my/project/", lookupPath.Prefix)
+		require.Equal(t, uint64(124), lookupPath.ProjectID)
+		require.True(t, lookupPath.IsHTTPSOnly)
+		require.True(t, lookupPath.HasAccessControl)
+
+		r = httptest.NewRequest(http.MethodGet, "https://group.gitlab-example.com/index.html", nil)
+		lookupPath, err = d.GetLookupPath(r)
+		require.NoError(t, err)
+		require.Equal(t, "/", lookupPath.Prefix)
+		require.True(t, lookupPath.IsNamespaceProject)
+	})
+
+	t.Run("unknown domain", func(t *testing.T) {
+		d, err := s.GetDomain(context.Background(), "unknown.example.com")
+		require.ErrorIs(t, err, domain.ErrDomainDoesNotExist)
+		require.Nil(t, d)
+	})
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.yml")
+	writeDomains(t, path, "first.example.com")
+
+	s, err := New(path, true)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	require.NoError(t, s.Resolve(context.Background(), "first.example.com").Error)
+
+	writeDomains(t, path, "second.example.com")
+
+	require.Eventually(t, func() bool {
+		return s.Resolve(context.Background(), "second.example.com").Error == nil
+	}, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, s.Resolve(context.Background(), "first.example.com").Error, domain.ErrDomainDoesNotExist)
+
+	// an invalid file keeps the previous configuration
+	require.NoError(t, os.WriteFile(path, []byte("domains: ["), 0600))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, s.Resolve(context.Background(), "second.example.com").Error)
+}
+
+func writeDomains(t *testing.T, path, name string) {
+	t.Helper()
+
+	content := "domains:\n  " + name + ":\n    lookup_paths:\n      - prefix: /\n        source: {type: zip, path: public.zip}\n"
+
+	// write and rename the file to replace it atomically
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0600))
+	require.NoError(t, os.Rename(tmp, path))
+}
@@ -0,0 +1,88 @@
+package inflate
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// CheckpointSpan is the minimum number of inflated bytes between two
+// checkpoints. Each checkpoint holds a window of up to 32KiB, so an index
+// costs around 3% of the size of the inflated stream in memory.
+const CheckpointSpan = 1024 * 1024
+
+// checkpointCost is the cost of an indexed checkpoint without its window
+var checkpointCost = int64(unsafe.Sizeof(Checkpoint{}) + unsafe.Sizeof(&Checkpoint{}))
+
+// Index holds the checkpoints of a stream, so that any of its inflated data
+// can be read by inflating at most CheckpointSpan bytes before it. An Index
+// is not modified once its Reader is done with it, so it can be shared.
+type Index struct {
+	checkpoints []*Checkpoint
+}
+
+// Index returns the index f builds while it is read, from the first block
+// it reads. It must be called before reading from f.
+func (f *Reader) Index() *Index {
+	index := &Index{}
+
+	f.onBlock = func(f *Reader) {
+		if n := len(index.checkpoints); n > 0 && f.out-index.checkpoints[n-1].Out < CheckpointSpan {
+			return
+		}
+
+		index.checkpoints = append(index.checkpoints, f.checkpoint())
+	}
+
+	return index
+}
+
+// Len returns the number of checkpoints of g
+func (g *Index) Len() int {
+	return len(g.checkpoints)
+}
+
+// Cost returns the estimated memory cost of g
+func (g *Index) Cost() int64 {
+	var cost int64
+
+	for _, c := range g.checkpoints {
+		cost += checkpointCost + int64(cap(c.Window))
+	}
+
+	return cost
+}
+
+// Find returns the last checkpoint preceding offset, or nil
+func (g *Index) Find(offset int64) *Checkpoint {
+	i := sort.Search(len(g.checkpoints), func(i int) bool {
+		return g.checkpoints[i].Out > offset
+	})
+
+	if i == 0 {
+		return nil
+	}
+
+	return g.checkpoints[i-1]
+}
+
+// Extend returns an index with the checkpoints of g followed by the ones of
+// other past them, which is g itself when other has none past them
+func (g *Index) Extend(other *Index) *Index {
+	var last int64 = -1
+	if n := len(g.checkpoints); n > 0 {
+		last = g.checkpoints[n-1].Out
+	}
+
+	i := sort.Search(len(other.checkpoints), func(i int) bool {
+		return other.checkpoints[i].Out > last
+	})
+
+	if i == len(other.checkpoints) {
+		return g
+	}
+
+	checkpoints := make([]*Checkpoint, 0, len(g.checkpoints)+len(other.checkpoints)-i)
+	checkpoints = append(checkpoints, g.checkpoints...)
+
+	return &Index{checkpoints: append(checkpoints, other.checkpoints[i:]...)}
+}
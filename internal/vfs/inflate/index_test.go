@@ -0,0 +1,76 @@
+package inflate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	data := testData(4 * CheckpointSpan)
+	compressed := gzipData(t, gzip.DefaultCompression, data)
+
+	f := NewGzipReader(bufio.NewReader(bytes.NewReader(compressed)))
+	index := f.Index()
+
+	_, err := io.Copy(io.Discard, f)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, index.Len(), 4)
+	require.Greater(t, index.Cost(), int64(3*windowSize))
+
+	for _, offset := range []int64{0, CheckpointSpan - 1, CheckpointSpan, 3*CheckpointSpan + 100, int64(len(data)) - 1} {
+		c := index.Find(offset)
+		require.NotNil(t, c)
+		require.LessOrEqual(t, c.Out, offset)
+		require.Less(t, offset-c.Out, int64(2*CheckpointSpan), "a checkpoint is taken every CheckpointSpan")
+
+		f, err := Resume(bufio.NewReader(bytes.NewReader(compressed[c.In:])), c)
+		require.NoError(t, err)
+		require.NoError(t, f.Skip(offset-c.Out))
+
+		b := make([]byte, 1)
+		_, err = io.ReadFull(f, b)
+		require.NoError(t, err)
+		require.Equal(t, data[offset], b[0])
+	}
+
+	require.Nil(t, (&Index{}).Find(0))
+}
+
+func TestIndexExtend(t *testing.T) {
+	data := testData(3 * CheckpointSpan)
+	compressed := gzipData(t, gzip.DefaultCompression, data)
+
+	f := NewGzipReader(bufio.NewReader(bytes.NewReader(compressed)))
+	full := f.Index()
+
+	_, err := io.Copy(io.Discard, f)
+	require.NoError(t, err)
+
+	partial := &Index{checkpoints: full.checkpoints[:1]}
+
+	// the checkpoints taken after resuming from the first one extend it
+	c := partial.Find(0)
+	f, err = Resume(bufio.NewReader(bytes.NewReader(compressed[c.In:])), c)
+	require.NoError(t, err)
+
+	resumed := f.Index()
+
+	_, err = io.Copy(io.Discard, f)
+	require.NoError(t, err)
+
+	extended := partial.Extend(resumed)
+	require.Equal(t, full.Len(), extended.Len())
+
+	for i, c := range extended.checkpoints {
+		require.Equal(t, full.checkpoints[i].In, c.In)
+		require.Equal(t, full.checkpoints[i].Out, c.Out)
+	}
+
+	require.Same(t, extended, extended.Extend(resumed), "an index is not extended with the checkpoints it has")
+	require.Equal(t, resumed.checkpoints, (&Index{}).Extend(resumed).checkpoints, "an empty index is extended with all the checkpoints")
+}
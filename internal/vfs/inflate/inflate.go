@@ -0,0 +1,858 @@
+// Package inflate inflates deflate and gzip streams from checkpoints, so
+// that the inflated data can be read from any offset without inflating all
+// the data before it.
+package inflate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// windowSize is the maximum distance deflate looks back at, so the history
+// needed to resume inflating a stream
+const windowSize = 32 * 1024
+
+const (
+	maxBits      = 15
+	maxLitCodes  = 288
+	maxDistCodes = 30
+
+	// tableBits is the length of the codes decoded at once with the table of
+	// a huffman code, the longer ones being decoded one bit at a time
+	tableBits = 9
+)
+
+var (
+	ErrInvalidGzipHeader = errors.New("invalid gzip header")
+	ErrInvalidDeflate    = errors.New("invalid deflate stream")
+	ErrChecksum          = errors.New("invalid gzip checksum")
+)
+
+var (
+	lengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// codeLengthOrder is the order of the code lengths of the code length
+	// alphabet in a dynamic block header
+	codeLengthOrder = [19]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLit, fixedDist = fixedHuffman()
+)
+
+// huffman is a canonical Huffman code, the codes of up to tableBits bits of
+// which are decoded with a lookup table, and the longer ones one bit at a time
+type huffman struct {
+	// count is the number of codes of each length
+	count [maxBits + 1]uint16
+	// symbol are the symbols ordered by code
+	symbol []uint16
+	// table is indexed by the next tableBits bits of the stream, and holds
+	// the symbol of the code they start with shifted by 4 bits and the
+	// length of that code, or 0 when the code is longer than tableBits
+	table [1 << tableBits]uint16
+}
+
+func newHuffman(lengths []uint8) (*huffman, error) {
+	h := &huffman{symbol: make([]uint16, len(lengths))}
+
+	for _, length := range lengths {
+		h.count[length]++
+	}
+
+	// reject over-subscribed codes, incomplete ones are allowed e.g. for a
+	// single distance code
+	left := 1
+	for length := 1; length <= maxBits; length++ {
+		left <<= 1
+		left -= int(h.count[length])
+		if left < 0 {
+			return nil, ErrInvalidDeflate
+		}
+	}
+
+	var offsets [maxBits + 1]uint16
+	for length := 1; length < maxBits; length++ {
+		offsets[length+1] = offsets[length] + h.count[length]
+	}
+
+	for symbol, length := range lengths {
+		if length != 0 {
+			h.symbol[offsets[length]] = uint16(symbol)
+			offsets[length]++
+		}
+	}
+
+	h.fillTable(lengths)
+
+	return h, nil
+}
+
+// fillTable fills the table of the codes of up to tableBits bits, the bits
+// of which are read from the stream starting with the most significant bit
+// of the code
+func (h *huffman) fillTable(lengths []uint8) {
+	var next [maxBits + 1]int
+
+	code := 0
+	for length := 1; length <= maxBits; length++ {
+		if length > 1 {
+			code = (code + int(h.count[length-1])) << 1
+		}
+
+		next[length] = code
+	}
+
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+
+		code := next[length]
+		next[length]++
+
+		if length > tableBits {
+			continue
+		}
+
+		reversed := 0
+		for i := uint8(0); i < length; i++ {
+			reversed |= (code >> i & 1) << (length - 1 - i)
+		}
+
+		for i := reversed; i < len(h.table); i += 1 << length {
+			h.table[i] = uint16(symbol)<<4 | uint16(length)
+		}
+	}
+}
+
+func fixedHuffman() (*huffman, *huffman) {
+	var lengths [maxLitCodes + maxDistCodes]uint8
+
+	for symbol := range lengths {
+		switch {
+		case symbol < 144:
+			lengths[symbol] = 8
+		case symbol < 256:
+			lengths[symbol] = 9
+		case symbol < 280:
+			lengths[symbol] = 7
+		case symbol < maxLitCodes:
+			lengths[symbol] = 8
+		default:
+			lengths[symbol] = 5
+		}
+	}
+
+	lit, _ := newHuffman(lengths[:maxLitCodes])
+	dist, _ := newHuffman(lengths[maxLitCodes:])
+
+	return lit, dist
+}
+
+type inflateState int
+
+const (
+	stateMemberHeader inflateState = iota
+	stateBlockHeader
+	stateStored
+	stateHuffman
+	stateCopy
+	stateMemberTrailer
+	stateEOF
+)
+
+// Checkpoint is a position of a deflate stream from which it can be inflated
+// again without the data before it
+type Checkpoint struct {
+	// In is the offset of the byte holding the first bit of a deflate block
+	In int64
+	// Bit is the number of bits of that byte preceding the block
+	Bit uint8
+	// Out is the offset of the block in the inflated data
+	Out int64
+	// Window is the inflated data preceding the block the block may refer to
+	Window []byte
+
+	// gzip is true for the checkpoints of gzip streams
+	gzip bool
+}
+
+// Reader inflates raw deflate streams, or gzip streams including the ones
+// made of several members, and can start inflating them from a Checkpoint.
+// Unlike compress/flate and compress/gzip, it tracks the exact position of
+// its deflate blocks, and calls onBlock at the start of each one of them so
+// that checkpoints can be taken. The CRC-32 and size of the gzip members
+// inflated from their start are verified, but not the ones of the member
+// resumed from a checkpoint as it is not inflated entirely.
+type Reader struct {
+	r io.ByteReader
+	// in is the offset of the next byte read from r
+	in int64
+	// out is the offset of the next byte inflated
+	out int64
+
+	bits     uint32
+	bitCount uint
+
+	gzip  bool
+	state inflateState
+	final bool
+
+	lit, dist *huffman
+
+	// remaining is the number of bytes left to copy from a stored block or
+	// from the window, at distance copyDist
+	remaining int
+	copyDist  int
+
+	window     [windowSize]byte
+	windowPos  int
+	windowFull bool
+
+	// verify is true when the current gzip member is inflated from its
+	// start, at memberOut, digest being the CRC-32 of its data
+	verify    bool
+	memberOut int64
+	digest    uint32
+
+	onBlock func(*Reader)
+}
+
+// NewReader returns a Reader inflating a raw deflate stream from its start
+func NewReader(r io.ByteReader) *Reader {
+	return &Reader{r: r, state: stateBlockHeader}
+}
+
+// NewGzipReader returns a Reader inflating a gzip stream from its start
+func NewGzipReader(r io.ByteReader) *Reader {
+	return &Reader{r: r, gzip: true, state: stateMemberHeader}
+}
+
+// Resume returns a Reader inflating the stream of c from c, r reading the
+// stream from c.In
+func Resume(r io.ByteReader, c *Checkpoint) (*Reader, error) {
+	f := &Reader{r: r, in: c.In, out: c.Out, gzip: c.gzip, state: stateBlockHeader}
+
+	if c.Bit > 0 {
+		if _, err := f.needBits(8); err != nil {
+			return nil, err
+		}
+
+		f.dropBits(uint(c.Bit))
+	}
+
+	f.windowPos = copy(f.window[:], c.Window)
+	f.windowFull = f.windowPos == windowSize
+	f.windowPos %= windowSize
+
+	return f, nil
+}
+
+// checkpoint returns the current position of f, which must be at the start
+// of a block
+func (f *Reader) checkpoint() *Checkpoint {
+	bitOffset := f.in*8 - int64(f.bitCount)
+
+	return &Checkpoint{
+		In:     bitOffset / 8,
+		Bit:    uint8(bitOffset % 8),
+		Out:    f.out,
+		Window: f.history(),
+		gzip:   f.gzip,
+	}
+}
+
+// Skip inflates and discards the next n bytes
+func (f *Reader) Skip(n int64) error {
+	if _, err := io.CopyN(io.Discard, f, n); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	return nil
+}
+
+// history returns a copy of the window in order
+func (f *Reader) history() []byte {
+	if !f.windowFull {
+		return append([]byte(nil), f.window[:f.windowPos]...)
+	}
+
+	history := make([]byte, 0, windowSize)
+	history = append(history, f.window[f.windowPos:]...)
+
+	return append(history, f.window[:f.windowPos]...)
+}
+
+func (f *Reader) readByte() (byte, error) {
+	b, err := f.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	f.in++
+
+	return b, nil
+}
+
+// nextByte returns the next byte of the stream, which must be at a byte
+// boundary, the bytes buffered being returned first
+func (f *Reader) nextByte() (byte, error) {
+	if f.bitCount >= 8 {
+		b := byte(f.bits)
+		f.dropBits(8)
+
+		return b, nil
+	}
+
+	return f.readByte()
+}
+
+// alignBits drops the bits buffered up to the next byte boundary
+func (f *Reader) alignBits() {
+	f.dropBits(f.bitCount % 8)
+}
+
+// needBits ensures that at least n bits are buffered. The position of the
+// stream is known exactly as it does not include the bits buffered.
+func (f *Reader) needBits(n uint) (uint32, error) {
+	for f.bitCount < n {
+		b, err := f.readByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+
+		f.bits |= uint32(b) << f.bitCount
+		f.bitCount += 8
+	}
+
+	return f.bits & (1<<n - 1), nil
+}
+
+func (f *Reader) dropBits(n uint) {
+	f.bits >>= n
+	f.bitCount -= n
+}
+
+func (f *Reader) readBits(n uint) (int, error) {
+	v, err := f.needBits(n)
+	if err != nil {
+		return 0, err
+	}
+
+	f.dropBits(n)
+
+	return int(v), nil
+}
+
+func (f *Reader) decode(h *huffman) (int, error) {
+	// the stream may end before tableBits bits, the code being shorter
+	f.needBits(tableBits) //nolint:errcheck
+
+	if entry := h.table[f.bits&(1<<tableBits-1)]; entry != 0 && uint(entry&15) <= f.bitCount {
+		f.dropBits(uint(entry & 15))
+
+		return int(entry >> 4), nil
+	}
+
+	code, first, index := 0, 0, 0
+
+	for length := 1; length <= maxBits; length++ {
+		if f.bitCount == 0 {
+			if _, err := f.needBits(1); err != nil {
+				return 0, err
+			}
+		}
+
+		code |= int(f.bits & 1)
+		f.dropBits(1)
+		count := int(h.count[length])
+
+		if code-count < first {
+			return int(h.symbol[index+code-first]), nil
+		}
+
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+
+	return 0, ErrInvalidDeflate
+}
+
+// Read inflates up to len(p) bytes into p
+func (f *Reader) Read(p []byte) (n int, err error) {
+	// start is the offset of the data of p of the current gzip member
+	start := 0
+
+	defer func() {
+		f.digest = crc32.Update(f.digest, crc32.IEEETable, p[start:n])
+	}()
+
+	for n < len(p) {
+		switch f.state {
+		case stateMemberHeader:
+			err = f.readMemberHeader()
+		case stateBlockHeader:
+			if f.onBlock != nil {
+				f.onBlock(f)
+			}
+
+			err = f.readBlockHeader()
+		case stateStored:
+			var read int
+			read, err = f.readStored(p[n:])
+			n += read
+
+			if f.remaining == 0 {
+				f.endBlock()
+			}
+		case stateHuffman:
+			for err == nil && n < len(p) && f.state == stateHuffman {
+				err = f.readSymbol(p, &n)
+			}
+		case stateCopy:
+			n += f.copyWindow(p[n:])
+			if f.remaining == 0 {
+				f.state = stateHuffman
+			}
+		case stateMemberTrailer:
+			f.digest = crc32.Update(f.digest, crc32.IEEETable, p[start:n])
+			start = n
+
+			err = f.readMemberTrailer()
+		case stateEOF:
+			if n > 0 {
+				return n, nil
+			}
+
+			return 0, io.EOF
+		}
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (f *Reader) write(b byte) {
+	f.window[f.windowPos] = b
+	f.windowPos++
+
+	if f.windowPos == windowSize {
+		f.windowPos = 0
+		f.windowFull = true
+	}
+
+	f.out++
+}
+
+func (f *Reader) readStored(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) && f.remaining > 0 {
+		b, err := f.nextByte()
+		if err != nil {
+			return n, unexpectedEOF(err)
+		}
+
+		p[n] = b
+		f.write(b)
+		n++
+		f.remaining--
+	}
+
+	return n, nil
+}
+
+// copyWindow copies the data at copyDist in the window, in chunks that do
+// not wrap around the window nor overlap the data they are copied to
+func (f *Reader) copyWindow(p []byte) int {
+	n := 0
+
+	for n < len(p) && f.remaining > 0 {
+		from := (f.windowPos - f.copyDist + windowSize) % windowSize
+
+		chunk := min(len(p)-n, f.remaining, f.copyDist, windowSize-from, windowSize-f.windowPos)
+		copy(p[n:n+chunk], f.window[from:from+chunk])
+		copy(f.window[f.windowPos:], p[n:n+chunk])
+
+		f.windowPos += chunk
+		if f.windowPos == windowSize {
+			f.windowPos = 0
+			f.windowFull = true
+		}
+
+		f.out += int64(chunk)
+		n += chunk
+		f.remaining -= chunk
+	}
+
+	return n
+}
+
+func min(values ...int) int {
+	m := values[0]
+
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
+
+func (f *Reader) endBlock() {
+	switch {
+	case !f.final:
+		f.state = stateBlockHeader
+	case f.gzip:
+		f.state = stateMemberTrailer
+	default:
+		f.state = stateEOF
+	}
+}
+
+func (f *Reader) readSymbol(p []byte, n *int) error {
+	symbol, err := f.decode(f.lit)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case symbol < 256:
+		p[*n] = byte(symbol)
+		f.write(byte(symbol))
+		*n++
+
+		return nil
+	case symbol == 256:
+		f.endBlock()
+
+		return nil
+	}
+
+	symbol -= 257
+	if symbol >= len(lengthBase) {
+		return ErrInvalidDeflate
+	}
+
+	extra, err := f.readBits(uint(lengthExtra[symbol]))
+	if err != nil {
+		return err
+	}
+
+	length := int(lengthBase[symbol]) + extra
+
+	symbol, err = f.decode(f.dist)
+	if err != nil {
+		return err
+	}
+
+	if symbol >= len(distBase) {
+		return ErrInvalidDeflate
+	}
+
+	if extra, err = f.readBits(uint(distExtra[symbol])); err != nil {
+		return err
+	}
+
+	dist := int(distBase[symbol]) + extra
+	if !f.windowFull && dist > f.windowPos {
+		return fmt.Errorf("%w: distance too far back", ErrInvalidDeflate)
+	}
+
+	f.remaining, f.copyDist = length, dist
+	f.state = stateCopy
+
+	return nil
+}
+
+func (f *Reader) readBlockHeader() error {
+	header, err := f.readBits(3)
+	if err != nil {
+		return err
+	}
+
+	f.final = header&1 == 1
+
+	switch header >> 1 {
+	case 0:
+		return f.readStoredHeader()
+	case 1:
+		f.lit, f.dist = fixedLit, fixedDist
+	case 2:
+		if err := f.readDynamicHeader(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: invalid block type", ErrInvalidDeflate)
+	}
+
+	f.state = stateHuffman
+
+	return nil
+}
+
+func (f *Reader) readStoredHeader() error {
+	// stored blocks start at the next byte
+	f.alignBits()
+
+	var header [4]byte
+	for i := range header {
+		b, err := f.nextByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		header[i] = b
+	}
+
+	length := int(header[0]) | int(header[1])<<8
+	if length != ^(int(header[2])|int(header[3])<<8)&0xffff {
+		return fmt.Errorf("%w: invalid stored block length", ErrInvalidDeflate)
+	}
+
+	f.remaining = length
+	f.state = stateStored
+
+	if length == 0 {
+		f.endBlock()
+	}
+
+	return nil
+}
+
+func (f *Reader) readDynamicHeader() error {
+	litCount, err := f.readBits(5)
+	if err != nil {
+		return err
+	}
+
+	distCount, err := f.readBits(5)
+	if err != nil {
+		return err
+	}
+
+	codeLengthCount, err := f.readBits(4)
+	if err != nil {
+		return err
+	}
+
+	litCount += 257
+	distCount++
+	codeLengthCount += 4
+
+	if litCount > maxLitCodes || distCount > maxDistCodes {
+		return fmt.Errorf("%w: too many codes", ErrInvalidDeflate)
+	}
+
+	var codeLengthLengths [19]uint8
+	for i := 0; i < codeLengthCount; i++ {
+		length, err := f.readBits(3)
+		if err != nil {
+			return err
+		}
+
+		codeLengthLengths[codeLengthOrder[i]] = uint8(length)
+	}
+
+	codeLengths, err := newHuffman(codeLengthLengths[:])
+	if err != nil {
+		return err
+	}
+
+	lengths := make([]uint8, litCount+distCount)
+
+	for i := 0; i < len(lengths); {
+		symbol, err := f.decode(codeLengths)
+		if err != nil {
+			return err
+		}
+
+		if symbol < 16 {
+			lengths[i] = uint8(symbol)
+			i++
+
+			continue
+		}
+
+		var length uint8
+		var repeat int
+
+		switch symbol {
+		case 16:
+			if i == 0 {
+				return fmt.Errorf("%w: repeated length without previous one", ErrInvalidDeflate)
+			}
+
+			length = lengths[i-1]
+			repeat, err = f.readBits(2)
+			repeat += 3
+		case 17:
+			repeat, err = f.readBits(3)
+			repeat += 3
+		default:
+			repeat, err = f.readBits(7)
+			repeat += 11
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if i+repeat > len(lengths) {
+			return fmt.Errorf("%w: too many lengths", ErrInvalidDeflate)
+		}
+
+		for ; repeat > 0; repeat-- {
+			lengths[i] = length
+			i++
+		}
+	}
+
+	if lengths[256] == 0 {
+		return fmt.Errorf("%w: missing end of block code", ErrInvalidDeflate)
+	}
+
+	if f.lit, err = newHuffman(lengths[:litCount]); err != nil {
+		return err
+	}
+
+	f.dist, err = newHuffman(lengths[litCount:])
+
+	return err
+}
+
+// readMemberHeader reads the header of a gzip member, see RFC 1952
+func (f *Reader) readMemberHeader() error {
+	b, err := f.nextByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	return f.readMemberHeaderAfter(b)
+}
+
+// readMemberHeaderAfter reads the header of a gzip member the first byte of
+// which has been read already
+func (f *Reader) readMemberHeaderAfter(first byte) error {
+	header := [10]byte{first}
+	for i := 1; i < len(header); i++ {
+		b, err := f.nextByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		header[i] = b
+	}
+
+	if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 {
+		return ErrInvalidGzipHeader
+	}
+
+	flags := header[3]
+
+	// FEXTRA
+	if flags&0x04 != 0 {
+		length, err := f.readBits(16)
+		if err != nil {
+			return err
+		}
+
+		if err := f.skipBytes(length); err != nil {
+			return err
+		}
+	}
+
+	// FNAME and FCOMMENT are zero-terminated
+	for _, flag := range []byte{0x08, 0x10} {
+		if flags&flag == 0 {
+			continue
+		}
+
+		for {
+			b, err := f.nextByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+
+			if b == 0 {
+				break
+			}
+		}
+	}
+
+	// FHCRC
+	if flags&0x02 != 0 {
+		if err := f.skipBytes(2); err != nil {
+			return err
+		}
+	}
+
+	f.state = stateBlockHeader
+	f.verify = true
+	f.memberOut = f.out
+	f.digest = 0
+
+	return nil
+}
+
+// readMemberTrailer reads the CRC-32 and size of a gzip member, which are
+// verified when the member has been inflated from its start. The member is
+// followed by another member or by the end of the stream.
+func (f *Reader) readMemberTrailer() error {
+	f.alignBits()
+
+	var trailer [8]byte
+	for i := range trailer {
+		b, err := f.nextByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		trailer[i] = b
+	}
+
+	if f.verify && (binary.LittleEndian.Uint32(trailer[:4]) != f.digest ||
+		binary.LittleEndian.Uint32(trailer[4:]) != uint32(f.out-f.memberOut)) {
+		return ErrChecksum
+	}
+
+	b, err := f.nextByte()
+	if errors.Is(err, io.EOF) {
+		f.state = stateEOF
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return f.readMemberHeaderAfter(b)
+}
+
+func (f *Reader) skipBytes(n int) error {
+	for ; n > 0; n-- {
+		if _, err := f.nextByte(); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
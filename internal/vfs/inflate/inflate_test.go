@@ -0,0 +1,199 @@
+package inflate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testData returns compressible data of size
+func testData(size int) []byte {
+	random := rand.New(rand.NewSource(int64(size)))
+	words := []string{"gitlab ", "pages ", "archive ", "tar ", "\n", "<html>", "</html>"}
+
+	var b bytes.Buffer
+	for b.Len() < size {
+		if random.Intn(10) == 0 {
+			b.WriteByte(byte(random.Intn(256)))
+			continue
+		}
+
+		b.WriteString(words[random.Intn(len(words))])
+	}
+
+	return b.Bytes()[:size]
+}
+
+func gzipData(t testing.TB, level int, members ...[]byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	for i, data := range members {
+		w, err := gzip.NewWriterLevel(&b, level)
+		require.NoError(t, err)
+
+		w.Name = "member"
+		w.Comment = "test"
+		w.Extra = []byte{byte(i)}
+
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	return b.Bytes()
+}
+
+func TestInflater(t *testing.T) {
+	data := testData(300 * 1024)
+
+	tests := map[string]struct {
+		level int
+	}{
+		"stored":  {level: gzip.NoCompression},
+		"fastest": {level: gzip.BestSpeed},
+		"default": {level: gzip.DefaultCompression},
+		"huffman": {level: gzip.HuffmanOnly},
+		"best":    {level: gzip.BestCompression},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			compressed := gzipData(t, tc.level, data[:100*1024], data[100*1024:])
+
+			var checkpoints []*Checkpoint
+
+			f := NewGzipReader(bufio.NewReader(bytes.NewReader(compressed)))
+			f.onBlock = func(f *Reader) {
+				checkpoints = append(checkpoints, f.checkpoint())
+			}
+
+			inflated, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, data, inflated)
+			require.NotEmpty(t, checkpoints)
+
+			// every block can be inflated again from its checkpoint
+			for _, c := range checkpoints {
+				f, err := Resume(bufio.NewReader(bytes.NewReader(compressed[c.In:])), c)
+				require.NoError(t, err)
+
+				inflated, err := io.ReadAll(f)
+				require.NoError(t, err)
+				require.Equal(t, data[c.Out:], inflated)
+			}
+		})
+	}
+}
+
+func TestRawInflater(t *testing.T) {
+	data := testData(300 * 1024)
+
+	for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.DefaultCompression, flate.HuffmanOnly} {
+		var b bytes.Buffer
+
+		w, err := flate.NewWriter(&b, level)
+		require.NoError(t, err)
+
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		// the data following the final block is not read
+		compressed := append(b.Bytes(), "trailing data"...)
+
+		var checkpoints []*Checkpoint
+
+		f := NewReader(bufio.NewReader(bytes.NewReader(compressed)))
+		f.onBlock = func(f *Reader) {
+			checkpoints = append(checkpoints, f.checkpoint())
+		}
+
+		inflated, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, data, inflated)
+		require.NotEmpty(t, checkpoints)
+
+		for _, c := range checkpoints {
+			f, err := Resume(bufio.NewReader(bytes.NewReader(compressed[c.In:])), c)
+			require.NoError(t, err)
+
+			inflated, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, data[c.Out:], inflated)
+		}
+	}
+}
+
+func TestInflaterErrors(t *testing.T) {
+	compressed := gzipData(t, gzip.DefaultCompression, testData(1024))
+
+	tests := map[string]struct {
+		compressed  []byte
+		expectedErr error
+	}{
+		"empty": {
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"not gzip": {
+			compressed:  []byte("not a gzip stream"),
+			expectedErr: ErrInvalidGzipHeader,
+		},
+		"truncated": {
+			compressed:  compressed[:len(compressed)/2],
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"invalid block type": {
+			compressed:  []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff, 0x07},
+			expectedErr: ErrInvalidDeflate,
+		},
+		"invalid checksum": {
+			compressed:  corruptTrailer(compressed, 8),
+			expectedErr: ErrChecksum,
+		},
+		"invalid size": {
+			compressed:  corruptTrailer(compressed, 4),
+			expectedErr: ErrChecksum,
+		},
+		"trailing garbage": {
+			compressed:  append(append([]byte{}, compressed...), []byte("garbage!!!")...),
+			expectedErr: ErrInvalidGzipHeader,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := io.ReadAll(NewGzipReader(bufio.NewReader(bytes.NewReader(tc.compressed))))
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+// corruptTrailer returns compressed with the byte at offset from its end
+// inverted
+func corruptTrailer(compressed []byte, offset int) []byte {
+	corrupted := append([]byte{}, compressed...)
+	corrupted[len(corrupted)-offset] ^= 0xff
+
+	return corrupted
+}
+
+func BenchmarkInflater(b *testing.B) {
+	data := testData(4 * 1024 * 1024)
+	compressed := gzipData(b, gzip.DefaultCompression, data)
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := io.Copy(io.Discard, NewGzipReader(bufio.NewReader(bytes.NewReader(compressed))))
+		require.NoError(b, err)
+	}
+}
@@ -0,0 +1,129 @@
+package local
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
+)
+
+const (
+	// contentIDsCacheSize is the number of content identifiers cached, each
+	// taking about 100 bytes
+	contentIDsCacheSize = 100000
+	// contentIDsCacheExpiration is the time a content identifier is cached,
+	// it is computed again when the file is modified anyway
+	contentIDsCacheExpiration = time.Hour
+	// contentIDLen is the length of the hash of a file kept in its identifier
+	contentIDLen = 16
+	// contentIDMaxSyncHashSize is the size of the largest files hashed before
+	// being served. The larger ones are hashed in the background, so that no
+	// request waits for a large file to be read in full.
+	contentIDMaxSyncHashSize = 64 * 1024
+)
+
+// errContentIDPending is returned while a large file is hashed in the
+// background
+var errContentIDPending = errors.New("content identifier is being computed")
+
+// contentIDs caches the identifiers of the content of the files by path, size
+// and modification time, so that they are only hashed when they are modified
+var contentIDs = lru.New(
+	"local-content-ids",
+	lru.WithMaxSize(contentIDsCacheSize),
+	lru.WithExpirationInterval(contentIDsCacheExpiration),
+)
+
+// hashing holds the keys of the large files being hashed in the background
+var hashing sync.Map
+
+// ContentID returns the SHA256 of the content of the regular file name. Large
+// files are hashed in the background, errContentIDPending being returned
+// until their hash is ready.
+func (r *Root) ContentID(ctx context.Context, name string) (string, error) {
+	file, fi, err := r.openRegular(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	id, err := contentIDs.FindOrFetch(r.rootPath+"/", contentIDKey(name, fi), func() (interface{}, error) {
+		if fi.Size() > contentIDMaxSyncHashSize {
+			r.hashInBackground(name)
+			return nil, errContentIDPending
+		}
+
+		return hashContent(file)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id.(string), nil
+}
+
+// hashInBackground caches the content identifier of the file name, unless it
+// is being hashed already
+func (r *Root) hashInBackground(name string) {
+	if _, loaded := hashing.LoadOrStore(r.rootPath+"/"+name, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer hashing.Delete(r.rootPath + "/" + name)
+
+		file, fi, err := r.openRegular(context.Background(), name)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		// the file is keyed by the modification time of the content hashed,
+		// which may have changed since it has been requested
+		_, err = contentIDs.FindOrFetch(r.rootPath+"/", contentIDKey(name, fi), func() (interface{}, error) {
+			return hashContent(file)
+		})
+		if err != nil {
+			log.WithError(err).WithField("name", name).Error("failed to hash file content")
+		}
+	}()
+}
+
+// openRegular opens the regular file name, and stats the opened file so that
+// its key holds the modification time of the content hashed
+func (r *Root) openRegular(ctx context.Context, name string) (*os.File, os.FileInfo, error) {
+	file, err := r.Open(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := file.(*os.File).Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file.(*os.File), fi, nil
+}
+
+func contentIDKey(name string, fi os.FileInfo) string {
+	return fmt.Sprintf("%s\x00%d\x00%d", name, fi.Size(), fi.ModTime().UnixNano())
+}
+
+func hashContent(r io.Reader) (interface{}, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:contentIDLen]), nil
+}
@@ -0,0 +1,85 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentID(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("GitLab Pages"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "copy.html"), []byte("GitLab Pages"), 0600))
+	require.NoError(t, os.Symlink("index.html", filepath.Join(dir, "symlink.html")))
+
+	root, err := localVFS.Root(ctx, dir, "")
+	require.NoError(t, err)
+
+	id, err := root.(*Root).ContentID(ctx, "index.html")
+	require.NoError(t, err)
+	require.Equal(t, "fade6ba415ef046564b3c955b3c263ea", id)
+
+	copyID, err := root.(*Root).ContentID(ctx, "copy.html")
+	require.NoError(t, err)
+	require.Equal(t, id, copyID, "the identifier only depends on the content")
+
+	_, err = root.(*Root).ContentID(ctx, "subdir")
+	require.ErrorIs(t, err, errNotFile)
+
+	_, err = root.(*Root).ContentID(ctx, "symlink.html")
+	require.Error(t, err, "symlinks are not followed")
+
+	_, err = root.(*Root).ContentID(ctx, "missing.html")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// the file is hashed again when it is modified
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("GitLab Pages!"), 0600))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "index.html"), time.Now(), time.Now().Add(time.Second)))
+
+	modifiedID, err := root.(*Root).ContentID(ctx, "index.html")
+	require.NoError(t, err)
+	require.NotEqual(t, id, modifiedID)
+}
+
+func TestContentIDLargeFile(t *testing.T) {
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("GitLab Pages"), 1024*1024)
+
+	var ids []string
+
+	// every deployment writes the file again with a new modification time
+	for i, modTime := range []time.Time{time.Unix(1600000000, 0), time.Unix(1700000000, 0)} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "large.bin")
+
+		require.NoError(t, os.WriteFile(path, content, 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+		root, err := localVFS.Root(ctx, dir, strconv.Itoa(i))
+		require.NoError(t, err)
+
+		// large files are hashed in the background
+		_, err = root.(*Root).ContentID(ctx, "large.bin")
+		require.ErrorIs(t, err, errContentIDPending)
+
+		var id string
+		require.Eventually(t, func() bool {
+			id, err = root.(*Root).ContentID(ctx, "large.bin")
+			return err == nil
+		}, time.Second, time.Millisecond)
+
+		ids = append(ids, id)
+	}
+
+	require.Equal(t, ids[0], ids[1], "large files are identified by their content too")
+}
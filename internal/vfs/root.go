@@ -2,6 +2,7 @@ package vfs
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strconv"
 
@@ -18,6 +19,17 @@ type Root interface {
 	Open(ctx context.Context, name string) (File, error)
 }
 
+// ErrNoContentID is returned by the roots which do not identify the content
+// of their files
+var ErrNoContentID = errors.New("no content identifier")
+
+// ContentIdentifier is implemented by the roots which identify the content of
+// their files, so that the ETag of a file only changes with its content
+type ContentIdentifier interface {
+	// ContentID returns an identifier of the content of the regular file name
+	ContentID(ctx context.Context, name string) (string, error)
+}
+
 type instrumentedRoot struct {
 	root     Root
 	name     string
@@ -68,3 +80,21 @@ func (i *instrumentedRoot) Open(ctx context.Context, name string) (File, error)
 
 	return f, err
 }
+
+func (i *instrumentedRoot) ContentID(ctx context.Context, name string) (string, error) {
+	identifier, ok := i.root.(ContentIdentifier)
+	if !ok {
+		return "", ErrNoContentID
+	}
+
+	id, err := identifier.ContentID(ctx, name)
+
+	i.increment("ContentID", err)
+	i.log(ctx).
+		WithField("name", name).
+		WithField("ret-id", id).
+		WithError(err).
+		Traceln("ContentID call")
+
+	return id, err
+}
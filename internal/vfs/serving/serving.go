@@ -247,32 +247,52 @@ func writeNotModified(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNotModified)
 }
 
+// WithoutContent reports whether the response to r has no content, because
+// it is a HEAD request or because one of its preconditions results in sending
+// StatusNotModified or StatusPreconditionFailed. The ETag of the response must
+// already be set, so that the content is only prepared when it is sent.
+func WithoutContent(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
+	return r.Method == http.MethodHead || evalPreconditions(w, r, modtime) != 0
+}
+
 // checkPreconditions evaluates request preconditions and reports whether a precondition
 // resulted in sending StatusNotModified or StatusPreconditionFailed.
 func checkPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time) (done bool) {
+	switch evalPreconditions(w, r, modtime) {
+	case http.StatusNotModified:
+		writeNotModified(w)
+		return true
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return true
+	}
+
+	return false
+}
+
+// evalPreconditions evaluates request preconditions and returns the status
+// they result in, StatusNotModified or StatusPreconditionFailed, or 0 when
+// the content is sent.
+func evalPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time) int {
 	// This function carefully follows RFC 7232 section 6.
 	ch := checkIfMatch(w, r)
 	if ch == condNone {
 		ch = checkIfUnmodifiedSince(r, modtime)
 	}
 	if ch == condFalse {
-		w.WriteHeader(http.StatusPreconditionFailed)
-		return true
+		return http.StatusPreconditionFailed
 	}
 	switch checkIfNoneMatch(w, r) {
 	case condFalse:
 		if r.Method == http.MethodGet || r.Method == http.MethodHead {
-			writeNotModified(w)
-			return true
+			return http.StatusNotModified
 		}
-		w.WriteHeader(http.StatusPreconditionFailed)
-		return true
+		return http.StatusPreconditionFailed
 	case condNone:
 		if checkIfModifiedSince(r, modtime) == condFalse {
-			writeNotModified(w)
-			return true
+			return http.StatusNotModified
 		}
 	}
 
-	return false
+	return 0
 }
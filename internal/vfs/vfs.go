@@ -18,6 +18,14 @@ type VFS interface {
 	Reconfigure(config *config.Config) error
 }
 
+// Invalidator is implemented by a VFS that caches the roots it opens, so that
+// the roots can be reopened when their content changes
+type Invalidator interface {
+	// Invalidate drops the cached roots of cacheKeys and returns how many were
+	// dropped
+	Invalidate(cacheKeys []string) int
+}
+
 func Instrumented(fs VFS) VFS {
 	return &instrumentedVFS{fs: fs}
 }
@@ -57,3 +65,12 @@ func (i *instrumentedVFS) Name() string {
 func (i *instrumentedVFS) Reconfigure(cfg *config.Config) error {
 	return i.fs.Reconfigure(cfg)
 }
+
+func (i *instrumentedVFS) Invalidate(cacheKeys []string) int {
+	invalidator, ok := i.fs.(Invalidator)
+	if !ok {
+		return 0
+	}
+
+	return invalidator.Invalidate(cacheKeys)
+}
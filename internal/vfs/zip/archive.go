@@ -2,22 +2,22 @@ package zip
 
 import (
 	"archive/zip"
+	"compress/flate"
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
-	"path"
-	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
@@ -32,84 +32,68 @@ var (
 	errNotFile     = errors.New("not a file")
 )
 
-type archiveStatus int
-
-const (
-	archiveOpening archiveStatus = iota
-	archiveOpenError
-	archiveOpened
-	archiveCorrupted
-)
-
 // zipArchive implements the vfs.Root interface.
-// It represents a zip archive saving all its files in memory.
+// It represents a zip archive keeping a compact index of its files in memory.
 // It holds an httprange.Resource that can be read with httprange.RangedReader in chunks.
 type zipArchive struct {
-	fs *zipVFS
+	fs *VFS
+
+	archiveOpener
 
-	once        sync.Once
-	done        chan struct{}
-	openTimeout time.Duration
+	// key is the SHA256 checksum of the archive, which keys the cache
+	key string
+	// budget accounts the memory cost of the archive
+	budget *memoryBudget
 
-	cacheNamespace string
+	reader *httprange.RangedReader
+	// file is the archive opened from the disk cache instead of resource. It
+	// is closed once the archive is evicted and the files opened from it are
+	// closed.
+	file *cachedFile
 
-	resource *httprange.Resource
-	reader   *httprange.RangedReader
-	err      error
+	index *index
 
-	files       map[string]*zip.File
-	directories map[string]*zip.FileHeader
+	// symlinks are read from the archive on first use, and checkpoints are
+	// taken the first time deflated files are read from another offset than
+	// their start, both keyed by the position of their file in index
+	mux         sync.Mutex
+	symlinks    map[int]string
+	checkpoints map[int]*inflate.Index
 }
 
-func newArchive(fs *zipVFS, openTimeout time.Duration) *zipArchive {
+func newArchive(fs *VFS, openTimeout time.Duration) *zipArchive {
 	return &zipArchive{
-		fs:             fs,
-		done:           make(chan struct{}),
-		files:          make(map[string]*zip.File),
-		directories:    make(map[string]*zip.FileHeader),
-		openTimeout:    openTimeout,
-		cacheNamespace: strconv.FormatInt(atomic.AddInt64(fs.archiveCount, 1), 10) + ":",
+		fs:     fs,
+		budget: fs.budget,
+		archiveOpener: archiveOpener{
+			done:        make(chan struct{}),
+			openTimeout: openTimeout,
+		},
+		index:       newIndex(nil),
+		symlinks:    make(map[int]string),
+		checkpoints: make(map[int]*inflate.Index),
 	}
 }
 
-func (a *zipArchive) openArchive(parentCtx context.Context, url string) (err error) {
-	// always try to update URL on resource
-	if a.resource != nil {
-		a.resource.SetURL(url)
-	}
-
-	// return early if openArchive was done already in a concurrent request
-	if status, err := a.openStatus(); status != archiveOpening {
-		return err
-	}
+// newZipArchive returns a new zip archive of the cache of fs. It is the
+// newArchiveFunc of the zip archives.
+func newZipArchive(fs *VFS, key string) archive {
+	a := newArchive(fs, fs.openTimeout)
+	a.key = key
 
-	ctx, cancel := context.WithTimeout(parentCtx, a.openTimeout)
-	defer cancel()
+	return a
+}
 
-	a.once.Do(func() {
-		// read archive once in its own routine with its own timeout
-		// if parentCtx is canceled, readArchive will continue regardless and will be cached in memory
-		go a.readArchive(url)
-	})
-
-	// wait for readArchive to be done or return if the parent context is canceled
-	select {
-	case <-a.done:
-		return a.err
-	case <-ctx.Done():
-		err := ctx.Err()
-		if errors.Is(err, context.Canceled) {
-			log.ContextLogger(parentCtx).WithError(err).Traceln("open zip archive request canceled")
-		} else if errors.Is(err, context.DeadlineExceeded) {
-			log.ContextLogger(parentCtx).WithError(err).Traceln("open zip archive timed out")
-		}
+func (a *zipArchive) cacheKey() string {
+	return a.key
+}
 
-		return err
-	}
+func (a *zipArchive) openArchive(ctx context.Context, url string) error {
+	return a.open(ctx, url, "zip", a.readArchive)
 }
 
-// readArchive creates an httprange.Resource that can read the archive's contents and stores a slice of *zip.Files
-// that can be accessed later when calling any of th vfs.VFS operations
+// readArchive creates an httprange.Resource that can read the archive's contents and builds the index of its files
+// that is used later when calling any of th vfs.VFS operations
 func (a *zipArchive) readArchive(url string) {
 	defer close(a.done)
 
@@ -117,24 +101,41 @@ func (a *zipArchive) readArchive(url string) {
 	ctx, cancel := context.WithTimeout(context.Background(), a.openTimeout)
 	defer cancel()
 
-	a.resource, a.err = httprange.NewResource(ctx, url, a.fs.httpClient)
-	if a.err != nil {
-		log.WithFields(log.Fields{
-			"archive_url": url,
-		}).WithError(a.err).Infoln("read zip archive request failed")
-		metrics.ZipOpened.WithLabelValues("error").Inc()
-		return
+	var idx *index
+
+	if file, size := a.openCachedArchive(); file != nil {
+		if idx, a.err = readIndex(file, size); a.err == nil {
+			a.file = &cachedFile{file: file}
+		} else {
+			// the archive is read from resource instead of the corrupted file
+			log.WithFields(log.Fields{
+				"archive_key": a.key,
+			}).WithError(a.err).Warn("discarding unreadable archive from the disk cache")
+
+			file.Close()
+			a.fs.diskCache.discard(a.key)
+			idx, a.err = nil, nil
+		}
 	}
 
-	var archive *zip.Reader
+	if a.file == nil {
+		a.resource, a.err = httprange.NewResource(ctx, url, a.fs.httpClient)
+		if a.err != nil {
+			log.WithFields(log.Fields{
+				"archive_url": url,
+			}).WithError(a.err).Infoln("read zip archive request failed")
+			metrics.ZipOpened.WithLabelValues("error").Inc()
+			return
+		}
 
-	// load all archive files into memory using a cached ranged reader
-	a.reader = httprange.NewRangedReader(a.resource)
-	a.reader.WithCachedReader(ctx, func() {
-		archive, a.err = zip.NewReader(a.reader, a.resource.Size)
-	})
+		// read the central directory using a cached ranged reader
+		a.reader = httprange.NewRangedReader(a.resource)
+		a.reader.WithCachedReader(ctx, func() {
+			idx, a.err = readIndex(a.reader, a.resource.Size)
+		})
+	}
 
-	if archive == nil || a.err != nil {
+	if idx == nil || a.err != nil {
 		log.WithFields(log.Fields{
 			"archive_url": url,
 		}).WithError(a.err).Infoln("loading zip archive files into memory failed")
@@ -142,155 +143,161 @@ func (a *zipArchive) readArchive(url string) {
 		return
 	}
 
-	// TODO: Improve preprocessing of zip archives https://gitlab.com/gitlab-org/gitlab-pages/-/issues/432
-	for _, file := range archive.File {
-		if !strings.HasPrefix(file.Name, dirPrefix) {
-			continue
-		}
-
-		// Each Modified timestamp contains a pointer to a unique timezone
-		// object. This wastes a lot of memory. By setting the timezone to UTC on
-		// each timestamp, we allow the unique timezone objects to be
-		// garbage-collected. Also see
-		// https://gitlab.com/gitlab-org/gitlab-pages/-/issues/702.
-		file.Modified = file.Modified.UTC()
-
-		if file.Mode().IsDir() {
-			a.directories[file.Name] = &file.FileHeader
-		} else {
-			a.files[file.Name] = file
-		}
-
-		a.addPathDirectory(file.Name)
+	if a.resource != nil && a.fs.diskCache != nil && validDiskCacheKey(a.key) && !strings.HasPrefix(url, "file://") {
+		// keep the archive on disk for the next time it is opened
+		go a.fs.diskCache.fill(context.Background(), a.key, a.resource)
 	}
 
-	// Each file stores a pointer to the zip.reader.
-	// The file slice is not used so we null it out
-	// to reduce memory consumption.
-	archive.File = nil
+	a.index = idx
+	a.account(idx.cost())
 
-	fileCount := float64(len(a.files))
+	fileCount := float64(idx.files)
 	metrics.ZipOpened.WithLabelValues("ok").Inc()
 	metrics.ZipOpenedEntriesCount.Add(fileCount)
 	metrics.ZipArchiveEntriesCached.Add(fileCount)
+	metrics.ZipArchiveCost.Observe(float64(idx.cost()))
 }
 
-// addPathDirectory adds a directory for a given path
-func (a *zipArchive) addPathDirectory(pathname string) {
-	// Split dir and file from `path`
-	pathname, _ = path.Split(pathname)
-	if pathname == "" {
-		return
+// account adds cost to the memory cost of the archive, evicting other
+// archives from the cache if the memory budget is exceeded
+func (a *zipArchive) account(cost int64) {
+	a.fs.evict(a.budget.grow(a, cost))
+}
+
+// openCachedArchive returns the archive from the disk cache and its size, or
+// nil when it is not cached
+func (a *zipArchive) openCachedArchive() (*os.File, int64) {
+	if a.fs.diskCache == nil || !validDiskCacheKey(a.key) {
+		return nil, 0
 	}
 
-	if a.directories[pathname] != nil {
-		return
+	return a.fs.diskCache.open(a.key)
+}
+
+// readerAt returns the reader of the archive
+func (a *zipArchive) readerAt() io.ReaderAt {
+	if a.file != nil {
+		return a.file
 	}
 
-	a.directories[pathname] = &zip.FileHeader{
-		Name: pathname,
+	return a.reader
+}
+
+// section returns a reader of the size bytes of the archive at offset
+func (a *zipArchive) section(ctx context.Context, offset, size int64) (vfs.SeekableFile, error) {
+	if a.file != nil {
+		return a.file.section(offset, size)
 	}
+
+	return a.reader.SectionReader(ctx, offset, size), nil
 }
 
-func (a *zipArchive) findFile(name string) *zip.File {
-	name = path.Clean(dirPrefix + name)
+// findFile returns the position of the file of name in the index, or -1
+func (a *zipArchive) findFile(name string) int {
+	if i := a.index.find(name); i >= 0 && !a.index.modes[i].IsDir() {
+		return i
+	}
 
-	return a.files[name]
+	return -1
 }
 
-func (a *zipArchive) findDirectory(name string) *zip.FileHeader {
-	name = path.Clean(dirPrefix + name)
+// findDirectory returns the position of the directory of name in the index,
+// or -1
+func (a *zipArchive) findDirectory(name string) int {
+	if i := a.index.find(name); i >= 0 && a.index.modes[i].IsDir() {
+		return i
+	}
 
-	return a.directories[name+"/"]
+	return -1
 }
 
 // Open finds the file by name inside the zipArchive and returns a reader that can be served by the VFS
 func (a *zipArchive) Open(ctx context.Context, name string) (vfs.File, error) {
 	file := a.findFile(name)
-	if file == nil {
-		if a.findDirectory(name) != nil {
+	if file < 0 {
+		if a.findDirectory(name) >= 0 {
 			return nil, errNotFile
 		}
 		return nil, os.ErrNotExist
 	}
 
-	if !file.Mode().IsRegular() {
+	if !a.index.modes[file].IsRegular() {
 		return nil, errNotFile
 	}
 
-	dataOffset, err := a.fs.dataOffsetCache.FindOrFetch(a.cacheNamespace, name, func() (interface{}, error) {
-		return file.DataOffset()
-	})
+	dataOffset, err := a.dataOffset(file)
 	if err != nil {
 		return nil, err
 	}
 
 	// only read from dataOffset up to the size of the compressed file
-	reader := a.reader.SectionReader(ctx, dataOffset.(int64), int64(file.CompressedSize64))
+	reader, err := a.section(ctx, dataOffset, a.index.compressedSizes[file])
+	if err != nil {
+		return nil, err
+	}
 
-	switch file.Method {
+	switch method := a.index.methods[file]; method {
 	case zip.Deflate:
-		return newDeflateReader(reader), nil
+		return newDeflateFile(a, file, reader), nil
 	case zip.Store:
 		return reader, nil
 	default:
-		return nil, fmt.Errorf("unsupported compression method: %x", file.Method)
+		rc, err := decompress(method, reader, a.index.sizes[file])
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+
+		return &decompressedFile{ReadCloser: rc, section: reader}, nil
 	}
 }
 
 // Lstat finds the file by name inside the zipArchive and returns its FileInfo
 func (a *zipArchive) Lstat(ctx context.Context, name string) (os.FileInfo, error) {
+	if i := a.index.find(name); i >= 0 {
+		return a.index.fileInfo(i), nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// ContentID finds the file by name inside the zipArchive and returns its
+// CRC32 and its size, which identify its content
+func (a *zipArchive) ContentID(ctx context.Context, name string) (string, error) {
 	file := a.findFile(name)
-	if file != nil {
-		return file.FileInfo(), nil
+	if file < 0 {
+		if a.findDirectory(name) >= 0 {
+			return "", errNotFile
+		}
+		return "", os.ErrNotExist
 	}
 
-	directory := a.findDirectory(name)
-	if directory != nil {
-		return directory.FileInfo(), nil
+	if !a.index.modes[file].IsRegular() {
+		return "", errNotFile
 	}
 
-	return nil, os.ErrNotExist
+	return fmt.Sprintf("%08x-%x", a.index.crc32s[file], a.index.sizes[file]), nil
 }
 
 // ReadLink finds the file by name inside the zipArchive and returns the contents of the symlink
 func (a *zipArchive) Readlink(ctx context.Context, name string) (string, error) {
 	file := a.findFile(name)
-	if file == nil {
-		if a.findDirectory(name) != nil {
+	if file < 0 {
+		if a.findDirectory(name) >= 0 {
 			return "", errNotSymlink
 		}
 		return "", os.ErrNotExist
 	}
 
-	if file.FileInfo().Mode()&os.ModeSymlink != os.ModeSymlink {
+	if a.index.modes[file]&os.ModeSymlink != os.ModeSymlink {
 		return "", errNotSymlink
 	}
 
-	symlinkValue, err := a.fs.readlinkCache.FindOrFetch(a.cacheNamespace, name, func() (interface{}, error) {
-		rc, err := file.Open()
-		if err != nil {
-			return nil, err
-		}
-		defer rc.Close()
-
-		var link [maxSymlinkSize + 1]byte
-
-		// read up to len(symlink) bytes from the link file
-		n, err := io.ReadFull(rc, link[:])
-		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
-			// if err == io.ErrUnexpectedEOF the link is smaller than len(symlink) so it's OK to not return it
-			return nil, err
-		}
-
-		return string(link[:n]), nil
-	})
+	symlink, err := a.symlink(file)
 	if err != nil {
 		return "", err
 	}
 
-	symlink := symlinkValue.(string)
-
 	// return errSymlinkSize if the number of bytes read from the link is too big
 	if len(symlink) > maxSymlinkSize {
 		return "", errSymlinkSize
@@ -299,25 +306,162 @@ func (a *zipArchive) Readlink(ctx context.Context, name string) (string, error)
 	return symlink, nil
 }
 
-// onEvicted called by the zipVFS.cache when an archive is removed from the cache
-func (a *zipArchive) onEvicted() {
-	metrics.ZipArchiveEntriesCached.Sub(float64(len(a.files)))
+// dataOffset returns the offset of the data of file, which is read from its
+// local header on first use
+func (a *zipArchive) dataOffset(file int) (int64, error) {
+	if dataOffset := a.index.dataOffset(file); dataOffset > 0 {
+		metrics.ZipCacheRequests.WithLabelValues("data-offset", "hit").Inc()
+		return dataOffset, nil
+	}
+
+	var header [fileHeaderLen]byte
+
+	_, err := a.readerAt().ReadAt(header[:], a.index.headerOffsets[file])
+	if err != nil {
+		metrics.ZipCacheRequests.WithLabelValues("data-offset", "error").Inc()
+		return 0, err
+	}
+
+	dataOffset, err := a.index.setDataOffset(file, header[:])
+	if err != nil {
+		metrics.ZipCacheRequests.WithLabelValues("data-offset", "error").Inc()
+		return 0, err
+	}
+
+	metrics.ZipCacheRequests.WithLabelValues("data-offset", "miss").Inc()
+
+	return dataOffset, nil
 }
 
-func (a *zipArchive) openStatus() (archiveStatus, error) {
-	select {
-	case <-a.done:
-		if a.err != nil {
-			return archiveOpenError, a.err
-		}
+// symlink returns the target of the symlink file, up to maxSymlinkSize+1
+// bytes, which is read from the archive on first use
+func (a *zipArchive) symlink(file int) (string, error) {
+	a.mux.Lock()
+	symlink, found := a.symlinks[file]
+	a.mux.Unlock()
 
-		if a.resource != nil && a.resource.Err() != nil {
-			return archiveCorrupted, a.resource.Err()
-		}
+	if found {
+		metrics.ZipCacheRequests.WithLabelValues("readlink", "hit").Inc()
+		return symlink, nil
+	}
 
-		return archiveOpened, nil
+	symlink, err := a.readSymlink(file)
+	if err != nil {
+		metrics.ZipCacheRequests.WithLabelValues("readlink", "error").Inc()
+		return "", err
+	}
 
+	metrics.ZipCacheRequests.WithLabelValues("readlink", "miss").Inc()
+
+	a.mux.Lock()
+	_, found = a.symlinks[file]
+	a.symlinks[file] = symlink
+	a.mux.Unlock()
+
+	if !found {
+		a.account(symlinkCost + int64(len(symlink)))
+	}
+
+	return symlink, nil
+}
+
+func (a *zipArchive) readSymlink(file int) (string, error) {
+	dataOffset, err := a.dataOffset(file)
+	if err != nil {
+		return "", err
+	}
+
+	var rc io.Reader = io.NewSectionReader(a.readerAt(), dataOffset, a.index.compressedSizes[file])
+
+	switch method := a.index.methods[file]; method {
+	case zip.Deflate:
+		fr := flate.NewReader(rc)
+		defer fr.Close()
+
+		rc = fr
+	case zip.Store:
 	default:
-		return archiveOpening, nil
+		dr, err := decompress(method, rc, a.index.sizes[file])
+		if err != nil {
+			return "", err
+		}
+		defer dr.Close()
+
+		rc = dr
+	}
+
+	var link [maxSymlinkSize + 1]byte
+
+	// read up to len(symlink) bytes from the link file
+	n, err := io.ReadFull(rc, link[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		// if err == io.ErrUnexpectedEOF the link is smaller than len(symlink) so it's OK to not return it
+		return "", err
+	}
+
+	// the whole link has been read when it is smaller than len(symlink)
+	if n < len(link) && crc32.ChecksumIEEE(link[:n]) != a.index.crc32s[file] {
+		return "", zip.ErrChecksum
+	}
+
+	return string(link[:n]), nil
+}
+
+// fileCheckpoints returns the checkpoints of the deflated file, or nil when
+// none has been taken yet
+func (a *zipArchive) fileCheckpoints(file int) *inflate.Index {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.checkpoints[file]
+}
+
+// addCheckpoints adds the checkpoints of index past the ones of the deflated
+// file, index not being modified anymore
+func (a *zipArchive) addCheckpoints(file int, index *inflate.Index) {
+	if index.Len() == 0 {
+		return
+	}
+
+	a.mux.Lock()
+	current, found := a.checkpoints[file]
+
+	cost := checkpointsCost
+	if found {
+		index = current.Extend(index)
+		cost = -current.Cost()
+	}
+
+	a.checkpoints[file] = index
+	a.mux.Unlock()
+
+	if index != current {
+		a.account(cost + index.Cost())
+	}
+}
+
+// onEvicted called by the VFS.cache when an archive is removed from the
+// cache, which closes the file of the disk cache once it is not read anymore
+func (a *zipArchive) onEvicted() {
+	if status, _ := a.openStatus(); status != archiveOpening {
+		a.release()
+		return
+	}
+
+	// the file is opened and the entries are counted by the archive still
+	// being read
+	go func() {
+		<-a.done
+		a.release()
+	}()
+}
+
+// release uncounts the entries of the archive, and closes the file of the
+// disk cache once its readers are closed
+func (a *zipArchive) release() {
+	metrics.ZipArchiveEntriesCached.Sub(float64(a.index.files))
+
+	if a.file != nil {
+		a.file.evict()
 	}
 }
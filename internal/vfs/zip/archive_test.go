@@ -6,6 +6,7 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"net/http"
@@ -13,17 +14,18 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 var (
@@ -284,6 +286,57 @@ func testLstat(t *testing.T, zip *zipArchive) {
 	}
 }
 
+func TestContentID(t *testing.T) {
+	t.Run("content_id_from_server", runZipTest(t, testContentID, false))
+	t.Run("content_id_from_disk", runZipTest(t, testContentID, true))
+}
+
+func testContentID(t *testing.T, zip *zipArchive) {
+	tests := map[string]struct {
+		file        string
+		expectedErr error
+	}{
+		"file": {
+			file: "index.html",
+		},
+		"deflated_file": {
+			file: "subdir/linked.html",
+		},
+		"symlink": {
+			file:        "symlink.html",
+			expectedErr: errNotFile,
+		},
+		"directory": {
+			file:        "subdir",
+			expectedErr: errNotFile,
+		},
+		"missing": {
+			file:        "missing.html",
+			expectedErr: os.ErrNotExist,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			id, err := zip.ContentID(context.Background(), tt.file)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+
+			f, err := zip.Open(context.Background(), tt.file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			content, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("%08x-%x", crc32.ChecksumIEEE(content), len(content)), id)
+		})
+	}
+}
+
 func TestReadLink(t *testing.T) {
 	t.Run("read_link_from_server", runZipTest(t, testReadLink, false))
 	t.Run("read_link_from_disk", runZipTest(t, testReadLink, true))
@@ -353,7 +406,7 @@ func TestArchiveCanBeReadAfterOpenCtxCanceled(t *testing.T) {
 	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
 	defer cleanup()
 
-	fs := New(&zipCfg).(*zipVFS)
+	fs := New(&zipCfg)
 	zip := newArchive(fs, time.Second)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -372,11 +425,44 @@ func TestArchiveCanBeReadAfterOpenCtxCanceled(t *testing.T) {
 	require.NoError(t, file.Close())
 }
 
+func TestArchiveEvictedWhileOpening(t *testing.T) {
+	chdir := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer chdir()
+
+	release := make(chan struct{})
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.ServeFile(w, r, "group/zip.gitlab.io/public.zip")
+	}))
+	defer testServer.Close()
+
+	entriesCached := testutil.ToFloat64(metrics.ZipArchiveEntriesCached)
+
+	fs := New(&zipCfg)
+	zip := newArchive(fs, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := zip.openArchive(ctx, testServer.URL+"/public.zip")
+	require.ErrorIs(t, err, context.Canceled)
+
+	zip.onEvicted()
+
+	close(release)
+	<-zip.done
+
+	// the entries counted once the archive is opened are uncounted
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.ZipArchiveEntriesCached) == entriesCached
+	}, time.Second, time.Millisecond)
+}
+
 func TestReadArchiveFails(t *testing.T) {
 	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
 	defer cleanup()
 
-	zfs := New(&zipCfg).(*zipVFS)
+	zfs := New(&zipCfg)
 	zip := newArchive(zfs, time.Second)
 
 	err := zip.openArchive(context.Background(), testServerURL+"/unkown.html")
@@ -440,20 +526,20 @@ func TestMinimalRangeRequests(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	fs := New(&zipCfg).(*zipVFS)
+	fs := New(&zipCfg)
 	zip := newArchive(fs, time.Second)
 	err := zip.openArchive(ctx, srv.URL+"/public.zip")
 	require.NoError(t, err)
 
-	require.Len(t, zip.files, len(entries))
+	require.Equal(t, len(entries), zip.index.files)
 	require.Len(t, ranges, 3, "range requests should be minimal")
 
-	for _, zf := range zip.files {
-		if !zf.Mode().IsRegular() {
+	for i := 0; i < zip.index.len(); i++ {
+		if !zip.index.modes[i].IsRegular() {
 			continue
 		}
 
-		f, err := zip.Open(context.Background(), strings.TrimPrefix(zf.Name, "public/"))
+		f, err := zip.Open(context.Background(), zip.index.name(i))
 		require.NoError(t, err)
 
 		io.Copy(io.Discard, f)
@@ -462,7 +548,8 @@ func TestMinimalRangeRequests(t *testing.T) {
 	}
 
 	// ensure minimal requests: https://gitlab.com/gitlab-org/gitlab-pages/-/issues/625
-	require.Len(t, ranges, 11, "range requests should be minimal")
+	// the empty file is not read from the archive
+	require.Len(t, ranges, 10, "range requests should be minimal")
 }
 
 func openZipArchive(t *testing.T, requests *int64, fromDisk bool) (*zipArchive, func()) {
@@ -479,7 +566,7 @@ func openZipArchive(t *testing.T, requests *int64, fromDisk bool) (*zipArchive,
 
 	zipCfg.AllowedPaths = []string{wd}
 
-	fs := New(&zipCfg).(*zipVFS)
+	fs := New(&zipCfg)
 	err = fs.Reconfigure(&config.Config{Zip: zipCfg})
 	require.NoError(t, err)
 
@@ -498,7 +585,7 @@ func openZipArchive(t *testing.T, requests *int64, fromDisk bool) (*zipArchive,
 	// public/ public/index.html public/404.html public/symlink.html
 	// public/subdir/ public/subdir/hello.html public/subdir/linked.html
 	// public/bad_symlink.html public/subdir/2bp3Qzs...
-	require.NotZero(t, zip.files)
+	require.NotZero(t, zip.index.files)
 
 	return zip, func() {
 		cleanup()
@@ -555,7 +642,7 @@ func benchmarkArchiveRead(b *testing.B, size int64) {
 	ts := httptest.NewServer(m)
 	defer ts.Close()
 
-	fs := New(&zipCfg).(*zipVFS)
+	fs := New(&zipCfg)
 
 	b.ReportAllocs()
 	b.ResetTimer()
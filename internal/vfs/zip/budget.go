@@ -0,0 +1,157 @@
+package zip
+
+import (
+	"container/list"
+	"sync"
+	"unsafe"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// mapEntryCost is the estimated overhead of an entry of a map, on top of the
+// size of its key and value
+const mapEntryCost = 64
+
+// symlinkCost is the cost of a cached symlink without its target
+var symlinkCost = int64(mapEntryCost + unsafe.Sizeof(0) + unsafe.Sizeof(""))
+
+// checkpointsCost is the cost of the cached checkpoints of a deflated file
+// without the checkpoints themselves
+var checkpointsCost = int64(mapEntryCost + unsafe.Sizeof(0) + unsafe.Sizeof(&inflate.Index{}) + unsafe.Sizeof(inflate.Index{}))
+
+// budgetEntry is the cost of an archive accounted in a memoryBudget
+type budgetEntry struct {
+	archive archive
+	cost    int64
+	hits    int64
+}
+
+// memoryBudget accounts the estimated memory cost of the archives of the
+// cache. When the cost of the archives exceeds max, it elects the archives to
+// evict with its policy: the least recently used ones, or the least
+// frequently used ones with lfu, the least recently used one first among
+// the ones with as many hits. A max of 0 never elects any archive.
+type memoryBudget struct {
+	mux  sync.Mutex
+	max  int64
+	lfu  bool
+	used int64
+	// recency lists the entries from the most to the least recently used
+	recency *list.List
+	entries map[archive]*list.Element
+}
+
+func newMemoryBudget(max int64, policy string) *memoryBudget {
+	return &memoryBudget{
+		max:     max,
+		lfu:     policy == config.ZipEvictionLFU,
+		recency: list.New(),
+		entries: make(map[archive]*list.Element),
+	}
+}
+
+// add starts accounting a, which costs nothing until it is opened
+func (b *memoryBudget) add(a archive) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.entries[a] == nil {
+		b.entries[a] = b.recency.PushFront(&budgetEntry{archive: a, hits: 1})
+	}
+}
+
+// touch records a use of a
+func (b *memoryBudget) touch(a archive) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if element := b.entries[a]; element != nil {
+		element.Value.(*budgetEntry).hits++
+		b.recency.MoveToFront(element)
+	}
+}
+
+// grow adds cost to the cost of a, and returns the other archives to
+// evict for all of them to fit in the budget. The archives returned are no
+// longer accounted. Nothing is accounted for an archive that has been removed.
+func (b *memoryBudget) grow(a archive, cost int64) []archive {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	element := b.entries[a]
+	if element == nil {
+		return nil
+	}
+
+	element.Value.(*budgetEntry).cost += cost
+	b.used += cost
+	metrics.ZipCachedArchivesCost.Add(float64(cost))
+
+	var victims []archive
+
+	for b.max > 0 && b.used > b.max {
+		victim := b.victim(element)
+		if victim == nil {
+			break
+		}
+
+		victims = append(victims, victim.Value.(*budgetEntry).archive)
+		b.removeElement(victim)
+	}
+
+	return victims
+}
+
+// victim returns the entry to evict next, other than keep. The archives
+// still opening cost nothing and are never evicted.
+func (b *memoryBudget) victim(keep *list.Element) *list.Element {
+	var victim *list.Element
+
+	for element := b.recency.Back(); element != nil; element = element.Prev() {
+		entry := element.Value.(*budgetEntry)
+		if element == keep || entry.cost == 0 {
+			continue
+		}
+
+		if !b.lfu {
+			return element
+		}
+
+		if victim == nil || entry.hits < victim.Value.(*budgetEntry).hits {
+			victim = element
+		}
+	}
+
+	return victim
+}
+
+// remove stops accounting a
+func (b *memoryBudget) remove(a archive) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if element := b.entries[a]; element != nil {
+		b.removeElement(element)
+	}
+}
+
+// clear stops accounting all the archives, when the cache is reset
+func (b *memoryBudget) clear() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for element := b.recency.Front(); element != nil; element = b.recency.Front() {
+		b.removeElement(element)
+	}
+}
+
+func (b *memoryBudget) removeElement(element *list.Element) {
+	entry := element.Value.(*budgetEntry)
+
+	b.recency.Remove(element)
+	delete(b.entries, entry.archive)
+	b.used -= entry.cost
+	metrics.ZipCachedArchivesCost.Sub(float64(entry.cost))
+}
@@ -0,0 +1,149 @@
+package zip
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+func TestMemoryBudgetVictims(t *testing.T) {
+	tests := map[string]struct {
+		policy          string
+		max             int64
+		cost            int64
+		expectedVictims []string
+		expectedUsed    int64
+	}{
+		"lru evicts the least recently used archive": {
+			policy:          config.ZipEvictionLRU,
+			max:             250,
+			cost:            100,
+			expectedVictims: []string{"first"},
+			expectedUsed:    200,
+		},
+		"lru evicts as many archives as needed": {
+			policy:          config.ZipEvictionLRU,
+			max:             250,
+			cost:            200,
+			expectedVictims: []string{"first", "second"},
+			expectedUsed:    200,
+		},
+		"lfu evicts the least frequently used archive": {
+			policy:          config.ZipEvictionLFU,
+			max:             250,
+			cost:            100,
+			expectedVictims: []string{"second"},
+			expectedUsed:    200,
+		},
+		"no limit": {
+			policy:       config.ZipEvictionLRU,
+			cost:         100,
+			expectedUsed: 300,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := newMemoryBudget(tc.max, tc.policy)
+
+			archives := make(map[string]*zipArchive)
+			for _, key := range []string{"first", "second", "third"} {
+				archives[key] = &zipArchive{key: key}
+				b.add(archives[key])
+			}
+
+			require.Empty(t, b.grow(archives["first"], 100))
+			require.Empty(t, b.grow(archives["second"], 100))
+
+			// the first archive is used more often, but less recently
+			b.touch(archives["first"])
+			b.touch(archives["first"])
+			b.touch(archives["second"])
+
+			var victims []string
+			for _, victim := range b.grow(archives["third"], tc.cost) {
+				victims = append(victims, victim.cacheKey())
+			}
+
+			require.Equal(t, tc.expectedVictims, victims)
+			require.Equal(t, tc.expectedUsed, b.used)
+
+			// the victims are no longer accounted
+			for _, victim := range victims {
+				require.Empty(t, b.grow(archives[victim], 1000))
+			}
+		})
+	}
+}
+
+func TestMemoryBudgetNeverEvictsOpeningArchives(t *testing.T) {
+	b := newMemoryBudget(100, config.ZipEvictionLRU)
+
+	opening, opened := &zipArchive{key: "opening"}, &zipArchive{key: "opened"}
+	b.add(opening)
+	b.add(opened)
+
+	require.Empty(t, b.grow(opened, 1000), "the archive growing is not evicted")
+
+	b.remove(opened)
+	require.Zero(t, b.used)
+	require.Empty(t, b.entries[opened])
+}
+
+func TestVFSEvictsArchivesOverMemoryBudget(t *testing.T) {
+	u, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	cfg := zipCfg
+	cfg.MemoryBudget = 1
+	cfg.EvictionPolicy = config.ZipEvictionLRU
+
+	vfs := New(&cfg)
+
+	first, err := vfs.Root(context.Background(), u+"/public.zip", "first")
+	require.NoError(t, err)
+	require.NotZero(t, vfs.budget.used)
+
+	_, err = vfs.Root(context.Background(), u+"/public.zip", "second")
+	require.NoError(t, err)
+
+	_, found := vfs.cache.Get("first")
+	require.False(t, found, "the least recently used archive is evicted")
+
+	_, found = vfs.cache.Get("second")
+	require.True(t, found)
+
+	// the evicted archive keeps serving the requests that hold it
+	fi, err := first.Lstat(context.Background(), "index.html")
+	require.NoError(t, err)
+	require.Equal(t, "index.html", fi.Name())
+}
+
+func TestArchiveAccountsSymlinks(t *testing.T) {
+	u, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	vfs := New(&zipCfg)
+
+	root, err := vfs.Root(context.Background(), u+"/public.zip", "key")
+	require.NoError(t, err)
+
+	used := vfs.budget.used
+
+	f, err := root.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, used, vfs.budget.used, "the data offsets are part of the index")
+
+	target, err := root.Readlink(context.Background(), "symlink.html")
+	require.NoError(t, err)
+	require.Equal(t, used+symlinkCost+int64(len(target)), vfs.budget.used)
+
+	// the symlink is read once
+	_, err = root.Readlink(context.Background(), "symlink.html")
+	require.NoError(t, err)
+	require.Equal(t, used+symlinkCost+int64(len(target)), vfs.budget.used)
+}
@@ -0,0 +1,126 @@
+package zip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// The compression methods of the zip entries read by decompressors, which are
+// registered by neither archive/zip nor the zipArchive
+const (
+	methodBzip2 uint16 = 12
+	methodLZMA  uint16 = 14
+	methodZstd  uint16 = 93
+	methodXZ    uint16 = 95
+)
+
+const (
+	// maxDecoderWindowSize is the maximum size of the window of decompressed
+	// data kept by a decoder, the zstd window or the LZMA dictionary, which
+	// is otherwise sized from the headers of the compressed data
+	maxDecoderWindowSize = 8 << 20
+	// maxDecompressedSize is the maximum size of a file read by a
+	// decompressor
+	maxDecompressedSize = 1 << 30
+)
+
+var (
+	errLZMAProperties   = errors.New("decompress: invalid LZMA properties")
+	errDecompressedSize = errors.New("decompress: file too large")
+)
+
+// decompressors decompress the files of an archive compressed with the other
+// methods than Store and Deflate. A decompressor reads the compressed data of
+// a file of size from r.
+var decompressors = map[uint16]func(r io.Reader, size int64) (io.ReadCloser, error){
+	methodBzip2: func(r io.Reader, size int64) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	methodLZMA: newLZMAReader,
+	methodZstd: func(r io.Reader, size int64) (io.ReadCloser, error) {
+		// a single goroutine decodes the file synchronously while it is read
+		dr, err := zstd.NewReader(r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(maxDecoderWindowSize),
+			zstd.WithDecoderMaxMemory(maxDecoderWindowSize),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return dr.IOReadCloser(), nil
+	},
+	methodXZ: newXZReader,
+}
+
+// decompress returns a reader of the file of size compressed with method,
+// whose compressed data is read from r
+func decompress(method uint16, r io.Reader, size int64) (io.ReadCloser, error) {
+	decompressor := decompressors[method]
+	if decompressor == nil {
+		return nil, fmt.Errorf("unsupported compression method: %x", method)
+	}
+
+	// the size is checked before building the decoder, whose memory may
+	// depend on it
+	if size > maxDecompressedSize {
+		return nil, errDecompressedSize
+	}
+
+	return decompressor(r, size)
+}
+
+// newLZMAReader reads the LZMA data of a zip entry, which starts with the
+// version of the encoder and the properties of the stream. It is converted
+// into a .lzma stream whose header holds the properties and the size of the
+// file, the end of stream marker being optional.
+func newLZMAReader(r io.Reader, size int64) (io.ReadCloser, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint16(prefix[2:]) != 5 {
+		return nil, errLZMAProperties
+	}
+
+	header := make([]byte, lzma.HeaderLen)
+	if _, err := io.ReadFull(r, header[:5]); err != nil {
+		return nil, err
+	}
+
+	binary.LittleEndian.PutUint64(header[5:], uint64(size))
+
+	// the dictionary is allocated up to the smaller of its size in the header
+	// and the size of the file, which are both limited
+	lr, err := lzma.ReaderConfig{DictCap: maxDecoderWindowSize}.NewReader(io.MultiReader(bytes.NewReader(header), r))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(lr), nil
+}
+
+// decompressedFile is a file of an archive read by a decompressor
+type decompressedFile struct {
+	io.ReadCloser
+	section io.Closer
+}
+
+// Close closes the decompressor and the section of the file
+func (f *decompressedFile) Close() error {
+	err := f.ReadCloser.Close()
+
+	if sectionErr := f.section.Close(); err == nil {
+		err = sectionErr
+	}
+
+	return err
+}
@@ -0,0 +1,240 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// bzip2Data is "bzip2 compressed file\n" compressed by bzip2
+var bzip2Data = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x00, 0x10, 0xa2, 0xc7, 0x00, 0x00,
+	0x02, 0x59, 0x80, 0x00, 0x10, 0x40, 0x00, 0x10, 0x00, 0x1f, 0x26, 0xd8, 0x10, 0x20, 0x00, 0x31,
+	0x4c, 0x00, 0x13, 0x42, 0x83, 0x13, 0x26, 0x32, 0x8d, 0xc6, 0xa4, 0x55, 0xf0, 0x09, 0x26, 0xc8,
+	0xd9, 0xa8, 0x93, 0xf1, 0x77, 0x24, 0x53, 0x85, 0x09, 0x00, 0x01, 0x0a, 0x2c, 0x70,
+}
+
+func compressZstd(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	zw, err := zstd.NewWriter(&b)
+	require.NoError(t, err)
+
+	_, err = zw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return b.Bytes()
+}
+
+func compressXZ(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	xw, err := xz.NewWriter(&b)
+	require.NoError(t, err)
+
+	_, err = xw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, xw.Close())
+
+	return b.Bytes()
+}
+
+// compressLZMA compresses content the way zip archivers do, with the version
+// of the encoder and the properties of the stream followed by the stream
+// ended by an end of stream marker
+func compressLZMA(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	lw, err := lzma.WriterConfig{EOSMarker: true, Size: -1}.NewWriter(&b)
+	require.NoError(t, err)
+
+	_, err = lw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, lw.Close())
+
+	stream := b.Bytes()
+
+	return append([]byte{9, 20, 5, 0}, append(stream[:5:5], stream[lzma.HeaderLen:]...)...)
+}
+
+type rawFile struct {
+	name       string
+	method     uint16
+	mode       os.FileMode
+	content    string
+	compressed []byte
+	// size is the size of the file declared in its header, instead of the
+	// size of its content
+	size uint64
+}
+
+func openRawArchive(t *testing.T, files []rawFile) *zipArchive {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	zw := zip.NewWriter(&b)
+
+	for _, file := range files {
+		header := &zip.FileHeader{
+			Name:               "public/" + file.name,
+			Method:             file.method,
+			CRC32:              crc32.ChecksumIEEE([]byte(file.content)),
+			CompressedSize64:   uint64(len(file.compressed)),
+			UncompressedSize64: uint64(len(file.content)),
+		}
+		header.SetMode(file.mode)
+
+		if file.size > 0 {
+			header.UncompressedSize64 = file.size
+		}
+
+		w, err := zw.CreateRaw(header)
+		require.NoError(t, err)
+
+		_, err = w.Write(file.compressed)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, zw.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "public.zip", time.Time{}, bytes.NewReader(b.Bytes()))
+	}))
+	t.Cleanup(server.Close)
+
+	archive := newArchive(New(&zipCfg), time.Second)
+	require.NoError(t, archive.openArchive(context.Background(), server.URL+"/public.zip"))
+
+	return archive
+}
+
+func TestOpenDecompressedFiles(t *testing.T) {
+	content := string(compressibleData(10000))
+
+	files := []rawFile{
+		{name: "zstd.html", method: methodZstd, content: content, compressed: compressZstd(t, []byte(content))},
+		{name: "xz.html", method: methodXZ, content: content, compressed: compressXZ(t, []byte(content))},
+		{name: "lzma.html", method: methodLZMA, content: content, compressed: compressLZMA(t, []byte(content))},
+		{name: "bzip2.txt", method: methodBzip2, content: "bzip2 compressed file\n", compressed: bzip2Data},
+		{name: "unsupported.html", method: 98, content: content, compressed: []byte(content)},
+		{name: "zstd-symlink.html", method: methodZstd, mode: os.ModeSymlink | 0777, content: "zstd.html", compressed: compressZstd(t, []byte("zstd.html"))},
+		{name: "lzma-symlink.html", method: methodLZMA, mode: os.ModeSymlink | 0777, content: "lzma.html", compressed: compressLZMA(t, []byte("lzma.html"))},
+	}
+
+	archive := openRawArchive(t, files)
+
+	for _, file := range files {
+		t.Run(file.name, func(t *testing.T) {
+			if file.mode&os.ModeSymlink != 0 {
+				target, err := archive.Readlink(context.Background(), file.name)
+				require.NoError(t, err)
+				require.Equal(t, file.content, target)
+
+				return
+			}
+
+			f, err := archive.Open(context.Background(), file.name)
+			if file.method == 98 {
+				require.EqualError(t, err, "unsupported compression method: 62")
+				return
+			}
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, file.content, string(data))
+			require.NoError(t, f.Close())
+		})
+	}
+}
+
+func TestOpenLZMAFileWithInvalidProperties(t *testing.T) {
+	compressed := compressLZMA(t, []byte("lzma"))
+	compressed[2] = 4
+
+	archive := openRawArchive(t, []rawFile{
+		{name: "lzma.html", method: methodLZMA, content: "lzma", compressed: compressed},
+	})
+
+	_, err := archive.Open(context.Background(), "lzma.html")
+	require.ErrorIs(t, err, errLZMAProperties)
+}
+
+func TestOpenDecompressedFilesWithHostileHeaders(t *testing.T) {
+	// a LZMA stream whose dictionary is 1GiB
+	lzmaData := compressLZMA(t, []byte("lzma"))
+	binary.LittleEndian.PutUint32(lzmaData[5:], 1<<30)
+
+	// a zstd frame whose window is 1GiB
+	zstdData := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 20 << 3, 0x01, 0x00, 0x00}
+
+	// a xz stream whose first block has the largest LZMA2 dictionary
+	xzData := append([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x01}, 0x69, 0x22, 0xde, 0x36)
+	blockHeader := []byte{0x02, 0x00, 0x21, 0x01, 0x28, 0x00, 0x00, 0x00}
+	xzData = append(xzData, blockHeader...)
+	xzData = append(xzData, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(xzData[len(xzData)-4:], crc32.ChecksumIEEE(blockHeader))
+
+	archive := openRawArchive(t, []rawFile{
+		{name: "lzma.html", method: methodLZMA, content: "lzma", compressed: lzmaData, size: 512 << 20},
+		{name: "zstd.html", method: methodZstd, content: "zstd", compressed: zstdData},
+		{name: "xz.html", method: methodXZ, content: "xz", compressed: xzData},
+		{name: "large.html", method: methodZstd, content: "zstd", compressed: compressZstd(t, []byte("zstd")), size: maxDecompressedSize + 1},
+	})
+
+	tests := map[string]struct {
+		name        string
+		expectedErr string
+	}{
+		"lzma dictionary": {
+			name:        "lzma.html",
+			expectedErr: "exceeds configured dictionary capacity",
+		},
+		"zstd window": {
+			name:        "zstd.html",
+			expectedErr: zstd.ErrWindowSizeExceeded.Error(),
+		},
+		"xz dictionary": {
+			name:        "xz.html",
+			expectedErr: errXZDictionary.Error(),
+		},
+		"declared size": {
+			name:        "large.html",
+			expectedErr: errDecompressedSize.Error(),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := archive.Open(context.Background(), tt.name)
+			if err == nil {
+				_, err = io.ReadAll(f)
+				f.Close()
+			}
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
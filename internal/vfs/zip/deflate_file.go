@@ -0,0 +1,180 @@
+package zip
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+var (
+	errSeekInvalidWhence = errors.New("deflatefile: invalid whence")
+	errSeekNegative      = errors.New("deflatefile: negative position")
+)
+
+// deflateFile is a deflated file of an archive, which implements the
+// vfs.SeekableFile interface so that byte ranges of it can be served.
+// It is inflated with a deflateReader when it is read from its start, and
+// otherwise from the closest of the checkpoints of the file kept by the
+// archive, which are taken the first time the file is read from another
+// offset than its start.
+type deflateFile struct {
+	archive *zipArchive
+	file    int
+	size    int64
+
+	// section reads the compressed data of the file
+	section vfs.SeekableFile
+
+	// offset is the offset of the next byte to read, set by Seek
+	offset int64
+	// reader inflates the file from pos, it is nil until the file is read
+	reader io.Reader
+	pos    int64
+
+	// flateReader is the reader when the file is inflated from its start
+	flateReader *deflateReader
+	// index holds the checkpoints taken by the reader when the file is
+	// inflated from a checkpoint, which are added to the ones of the archive
+	index *inflate.Index
+
+	closed bool
+}
+
+func newDeflateFile(archive *zipArchive, file int, section vfs.SeekableFile) *deflateFile {
+	return &deflateFile{
+		archive: archive,
+		file:    file,
+		size:    archive.index.sizes[file],
+		section: section,
+	}
+}
+
+// Read inflates the file from its offset
+func (f *deflateFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, ErrClosedReader
+	}
+
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.reader == nil || f.pos != f.offset {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.reader.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+
+	return n, err
+}
+
+// Seek sets the offset of the next Read, the file is only inflated again
+// from that offset when it is read
+func (f *deflateFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errSeekInvalidWhence
+	}
+
+	if offset < 0 {
+		return 0, errSeekNegative
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Close stops inflating the file and closes its section
+func (f *deflateFile) Close() error {
+	if f.closed {
+		return ErrClosedReader
+	}
+
+	f.release()
+	f.closed = true
+
+	return f.section.Close()
+}
+
+// open starts inflating the file at its offset
+func (f *deflateFile) open() error {
+	f.release()
+
+	if f.offset == 0 {
+		if _, err := f.section.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		f.flateReader = newDeflateReader(io.NopCloser(f.section))
+		f.reader, f.pos = f.flateReader, 0
+
+		return nil
+	}
+
+	var c *inflate.Checkpoint
+	if index := f.archive.fileCheckpoints(f.file); index != nil {
+		c = index.Find(f.offset)
+		metrics.ZipCacheRequests.WithLabelValues("checkpoints", "hit").Inc()
+	} else {
+		metrics.ZipCacheRequests.WithLabelValues("checkpoints", "miss").Inc()
+	}
+
+	// inflate the file from its start when it has no checkpoint yet
+	if c == nil {
+		c = &inflate.Checkpoint{}
+	}
+
+	if _, err := f.section.Seek(c.In, io.SeekStart); err != nil {
+		return err
+	}
+
+	var inflater *inflate.Reader
+	if c.In == 0 {
+		inflater = inflate.NewReader(bufio.NewReader(f.section))
+	} else {
+		var err error
+		if inflater, err = inflate.Resume(bufio.NewReader(f.section), c); err != nil {
+			return err
+		}
+	}
+
+	f.index = inflater.Index()
+
+	if err := inflater.Skip(f.offset - c.Out); err != nil {
+		return err
+	}
+
+	f.reader, f.pos = inflater, f.offset
+
+	return nil
+}
+
+// release stops inflating the file, and adds the checkpoints taken while
+// inflating it to the ones of the archive
+func (f *deflateFile) release() {
+	if f.flateReader != nil {
+		f.flateReader.Close()
+		f.flateReader = nil
+	}
+
+	if f.index != nil {
+		f.archive.addCheckpoints(f.file, f.index)
+		f.index = nil
+	}
+
+	f.reader = nil
+}
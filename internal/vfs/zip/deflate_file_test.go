@@ -0,0 +1,176 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
+)
+
+// compressibleData returns compressible data of size, which is deflated in
+// several blocks
+func compressibleData(size int) []byte {
+	random := rand.New(rand.NewSource(int64(size)))
+	words := []string{"gitlab ", "pages ", "archive ", "zip ", "\n", "<html>", "</html>"}
+
+	var b bytes.Buffer
+	for b.Len() < size {
+		if random.Intn(10) == 0 {
+			b.WriteByte(byte(random.Intn(256)))
+			continue
+		}
+
+		b.WriteString(words[random.Intn(len(words))])
+	}
+
+	return b.Bytes()[:size]
+}
+
+func openDeflatedArchive(t *testing.T, content []byte, requests *int64) *zipArchive {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	zw := zip.NewWriter(&b)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "public/large.bin", Method: zip.Deflate})
+	require.NoError(t, err)
+
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		http.ServeContent(w, r, "public.zip", time.Time{}, bytes.NewReader(b.Bytes()))
+	}))
+	t.Cleanup(server.Close)
+
+	archive := newArchive(New(&zipCfg), time.Second)
+	require.NoError(t, archive.openArchive(context.Background(), server.URL+"/public.zip"))
+
+	return archive
+}
+
+func TestDeflateFileSeek(t *testing.T) {
+	content := compressibleData(3*inflate.CheckpointSpan + 1000)
+
+	var requests int64
+	archive := openDeflatedArchive(t, content, &requests)
+
+	file := archive.findFile("large.bin")
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		size   int
+	}{
+		{name: "start", offset: 0, whence: io.SeekStart, size: 100},
+		{name: "past the first checkpoint", offset: inflate.CheckpointSpan + 100, whence: io.SeekStart, size: 1000},
+		{name: "across checkpoints", offset: 2*inflate.CheckpointSpan - 500, whence: io.SeekStart, size: inflate.CheckpointSpan},
+		{name: "end", offset: -10, whence: io.SeekEnd, size: 10},
+		{name: "back before a checkpoint", offset: 200, whence: io.SeekStart, size: 1000},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := archive.Open(context.Background(), "large.bin")
+			require.NoError(t, err)
+			defer f.Close()
+
+			seeker, ok := f.(vfs.SeekableFile)
+			require.True(t, ok, "deflated files are seekable")
+
+			offset, err := seeker.Seek(tc.offset, tc.whence)
+			require.NoError(t, err)
+
+			data := make([]byte, tc.size)
+			_, err = io.ReadFull(f, data)
+			require.NoError(t, err)
+			require.Equal(t, content[offset:offset+int64(tc.size)], data)
+
+			// reading continues at the next offset
+			current, err := seeker.Seek(0, io.SeekCurrent)
+			require.NoError(t, err)
+			require.Equal(t, offset+int64(tc.size), current)
+		})
+	}
+
+	checkpoints := archive.fileCheckpoints(file)
+	require.NotNil(t, checkpoints)
+	require.GreaterOrEqual(t, checkpoints.Len(), 3, "the checkpoints of the file are kept")
+
+	// a read near the end of the file only inflates it from its last checkpoint
+	f, err := archive.Open(context.Background(), "large.bin")
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.(vfs.SeekableFile).Seek(-1, io.SeekEnd)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, content[len(content)-1:], data)
+	require.Same(t, checkpoints, archive.fileCheckpoints(file), "no checkpoint is taken twice")
+}
+
+func TestDeflateFileReadsPastEnd(t *testing.T) {
+	var requests int64
+	archive := openDeflatedArchive(t, compressibleData(1000), &requests)
+
+	f, err := archive.Open(context.Background(), "large.bin")
+	require.NoError(t, err)
+
+	seeker := f.(vfs.SeekableFile)
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), size)
+
+	opened := atomic.LoadInt64(&requests)
+
+	_, err = seeker.Seek(10, io.SeekEnd)
+	require.NoError(t, err)
+
+	n, err := f.Read(make([]byte, 10))
+	require.Zero(t, n)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, opened, atomic.LoadInt64(&requests), "the archive is not read")
+
+	_, err = seeker.Seek(-1, io.SeekStart)
+	require.Error(t, err)
+
+	require.NoError(t, f.Close())
+	require.ErrorIs(t, f.Close(), ErrClosedReader)
+}
+
+func TestArchiveAccountsCheckpoints(t *testing.T) {
+	var requests int64
+	archive := openDeflatedArchive(t, compressibleData(2*inflate.CheckpointSpan), &requests)
+	archive.fs.budget.add(archive)
+
+	f, err := archive.Open(context.Background(), "large.bin")
+	require.NoError(t, err)
+
+	_, err = f.(vfs.SeekableFile).Seek(inflate.CheckpointSpan, io.SeekStart)
+	require.NoError(t, err)
+
+	_, err = io.Copy(io.Discard, f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	checkpoints := archive.fileCheckpoints(archive.findFile("large.bin"))
+	require.NotNil(t, checkpoints)
+	require.Equal(t, checkpointsCost+checkpoints.Cost(), archive.fs.budget.used)
+}
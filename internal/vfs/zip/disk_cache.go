@@ -0,0 +1,398 @@
+package zip
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const diskCacheTempSuffix = ".tmp"
+
+var errChecksumMismatch = errors.New("archive checksum mismatch")
+
+// cachedFile is an archive opened from the disk cache. It stays open while
+// the archive is cached, and until its last reader is closed once the archive
+// is evicted.
+type cachedFile struct {
+	file *os.File
+
+	mux     sync.Mutex
+	readers int
+	evicted bool
+}
+
+// acquire adds a reader of the file, and returns false when the file has
+// been closed already
+func (f *cachedFile) acquire() bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.evicted && f.readers == 0 {
+		return false
+	}
+
+	f.readers++
+
+	return true
+}
+
+// release removes a reader of the file, closing it when it is the last
+// reader of an evicted archive
+func (f *cachedFile) release() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.readers--
+	f.closeIfUnused()
+}
+
+// evict closes the file once its readers are closed
+func (f *cachedFile) evict() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.evicted {
+		return
+	}
+
+	f.evicted = true
+	f.closeIfUnused()
+}
+
+func (f *cachedFile) closeIfUnused() {
+	if f.evicted && f.readers == 0 {
+		f.file.Close()
+	}
+}
+
+// ReadAt reads the file as a reader released once the read is done
+func (f *cachedFile) ReadAt(p []byte, off int64) (int, error) {
+	if !f.acquire() {
+		return 0, os.ErrClosed
+	}
+	defer f.release()
+
+	return f.file.ReadAt(p, off)
+}
+
+// section returns a reader of the size bytes of the file at offset, which
+// keeps the file open until it is closed
+func (f *cachedFile) section(offset, size int64) (*fileSectionReader, error) {
+	if !f.acquire() {
+		return nil, os.ErrClosed
+	}
+
+	return &fileSectionReader{SectionReader: io.NewSectionReader(f.file, offset, size), file: f}, nil
+}
+
+// fileSectionReader reads the section of a file of an archive opened from
+// the disk cache
+type fileSectionReader struct {
+	*io.SectionReader
+
+	file   *cachedFile
+	closed bool
+}
+
+func (r *fileSectionReader) Close() error {
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	r.closed = true
+	r.file.release()
+
+	return nil
+}
+
+// diskCacheEntry is an archive stored in the disk cache
+type diskCacheEntry struct {
+	key  string
+	size int64
+}
+
+// diskCache keeps whole archives in a local directory, named after their
+// SHA256 checksum, so that they are read from the disk instead of the object
+// storage after they are evicted from the memory cache or after a restart.
+// The least recently used archives are evicted when the archives exceed
+// maxSize. The archives are written to temporary files renamed once they are
+// complete and verified, so that a crash never leaves a partial archive
+// behind.
+type diskCache struct {
+	dir     string
+	maxSize int64
+
+	mux     sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	// filling are the keys of the archives being downloaded
+	filling map[string]bool
+}
+
+// newDiskCache returns the disk cache of dir, with the archives it already
+// contains ordered by their modification time
+func newDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating zip disk cache directory: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading zip disk cache directory: %w", err)
+	}
+
+	c := &diskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		filling: make(map[string]bool),
+	}
+
+	var infos []os.FileInfo
+
+	for _, file := range files {
+		// temporary files are left behind by downloads interrupted by a crash
+		if strings.HasSuffix(file.Name(), diskCacheTempSuffix) {
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+
+		if !validDiskCacheKey(file.Name()) || !file.Type().IsRegular() {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	// the most recently used archives are at the front of the list
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		c.entries[info.Name()] = c.lru.PushBack(&diskCacheEntry{key: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+
+	c.mux.Lock()
+	c.evict()
+	c.mux.Unlock()
+
+	return c, nil
+}
+
+// validDiskCacheKey returns true when key is a hex encoded SHA256 checksum,
+// which is safe to use as a file name
+func validDiskCacheKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(key)
+
+	return err == nil
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// open returns the archive of key and its size, or nil when it is not cached
+func (c *diskCache) open(key string) (*os.File, int64) {
+	c.mux.Lock()
+	element := c.entries[key]
+	if element != nil {
+		c.lru.MoveToFront(element)
+	}
+	c.mux.Unlock()
+
+	if element == nil {
+		metrics.ZipDiskCacheRequests.WithLabelValues("miss").Inc()
+		return nil, 0
+	}
+
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		// the archive has been removed from the directory
+		c.mux.Lock()
+		if c.entries[key] == element {
+			c.remove(element)
+			c.updateMetrics()
+		}
+		c.mux.Unlock()
+
+		metrics.ZipDiskCacheRequests.WithLabelValues("miss").Inc()
+		return nil, 0
+	}
+
+	// the modification time orders the archives after a restart
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+
+	metrics.ZipDiskCacheRequests.WithLabelValues("hit").Inc()
+
+	return file, element.Value.(*diskCacheEntry).size
+}
+
+// discard removes the archive of key, which could not be read
+func (c *diskCache) discard(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if element := c.entries[key]; element != nil {
+		c.remove(element)
+		c.updateMetrics()
+	}
+}
+
+// fill downloads the archive of resource into the cache, unless it is
+// already cached, being downloaded or larger than the cache. The checksum of
+// the downloaded archive must be key.
+func (c *diskCache) fill(ctx context.Context, key string, resource *httprange.Resource) {
+	size := resource.Size
+
+	c.mux.Lock()
+	if c.entries[key] != nil || c.filling[key] || size > c.maxSize {
+		c.mux.Unlock()
+		return
+	}
+
+	// the space of the archive is reserved while it is downloaded
+	c.filling[key] = true
+	c.size += size
+	c.evict()
+	c.mux.Unlock()
+
+	err := c.download(ctx, key, resource)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.filling, key)
+
+	if err != nil {
+		c.size -= size
+
+		result := "error"
+		if errors.Is(err, errChecksumMismatch) {
+			result = "checksum_mismatch"
+		}
+
+		metrics.ZipDiskCacheFills.WithLabelValues(result).Inc()
+		log.WithError(err).WithFields(log.Fields{
+			"archive_key": key,
+		}).Error("failed to download archive into the disk cache")
+
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&diskCacheEntry{key: key, size: size})
+	c.updateMetrics()
+
+	metrics.ZipDiskCacheFills.WithLabelValues("ok").Inc()
+}
+
+// download writes the archive of resource to the file of key
+func (c *diskCache) download(ctx context.Context, key string, resource *httprange.Resource) error {
+	tmp, err := os.CreateTemp(c.dir, key+".*"+diskCacheTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	reader := httprange.NewReader(ctx, resource, 0, resource.Size)
+	defer reader.Close()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tmp, hash), reader)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != key {
+		return errChecksumMismatch
+	}
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return err
+	}
+
+	// persist the rename
+	dir, err := os.Open(c.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// evict removes the least recently used archives until the archives and the
+// space reserved for the downloads fit in the cache. It must be called with
+// the lock held.
+func (c *diskCache) evict() {
+	for c.size > c.maxSize {
+		element := c.lru.Back()
+		if element == nil {
+			break
+		}
+
+		c.remove(element)
+	}
+
+	c.updateMetrics()
+}
+
+// remove removes the archive of element, which the archives opened from it
+// can still read until they are closed. It must be called with the lock
+// held.
+func (c *diskCache) remove(element *list.Element) {
+	entry := element.Value.(*diskCacheEntry)
+
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+
+	if err := os.Remove(c.path(entry.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).WithFields(log.Fields{
+			"archive_key": entry.key,
+		}).Error("failed to evict archive from the disk cache")
+	}
+}
+
+func (c *diskCache) updateMetrics() {
+	metrics.ZipDiskCacheSize.Set(float64(c.size))
+	metrics.ZipDiskCacheEntries.Set(float64(len(c.entries)))
+}
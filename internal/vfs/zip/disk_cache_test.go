@@ -0,0 +1,264 @@
+package zip
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+)
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func newDiskCacheResource(t *testing.T, content []byte) *httprange.Resource {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "public.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	resource, err := httprange.NewResource(context.Background(), server.URL+"/public.zip", http.DefaultClient)
+	require.NoError(t, err)
+
+	return resource
+}
+
+func readDiskCache(t *testing.T, c *diskCache, key string) []byte {
+	t.Helper()
+
+	file, size := c.open(key)
+	if file == nil {
+		return nil
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), size)
+
+	return content
+}
+
+func TestNewDiskCache(t *testing.T) {
+	dir := t.TempDir()
+
+	older, newer := []byte("older archive"), []byte("newer archive")
+	olderKey, newerKey := sha256Hex(older), sha256Hex(newer)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, olderKey), older, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, newerKey), newer, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, olderKey+".123"+diskCacheTempSuffix), []byte("partial"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unrelated"), []byte("unrelated"), 0600))
+
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, olderKey), past, past))
+
+	// only fits one of the archives
+	c, err := newDiskCache(dir, int64(len(newer)))
+	require.NoError(t, err)
+
+	require.Nil(t, readDiskCache(t, c, olderKey), "the least recently used archive is evicted")
+	require.Equal(t, newer, readDiskCache(t, c, newerKey))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+
+	require.ElementsMatch(t, []string{newerKey, "unrelated"}, names, "temporary files are removed")
+}
+
+func TestDiskCacheFill(t *testing.T) {
+	content := []byte("archive content")
+
+	tests := map[string]struct {
+		key             string
+		maxSize         int64
+		expectedContent []byte
+	}{
+		"cached":            {key: sha256Hex(content), maxSize: 1024, expectedContent: content},
+		"checksum mismatch": {key: sha256Hex([]byte("other content")), maxSize: 1024},
+		"larger than cache": {key: sha256Hex(content), maxSize: int64(len(content)) - 1},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			c, err := newDiskCache(dir, tc.maxSize)
+			require.NoError(t, err)
+
+			c.fill(context.Background(), tc.key, newDiskCacheResource(t, content))
+
+			require.Equal(t, tc.expectedContent, readDiskCache(t, c, tc.key))
+
+			files, err := os.ReadDir(dir)
+			require.NoError(t, err)
+
+			if tc.expectedContent == nil {
+				require.Empty(t, files)
+				require.Zero(t, c.size)
+			} else {
+				require.Len(t, files, 1)
+				require.Equal(t, int64(len(content)), c.size)
+			}
+		})
+	}
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	first, second, third := []byte("first archive"), []byte("second archive"), []byte("third archive")
+
+	// fits two of the archives
+	c, err := newDiskCache(t.TempDir(), int64(len(first)+len(second)))
+	require.NoError(t, err)
+
+	c.fill(context.Background(), sha256Hex(first), newDiskCacheResource(t, first))
+	c.fill(context.Background(), sha256Hex(second), newDiskCacheResource(t, second))
+
+	// the first archive becomes the most recently used one
+	require.Equal(t, first, readDiskCache(t, c, sha256Hex(first)))
+
+	c.fill(context.Background(), sha256Hex(third), newDiskCacheResource(t, third))
+
+	require.Equal(t, first, readDiskCache(t, c, sha256Hex(first)))
+	require.Nil(t, readDiskCache(t, c, sha256Hex(second)))
+	require.Equal(t, third, readDiskCache(t, c, sha256Hex(third)))
+	require.Equal(t, int64(len(first)+len(third)), c.size)
+}
+
+func TestVFSServesArchivesFromDiskCache(t *testing.T) {
+	var requests int64
+
+	u, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", &requests)
+	defer cleanup()
+
+	key := "d6b318b399cfe9a1c8483e49847ee49a2676d8cfd6df57ec64d971ad03640a75"
+
+	cfg := zipCfg
+	cfg.DiskCacheDir = t.TempDir()
+	cfg.DiskCacheMaxSize = 1024 * 1024
+
+	newVFS := func() *VFS {
+		vfs := New(&cfg)
+		require.NoError(t, vfs.Reconfigure(&config.Config{Zip: cfg}))
+
+		return vfs
+	}
+
+	_, err := newVFS().Root(context.Background(), u+"/public.zip", key)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(cfg.DiskCacheDir, key))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "the archive is downloaded into the disk cache")
+
+	// a new instance, like after a restart, opens the archive from the disk
+	atomic.StoreInt64(&requests, 0)
+
+	root, err := newVFS().Root(context.Background(), u+"/public.zip", key)
+	require.NoError(t, err)
+
+	f, err := root.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "zip.gitlab.io/project/index.html\n", string(content))
+
+	target, err := root.Readlink(context.Background(), "symlink.html")
+	require.NoError(t, err)
+	require.Equal(t, "subdir/linked.html", target)
+
+	require.Zero(t, atomic.LoadInt64(&requests))
+}
+
+func TestVFSDiscardsCorruptedArchivesFromDiskCache(t *testing.T) {
+	var requests int64
+
+	u, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", &requests)
+	defer cleanup()
+
+	key := "d6b318b399cfe9a1c8483e49847ee49a2676d8cfd6df57ec64d971ad03640a75"
+
+	cfg := zipCfg
+	cfg.DiskCacheDir = t.TempDir()
+	cfg.DiskCacheMaxSize = 1024 * 1024
+
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.DiskCacheDir, key), []byte("not a zip archive"), 0600))
+
+	vfs := New(&cfg)
+	require.NoError(t, vfs.Reconfigure(&config.Config{Zip: cfg}))
+
+	root, err := vfs.Root(context.Background(), u+"/public.zip", key)
+	require.NoError(t, err)
+
+	f, err := root.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "zip.gitlab.io/project/index.html\n", string(content))
+	require.NotZero(t, atomic.LoadInt64(&requests), "the archive is read from the object storage")
+
+	// the corrupted archive is replaced by the one downloaded again
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(filepath.Join(cfg.DiskCacheDir, key))
+		return err == nil && sha256Hex(content) == key
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCachedFileClosedOnceEvictedAndReleased(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "archive")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("archive content")
+	require.NoError(t, err)
+
+	f := &cachedFile{file: file}
+
+	section, err := f.section(0, 7)
+	require.NoError(t, err)
+
+	f.evict()
+
+	// the open section keeps the file open after it is evicted
+	content, err := io.ReadAll(section)
+	require.NoError(t, err)
+	require.Equal(t, "archive", string(content))
+
+	require.NoError(t, section.Close())
+	require.ErrorIs(t, section.Close(), os.ErrClosed)
+
+	_, err = file.Stat()
+	require.ErrorIs(t, err, os.ErrClosed, "the file is closed with its last section")
+
+	_, err = f.section(0, 7)
+	require.ErrorIs(t, err, os.ErrClosed)
+
+	_, err = f.ReadAt(make([]byte, 7), 0)
+	require.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,575 @@
+package zip
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	fileHeaderSignature      = 0x04034b50
+	directoryHeaderSignature = 0x02014b50
+	directoryEndSignature    = 0x06054b50
+	directory64LocSignature  = 0x07064b50
+	directory64EndSignature  = 0x06064b50
+
+	fileHeaderLen      = 30
+	directoryHeaderLen = 46
+	directoryEndLen    = 22
+	directory64LocLen  = 20
+	directory64EndLen  = 56
+
+	zip64ExtraID       = 0x0001
+	ntfsExtraID        = 0x000a
+	unixExtraID        = 0x000d
+	extTimeExtraID     = 0x5455
+	infoZipUnixExtraID = 0x5855
+
+	uint16max = 0xffff
+	uint32max = 0xffffffff
+
+	// directoryBufferSize is the size of the buffer the central directory is
+	// read with, which is not allocated from its size as it may be corrupted
+	directoryBufferSize = 64 * 1024
+
+	// ntfsEpochOffset is the number of 100ns intervals between the NTFS and
+	// the Unix epochs
+	ntfsEpochOffset = 116444736000000000
+
+	// indexEntryCost is the memory cost of an entry in the packed arrays of
+	// an index, without its name
+	indexEntryCost = 4 + 4 + 8 + 2 + 4 + 8 + 8 + 8 + 8
+)
+
+// index is the compact and immutable index of the entries of the public
+// directory of an archive, read from its central directory. The names of the
+// entries, relative to the public directory, are sorted and concatenated in
+// names, the other fields of an entry being at the same position of the
+// packed arrays. The parent directories of the entries missing from the
+// archive are indexed too, the public directory itself being named "".
+type index struct {
+	names    string
+	nameEnds []uint32
+
+	modes    []os.FileMode
+	modTimes []int64
+	methods  []uint16
+	crc32s   []uint32
+
+	headerOffsets   []int64
+	compressedSizes []int64
+	sizes           []int64
+	// dataOffsets are read from the local headers of the files on first use,
+	// 0 until then, and accessed atomically
+	dataOffsets []int64
+
+	// files is the number of entries which are not directories
+	files int
+}
+
+// indexEntry is an entry of the central directory while the index is built
+type indexEntry struct {
+	name           string
+	mode           os.FileMode
+	modTime        int64
+	method         uint16
+	crc32          uint32
+	headerOffset   int64
+	compressedSize int64
+	size           int64
+}
+
+// fileInfo is the os.FileInfo of an entry of an index
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// readIndex reads the central directory of the archive of size bytes read by
+// r. The directory is read sequentially, so that the entries are not copied
+// from archive/zip's File headers, which keep more fields than the index, and
+// that a corrupted directory size never allocates more than the buffer.
+func readIndex(r io.ReaderAt, size int64) (*index, error) {
+	records, dirSize, dirOffset, baseOffset, err := readDirectoryEnd(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if dirSize > size-dirOffset {
+		return nil, zip.ErrFormat
+	}
+
+	dir := bufio.NewReaderSize(io.NewSectionReader(r, dirOffset, dirSize), directoryBufferSize)
+	buf := make([]byte, directoryHeaderLen)
+
+	var entries []indexEntry
+	var count uint64
+
+	for {
+		if _, err := dir.Peek(1); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if buf, err = nextDirectoryHeader(dir, buf); err != nil {
+			return nil, err
+		}
+
+		entry, _, err := readDirectoryHeader(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		count++
+
+		if !strings.HasPrefix(entry.name, dirPrefix) {
+			continue
+		}
+
+		entry.name = strings.TrimSuffix(strings.TrimPrefix(entry.name, dirPrefix), "/")
+		entry.headerOffset += baseOffset
+		entries = append(entries, entry)
+	}
+
+	// the number of records is truncated to 16 bits in archives with more
+	// records which are not zip64 archives
+	if uint16(count) != uint16(records) {
+		return nil, zip.ErrFormat
+	}
+
+	return newIndex(entries), nil
+}
+
+// readDirectoryEnd returns the number of records, the size and the offset of
+// the central directory from the end of central directory record, which is
+// looked for in the last 1KiB of the archive and then in the last 65KiB, and
+// the offset of the archive data shifting the offsets of the directory
+func readDirectoryEnd(r io.ReaderAt, size int64) (records uint64, dirSize, dirOffset, baseOffset int64, err error) {
+	var buf []byte
+	var bufOffset, endOffset int64
+
+	for i, bufSize := range []int64{1024, 65 * 1024} {
+		if bufSize > size {
+			bufSize = size
+		}
+
+		buf = make([]byte, bufSize)
+		bufOffset = size - bufSize
+
+		if _, err := r.ReadAt(buf, bufOffset); err != nil && !errors.Is(err, io.EOF) {
+			return 0, 0, 0, 0, err
+		}
+
+		if p := findDirectoryEnd(buf); p >= 0 {
+			endOffset = bufOffset + int64(p)
+			break
+		}
+
+		if i == 1 || bufSize == size {
+			return 0, 0, 0, 0, zip.ErrFormat
+		}
+	}
+
+	end := buf[endOffset-bufOffset:]
+
+	records = uint64(binary.LittleEndian.Uint16(end[10:]))
+	size32 := binary.LittleEndian.Uint32(end[12:])
+	offset32 := binary.LittleEndian.Uint32(end[16:])
+
+	dirSize, dirOffset = int64(size32), int64(offset32)
+
+	if records == uint16max || size32 == uint32max || offset32 == uint32max {
+		offset, records64, dirSize64, dirOffset64, err := readDirectory64End(r, buf, bufOffset, endOffset)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+
+		// archives with as many records without the zip64 records are valid
+		if offset >= 0 {
+			endOffset, records, dirSize, dirOffset = offset, records64, dirSize64, dirOffset64
+		}
+	}
+
+	// the archive may be prefixed with data, like self-extracting archives,
+	// which shifts all the offsets of the directory
+	baseOffset = endOffset - dirSize - dirOffset
+	if baseOffset < 0 || dirSize < 0 || dirOffset < 0 || baseOffset+dirOffset >= size {
+		return 0, 0, 0, 0, zip.ErrFormat
+	}
+
+	return records, dirSize, baseOffset + dirOffset, baseOffset, nil
+}
+
+// findDirectoryEnd returns the position of the end of central directory
+// record in buf, or -1
+func findDirectoryEnd(buf []byte) int {
+	for i := len(buf) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) != directoryEndSignature {
+			continue
+		}
+
+		// the comment of the archive must fit after the record
+		if commentLen := int(binary.LittleEndian.Uint16(buf[i+20:])); i+directoryEndLen+commentLen <= len(buf) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// readDirectory64End returns the offset of the zip64 end of central directory
+// record, and the number of records, the size and the offset of the central
+// directory it holds, or an offset of -1 when the archive has no zip64 end of
+// central directory locator. The locator precedes the end of central
+// directory record at endOffset, and is read from buf when it holds it.
+func readDirectory64End(r io.ReaderAt, buf []byte, bufOffset, endOffset int64) (int64, uint64, int64, int64, error) {
+	locOffset := endOffset - directory64LocLen
+	if locOffset < 0 {
+		return -1, 0, 0, 0, nil
+	}
+
+	var loc []byte
+	if locOffset >= bufOffset {
+		loc = buf[locOffset-bufOffset : endOffset-bufOffset]
+	} else {
+		loc = make([]byte, directory64LocLen)
+		if _, err := r.ReadAt(loc, locOffset); err != nil {
+			return 0, 0, 0, 0, err
+		}
+	}
+
+	if binary.LittleEndian.Uint32(loc) != directory64LocSignature {
+		return -1, 0, 0, 0, nil
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(loc[8:]))
+	if offset < 0 || offset > locOffset-directory64EndLen {
+		return 0, 0, 0, 0, zip.ErrFormat
+	}
+
+	end := make([]byte, directory64EndLen)
+	if _, err := r.ReadAt(end, offset); err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	if binary.LittleEndian.Uint32(end) != directory64EndSignature {
+		return 0, 0, 0, 0, zip.ErrFormat
+	}
+
+	records := binary.LittleEndian.Uint64(end[32:])
+	dirSize := int64(binary.LittleEndian.Uint64(end[40:]))
+	dirOffset := int64(binary.LittleEndian.Uint64(end[48:]))
+
+	return offset, records, dirSize, dirOffset, nil
+}
+
+// nextDirectoryHeader reads the next central directory header of dir into
+// buf, which is grown when the header does not fit
+func nextDirectoryHeader(dir io.Reader, buf []byte) ([]byte, error) {
+	buf = buf[:directoryHeaderLen]
+	if _, err := io.ReadFull(dir, buf); err != nil {
+		return nil, directoryReadError(err)
+	}
+
+	if binary.LittleEndian.Uint32(buf) != directoryHeaderSignature {
+		return nil, zip.ErrFormat
+	}
+
+	n := directoryHeaderLen +
+		int(binary.LittleEndian.Uint16(buf[28:])) +
+		int(binary.LittleEndian.Uint16(buf[30:])) +
+		int(binary.LittleEndian.Uint16(buf[32:]))
+
+	if cap(buf) < n {
+		buf = append(buf, make([]byte, n-len(buf))...)
+	}
+
+	buf = buf[:n]
+	if _, err := io.ReadFull(dir, buf[directoryHeaderLen:]); err != nil {
+		return nil, directoryReadError(err)
+	}
+
+	return buf, nil
+}
+
+// directoryReadError returns zip.ErrFormat when the central directory ends
+// before the end of a header
+func directoryReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return zip.ErrFormat
+	}
+
+	return err
+}
+
+// readDirectoryHeader reads the central directory header at the start of
+// buf, and returns its entry and length
+func readDirectoryHeader(buf []byte) (indexEntry, int, error) {
+	if len(buf) < directoryHeaderLen || binary.LittleEndian.Uint32(buf) != directoryHeaderSignature {
+		return indexEntry{}, 0, zip.ErrFormat
+	}
+
+	nameLen := int(binary.LittleEndian.Uint16(buf[28:]))
+	extraLen := int(binary.LittleEndian.Uint16(buf[30:]))
+	commentLen := int(binary.LittleEndian.Uint16(buf[32:]))
+
+	n := directoryHeaderLen + nameLen + extraLen + commentLen
+	if len(buf) < n {
+		return indexEntry{}, 0, zip.ErrFormat
+	}
+
+	name := string(buf[directoryHeaderLen : directoryHeaderLen+nameLen])
+	extra := buf[directoryHeaderLen+nameLen : directoryHeaderLen+nameLen+extraLen]
+
+	header := zip.FileHeader{
+		Name:           name,
+		CreatorVersion: binary.LittleEndian.Uint16(buf[4:]),
+		ExternalAttrs:  binary.LittleEndian.Uint32(buf[38:]),
+	}
+
+	compressedSize := uint64(binary.LittleEndian.Uint32(buf[20:]))
+	size := uint64(binary.LittleEndian.Uint32(buf[24:]))
+	headerOffset := uint64(binary.LittleEndian.Uint32(buf[42:]))
+
+	modTime := msDosTimeToTime(binary.LittleEndian.Uint16(buf[14:]), binary.LittleEndian.Uint16(buf[12:]))
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		fieldLen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+fieldLen {
+			return indexEntry{}, 0, zip.ErrFormat
+		}
+
+		field := extra[4 : 4+fieldLen]
+		extra = extra[4+fieldLen:]
+
+		switch id {
+		case zip64ExtraID:
+			// the zip64 values are present in this order when their 32 bits
+			// values are saturated
+			for _, value := range []*uint64{&size, &compressedSize, &headerOffset} {
+				if *value != uint32max {
+					continue
+				}
+
+				if len(field) < 8 {
+					return indexEntry{}, 0, zip.ErrFormat
+				}
+
+				*value = binary.LittleEndian.Uint64(field)
+				field = field[8:]
+			}
+
+		case ntfsExtraID:
+			if len(field) >= 32 && binary.LittleEndian.Uint16(field[4:]) == 1 && binary.LittleEndian.Uint16(field[6:]) == 24 {
+				ticks := int64(binary.LittleEndian.Uint64(field[8:]))
+				modTime = time.Unix(0, (ticks-ntfsEpochOffset)*100)
+			}
+
+		case unixExtraID, infoZipUnixExtraID:
+			if len(field) >= 8 {
+				modTime = time.Unix(int64(binary.LittleEndian.Uint32(field[4:])), 0)
+			}
+
+		case extTimeExtraID:
+			if len(field) >= 5 && field[0]&1 != 0 {
+				modTime = time.Unix(int64(int32(binary.LittleEndian.Uint32(field[1:]))), 0)
+			}
+		}
+	}
+
+	if compressedSize > 1<<63-1 || size > 1<<63-1 || headerOffset > 1<<63-1 {
+		return indexEntry{}, 0, zip.ErrFormat
+	}
+
+	return indexEntry{
+		name:           name,
+		mode:           header.Mode(),
+		modTime:        modTime.Unix(),
+		method:         binary.LittleEndian.Uint16(buf[10:]),
+		crc32:          binary.LittleEndian.Uint32(buf[16:]),
+		headerOffset:   int64(headerOffset),
+		compressedSize: int64(compressedSize),
+		size:           int64(size),
+	}, n, nil
+}
+
+// msDosTimeToTime converts an MS-DOS date and time into a time.Time, in UTC
+// as MS-DOS times have no time zone
+func msDosTimeToTime(dosDate, dosTime uint16) time.Time {
+	return time.Date(
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0,
+		time.UTC,
+	)
+}
+
+// newIndex packs entries and their missing parent directories into an index.
+// The files of entries take precedence over the directories of the same
+// name, and the first of the entries of the same name over the others.
+func newIndex(entries []indexEntry) *index {
+	if len(entries) > 0 {
+		seen := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			seen[entry.name] = true
+		}
+
+		for _, entry := range entries {
+			for dir := entry.name; dir != ""; {
+				if dir = path.Dir(dir); dir == "." {
+					dir = ""
+				}
+
+				if seen[dir] {
+					break
+				}
+
+				seen[dir] = true
+				entries = append(entries, indexEntry{
+					name: dir,
+					mode: (&zip.FileHeader{Name: dirPrefix + dir + "/"}).Mode(),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+
+		return !entries[i].mode.IsDir() && entries[j].mode.IsDir()
+	})
+
+	idx := &index{}
+
+	var names strings.Builder
+
+	for i, entry := range entries {
+		if i > 0 && entry.name == entries[i-1].name {
+			continue
+		}
+
+		names.WriteString(entry.name)
+
+		idx.nameEnds = append(idx.nameEnds, uint32(names.Len()))
+		idx.modes = append(idx.modes, entry.mode)
+		idx.modTimes = append(idx.modTimes, entry.modTime)
+		idx.methods = append(idx.methods, entry.method)
+		idx.crc32s = append(idx.crc32s, entry.crc32)
+		idx.headerOffsets = append(idx.headerOffsets, entry.headerOffset)
+		idx.compressedSizes = append(idx.compressedSizes, entry.compressedSize)
+		idx.sizes = append(idx.sizes, entry.size)
+
+		if !entry.mode.IsDir() {
+			idx.files++
+		}
+	}
+
+	idx.names = names.String()
+	idx.dataOffsets = make([]int64, len(idx.nameEnds))
+
+	return idx
+}
+
+// len returns the number of entries of the index
+func (idx *index) len() int {
+	return len(idx.nameEnds)
+}
+
+// cost returns the estimated memory cost of the index
+func (idx *index) cost() int64 {
+	return int64(len(idx.names)) + int64(idx.len())*indexEntryCost
+}
+
+// name returns the name of the entry i
+func (idx *index) name(i int) string {
+	var start uint32
+	if i > 0 {
+		start = idx.nameEnds[i-1]
+	}
+
+	return idx.names[start:idx.nameEnds[i]]
+}
+
+// find returns the position of the entry of the cleaned path of name in the
+// public directory, or -1
+func (idx *index) find(name string) int {
+	name = path.Clean(dirPrefix + name)
+
+	switch {
+	case name+"/" == dirPrefix:
+		name = ""
+	case strings.HasPrefix(name, dirPrefix):
+		name = name[len(dirPrefix):]
+	default:
+		return -1
+	}
+
+	i := sort.Search(idx.len(), func(i int) bool {
+		return idx.name(i) >= name
+	})
+
+	if i < idx.len() && idx.name(i) == name {
+		return i
+	}
+
+	return -1
+}
+
+// fileInfo returns the os.FileInfo of the entry i
+func (idx *index) fileInfo(i int) os.FileInfo {
+	name := path.Base(dirPrefix + idx.name(i))
+
+	return &fileInfo{
+		name:    name,
+		size:    idx.sizes[i],
+		mode:    idx.modes[i],
+		modTime: time.Unix(idx.modTimes[i], 0).UTC(),
+	}
+}
+
+// dataOffset returns the data offset of the file i read from its local
+// header, or 0 when it has not been read yet
+func (idx *index) dataOffset(i int) int64 {
+	return atomic.LoadInt64(&idx.dataOffsets[i])
+}
+
+// setDataOffset sets the data offset of the file i, which local header
+// starts with header
+func (idx *index) setDataOffset(i int, header []byte) (int64, error) {
+	if len(header) < fileHeaderLen || binary.LittleEndian.Uint32(header) != fileHeaderSignature {
+		return 0, zip.ErrFormat
+	}
+
+	nameLen := int64(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(header[28:]))
+
+	dataOffset := idx.headerOffsets[i] + fileHeaderLen + nameLen + extraLen
+	atomic.StoreInt64(&idx.dataOffsets[i], dataOffset)
+
+	return dataOffset, nil
+}
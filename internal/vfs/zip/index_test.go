@@ -0,0 +1,261 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// createIndexedArchive returns a zip archive of the headers, with their name
+// as content
+func createIndexedArchive(t *testing.T, headers ...*zip.FileHeader) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	zw := zip.NewWriter(&b)
+
+	for _, header := range headers {
+		w, err := zw.CreateHeader(header)
+		require.NoError(t, err)
+
+		if !header.Mode().IsDir() {
+			_, err = w.Write([]byte(header.Name))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, zw.Close())
+
+	return b.Bytes()
+}
+
+// requireIndexMatches checks that the index of content matches the central
+// directory read by archive/zip
+func requireIndexMatches(t *testing.T, content []byte) *index {
+	t.Helper()
+
+	idx, err := readIndex(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	for _, file := range archive.File {
+		if !strings.HasPrefix(file.Name, dirPrefix) {
+			require.Equal(t, -1, idx.find("../"+file.Name), "only the public directory is indexed")
+			continue
+		}
+
+		i := idx.find(file.Name[len(dirPrefix):])
+		require.GreaterOrEqual(t, i, 0, file.Name)
+
+		dataOffset, err := file.DataOffset()
+		require.NoError(t, err)
+
+		fi := idx.fileInfo(i)
+		require.Equal(t, file.FileInfo().Name(), fi.Name())
+		require.Equal(t, file.Mode(), fi.Mode())
+		require.Equal(t, file.Modified.Unix(), fi.ModTime().Unix())
+		require.Equal(t, int64(file.UncompressedSize64), fi.Size())
+		require.Equal(t, file.Method, idx.methods[i])
+		require.Equal(t, file.CRC32, idx.crc32s[i])
+		require.Equal(t, int64(file.CompressedSize64), idx.compressedSizes[i])
+
+		var header [fileHeaderLen]byte
+		_, err = bytes.NewReader(content).ReadAt(header[:], idx.headerOffsets[i])
+		require.NoError(t, err)
+
+		offset, err := idx.setDataOffset(i, header[:])
+		require.NoError(t, err)
+		require.Equal(t, dataOffset, offset)
+		require.Equal(t, offset, idx.dataOffset(i))
+	}
+
+	return idx
+}
+
+func TestReadIndex(t *testing.T) {
+	modified := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	headers := []*zip.FileHeader{
+		{Name: "public/", Modified: modified},
+		{Name: "public/index.html", Method: zip.Deflate, Modified: modified},
+		{Name: "public/stored.html", Method: zip.Store, Modified: modified},
+		{Name: "public/a/b/c.html", Method: zip.Deflate},
+		{Name: "public/a/b/d.html", Method: zip.Deflate},
+		{Name: "not-public/secret.html", Method: zip.Deflate},
+	}
+
+	headers[2].SetMode(0600)
+
+	content := createIndexedArchive(t, headers...)
+
+	t.Run("archive", func(t *testing.T) {
+		idx := requireIndexMatches(t, content)
+
+		require.Equal(t, 4, idx.files)
+		require.Equal(t, []string{"", "a", "a/b", "a/b/c.html", "a/b/d.html", "index.html", "stored.html"}, indexNames(idx))
+	})
+
+	t.Run("prefixed archive", func(t *testing.T) {
+		prefix := []byte("#!/bin/sh\nexit 0\n")
+
+		requireIndexMatches(t, append(prefix, content...))
+	})
+}
+
+func TestReadIndexZip64(t *testing.T) {
+	var headers []*zip.FileHeader
+	for i := 0; i < 1<<16+1; i++ {
+		headers = append(headers, &zip.FileHeader{Name: fmt.Sprintf("public/%d", i), Method: zip.Store})
+	}
+
+	content := createIndexedArchive(t, headers...)
+
+	idx := requireIndexMatches(t, content)
+	require.Equal(t, len(headers), idx.files)
+}
+
+func TestIndexFind(t *testing.T) {
+	idx := requireIndexMatches(t, createIndexedArchive(t,
+		&zip.FileHeader{Name: "public/index.html"},
+		&zip.FileHeader{Name: "public/subdir/index.html"},
+		&zip.FileHeader{Name: "publicity.html"},
+	))
+
+	tests := map[string]struct {
+		name         string
+		expectedName string
+		expectedMode fs.FileMode
+	}{
+		"file":                 {name: "index.html", expectedName: "index.html"},
+		"file in subdir":       {name: "subdir/index.html", expectedName: "index.html"},
+		"unclean path":         {name: "./subdir//../index.html", expectedName: "index.html"},
+		"implicit directory":   {name: "subdir/", expectedName: "subdir", expectedMode: fs.ModeDir},
+		"root":                 {name: "", expectedName: "public", expectedMode: fs.ModeDir},
+		"root slash":           {name: "/", expectedName: "public", expectedMode: fs.ModeDir},
+		"missing file":         {name: "missing.html"},
+		"outside public":       {name: "../publicity.html"},
+		"traversal to sibling": {name: "../not-public/index.html"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			i := idx.find(tc.name)
+			if tc.expectedName == "" {
+				require.Equal(t, -1, i)
+				return
+			}
+
+			require.GreaterOrEqual(t, i, 0)
+
+			fi := idx.fileInfo(i)
+			require.Equal(t, tc.expectedName, fi.Name())
+			require.Equal(t, tc.expectedMode, fi.Mode()&fs.ModeDir)
+		})
+	}
+}
+
+func TestReadIndexFails(t *testing.T) {
+	content := createIndexedArchive(t, &zip.FileHeader{Name: "public/index.html"})
+
+	tests := map[string][]byte{
+		"empty":                 {},
+		"not an archive":        bytes.Repeat([]byte("not an archive"), 100),
+		"truncated archive":     content[:len(content)-1],
+		"truncated directory":   content[len(content)-directoryEndLen-10:],
+		"corrupted directory":   append(bytes.Repeat([]byte{0}, len(content)-directoryEndLen), content[len(content)-directoryEndLen:]...),
+		"without end signature": content[:len(content)-directoryEndLen],
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readIndex(bytes.NewReader(content), int64(len(content)))
+			require.ErrorIs(t, err, zip.ErrFormat)
+		})
+	}
+}
+
+func TestFixturesIndex(t *testing.T) {
+	for _, name := range []string{"public.zip", "public-without-dirs.zip"} {
+		t.Run(name, func(t *testing.T) {
+			content, err := os.ReadFile("../../../shared/pages/group/zip.gitlab.io/" + name)
+			require.NoError(t, err)
+
+			requireIndexMatches(t, content)
+		})
+	}
+}
+
+// BenchmarkReadIndex compares readIndex with an index built from the File
+// headers of archive/zip, which does not export the offsets of the local
+// headers, so that their data offsets are read upfront
+func BenchmarkReadIndex(b *testing.B) {
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < 10000; i++ {
+		w, err := zw.Create(fmt.Sprintf("public/dir%d/file%d.html", i%100, i))
+		require.NoError(b, err)
+
+		_, err = w.Write([]byte("content"))
+		require.NoError(b, err)
+	}
+	require.NoError(b, zw.Close())
+
+	content := buf.Bytes()
+
+	b.Run("readIndex", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for n := 0; n < b.N; n++ {
+			_, err := readIndex(bytes.NewReader(content), int64(len(content)))
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("archive/zip", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for n := 0; n < b.N; n++ {
+			archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+			require.NoError(b, err)
+
+			var entries []indexEntry
+			for _, file := range archive.File {
+				dataOffset, err := file.DataOffset()
+				require.NoError(b, err)
+
+				entries = append(entries, indexEntry{
+					name:           strings.TrimPrefix(file.Name, dirPrefix),
+					mode:           file.Mode(),
+					modTime:        file.Modified.Unix(),
+					method:         file.Method,
+					crc32:          file.CRC32,
+					headerOffset:   dataOffset,
+					compressedSize: int64(file.CompressedSize64),
+					size:           int64(file.UncompressedSize64),
+				})
+			}
+
+			newIndex(entries)
+		}
+	})
+}
+
+func indexNames(idx *index) []string {
+	var names []string
+	for i := 0; i < idx.len(); i++ {
+		names = append(names, idx.name(i))
+	}
+
+	return names
+}
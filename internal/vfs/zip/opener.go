@@ -0,0 +1,103 @@
+package zip
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+type archiveStatus int
+
+const (
+	archiveOpening archiveStatus = iota
+	archiveOpenError
+	archiveOpened
+	archiveCorrupted
+)
+
+// archive is an archive of the cache of a VFS, which is opened once and
+// accounted in its memory budget
+type archive interface {
+	vfs.Root
+
+	cacheKey() string
+	openArchive(ctx context.Context, url string) error
+	openStatus() (archiveStatus, error)
+	onEvicted()
+}
+
+// newArchiveFunc returns a new archive of the cache of fs keyed by key
+type newArchiveFunc func(fs *VFS, key string) archive
+
+// archiveOpener opens an archive once in its own goroutine, the requests
+// waiting for the archive up to openTimeout
+type archiveOpener struct {
+	once        sync.Once
+	done        chan struct{}
+	openTimeout time.Duration
+
+	resource *httprange.Resource
+	err      error
+}
+
+// open reads the archive of url with read once, and waits for it to be done
+// or for ctx to be canceled. read must close done once the archive is read.
+func (o *archiveOpener) open(parentCtx context.Context, url, format string, read func(url string)) error {
+	// always try to update URL on resource
+	if o.resource != nil {
+		o.resource.SetURL(url)
+	}
+
+	// return early if openArchive was done already in a concurrent request
+	if status, err := o.openStatus(); status != archiveOpening {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, o.openTimeout)
+	defer cancel()
+
+	o.once.Do(func() {
+		// read archive once in its own routine with its own timeout
+		// if parentCtx is canceled, read will continue regardless and will be cached in memory
+		go read(url)
+	})
+
+	// wait for read to be done or return if the parent context is canceled
+	select {
+	case <-o.done:
+		return o.err
+	case <-ctx.Done():
+		err := ctx.Err()
+		if errors.Is(err, context.Canceled) {
+			log.ContextLogger(parentCtx).WithError(err).Tracef("open %s archive request canceled", format)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.ContextLogger(parentCtx).WithError(err).Tracef("open %s archive timed out", format)
+		}
+
+		return err
+	}
+}
+
+func (o *archiveOpener) openStatus() (archiveStatus, error) {
+	select {
+	case <-o.done:
+		if o.err != nil {
+			return archiveOpenError, o.err
+		}
+
+		if o.resource != nil && o.resource.Err() != nil {
+			return archiveCorrupted, o.resource.Err()
+		}
+
+		return archiveOpened, nil
+
+	default:
+		return archiveOpening, nil
+	}
+}
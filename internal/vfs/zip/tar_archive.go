@@ -0,0 +1,367 @@
+package zip
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unsafe"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// minSeekDistance is the minimum size of the entries skipped with a new
+// range request while indexing an uncompressed tar archive, smaller entries
+// are read through as it is cheaper than a new request
+const minSeekDistance = 1024 * 1024
+
+// tarEntryCost is the cost of an entry of a tar archive without its path and
+// the target of its link
+var tarEntryCost = int64(mapEntryCost + unsafe.Sizeof("") + unsafe.Sizeof(&tarEntry{}) + unsafe.Sizeof(tarEntry{}))
+
+// tarEntry is a file, directory or symlink of a tar archive. It implements
+// os.FileInfo.
+type tarEntry struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	// offset is the offset of the content of the entry in the tar stream
+	offset int64
+	// link is the target of a symlink
+	link string
+}
+
+func (e *tarEntry) Name() string       { return e.name }
+func (e *tarEntry) Size() int64        { return e.size }
+func (e *tarEntry) Mode() os.FileMode  { return e.mode }
+func (e *tarEntry) ModTime() time.Time { return e.modTime }
+func (e *tarEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *tarEntry) Sys() interface{}   { return nil }
+
+// tarArchive implements the vfs.Root interface.
+// It represents a tar archive, optionally compressed with gzip, the entries
+// of which are indexed in memory. The content of the entries of uncompressed
+// archives is read with range requests of the httprange.Resource, while the
+// ones of gzip compressed archives are inflated from the closest checkpoint
+// of their index.
+type tarArchive struct {
+	fs *VFS
+
+	archiveOpener
+
+	// key is the SHA256 checksum of the archive, which keys the cache
+	key string
+	// budget accounts the memory cost of the archive
+	budget *memoryBudget
+
+	// ctx is canceled once the archive is evicted, to stop indexing it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// index is the index of the gzip stream of compressed archives
+	index *inflate.Index
+
+	files       map[string]*tarEntry
+	directories map[string]*tarEntry
+}
+
+// newTarArchive returns a new tar archive of the cache of fs. It is the
+// newArchiveFunc of the tar archives.
+func newTarArchive(fs *VFS, key string) archive {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &tarArchive{
+		fs:     fs,
+		key:    key,
+		budget: fs.budget,
+		archiveOpener: archiveOpener{
+			done:        make(chan struct{}),
+			openTimeout: fs.openTimeout,
+		},
+		ctx:         ctx,
+		cancel:      cancel,
+		files:       make(map[string]*tarEntry),
+		directories: make(map[string]*tarEntry),
+	}
+}
+
+func (a *tarArchive) cacheKey() string {
+	return a.key
+}
+
+func (a *tarArchive) openArchive(ctx context.Context, url string) error {
+	return a.open(ctx, url, "tar", a.readArchive)
+}
+
+// readArchive creates an httprange.Resource that can read the archive's
+// contents and indexes its entries, reading the whole archive once for
+// gzip compressed archives. Only the creation of the resource is bound by
+// the open timeout, as indexing large archives takes longer: the requests
+// stop waiting for it after the open timeout, but it carries on until the
+// archive is evicted.
+func (a *tarArchive) readArchive(url string) {
+	defer close(a.done)
+
+	// create the resource with a timeout separate from openArchive's
+	ctx, cancel := context.WithTimeout(a.ctx, a.openTimeout)
+	a.resource, a.err = httprange.NewResource(ctx, url, a.fs.httpClient)
+	cancel()
+
+	if a.err != nil {
+		log.WithFields(log.Fields{
+			"archive_url": url,
+		}).WithError(a.err).Infoln("read tar archive request failed")
+		metrics.TarOpened.WithLabelValues("error").Inc()
+		return
+	}
+
+	reader := httprange.NewReader(a.ctx, a.resource, 0, a.resource.Size)
+	defer reader.Close()
+
+	if a.err = a.readEntries(reader); a.err != nil {
+		log.WithFields(log.Fields{
+			"archive_url": url,
+		}).WithError(a.err).Infoln("loading tar archive entries into memory failed")
+		metrics.TarOpened.WithLabelValues("error").Inc()
+		return
+	}
+
+	state := "ok"
+	if a.index != nil {
+		state = "ok_gzip"
+	}
+
+	a.account(a.cost())
+
+	metrics.TarOpened.WithLabelValues(state).Inc()
+	metrics.TarOpenedEntriesCount.Add(float64(len(a.files)))
+}
+
+// account adds cost to the memory cost of the archive, evicting other
+// archives from the cache if the memory budget is exceeded
+func (a *tarArchive) account(cost int64) {
+	a.fs.evict(a.budget.grow(a, cost))
+}
+
+// cost returns the estimated memory cost of the entries and of the index of
+// the archive, the names of the entries being part of their path
+func (a *tarArchive) cost() int64 {
+	var cost int64
+
+	for _, entries := range []map[string]*tarEntry{a.files, a.directories} {
+		for name, e := range entries {
+			cost += tarEntryCost + int64(len(name)+len(e.link))
+		}
+	}
+
+	if a.index != nil {
+		cost += a.index.Cost()
+	}
+
+	return cost
+}
+
+// readEntries indexes the entries of the archive read by reader
+func (a *tarArchive) readEntries(reader *httprange.Reader) error {
+	buffered := bufio.NewReader(reader)
+
+	stream := &positionReader{r: buffered, buffered: buffered, seeker: reader}
+
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		f := inflate.NewGzipReader(buffered)
+		a.index = f.Index()
+
+		// the positions are the ones of the inflated stream
+		stream = &positionReader{r: f}
+	}
+
+	tr := tar.NewReader(stream)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) && a.index != nil {
+			// inflate the gzip stream up to its end, verifying its checksum
+			_, err = io.Copy(io.Discard, stream)
+			return err
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		a.addEntry(header, stream.pos)
+	}
+}
+
+// addEntry adds the entry of header, the content of which is at offset
+func (a *tarArchive) addEntry(header *tar.Header, offset int64) {
+	name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+	if !strings.HasPrefix(name+"/", dirPrefix) {
+		return
+	}
+
+	e := &tarEntry{
+		name:    path.Base(name),
+		mode:    header.FileInfo().Mode(),
+		size:    header.Size,
+		modTime: header.ModTime.UTC(),
+		offset:  offset,
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		e.size = 0
+		a.directories[name] = e
+	case tar.TypeReg:
+		a.files[name] = e
+	case tar.TypeSymlink:
+		e.link = header.Linkname
+		a.files[name] = e
+	case tar.TypeLink:
+		// hard links share the content of a file archived before them
+		target := a.files[path.Clean(strings.TrimPrefix(header.Linkname, "/"))]
+		if target == nil || !target.mode.IsRegular() {
+			return
+		}
+
+		e.mode, e.size, e.offset = target.mode, target.size, target.offset
+		a.files[name] = e
+	default:
+		return
+	}
+
+	a.addPathDirectories(name)
+}
+
+// addPathDirectories adds the missing parent directories of a given path
+func (a *tarArchive) addPathDirectories(pathname string) {
+	for dir := path.Dir(pathname); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if a.directories[dir] != nil {
+			return
+		}
+
+		a.directories[dir] = &tarEntry{name: path.Base(dir), mode: fs.ModeDir | 0755}
+	}
+}
+
+func (a *tarArchive) findFile(name string) *tarEntry {
+	return a.files[path.Clean(dirPrefix+name)]
+}
+
+func (a *tarArchive) findDirectory(name string) *tarEntry {
+	return a.directories[path.Clean(dirPrefix+name)]
+}
+
+// Open finds the file by name inside the tarArchive and returns a reader that can be served by the VFS
+func (a *tarArchive) Open(ctx context.Context, name string) (vfs.File, error) {
+	file := a.findFile(name)
+	if file == nil {
+		if a.findDirectory(name) != nil {
+			return nil, errNotFile
+		}
+		return nil, os.ErrNotExist
+	}
+
+	if !file.mode.IsRegular() {
+		return nil, errNotFile
+	}
+
+	if a.index != nil {
+		return newGzipSectionReader(ctx, a.index, a.resource, file.offset, file.size)
+	}
+
+	// uncompressed entries are seekable sections of the archive
+	return httprange.NewReader(ctx, a.resource, file.offset, file.size), nil
+}
+
+// Lstat finds the file by name inside the tarArchive and returns its FileInfo
+func (a *tarArchive) Lstat(ctx context.Context, name string) (os.FileInfo, error) {
+	if file := a.findFile(name); file != nil {
+		return file, nil
+	}
+
+	if directory := a.findDirectory(name); directory != nil {
+		return directory, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// Readlink finds the file by name inside the tarArchive and returns the target of the symlink
+func (a *tarArchive) Readlink(ctx context.Context, name string) (string, error) {
+	file := a.findFile(name)
+	if file == nil {
+		if a.findDirectory(name) != nil {
+			return "", errNotSymlink
+		}
+		return "", os.ErrNotExist
+	}
+
+	if file.mode&os.ModeSymlink != os.ModeSymlink {
+		return "", errNotSymlink
+	}
+
+	if len(file.link) > maxSymlinkSize {
+		return "", errSymlinkSize
+	}
+
+	return file.link, nil
+}
+
+// onEvicted called by the VFS.cache when an archive is removed from the
+// cache, which stops indexing it
+func (a *tarArchive) onEvicted() {
+	a.cancel()
+}
+
+// positionReader tracks the position of the tar stream read by tar.Reader,
+// which is the offset of the content of an entry after reading its header.
+// When seeker is defined, the large entries tar.Reader skips are skipped with
+// a new range request instead of being read through buffered.
+type positionReader struct {
+	r        io.Reader
+	buffered *bufio.Reader
+	seeker   *httprange.Reader
+	pos      int64
+}
+
+func (p *positionReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.pos += int64(n)
+
+	return n, err
+}
+
+// Seek only moves forward relatively to the current position, by offset
+// bytes when seeker is defined and offset is large enough, tar.Reader reading
+// through the rest
+func (p *positionReader) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekCurrent || p.seeker == nil || offset < minSeekDistance {
+		return p.pos, nil
+	}
+
+	if _, err := p.seeker.Seek(p.pos+offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	p.buffered.Reset(p.seeker)
+	p.pos += offset
+
+	return p.pos, nil
+}
@@ -0,0 +1,363 @@
+package zip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
+)
+
+var tarModTime = time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// tarData returns compressible data of size
+func tarData(size int) []byte {
+	random := rand.New(rand.NewSource(int64(size)))
+	words := []string{"gitlab ", "pages ", "archive ", "tar ", "\n", "<html>", "</html>"}
+
+	var b bytes.Buffer
+	for b.Len() < size {
+		if random.Intn(10) == 0 {
+			b.WriteByte(byte(random.Intn(256)))
+			continue
+		}
+
+		b.WriteString(words[random.Intn(len(words))])
+	}
+
+	return b.Bytes()[:size]
+}
+
+// tarFiles are the regular files of the test archives
+var tarFiles = map[string][]byte{
+	"public/index.html":          []byte("tar.gitlab.io/project/index.html\n"),
+	"public/subdir/linked.html":  []byte("tar.gitlab.io/project/subdir/linked.html\n"),
+	"public/large.bin":           tarData(3 * inflate.CheckpointSpan),
+	"public/after-large.html":    []byte("after large\n"),
+	"not-public/secret.html":     []byte("secret\n"),
+	"./public/dot-prefixed.html": []byte("dot prefixed\n"),
+}
+
+func createTar(t *testing.T, compress bool) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	var w io.Writer = &b
+
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&b)
+		w = gw
+	}
+
+	tw := tar.NewWriter(w)
+
+	write := func(header *tar.Header, content []byte) {
+		header.ModTime = tarModTime
+		header.Size = int64(len(content))
+		require.NoError(t, tw.WriteHeader(header))
+
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+
+	write(&tar.Header{Typeflag: tar.TypeDir, Name: "public/", Mode: 0755}, nil)
+
+	for _, name := range []string{
+		"public/index.html",
+		"public/subdir/linked.html",
+		"public/large.bin",
+		"public/after-large.html",
+		"not-public/secret.html",
+		"./public/dot-prefixed.html",
+	} {
+		write(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644}, tarFiles[name])
+	}
+
+	write(&tar.Header{Typeflag: tar.TypeSymlink, Name: "public/symlink.html", Linkname: "subdir/linked.html", Mode: 0777}, nil)
+	write(&tar.Header{Typeflag: tar.TypeSymlink, Name: "public/bad_symlink.html", Linkname: strings.Repeat("a", maxSymlinkSize+1), Mode: 0777}, nil)
+	write(&tar.Header{Typeflag: tar.TypeLink, Name: "public/hardlink.html", Linkname: "public/index.html", Mode: 0644}, nil)
+
+	require.NoError(t, tw.Close())
+
+	if gw != nil {
+		require.NoError(t, gw.Close())
+	}
+
+	return b.Bytes()
+}
+
+// newTarServer serves content with range requests support, and counts the
+// requests made to it in requests
+func newTarServer(t *testing.T, content []byte, requests *int64) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			atomic.AddInt64(requests, 1)
+		}
+
+		http.ServeContent(w, r, "public.tar", tarModTime, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/public.tar"
+}
+
+func openTarArchive(t *testing.T, compress bool, requests *int64) *tarArchive {
+	t.Helper()
+
+	url := newTarServer(t, createTar(t, compress), requests)
+
+	root, err := NewTar(New(&zipCfg)).Root(context.Background(), url, "cache-key")
+	require.NoError(t, err)
+	require.IsType(t, &tarArchive{}, root)
+
+	return root.(*tarArchive)
+}
+
+func runTarTest(t *testing.T, test func(t *testing.T, archive *tarArchive)) {
+	t.Run("uncompressed", func(t *testing.T) {
+		archive := openTarArchive(t, false, nil)
+		require.Nil(t, archive.index)
+
+		test(t, archive)
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		archive := openTarArchive(t, true, nil)
+		require.NotNil(t, archive.index)
+		require.GreaterOrEqual(t, archive.index.Len(), 3)
+
+		test(t, archive)
+	})
+}
+
+func TestTarOpen(t *testing.T) {
+	runTarTest(t, func(t *testing.T, archive *tarArchive) {
+		tests := map[string]struct {
+			file            string
+			expectedContent []byte
+			expectedErr     error
+		}{
+			"file":                 {file: "index.html", expectedContent: tarFiles["public/index.html"]},
+			"file in subdir":       {file: "subdir/linked.html", expectedContent: tarFiles["public/subdir/linked.html"]},
+			"large file":           {file: "large.bin", expectedContent: tarFiles["public/large.bin"]},
+			"file after large one": {file: "after-large.html", expectedContent: tarFiles["public/after-large.html"]},
+			"dot prefixed file":    {file: "dot-prefixed.html", expectedContent: tarFiles["./public/dot-prefixed.html"]},
+			"hard link":            {file: "hardlink.html", expectedContent: tarFiles["public/index.html"]},
+			"symlink":              {file: "symlink.html", expectedErr: errNotFile},
+			"directory":            {file: "subdir", expectedErr: errNotFile},
+			"missing file":         {file: "unknown.html", expectedErr: os.ErrNotExist},
+			"file outside public":  {file: "../not-public/secret.html", expectedErr: os.ErrNotExist},
+		}
+
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				f, err := archive.Open(context.Background(), tc.file)
+				if tc.expectedErr != nil {
+					require.ErrorIs(t, err, tc.expectedErr)
+					return
+				}
+
+				require.NoError(t, err)
+				defer f.Close()
+
+				content, err := io.ReadAll(f)
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedContent, content)
+			})
+		}
+	})
+}
+
+func TestTarOpenSeekable(t *testing.T) {
+	archive := openTarArchive(t, false, nil)
+
+	f, err := archive.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+	defer f.Close()
+
+	seeker, ok := f.(vfs.SeekableFile)
+	require.True(t, ok)
+
+	_, err = seeker.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(seeker)
+	require.NoError(t, err)
+	require.Equal(t, tarFiles["public/index.html"][7:], content)
+}
+
+func TestTarLstat(t *testing.T) {
+	runTarTest(t, func(t *testing.T, archive *tarArchive) {
+		tests := map[string]struct {
+			file         string
+			expectedName string
+			expectedSize int64
+			expectedMode os.FileMode
+			expectedErr  error
+		}{
+			"file":              {file: "index.html", expectedName: "index.html", expectedSize: int64(len(tarFiles["public/index.html"])), expectedMode: 0644},
+			"root":              {file: "", expectedName: "public", expectedMode: fs.ModeDir | 0755},
+			"implicit dir":      {file: "subdir/", expectedName: "subdir", expectedMode: fs.ModeDir | 0755},
+			"symlink":           {file: "symlink.html", expectedName: "symlink.html", expectedSize: 0, expectedMode: fs.ModeSymlink | 0777},
+			"missing file":      {file: "unknown.html", expectedErr: os.ErrNotExist},
+			"dot prefixed file": {file: "dot-prefixed.html", expectedName: "dot-prefixed.html", expectedSize: int64(len(tarFiles["./public/dot-prefixed.html"])), expectedMode: 0644},
+		}
+
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				fi, err := archive.Lstat(context.Background(), tc.file)
+				if tc.expectedErr != nil {
+					require.ErrorIs(t, err, tc.expectedErr)
+					return
+				}
+
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedName, fi.Name())
+				require.Equal(t, tc.expectedSize, fi.Size())
+				require.Equal(t, tc.expectedMode, fi.Mode())
+				require.Equal(t, tc.expectedMode.IsDir(), fi.IsDir())
+
+				if name == "file" {
+					require.Equal(t, tarModTime, fi.ModTime())
+				}
+			})
+		}
+	})
+}
+
+func TestTarReadlink(t *testing.T) {
+	runTarTest(t, func(t *testing.T, archive *tarArchive) {
+		tests := map[string]struct {
+			file           string
+			expectedTarget string
+			expectedErr    error
+		}{
+			"symlink":         {file: "symlink.html", expectedTarget: "subdir/linked.html"},
+			"symlink too big": {file: "bad_symlink.html", expectedErr: errSymlinkSize},
+			"file":            {file: "index.html", expectedErr: errNotSymlink},
+			"directory":       {file: "subdir", expectedErr: errNotSymlink},
+			"missing file":    {file: "unknown.html", expectedErr: os.ErrNotExist},
+		}
+
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				target, err := archive.Readlink(context.Background(), tc.file)
+				if tc.expectedErr != nil {
+					require.ErrorIs(t, err, tc.expectedErr)
+					return
+				}
+
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedTarget, target)
+			})
+		}
+	})
+}
+
+func TestTarReadArchiveSkipsLargeEntries(t *testing.T) {
+	var requests int64
+
+	archive := openTarArchive(t, false, &requests)
+
+	// the HEAD request of the resource, the reading of the archive until the
+	// large file, and the reading of the archive after it
+	require.Equal(t, int64(3), atomic.LoadInt64(&requests))
+	require.NotNil(t, archive.findFile("after-large.html"))
+}
+
+func TestTarReadArchiveFails(t *testing.T) {
+	tests := map[string]struct {
+		content []byte
+	}{
+		"not a tar archive":      {content: bytes.Repeat([]byte("not a tar archive"), 100)},
+		"truncated gzip archive": {content: createTar(t, true)[:20]},
+		"invalid gzip checksum":  {content: corruptGzipChecksum(createTar(t, true))},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			url := newTarServer(t, tc.content, nil)
+
+			_, err := NewTar(New(&zipCfg)).Root(context.Background(), url, "cache-key")
+			require.Error(t, err)
+		})
+	}
+}
+
+// corruptGzipChecksum returns compressed with an invalid CRC-32 in the
+// trailer of its last member
+func corruptGzipChecksum(compressed []byte) []byte {
+	compressed[len(compressed)-8] ^= 0xff
+
+	return compressed
+}
+
+func TestTarReadArchiveAfterOpenTimeout(t *testing.T) {
+	content := createTar(t, true)
+
+	var requests int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the archive takes longer to read than the open timeout
+		if atomic.AddInt64(&requests, 1) > 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		http.ServeContent(w, r, "public.tar.gz", tarModTime, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := zipCfg
+	cfg.OpenTimeout = 50 * time.Millisecond
+
+	fs := NewTar(New(&cfg))
+
+	_, err := fs.Root(context.Background(), server.URL+"/public.tar.gz", "cache-key")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.Eventually(t, func() bool {
+		root, err := fs.Root(context.Background(), server.URL+"/public.tar.gz", "cache-key")
+		if err != nil {
+			return false
+		}
+
+		_, err = root.Lstat(context.Background(), "index.html")
+
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond, "the archive is indexed after the open timeout")
+}
+
+func TestTarArchiveAccountsCost(t *testing.T) {
+	url := newTarServer(t, createTar(t, true), nil)
+
+	zfs := New(&zipCfg)
+
+	root, err := NewTar(zfs).Root(context.Background(), url, "cache-key")
+	require.NoError(t, err)
+
+	archive := root.(*tarArchive)
+	require.Greater(t, archive.cost(), archive.index.Cost())
+	require.Equal(t, archive.cost(), zfs.budget.used)
+
+	require.Equal(t, 1, zfs.Invalidate([]string{"cache-key"}))
+	require.Zero(t, zfs.budget.used)
+	require.ErrorIs(t, archive.ctx.Err(), context.Canceled, "the evicted archive stops being indexed")
+}
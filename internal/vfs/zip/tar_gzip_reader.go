@@ -0,0 +1,45 @@
+package zip
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/inflate"
+)
+
+// gzipSectionReader reads a section of the inflated data of a gzip stream
+type gzipSectionReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *gzipSectionReader) Close() error {
+	return r.closer.Close()
+}
+
+// newGzipSectionReader returns a reader of the size inflated bytes of
+// resource at offset, which inflates resource from the checkpoint of index
+// preceding offset
+func newGzipSectionReader(ctx context.Context, index *inflate.Index, resource *httprange.Resource, offset, size int64) (io.ReadCloser, error) {
+	c := index.Find(offset)
+	if c == nil {
+		return nil, inflate.ErrInvalidDeflate
+	}
+
+	reader := httprange.NewReader(ctx, resource, c.In, resource.Size-c.In)
+
+	f, err := inflate.Resume(bufio.NewReader(reader), c)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	if err := f.Skip(offset - c.Out); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return &gzipSectionReader{Reader: io.LimitReader(f, size), closer: reader}, nil
+}
@@ -3,6 +3,7 @@ package zip
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"net/http"
 	"sync"
@@ -15,34 +16,19 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httpfs"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
-	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
-const (
-	// we assume that each item costs around 100 bytes
-	// this gives around 5MB of raw memory needed without acceleration structures
-	defaultDataOffsetItems              = 50000
-	defaultDataOffsetExpirationInterval = time.Hour
-
-	// we assume that each item costs around 200 bytes
-	// this gives around 2MB of raw memory needed without acceleration structures
-	defaultReadlinkItems              = 10000
-	defaultReadlinkExpirationInterval = time.Hour
-)
-
 var (
 	errAlreadyCached   = errors.New("archive already cached")
 	errMissingCacheKey = errors.New("missing cache key")
 )
 
-type lruCache interface {
-	FindOrFetch(cacheNamespace, key string, fetchFn func() (interface{}, error)) (interface{}, error)
-}
-
-// zipVFS is a simple cached implementation of the vfs.VFS interface
-type zipVFS struct {
+// VFS is a simple cached implementation of the vfs.VFS interface, serving
+// zip archives. Its cache of the deployment archives is shared with the tar
+// VFS created by NewTar.
+type VFS struct {
 	cache     *cache.Cache
 	cacheLock sync.Mutex
 
@@ -51,60 +37,69 @@ type zipVFS struct {
 	cacheRefreshInterval    time.Duration
 	cacheCleanupInterval    time.Duration
 
-	dataOffsetCache lruCache
-	readlinkCache   lruCache
+	// budget evicts archives from cache when their estimated memory cost
+	// exceeds memoryBudget
+	budget         *memoryBudget
+	memoryBudget   int64
+	evictionPolicy string
+
+	httpClient *http.Client
 
-	// the `int64` needs to be 64bit aligned on some 32bit systems
-	// https://gitlab.com/gitlab-org/gitlab/-/issues/337261
-	archiveCount *int64
-	httpClient   *http.Client
+	// diskCache is the optional local disk cache of the archives
+	diskCache *diskCache
 }
 
-// New creates a zipVFS instance that can be used by a serving request
-func New(cfg *config.ZipServing) vfs.VFS {
-	zipVFS := &zipVFS{
+// New creates a VFS instance that can be used by a serving request
+func New(cfg *config.ZipServing) *VFS {
+	zfs := &VFS{
 		cacheExpirationInterval: cfg.ExpirationInterval,
 		cacheRefreshInterval:    cfg.RefreshInterval,
 		cacheCleanupInterval:    cfg.CleanupInterval,
 		openTimeout:             cfg.OpenTimeout,
+		memoryBudget:            cfg.MemoryBudget,
+		evictionPolicy:          cfg.EvictionPolicy,
 		httpClient: &http.Client{
-			Timeout: cfg.HTTPClientTimeout,
-			Transport: httptransport.NewMeteredRoundTripper(
-				httptransport.NewTransport(),
-				"zip_vfs",
-				metrics.HTTPRangeTraceDuration,
-				metrics.HTTPRangeRequestDuration,
-				metrics.HTTPRangeRequestsTotal,
-				httptransport.DefaultTTFBTimeout,
-			),
+			Timeout:   cfg.HTTPClientTimeout,
+			Transport: newMeteredTransport(httptransport.NewTransport()),
 		},
-		archiveCount: new(int64),
 	}
 
-	zipVFS.resetCache()
+	zfs.resetCache()
 
-	// TODO: To be removed with https://gitlab.com/gitlab-org/gitlab-pages/-/issues/480
-	zipVFS.dataOffsetCache = lru.New(
-		"data-offset",
-		lru.WithMaxSize(defaultDataOffsetItems),
-		lru.WithExpirationInterval(defaultDataOffsetExpirationInterval),
-		lru.WithCachedEntriesMetric(metrics.ZipCachedEntries),
-		lru.WithCachedRequestsMetric(metrics.ZipCacheRequests),
-	)
-	zipVFS.readlinkCache = lru.New(
-		"readlink",
-		lru.WithMaxSize(defaultReadlinkItems),
-		lru.WithExpirationInterval(defaultReadlinkExpirationInterval),
-		lru.WithCachedEntriesMetric(metrics.ZipCachedEntries),
-		lru.WithCachedRequestsMetric(metrics.ZipCacheRequests),
-	)
+	return zfs
+}
+
+// tarVFS serves tar and tar.gz archives from the cache of a VFS, so that
+// they share the settings and the memory budget of all the deployment
+// archives
+type tarVFS struct {
+	*VFS
+}
+
+// NewTar creates a vfs.VFS serving tar and tar.gz archives from the cache of
+// zfs
+func NewTar(zfs *VFS) vfs.VFS {
+	return &tarVFS{VFS: zfs}
+}
+
+// Root opens a tar archive given a URL path and returns an instance of
+// tarArchive that implements the vfs.Root interface
+func (tfs *tarVFS) Root(ctx context.Context, path string, cacheKey string) (vfs.Root, error) {
+	return tfs.root(ctx, path, cacheKey, newTarArchive)
+}
+
+func (tfs *tarVFS) Name() string {
+	return "tar"
+}
 
-	return zipVFS
+// Reconfigure does nothing, as the cache of the VFS is reconfigured with it
+func (tfs *tarVFS) Reconfigure(cfg *config.Config) error {
+	return nil
 }
 
-// Reconfigure will update the zipVFS configuration values and will reset the
+// Reconfigure will update the VFS configuration values and will reset the
 // cache
-func (zfs *zipVFS) Reconfigure(cfg *config.Config) error {
+func (zfs *VFS) Reconfigure(cfg *config.Config) error {
 	zfs.cacheLock.Lock()
 	defer zfs.cacheLock.Unlock()
 
@@ -112,37 +107,120 @@ func (zfs *zipVFS) Reconfigure(cfg *config.Config) error {
 	zfs.cacheExpirationInterval = cfg.Zip.ExpirationInterval
 	zfs.cacheRefreshInterval = cfg.Zip.RefreshInterval
 	zfs.cacheCleanupInterval = cfg.Zip.CleanupInterval
+	zfs.memoryBudget = cfg.Zip.MemoryBudget
+	zfs.evictionPolicy = cfg.Zip.EvictionPolicy
 
 	if err := zfs.reconfigureTransport(cfg); err != nil {
 		return err
 	}
 
+	zfs.diskCache = nil
+	if cfg.Zip.DiskCacheDir != "" {
+		diskCache, err := newDiskCache(cfg.Zip.DiskCacheDir, cfg.Zip.DiskCacheMaxSize)
+		if err != nil {
+			return err
+		}
+
+		zfs.diskCache = diskCache
+	}
+
 	zfs.resetCache()
 
 	return nil
 }
 
-func (zfs *zipVFS) reconfigureTransport(cfg *config.Config) error {
+func newMeteredTransport(transport http.RoundTripper) http.RoundTripper {
+	return httptransport.NewMeteredRoundTripper(
+		transport,
+		"zip_vfs",
+		metrics.HTTPRangeTraceDuration,
+		metrics.HTTPRangeRequestDuration,
+		metrics.HTTPRangeRequestsTotal,
+		httptransport.DefaultTTFBTimeout,
+	)
+}
+
+func (zfs *VFS) reconfigureTransport(cfg *config.Config) error {
 	fsTransport, err := httpfs.NewFileSystemPath(cfg.Zip.AllowedPaths)
 	if err != nil {
 		return err
 	}
 
+	if clientTLS := cfg.Zip.ClientTLS; clientTLS.Enabled() {
+		transport, err := httptransport.NewTransportWithClientTLS(clientTLS.CertFile, clientTLS.KeyFile, clientTLS.CAFile)
+		if err != nil {
+			return fmt.Errorf("loading client TLS files: %w", err)
+		}
+
+		zfs.httpClient = &http.Client{
+			Timeout:   zfs.httpClient.Timeout,
+			Transport: newMeteredTransport(transport),
+		}
+	}
+
 	zfs.httpClient.Transport.(httptransport.Transport).
 		RegisterProtocol("file", http.NewFileTransport(fsTransport))
 
 	return nil
 }
 
-func (zfs *zipVFS) resetCache() {
+func (zfs *VFS) resetCache() {
+	if zfs.budget != nil {
+		zfs.budget.clear()
+	}
+
+	budget := newMemoryBudget(zfs.memoryBudget, zfs.evictionPolicy)
+
+	zfs.budget = budget
 	zfs.cache = cache.New(zfs.cacheExpirationInterval, zfs.cacheCleanupInterval)
 	zfs.cache.OnEvicted(func(s string, i interface{}) {
 		metrics.ZipCachedEntries.WithLabelValues("archive").Dec()
 
-		i.(*zipArchive).onEvicted()
+		budget.remove(i.(archive))
+		i.(archive).onEvicted()
 	})
 }
 
+// evict deletes the archives elected by the memory budget from the cache,
+// unless they have already been replaced
+func (zfs *VFS) evict(archives []archive) {
+	if len(archives) == 0 {
+		return
+	}
+
+	zfs.cacheLock.Lock()
+	defer zfs.cacheLock.Unlock()
+
+	for _, a := range archives {
+		if cached, found := zfs.cache.Get(a.cacheKey()); found && cached == a {
+			// deleting the archive calls OnEvicted, which updates the metrics
+			zfs.cache.Delete(a.cacheKey())
+			metrics.ZipCacheBudgetEvictions.Inc()
+		}
+	}
+}
+
+// Invalidate drops the archives of cacheKeys from the cache, so that they are
+// opened again on their next request
+func (zfs *VFS) Invalidate(cacheKeys []string) int {
+	zfs.cacheLock.Lock()
+	defer zfs.cacheLock.Unlock()
+
+	var count int
+
+	for _, key := range cacheKeys {
+		if _, found := zfs.cache.Get(key); !found {
+			continue
+		}
+
+		// deleting the archive calls OnEvicted, which updates the metrics
+		zfs.cache.Delete(key)
+		count++
+	}
+
+	return count
+}
+
 // Root opens an archive given a URL path and returns an instance of zipArchive
 // that implements the vfs.VFS interface.
 // To avoid using locks, the findOrOpenArchive function runs inside of a for
@@ -150,14 +228,19 @@ func (zfs *zipVFS) resetCache() {
 // If findOrOpenArchive returns errAlreadyCached, the for loop will continue
 // to try and find the cached archive or return if there's an error, for example
 // if the context is canceled.
-func (zfs *zipVFS) Root(ctx context.Context, path string, cacheKey string) (vfs.Root, error) {
+func (zfs *VFS) Root(ctx context.Context, path string, cacheKey string) (vfs.Root, error) {
+	return zfs.root(ctx, path, cacheKey, newZipArchive)
+}
+
+// root opens the archive of path created by newArchive, see Root
+func (zfs *VFS) root(ctx context.Context, path string, cacheKey string, newArchive newArchiveFunc) (vfs.Root, error) {
 	if cacheKey == "" {
 		return nil, errMissingCacheKey
 	}
 
 	// we do it in loop to not use any additional locks
 	for {
-		root, err := zfs.findOrOpenArchive(ctx, cacheKey, path)
+		root, err := zfs.findOrOpenArchive(ctx, cacheKey, path, newArchive)
 		if errors.Is(err, errAlreadyCached) {
 			continue
 		}
@@ -171,7 +254,7 @@ func (zfs *zipVFS) Root(ctx context.Context, path string, cacheKey string) (vfs.
 	}
 }
 
-func (zfs *zipVFS) Name() string {
+func (zfs *VFS) Name() string {
 	return "zip"
 }
 
@@ -179,16 +262,18 @@ func (zfs *zipVFS) Name() string {
 // otherwise creates the archive entry in a cache and try to save it,
 // if saving fails it's because the archive has already been cached
 // (e.g. by another concurrent request)
-func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
+func (zfs *VFS) findOrCreateArchive(key string, newArchive newArchiveFunc) (archive, error) {
 	// This needs to happen in lock to ensure that
 	// concurrent access will not remove it
 	// it is needed due to the bug https://github.com/patrickmn/go-cache/issues/48
 	zfs.cacheLock.Lock()
 	defer zfs.cacheLock.Unlock()
 
-	archive, expiry, found := zfs.cache.GetWithExpiration(key)
+	cached, expiry, found := zfs.cache.GetWithExpiration(key)
 	if found {
-		status, zipErr := archive.(*zipArchive).openStatus()
+		zfs.budget.touch(cached.(archive))
+
+		status, zipErr := cached.(archive).openStatus()
 		switch status {
 		case archiveOpening:
 			metrics.ZipCacheRequests.WithLabelValues("archive", "hit-opening").Inc()
@@ -200,7 +285,7 @@ func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
 
 		case archiveOpened:
 			if time.Until(expiry) < zfs.cacheRefreshInterval {
-				zfs.cache.SetDefault(key, archive)
+				zfs.cache.SetDefault(key, cached)
 				metrics.ZipCacheRequests.WithLabelValues("archive", "hit-refresh").Inc()
 			} else {
 				metrics.ZipCacheRequests.WithLabelValues("archive", "hit").Inc()
@@ -213,12 +298,13 @@ func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
 				"archive_key": key,
 			}).Error("archive corrupted")
 			metrics.ZipCacheRequests.WithLabelValues("archive", "corrupted").Inc()
-			archive = nil
+			cached = nil
 		}
 	}
 
-	if archive == nil {
-		archive = newArchive(zfs, zfs.openTimeout)
+	if cached == nil {
+		created := newArchive(zfs, key)
+		cached = created
 
 		// We call delete to ensure that expired item
 		// is properly evicted as there's a bug in a cache library:
@@ -227,29 +313,31 @@ func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
 
 		// if adding the archive to the cache fails it means it's already been added before
 		// this is done to find concurrent additions.
-		if zfs.cache.Add(key, archive, zfs.cacheExpirationInterval) != nil {
+		if zfs.cache.Add(key, cached, zfs.cacheExpirationInterval) != nil {
 			metrics.ZipCacheRequests.WithLabelValues("archive", "already-cached").Inc()
 			return nil, errAlreadyCached
 		}
 
+		zfs.budget.add(created)
+
 		metrics.ZipCacheRequests.WithLabelValues("archive", "miss").Inc()
 		metrics.ZipCachedEntries.WithLabelValues("archive").Inc()
 	}
 
-	return archive.(*zipArchive), nil
+	return cached.(archive), nil
 }
 
 // findOrOpenArchive gets archive from cache and tries to open it
-func (zfs *zipVFS) findOrOpenArchive(ctx context.Context, key, path string) (*zipArchive, error) {
-	zipArchive, err := zfs.findOrCreateArchive(key)
+func (zfs *VFS) findOrOpenArchive(ctx context.Context, key, path string, newArchive newArchiveFunc) (archive, error) {
+	a, err := zfs.findOrCreateArchive(key, newArchive)
 	if err != nil {
 		return nil, err
 	}
 
-	err = zipArchive.openArchive(ctx, path)
+	err = a.openArchive(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
-	return zipArchive, nil
+	return a, nil
 }
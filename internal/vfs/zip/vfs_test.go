@@ -79,7 +79,7 @@ func TestVFSFindOrOpenArchiveConcurrentAccess(t *testing.T) {
 
 	path := testServerURL + "/public.zip"
 
-	vfs := New(&zipCfg).(*zipVFS)
+	vfs := New(&zipCfg)
 	key := "d6b318b399cfe9a1c8483e49847ee49a2676d8cfd6df57ec64d971ad03640a75"
 	root, err := vfs.Root(context.Background(), path, key)
 	require.NoError(t, err)
@@ -103,7 +103,7 @@ func TestVFSFindOrOpenArchiveConcurrentAccess(t *testing.T) {
 	}()
 
 	require.Eventually(t, func() bool {
-		_, err := vfs.findOrOpenArchive(context.Background(), key, path)
+		_, err := vfs.findOrOpenArchive(context.Background(), key, path, newZipArchive)
 		return errors.Is(err, errAlreadyCached)
 	}, 3*time.Second, time.Nanosecond)
 }
@@ -173,12 +173,12 @@ func TestVFSFindOrOpenArchiveRefresh(t *testing.T) {
 				cfg.ExpirationInterval = test.expirationInterval
 				cfg.RefreshInterval = test.refreshInterval
 
-				vfs := New(&cfg).(*zipVFS)
+				vfs := New(&cfg)
 
 				path := testServerURL + test.path
 
 				// create a new archive and increase counters
-				archive1, err1 := vfs.findOrOpenArchive(context.Background(), test.sha256, path)
+				archive1, err1 := vfs.findOrOpenArchive(context.Background(), test.sha256, path, newZipArchive)
 				if test.expectOpenError {
 					require.Error(t, err1)
 					require.Nil(t, archive1)
@@ -194,7 +194,7 @@ func TestVFSFindOrOpenArchiveRefresh(t *testing.T) {
 
 				if test.expectNewArchive {
 					// should return a new archive
-					archive2, err2 := vfs.findOrOpenArchive(context.Background(), test.sha256, path)
+					archive2, err2 := vfs.findOrOpenArchive(context.Background(), test.sha256, path, newZipArchive)
 					if test.expectOpenError {
 						require.Error(t, err2)
 						require.Nil(t, archive2)
@@ -206,7 +206,7 @@ func TestVFSFindOrOpenArchiveRefresh(t *testing.T) {
 				}
 
 				// should return exactly the same archive
-				archive2, err2 := vfs.findOrOpenArchive(context.Background(), test.sha256, path)
+				archive2, err2 := vfs.findOrOpenArchive(context.Background(), test.sha256, path, newZipArchive)
 				require.Equal(t, archive1, archive2, "same archive is returned")
 				require.Equal(t, err1, err2, "same error for the same archive")
 
@@ -265,3 +265,23 @@ func withExpectedArchiveCount(t *testing.T, archiveCount int, fn func(t *testing
 	archivesCountEnd := testutil.ToFloat64(archivesMetric)
 	require.Equal(t, float64(archiveCount), archivesCountEnd-archivesCount, "exact number of archives is cached")
 }
+
+func TestVFSInvalidate(t *testing.T) {
+	u, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	vfs := New(&zipCfg)
+
+	root, err := vfs.Root(context.Background(), u+"/public.zip", "sha1")
+	require.NoError(t, err)
+
+	require.Equal(t, 1, vfs.Invalidate([]string{"sha1", "unknown"}))
+	require.Zero(t, vfs.Invalidate([]string{"sha1"}))
+
+	_, found := vfs.cache.Get("sha1")
+	require.False(t, found)
+
+	reopened, err := vfs.Root(context.Background(), u+"/public.zip", "sha1")
+	require.NoError(t, err)
+	require.NotSame(t, root, reopened)
+}
@@ -0,0 +1,499 @@
+package zip
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	xzHeaderLen = 12
+	xzFooterLen = 12
+	// xzFilterLZMA2 is the identifier of the LZMA2 filter, the only filter
+	// supported
+	xzFilterLZMA2 = 0x21
+	// xzMaxUvarintLen is the maximum length of the variable length integers
+	// of the xz format, which are at most math.MaxInt64
+	xzMaxUvarintLen = 9
+)
+
+var (
+	errXZFormat      = errors.New("decompress: invalid xz data")
+	errXZUnsupported = errors.New("decompress: unsupported xz options")
+	errXZDictionary  = errors.New("decompress: xz dictionary too large")
+	errXZChecksum    = errors.New("decompress: xz checksum error")
+	errXZSize        = errors.New("decompress: xz block size mismatch")
+
+	xzMagic       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+	crc64Tab      = crc64.MakeTable(crc64.ECMA)
+)
+
+// xzReader reads the blocks of the first stream of .xz data. The blocks are
+// read by LZMA2 readers whose dictionary is allocated up to
+// maxDecoderWindowSize, as the xz reader allocates the dictionary of the size
+// found in the header of a block. The sizes of the blocks are checked against
+// their headers while they are read, and against the index and the footer of
+// the stream once they are all read.
+type xzReader struct {
+	r   *countingReader
+	err error
+	sum hash.Hash
+	// checkLE tells whether the check is stored as a little endian integer,
+	// while sum returns it big endian
+	checkLE bool
+	// flags are the stream flags of the header, repeated in the footer
+	flags [2]byte
+
+	// size is the size of the file, which the decompressed data must not
+	// exceed, and n the size decompressed so far
+	size int64
+	n    int64
+
+	block   *xzBlock
+	records []xzRecord
+}
+
+// xzBlock is the block being read
+type xzBlock struct {
+	io.Reader
+	xzBlockHeader
+
+	start int64
+	// n is the size decompressed so far
+	n int64
+}
+
+// xzBlockHeader holds the size of the header of a block, the sizes of the
+// block it declares, which are -1 when it does not, and the dictionary size
+// of its LZMA2 filter
+type xzBlockHeader struct {
+	size             int64
+	compressedSize   int64
+	uncompressedSize int64
+	dictCap          int64
+}
+
+// xzRecord is the record of a block read, to check the index against
+type xzRecord struct {
+	unpaddedSize     int64
+	uncompressedSize int64
+}
+
+// newXZReader reads the xz data of a zip entry
+func newXZReader(r io.Reader, size int64) (io.ReadCloser, error) {
+	xr := &xzReader{r: &countingReader{r: bufio.NewReader(r)}, size: size}
+
+	var header [xzHeaderLen]byte
+	if _, err := io.ReadFull(xr.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:len(xzMagic)], xzMagic) ||
+		crc32.ChecksumIEEE(header[6:8]) != binary.LittleEndian.Uint32(header[8:]) {
+		return nil, errXZFormat
+	}
+
+	if header[6] != 0 {
+		return nil, errXZFormat
+	}
+
+	switch header[7] {
+	case 0x00:
+	case 0x01:
+		xr.sum, xr.checkLE = crc32.NewIEEE(), true
+	case 0x04:
+		xr.sum, xr.checkLE = crc64.New(crc64Tab), true
+	case 0x0a:
+		xr.sum = sha256.New()
+	default:
+		return nil, errXZUnsupported
+	}
+
+	copy(xr.flags[:], header[6:8])
+
+	return io.NopCloser(xr), nil
+}
+
+// Read reads the decompressed data of the blocks
+func (xr *xzReader) Read(p []byte) (int, error) {
+	for xr.err == nil {
+		if xr.block == nil {
+			xr.err = xr.nextBlock()
+			continue
+		}
+
+		n, err := xr.block.Read(p)
+		if xr.sum != nil {
+			xr.sum.Write(p[:n])
+		}
+
+		xr.n += int64(n)
+		xr.block.n += int64(n)
+
+		// the data beyond the sizes of the file or of the block is not
+		// returned
+		if xr.n > xr.size || xr.block.uncompressedSize >= 0 && xr.block.n > xr.block.uncompressedSize {
+			xr.err = errXZSize
+			break
+		}
+
+		if errors.Is(err, io.EOF) {
+			xr.err = xr.endBlock()
+		} else if err != nil {
+			xr.err = err
+		}
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+
+	return 0, xr.err
+}
+
+// nextBlock reads the header of the next block, or reads the index and the
+// footer of the stream and returns io.EOF when the index is found
+func (xr *xzReader) nextBlock() error {
+	start := xr.r.n
+
+	b, err := xr.r.ReadByte()
+	if err != nil {
+		return noEOF(err)
+	}
+
+	if b == 0 {
+		if err := xr.readIndex(); err != nil {
+			return err
+		}
+
+		return io.EOF
+	}
+
+	header := make([]byte, (int(b)+1)*4)
+	header[0] = b
+
+	if _, err := io.ReadFull(xr.r, header[1:]); err != nil {
+		return noEOF(err)
+	}
+
+	end := len(header) - crc32.Size
+	if crc32.ChecksumIEEE(header[:end]) != binary.LittleEndian.Uint32(header[end:]) {
+		return errXZFormat
+	}
+
+	blockHeader, err := parseXZBlockHeader(header[:end])
+	if err != nil {
+		return err
+	}
+
+	if blockHeader.uncompressedSize > xr.size-xr.n {
+		return errXZSize
+	}
+
+	if blockHeader.dictCap > maxDecoderWindowSize {
+		return errXZDictionary
+	}
+
+	dictCap := blockHeader.dictCap
+	if dictCap < lzma.MinDictCap {
+		dictCap = lzma.MinDictCap
+	}
+
+	r, err := lzma.Reader2Config{DictCap: int(dictCap)}.NewReader2(xr.r)
+	if err != nil {
+		return err
+	}
+
+	xr.block = &xzBlock{Reader: r, xzBlockHeader: blockHeader, start: start}
+
+	return nil
+}
+
+// endBlock checks the sizes of the block read, and reads its padding and its
+// check
+func (xr *xzReader) endBlock() error {
+	block := xr.block
+	xr.block = nil
+
+	compressedSize := xr.r.n - block.start - block.size
+	if block.compressedSize >= 0 && compressedSize != block.compressedSize ||
+		block.uncompressedSize >= 0 && block.n != block.uncompressedSize {
+		return errXZSize
+	}
+
+	if err := xr.readPadding(xr.r, block.start); err != nil {
+		return err
+	}
+
+	checkSize, err := xr.readCheck()
+	if err != nil {
+		return err
+	}
+
+	xr.records = append(xr.records, xzRecord{
+		unpaddedSize:     block.size + compressedSize + int64(checkSize),
+		uncompressedSize: block.n,
+	})
+
+	return nil
+}
+
+// readCheck reads the check of the block read and returns its size
+func (xr *xzReader) readCheck() (int, error) {
+	if xr.sum == nil {
+		return 0, nil
+	}
+
+	sum := xr.sum.Sum(nil)
+	xr.sum.Reset()
+
+	check := make([]byte, len(sum))
+	if _, err := io.ReadFull(xr.r, check); err != nil {
+		return 0, noEOF(err)
+	}
+
+	if xr.checkLE {
+		for i, j := 0, len(check)-1; i < j; i, j = i+1, j-1 {
+			check[i], check[j] = check[j], check[i]
+		}
+	}
+
+	if !bytes.Equal(sum, check) {
+		return 0, errXZChecksum
+	}
+
+	return len(check), nil
+}
+
+// readIndex reads the index, whose indicator has been read, and the footer of
+// the stream, and checks them against the blocks read
+func (xr *xzReader) readIndex() error {
+	start := xr.r.n - 1
+
+	index := &crc32Reader{r: xr.r, crc: crc32.NewIEEE()}
+	index.crc.Write([]byte{0})
+
+	count, err := readXZUvarint(index)
+	if err != nil {
+		return err
+	}
+
+	// the records are compared with the blocks read, so the count read from
+	// the data never sizes an allocation
+	if count != uint64(len(xr.records)) {
+		return errXZFormat
+	}
+
+	for _, record := range xr.records {
+		unpaddedSize, err := readXZUvarint(index)
+		if err != nil {
+			return err
+		}
+
+		uncompressedSize, err := readXZUvarint(index)
+		if err != nil {
+			return err
+		}
+
+		if unpaddedSize != uint64(record.unpaddedSize) || uncompressedSize != uint64(record.uncompressedSize) {
+			return errXZFormat
+		}
+	}
+
+	if err := xr.readPadding(index, start); err != nil {
+		return err
+	}
+
+	var crc [crc32.Size]byte
+	if _, err := io.ReadFull(xr.r, crc[:]); err != nil {
+		return noEOF(err)
+	}
+
+	if index.crc.Sum32() != binary.LittleEndian.Uint32(crc[:]) {
+		return errXZFormat
+	}
+
+	return xr.readFooter(xr.r.n - start)
+}
+
+// readFooter reads the footer of the stream, which repeats the size of the
+// index and the stream flags
+func (xr *xzReader) readFooter(indexSize int64) error {
+	var footer [xzFooterLen]byte
+	if _, err := io.ReadFull(xr.r, footer[:]); err != nil {
+		return noEOF(err)
+	}
+
+	if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[:4]) ||
+		(int64(binary.LittleEndian.Uint32(footer[4:8]))+1)*4 != indexSize ||
+		!bytes.Equal(footer[8:10], xr.flags[:]) ||
+		!bytes.Equal(footer[10:], xzFooterMagic) {
+		return errXZFormat
+	}
+
+	return nil
+}
+
+// readPadding reads the null bytes which align r to a multiple of four bytes
+// from start
+func (xr *xzReader) readPadding(r io.ByteReader, start int64) error {
+	for (xr.r.n-start)%4 != 0 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return noEOF(err)
+		}
+
+		if b != 0 {
+			return errXZFormat
+		}
+	}
+
+	return nil
+}
+
+// parseXZBlockHeader parses the sizes and the LZMA2 filter of a block header,
+// without its CRC32
+func parseXZBlockHeader(header []byte) (xzBlockHeader, error) {
+	h := xzBlockHeader{
+		size:             int64(len(header) + crc32.Size),
+		compressedSize:   -1,
+		uncompressedSize: -1,
+	}
+
+	flags := header[1]
+	if flags&0x3f != 0 {
+		// reserved flags or several filters
+		return h, errXZUnsupported
+	}
+
+	r := bytes.NewReader(header[2:])
+
+	for _, size := range []struct {
+		present bool
+		value   *int64
+	}{
+		{present: flags&0x40 != 0, value: &h.compressedSize},
+		{present: flags&0x80 != 0, value: &h.uncompressedSize},
+	} {
+		if !size.present {
+			continue
+		}
+
+		v, err := readXZUvarint(r)
+		if err != nil {
+			return h, err
+		}
+
+		*size.value = int64(v)
+	}
+
+	if h.compressedSize == 0 {
+		return h, errXZFormat
+	}
+
+	id, err := readXZUvarint(r)
+	if err != nil {
+		return h, err
+	}
+
+	if id != xzFilterLZMA2 {
+		return h, errXZUnsupported
+	}
+
+	var props [2]byte
+	if _, err := io.ReadFull(r, props[:]); err != nil || props[0] != 1 {
+		return h, errXZFormat
+	}
+
+	for r.Len() > 0 {
+		if b, _ := r.ReadByte(); b != 0 {
+			return h, errXZFormat
+		}
+	}
+
+	h.dictCap, err = lzma.DecodeDictCap(props[1])
+	if err != nil {
+		return h, errXZFormat
+	}
+
+	return h, nil
+}
+
+// readXZUvarint reads a variable length integer of the xz format, which is
+// encoded in at most xzMaxUvarintLen bytes, the last one not being null
+func readXZUvarint(r io.ByteReader) (uint64, error) {
+	var v uint64
+
+	for i := 0; i < xzMaxUvarintLen; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+
+		v |= uint64(b&0x7f) << (7 * i)
+
+		if b&0x80 == 0 {
+			if b == 0 && i > 0 {
+				return 0, errXZFormat
+			}
+
+			return v, nil
+		}
+	}
+
+	return 0, errXZFormat
+}
+
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+
+	return b, err
+}
+
+// crc32Reader computes the CRC32 of the bytes read from r
+type crc32Reader struct {
+	r   io.ByteReader
+	crc hash.Hash32
+}
+
+func (cr *crc32Reader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc.Write([]byte{b})
+	}
+
+	return b, err
+}
@@ -0,0 +1,361 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// compressXZWith compresses content into a xz stream of blocks of blockSize
+// bytes, whose check is of type checkSum. Its dictionary is the smallest one,
+// to read it quickly.
+func compressXZWith(t testing.TB, content []byte, checkSum byte, blockSize int64) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	xw, err := xz.WriterConfig{
+		DictCap:    lzma.MinDictCap,
+		CheckSum:   checkSum,
+		NoCheckSum: checkSum == xz.None,
+		BlockSize:  blockSize,
+	}.NewWriter(&b)
+	require.NoError(t, err)
+
+	_, err = xw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, xw.Close())
+
+	return b.Bytes()
+}
+
+func readXZ(data []byte, size int64) ([]byte, error) {
+	r, err := newXZReader(bytes.NewReader(data), size)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// xzIndex returns the offset of the index of the stream data
+func xzIndex(data []byte) int {
+	backwardSize := binary.LittleEndian.Uint32(data[len(data)-8:])
+
+	return len(data) - xzFooterLen - int(backwardSize+1)*4
+}
+
+// setXZBlockHeader replaces the header of the first block of data, which
+// must be as long as header once its CRC32 is appended
+func setXZBlockHeader(t *testing.T, data []byte, header ...byte) []byte {
+	t.Helper()
+
+	size := int(data[xzHeaderLen]+1) * 4
+	require.Equal(t, size, len(header)+crc32.Size, "the header must keep its size")
+
+	result := append([]byte{}, data[:xzHeaderLen]...)
+	result = append(result, header...)
+	result = binary.LittleEndian.AppendUint32(result, crc32.ChecksumIEEE(header))
+
+	return append(result, data[xzHeaderLen+size:]...)
+}
+
+// setXZIndex replaces the index of data, whose footer is updated
+func setXZIndex(t *testing.T, data []byte, index ...byte) []byte {
+	t.Helper()
+
+	result := append([]byte{}, data[:xzIndex(data)]...)
+	result = append(result, index...)
+	result = binary.LittleEndian.AppendUint32(result, crc32.ChecksumIEEE(index))
+
+	footer := append([]byte{}, data[len(data)-xzFooterLen:]...)
+	binary.LittleEndian.PutUint32(footer[4:], uint32((len(index)+crc32.Size)/4-1))
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(footer[4:10]))
+
+	return append(result, footer...)
+}
+
+func TestXZReader(t *testing.T) {
+	content := compressibleData(10000)
+
+	for name, checkSum := range map[string]byte{"none": xz.None, "crc32": xz.CRC32, "crc64": xz.CRC64, "sha256": xz.SHA256} {
+		for _, blockSize := range []int64{1024, 1 << 20} {
+			data := compressXZWith(t, content, checkSum, blockSize)
+
+			decompressed, err := readXZ(data, int64(len(content)))
+			require.NoError(t, err, name)
+			require.Equal(t, content, decompressed, name)
+
+			// the data following the first stream is ignored
+			decompressed, err = readXZ(append(data, data...), int64(len(content)))
+			require.NoError(t, err, name)
+			require.Equal(t, content, decompressed, name)
+
+			_, err = readXZ(data, int64(len(content)-1))
+			require.ErrorIs(t, err, errXZSize, "%s: the data is larger than the file", name)
+		}
+	}
+}
+
+func TestXZReaderTruncatedData(t *testing.T) {
+	content := compressibleData(3000)
+	data := compressXZWith(t, content, xz.CRC32, 1024)
+
+	for size := 0; size < len(data); size++ {
+		_, err := readXZ(data[:size], int64(len(content)))
+		require.Error(t, err, "truncated at %d bytes", size)
+	}
+}
+
+func TestXZReaderCorruptedData(t *testing.T) {
+	content := compressibleData(3000)
+
+	// the content of the streams without check cannot be checked
+	for _, checkSum := range []byte{xz.CRC32, xz.CRC64, xz.SHA256} {
+		data := compressXZWith(t, content, checkSum, 1024)
+
+		for i := range data {
+			for _, mask := range []byte{0x01, 0x80, 0xff} {
+				corrupted := append([]byte{}, data...)
+				corrupted[i] ^= mask
+
+				// corrupted data is never decompressed, unless the bytes
+				// changed do not alter the content
+				decompressed, err := readXZ(corrupted, int64(len(content)))
+				if err == nil {
+					require.Equal(t, content, decompressed, "byte %d changed with %#x", i, mask)
+				}
+			}
+		}
+	}
+}
+
+func TestXZReaderBlockHeader(t *testing.T) {
+	content := []byte("xz compressed file\n")
+	data := compressXZWith(t, content, xz.CRC32, 0)
+
+	// the stream of a single block has an index of a single record: the
+	// indicator, the number of records, the unpadded size of the block and
+	// its uncompressed size
+	index := data[xzIndex(data):]
+	require.Equal(t, []byte{0x00, 0x01}, index[:2])
+
+	// the header of the block only has the LZMA2 filter
+	headerSize := int(data[xzHeaderLen]+1) * 4
+	require.Equal(t, []byte{0x00, xzFilterLZMA2, 0x01}, data[xzHeaderLen+1:xzHeaderLen+4])
+
+	unpaddedSize := int(index[2])
+	compressedSize := byte(unpaddedSize - headerSize - crc32.Size)
+	dictCap := data[xzHeaderLen+4]
+
+	tests := map[string]struct {
+		header      []byte
+		expectedErr error
+	}{
+		"sizes": {
+			header: []byte{0x02, 0xc0, compressedSize, byte(len(content)), xzFilterLZMA2, 0x01, dictCap, 0x00},
+		},
+		"compressed size too small": {
+			header:      []byte{0x02, 0x40, compressedSize - 1, xzFilterLZMA2, 0x01, dictCap, 0x00, 0x00},
+			expectedErr: errXZSize,
+		},
+		"compressed size too large": {
+			header:      []byte{0x02, 0x40, compressedSize + 1, xzFilterLZMA2, 0x01, dictCap, 0x00, 0x00},
+			expectedErr: errXZSize,
+		},
+		"null compressed size": {
+			header:      []byte{0x02, 0x40, 0x00, xzFilterLZMA2, 0x01, dictCap, 0x00, 0x00},
+			expectedErr: errXZFormat,
+		},
+		"uncompressed size too small": {
+			header:      []byte{0x02, 0x80, byte(len(content) - 1), xzFilterLZMA2, 0x01, dictCap, 0x00, 0x00},
+			expectedErr: errXZSize,
+		},
+		"uncompressed size too large": {
+			header:      []byte{0x02, 0x80, byte(len(content) + 1), xzFilterLZMA2, 0x01, dictCap, 0x00, 0x00},
+			expectedErr: errXZSize,
+		},
+		"uncompressed size larger than the file": {
+			header:      []byte{0x02, 0x80, 0xff, 0xff, 0x07, xzFilterLZMA2, 0x01, dictCap},
+			expectedErr: errXZSize,
+		},
+		"size not minimally encoded": {
+			header:      []byte{0x02, 0x80, byte(len(content)) | 0x80, 0x00, xzFilterLZMA2, 0x01, dictCap, 0x00},
+			expectedErr: errXZFormat,
+		},
+		"size longer than the header": {
+			header:      []byte{0x02, 0x80, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"filter longer than the header": {
+			header:      []byte{0x02, 0xc0, compressedSize, byte(len(content)), 0xff, 0xff, 0xff, 0xff},
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"properties longer than the header": {
+			header:      []byte{0x02, 0xc0, 0x80, 0x01, 0x80, 0x01, xzFilterLZMA2, 0x01},
+			expectedErr: errXZFormat,
+		},
+		"properties size": {
+			header:      []byte{0x02, 0x00, xzFilterLZMA2, 0x02, dictCap, 0x00, 0x00, 0x00},
+			expectedErr: errXZFormat,
+		},
+		"invalid dictionary size": {
+			header:      []byte{0x02, 0x00, xzFilterLZMA2, 0x01, 41, 0x00, 0x00, 0x00},
+			expectedErr: errXZFormat,
+		},
+		"non null padding": {
+			header:      []byte{0x02, 0x00, xzFilterLZMA2, 0x01, dictCap, 0x00, 0x01, 0x00},
+			expectedErr: errXZFormat,
+		},
+		"several filters": {
+			header:      []byte{0x02, 0x01, xzFilterLZMA2, 0x01, dictCap, 0x00, 0x00, 0x00},
+			expectedErr: errXZUnsupported,
+		},
+		"other filter": {
+			header:      []byte{0x02, 0x00, 0x03, 0x01, dictCap, 0x00, 0x00, 0x00},
+			expectedErr: errXZUnsupported,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			decompressed, err := readXZ(setXZBlockHeader(t, data, tt.header...), 1<<16)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				require.Equal(t, content, decompressed)
+				return
+			}
+
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestXZReaderIndex(t *testing.T) {
+	content := compressibleData(3000)
+	data := compressXZWith(t, content, xz.CRC32, 1024)
+
+	start := xzIndex(data)
+	end := len(data) - xzFooterLen - crc32.Size
+	index := data[start:end]
+
+	// the records of the 3 blocks, followed by the padding
+	require.Equal(t, []byte{0x00, 0x03}, index[:2])
+	records := index[2:]
+
+	tests := map[string]struct {
+		data        []byte
+		expectedErr error
+	}{
+		"rewritten": {
+			data: setXZIndex(t, data, index...),
+		},
+		"missing record": {
+			data:        setXZIndex(t, data, append([]byte{0x00, 0x02}, records...)...),
+			expectedErr: errXZFormat,
+		},
+		"extra record": {
+			data:        setXZIndex(t, data, append([]byte{0x00, 0x04}, records...)...),
+			expectedErr: errXZFormat,
+		},
+		"huge number of records": {
+			data:        setXZIndex(t, data, append([]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, records...)...),
+			expectedErr: errXZFormat,
+		},
+		"record size": {
+			data: func() []byte {
+				changed := append([]byte{}, index...)
+				// the uncompressed size of the first block follows its
+				// unpadded size
+				changed[2+lenXZUvarint(records)]++
+				return setXZIndex(t, data, changed...)
+			}(),
+			expectedErr: errXZFormat,
+		},
+		"non null padding": {
+			data: func() []byte {
+				changed := append([]byte{}, index...)
+				changed[len(changed)-1] = 1
+				return setXZIndex(t, data, changed...)
+			}(),
+			expectedErr: errXZFormat,
+		},
+		"missing padding": {
+			data:        setXZIndex(t, data, index[:len(index)-1]...),
+			expectedErr: errXZFormat,
+		},
+		"footer backward size": {
+			data: func() []byte {
+				changed := setXZIndex(t, data, index...)
+				footer := changed[len(changed)-xzFooterLen:]
+				binary.LittleEndian.PutUint32(footer[4:], binary.LittleEndian.Uint32(footer[4:])+1)
+				binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(footer[4:10]))
+				return changed
+			}(),
+			expectedErr: errXZFormat,
+		},
+		"footer flags": {
+			data: func() []byte {
+				changed := setXZIndex(t, data, index...)
+				footer := changed[len(changed)-xzFooterLen:]
+				footer[9] = xz.CRC64
+				binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(footer[4:10]))
+				return changed
+			}(),
+			expectedErr: errXZFormat,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			decompressed, err := readXZ(tt.data, int64(len(content)))
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				require.Equal(t, content, decompressed)
+				return
+			}
+
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+// lenXZUvarint returns the length of the variable length integer of data
+func lenXZUvarint(data []byte) int {
+	_, n := binary.Uvarint(data)
+	return n
+}
+
+func FuzzXZReader(f *testing.F) {
+	content := compressibleData(3000)
+
+	for _, checkSum := range []byte{xz.None, xz.CRC32, xz.CRC64, xz.SHA256} {
+		f.Add(compressXZWith(f, content, checkSum, 1024))
+		f.Add(compressXZWith(f, content[:100], checkSum, 0))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decompressed, err := readXZ(data, 1<<20)
+		if err != nil {
+			return
+		}
+
+		// the data decompressed matches the one of the xz package, which
+		// reads the dictionaries of any size
+		xr, err := xz.ReaderConfig{SingleStream: true}.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		expected, err := io.ReadAll(xr)
+		if err == nil {
+			require.Equal(t, expected, decompressed)
+		}
+	})
+}
@@ -23,6 +23,48 @@ var (
 		Help: "The number of GitLab API calls that failed",
 	})
 
+	// DomainsSourceCacheStale is the number of GitLab API call cache hits
+	// served past their expiration because they could not be refreshed
+	DomainsSourceCacheStale = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_cache_stale",
+		Help: "The number of GitLab domains API cache hits served stale because refreshing them failed",
+	})
+
+	// DomainsSourceCacheSubscriptionEvents is the number of domain events
+	// streamed into the cache by the GitLab API
+	DomainsSourceCacheSubscriptionEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_cache_subscription_events",
+		Help: "The number of GitLab domain events streamed into the domains cache by type",
+	}, []string{"type"})
+
+	// DomainsSourceChainAnswers is the number of domains lookups answered by
+	// each source of a chain of domains sources
+	DomainsSourceChainAnswers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_chain_answers_total",
+		Help: "The number of domains lookups answered by each chained domains source with different results: found, not_found or error",
+	}, []string{"source", "result"})
+
+	// DomainsSourceCacheRedisRequests is the number of requests made to Redis
+	// by the shared domains cache store
+	DomainsSourceCacheRedisRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_cache_redis_requests_total",
+		Help: "The number of requests made to Redis by the GitLab domains cache with different results",
+	}, []string{"operation", "result"})
+
+	// DomainsSourceAPICircuitBreakerState is the state of the circuit breaker
+	// of the GitLab API client, 1 for the current state and 0 for the others
+	DomainsSourceAPICircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gitlab_pages_domains_source_api_circuit_breaker_state",
+		Help: "The state of the GitLab domains API circuit breaker: closed, open or half-open",
+	}, []string{"state"})
+
+	// DomainsSourceAPICircuitBreakerRejected is the number of GitLab API calls
+	// not made because the circuit breaker was open
+	DomainsSourceAPICircuitBreakerRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_api_circuit_breaker_rejected_total",
+		Help: "The number of GitLab domains API calls rejected by the open circuit breaker",
+	})
+
 	// DomainsSourceAPIReqTotal is the number of calls made to the GitLab API that returned a 4XX error
 	DomainsSourceAPIReqTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_pages_domains_source_api_requests_total",
@@ -56,6 +98,35 @@ var (
 		Buckets: prometheus.ExponentialBuckets(1.0, 10.0, 9),
 	}, []string{"vfs_name"})
 
+	// CompressionRatio is the ratio of the size of the files compressed on the
+	// fly to their original size
+	CompressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitlab_pages_compression_ratio",
+		Help:    "The ratio of the size of the files compressed on the fly to their original size by encoding",
+		Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+	}, []string{"encoding"})
+
+	// CompressionCPUSeconds is the time spent compressing files on the fly,
+	// which is CPU bound
+	CompressionCPUSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitlab_pages_compression_cpu_seconds",
+		Help:    "The CPU time (in seconds) spent compressing a file on the fly by encoding",
+		Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+	}, []string{"encoding"})
+
+	// CompressionCacheRequests is the number of compressed variants looked up
+	// in the cache of the files compressed on the fly
+	CompressionCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_compression_cache_requests_total",
+		Help: "The number of compressed variants of files looked up in the compression cache with different results: hit or miss",
+	}, []string{"result"})
+
+	// CompressionCacheSize is the size of the compressed variants cached
+	CompressionCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gitlab_pages_compression_cache_size_bytes",
+		Help: "The size in bytes of the compressed variants of files in the compression cache",
+	})
+
 	// ServingTime metric for time taken to find a file serving it or not found.
 	ServingTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "gitlab_pages_serving_time_seconds",
@@ -157,6 +228,86 @@ var (
 		},
 	)
 
+	// ZipArchiveCost is the estimated memory cost of the zip archives opened
+	// into the cache
+	ZipArchiveCost = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gitlab_pages_zip_archive_cost_bytes",
+		Help:    "The estimated memory cost in bytes of the zip archives opened into the cache",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	// ZipCachedArchivesCost is the estimated memory cost of the zip archives
+	// currently in the cache
+	ZipCachedArchivesCost = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_pages_zip_cached_archives_cost_bytes",
+			Help: "The estimated memory cost in bytes of the zip archives currently in the cache",
+		},
+	)
+
+	// ZipCacheBudgetEvictions is the number of zip archives evicted from the
+	// cache to fit in its memory budget
+	ZipCacheBudgetEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_zip_cache_budget_evictions",
+			Help: "The number of zip archives evicted from the cache to fit in its memory budget",
+		},
+	)
+
+	// ZipDiskCacheRequests is the number of zip archives looked up in the
+	// local disk cache
+	ZipDiskCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_zip_disk_cache_requests",
+			Help: "The number of zip archives disk cache hits/misses",
+		},
+		[]string{"cache"},
+	)
+
+	// ZipDiskCacheFills is the number of zip archives downloaded into the local
+	// disk cache
+	ZipDiskCacheFills = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_zip_disk_cache_fills",
+			Help: "The number of zip archives downloaded into the disk cache by result: ok, error or checksum_mismatch",
+		},
+		[]string{"result"},
+	)
+
+	// ZipDiskCacheSize is the size of the archives in the local disk cache
+	ZipDiskCacheSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_pages_zip_disk_cache_size_bytes",
+			Help: "The size in bytes of the zip archives in the disk cache",
+		},
+	)
+
+	// ZipDiskCacheEntries is the number of archives in the local disk cache
+	ZipDiskCacheEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_pages_zip_disk_cache_entries",
+			Help: "The number of zip archives in the disk cache",
+		},
+	)
+
+	// TarOpened is the number of tar archives that have been opened
+	TarOpened = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_tar_opened",
+			Help: "The total number of tar archives that have been opened, ok_gzip for the gzip compressed ones",
+		},
+		[]string{"state"},
+	)
+
+	// TarOpenedEntriesCount is the number of files per tar archive total count
+	// over time
+	TarOpenedEntriesCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_tar_opened_entries_count",
+			Help: "The number of files per tar archive total count over time",
+		},
+	)
+
 	RejectedRequestsCount = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "gitlab_pages_unknown_method_rejected_requests",
@@ -226,11 +377,21 @@ func MustRegister() {
 	prometheus.MustRegister(
 		DomainsSourceCacheHit,
 		DomainsSourceCacheMiss,
+		DomainsSourceCacheStale,
+		DomainsSourceCacheSubscriptionEvents,
+		DomainsSourceChainAnswers,
+		DomainsSourceCacheRedisRequests,
+		DomainsSourceAPICircuitBreakerState,
+		DomainsSourceAPICircuitBreakerRejected,
 		DomainsSourceAPIReqTotal,
 		DomainsSourceAPICallDuration,
 		DomainsSourceAPITraceDuration,
 		DomainsSourceFailures,
 		DiskServingFileSize,
+		CompressionRatio,
+		CompressionCPUSeconds,
+		CompressionCacheRequests,
+		CompressionCacheSize,
 		ServingTime,
 		VFSOperations,
 		HTTPRangeRequestsTotal,
@@ -239,6 +400,15 @@ func MustRegister() {
 		HTTPRangeOpenRequests,
 		ZipOpened,
 		ZipOpenedEntriesCount,
+		ZipArchiveCost,
+		ZipCachedArchivesCost,
+		ZipCacheBudgetEvictions,
+		ZipDiskCacheRequests,
+		ZipDiskCacheFills,
+		ZipDiskCacheSize,
+		ZipDiskCacheEntries,
+		TarOpened,
+		TarOpenedEntriesCount,
 		ZipCacheRequests,
 		ZipArchiveEntriesCached,
 		ZipCachedEntries,
@@ -58,6 +58,12 @@ func TestCompressedEncoding(t *testing.T) {
 			"index.html",
 			"br",
 		},
+		{
+			"zstd encoding",
+			"group.gitlab-example.com",
+			"index.html",
+			"zstd",
+		},
 	}
 
 	RunPagesProcess(t,
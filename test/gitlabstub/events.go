@@ -0,0 +1,142 @@
+package gitlabstub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type emittedEvent struct {
+	event string
+	data  []byte
+}
+
+// EventEmitter streams the domain events it emits as server-sent events to
+// the subscribers of the events endpoint. The events are identified by their
+// position, and a subscriber sending the ID of the last event it received as
+// its Last-Event-ID resumes after it.
+type EventEmitter struct {
+	mux    sync.Mutex
+	events []emittedEvent
+	// emitted is closed when an event is emitted
+	emitted chan struct{}
+	// disconnected is closed to disconnect the subscribers
+	disconnected chan struct{}
+	// expired is the number of events subscribers cannot resume after anymore
+	expired int
+}
+
+// NewEventEmitter returns an EventEmitter without events
+func NewEventEmitter() *EventEmitter {
+	return &EventEmitter{
+		emitted:      make(chan struct{}),
+		disconnected: make(chan struct{}),
+	}
+}
+
+// EmitUpdated emits that the configuration of host is now domain, which is
+// encoded to JSON as sent by the internal pages API
+func (e *EventEmitter) EmitUpdated(host string, domain interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{"host": host, "domain": domain})
+	if err != nil {
+		return err
+	}
+
+	e.emit("domain_updated", data)
+
+	return nil
+}
+
+// EmitDeleted emits that host has been deleted
+func (e *EventEmitter) EmitDeleted(host string) {
+	data, _ := json.Marshal(map[string]string{"host": host})
+
+	e.emit("domain_deleted", data)
+}
+
+func (e *EventEmitter) emit(event string, data []byte) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.events = append(e.events, emittedEvent{event: event, data: data})
+
+	close(e.emitted)
+	e.emitted = make(chan struct{})
+}
+
+// Disconnect ends the streams of the current subscribers
+func (e *EventEmitter) Disconnect() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	close(e.disconnected)
+	e.disconnected = make(chan struct{})
+}
+
+// Expire prevents the subscribers from resuming before the events emitted so
+// far, as if they had been pruned
+func (e *EventEmitter) Expire() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.expired = len(e.events)
+}
+
+// ServeHTTP streams the events following the Last-Event-ID of r, or the
+// events emitted from now on without it
+func (e *EventEmitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	e.mux.Lock()
+	cursor := len(e.events)
+	expired := e.expired
+	e.mux.Unlock()
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		id, err := strconv.Atoi(lastEventID)
+		if err != nil || id > cursor {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+
+		if id < expired {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		cursor = id
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	for {
+		e.mux.Lock()
+		events := e.events[cursor:]
+		emitted, disconnected := e.emitted, e.disconnected
+		e.mux.Unlock()
+
+		for _, event := range events {
+			cursor++
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", cursor, event.event, event.data)
+		}
+		flusher.Flush()
+
+		select {
+		case <-emitted:
+		case <-disconnected:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -1,16 +1,20 @@
 package gitlabstub
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
 func defaultAPIHandler(delay time.Duration, pagesRoot string) http.HandlerFunc {
@@ -28,14 +32,72 @@ func defaultAPIHandler(delay time.Duration, pagesRoot string) http.HandlerFunc {
 
 		// check if predefined response exists
 		if responses, ok := apiResponses[domain]; ok {
-			if err := json.NewEncoder(w).Encode(responses.virtualDomain(pagesRoot)); err != nil {
+			body, err := json.Marshal(responses.virtualDomain(pagesRoot))
+			if err != nil {
 				log.Fatalf("fail to encode response for domain %q: %v", domain, err)
 			}
+
+			serveWithETag(w, r, body)
 			return
 		}
 
 		// serve lookup from files
-		lookupFromFile(domain, w)
+		lookupFromFile(domain, w, r)
+	}
+}
+
+// serveWithETag serves body with a strong ETag computed from its content, or
+// 304 Not Modified when the request's If-None-Match header matches it, like
+// the GitLab internal API does
+func serveWithETag(w http.ResponseWriter, r *http.Request, body []byte) {
+	etag := fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256(body)))
+
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if _, err := w.Write(body); err != nil {
+		log.Printf("fail to write response: %v", err)
+	}
+}
+
+// domainsListHandler lists the predefined responses, paginated with the page
+// and per_page query parameters like the GitLab internal API does
+func domainsListHandler(pagesRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hosts := make([]string, 0, len(apiResponses))
+		for host := range apiResponses {
+			hosts = append(hosts, host)
+		}
+
+		sort.Strings(hosts)
+
+		page, perPage := 1, 20
+		if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+			page = p
+		}
+		if p, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && p > 0 {
+			perPage = p
+		}
+
+		domains := []api.ListedVirtualDomain{}
+		for i := (page - 1) * perPage; i < len(hosts) && i < page*perPage; i++ {
+			domains = append(domains, api.ListedVirtualDomain{
+				Host:   hosts[i],
+				Domain: apiResponses[hosts[i]].virtualDomain(pagesRoot),
+			})
+		}
+
+		if page*perPage < len(hosts) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+
+		if err := json.NewEncoder(w).Encode(domains); err != nil {
+			log.Fatalf("fail to encode domains list: %v", err)
+		}
 	}
 }
 
@@ -68,8 +130,8 @@ func defaultUserHandler() http.HandlerFunc {
 	}
 }
 
-func lookupFromFile(domain string, w http.ResponseWriter) {
-	fixture, err := os.Open("../../shared/lookups/" + domain + ".json")
+func lookupFromFile(domain string, w http.ResponseWriter, r *http.Request) {
+	body, err := os.ReadFile("../../shared/lookups/" + domain + ".json")
 	if errors.Is(err, fs.ErrNotExist) {
 		w.WriteHeader(http.StatusNoContent)
 
@@ -81,11 +143,7 @@ func lookupFromFile(domain string, w http.ResponseWriter) {
 		log.Fatal(err)
 	}
 
-	defer fixture.Close()
-
-	if _, err = io.Copy(w, fixture); err != nil {
-		log.Fatal(err)
-	}
+	serveWithETag(w, r, body)
 
 	log.Printf("GitLab domain %s source stub served lookup", domain)
 }
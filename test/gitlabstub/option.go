@@ -11,6 +11,7 @@ type config struct {
 	pagesRoot    string
 	delay        time.Duration
 	tlsConfig    *tls.Config
+	events       *EventEmitter
 }
 
 type Option func(*config)
@@ -47,3 +48,11 @@ func WithCertificate(cert tls.Certificate) Option {
 		c.tlsConfig.Certificates = append(c.tlsConfig.Certificates, cert)
 	}
 }
+
+// WithEventEmitter streams the domain events of emitter from the events
+// endpoint, which does not exist otherwise
+func WithEventEmitter(emitter *EventEmitter) Option {
+	return func(c *config) {
+		c.events = emitter
+	}
+}
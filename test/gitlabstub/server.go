@@ -1,6 +1,7 @@
 package gitlabstub
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"os"
 
@@ -28,6 +29,13 @@ func NewUnstartedServer(opts ...Option) (*httptest.Server, error) {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/api/v4/internal/pages", conf.pagesHandler)
+	router.HandleFunc("/api/v4/internal/pages/domains", domainsListHandler(conf.pagesRoot))
+
+	if conf.events != nil {
+		router.Handle("/api/v4/internal/pages/events", conf.events)
+	} else {
+		router.Handle("/api/v4/internal/pages/events", http.NotFoundHandler())
+	}
 
 	authHandler := defaultAuthHandler()
 	router.HandleFunc("/oauth/token", authHandler)